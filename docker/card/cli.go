@@ -4,10 +4,12 @@ import (
 	"card/db"
 	"card/phoenix"
 	"card/util"
+	"card/web"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"os"
 	"strconv"
 	"time"
 
@@ -21,8 +23,16 @@ func processArgs(db_conn *sql.DB, args []string) {
 		sendLightningPayment(args)
 	case "ClearCardBalancesForTag":
 		clearCardBalancesForTag(db_conn, args)
+	case "ForfeitExpiredCards":
+		forfeitExpiredCards(db_conn, args)
 	case "SetupCardAmountForTag":
 		setupCardAmountForTag(db_conn, args)
+	case "ExportCardsCsv":
+		exportCardsCsv(db_conn, args)
+	case "ImportCardsCsv":
+		importCardsCsv(db_conn, args)
+	case "CheckBalances":
+		checkBalances(db_conn, args)
 	case "ProgramBatch":
 		programBatch(db_conn, args)
 	case "WipeCard":
@@ -83,7 +93,8 @@ func setupCardAmountForTag(db_conn *sql.DB, args []string) {
 
 	for _, card := range cards {
 
-		receipts := db.Db_get_total_paid_receipts(db_conn, card.CardId)
+		// a group's initial balance is not a previous loading
+		receipts := db.Db_get_total_loaded_receipts(db_conn, card.CardId)
 
 		if receipts > 0 {
 			log.Error("unexpected card receipts for cardId ", card.CardId)
@@ -99,7 +110,8 @@ func setupCardAmountForTag(db_conn *sql.DB, args []string) {
 	log.Info("card setup has been successful for group : ", groupTag)
 }
 
-// used for clearing down balances after events
+// used for clearing down balances after events; the balances are moved to
+// the forfeit house account so there is a record of where they went
 //
 // $ docker exec -it card bash
 // # ./app ClearCardBalancesForTag set1
@@ -111,31 +123,155 @@ func clearCardBalancesForTag(db_conn *sql.DB, args []string) {
 	}
 
 	groupTag := args[1]
+	houseAccount := web.ForfeitHouseAccount(db_conn)
 
 	cards := db.Db_select_cards_with_group_tag(db_conn, groupTag)
 
 	for _, card := range cards {
 
-		balance := getBalance(db_conn, card.CardId)
+		amount, err := db.Db_forfeit_card_balance(db_conn, card.CardId, houseAccount,
+			"cleared for group "+groupTag)
+		if err != nil {
+			log.Error("could not clear balance for cardId ", card.CardId)
+			return
+		}
 
-		if balance > 0 {
+		if amount > 0 {
 			log.Info("card.CardId : ", card.CardId)
-			log.Info("balance : ", balance)
+			log.Info("cleared : ", amount)
+		}
+	}
+
+	log.Info("card balances have been successfully cleared for group : ", groupTag)
+}
+
+// expires cards past their expiry date and forfeits balances past the payout
+// grace period; with dry-run it only reports what would happen
+//
+// $ docker exec -it card bash
+// # ./app ForfeitExpiredCards dry-run
+func forfeitExpiredCards(db_conn *sql.DB, args []string) {
 
-			// add the reducing payment record with the current timestamp
-			db.Db_add_card_payment(db_conn, card.CardId, balance, "")
+	dryRun := len(args) > 1 && args[1] == "dry-run"
 
-			balance = getBalance(db_conn, card.CardId)
+	run := web.RunCardExpiry(db_conn, db_conn, time.Now().Unix(), dryRun)
+
+	if dryRun {
+		fmt.Println("dry run - nothing has been changed")
+	}
+	fmt.Println("grace period days :", run.GraceDays)
+	fmt.Println("house account :", run.HouseAccount)
+	fmt.Println("cards expired :", len(run.Expired))
+	for _, card := range run.Forfeited {
+		fmt.Println("card", card.CardId, "group", card.GroupTag, "expired",
+			time.Unix(int64(card.ExpiresAt), 0).UTC().Format(time.DateOnly), "forfeit", card.BalanceSats, "sats")
+	}
+	fmt.Println("total forfeit sats :", run.ForfeitedSats)
+}
 
-			// verify that the card balance is <= 0
-			if balance > 0 {
-				log.Error("unexpected card balance > 0 for cardId ", card.CardId)
-				return
-			}
+// exports the cards as CSV to a file, or to stdout without one
+//
+// $ docker exec -it card bash
+// # ./app ExportCardsCsv /card_data/cards.csv
+func exportCardsCsv(db_conn *sql.DB, args []string) {
+
+	out := os.Stdout
+	if len(args) > 1 {
+		f, err := os.Create(args[1])
+		if err != nil {
+			log.Error("could not create file: ", err)
+			return
 		}
+		defer f.Close()
+		out = f
 	}
 
-	log.Info("card balances have been successfully cleared for group : ", groupTag)
+	if err := web.ExportCardsCSV(db_conn, out); err != nil {
+		log.Error("card export failed: ", err)
+	}
+}
+
+// checks a CSV of card updates and shows the changes it would make; with
+// commit the changes are made, if every row is valid
+//
+// $ docker exec -it card bash
+// # ./app ImportCardsCsv /card_data/cards.csv
+// # ./app ImportCardsCsv /card_data/cards.csv commit
+func importCardsCsv(db_conn *sql.DB, args []string) {
+
+	if len(args) < 2 {
+		log.Warn("needs ImportCardsCsv file [commit]")
+		return
+	}
+	commit := len(args) > 2 && args[2] == "commit"
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		log.Error("could not open file: ", err)
+		return
+	}
+	defer f.Close()
+
+	ci := web.PlanCardImport(db_conn, f)
+
+	for _, e := range ci.Errors {
+		fmt.Println("error :", e)
+	}
+	for _, row := range ci.Rows {
+		for _, e := range row.Errors {
+			fmt.Println("line", row.Line, "card", row.CardId, "error :", e)
+		}
+		for _, c := range row.Changes {
+			fmt.Printf("line %d card %d %s : %q -> %q\n", row.Line, row.CardId, c.Field, c.From, c.To)
+		}
+	}
+	fmt.Println("cards to update :", len(ci.Updates))
+
+	if !ci.Valid() {
+		fmt.Println("the import has errors - nothing has been changed")
+		return
+	}
+	if !commit {
+		fmt.Println("preview only - run again with commit to make these changes")
+		return
+	}
+	if err := web.ApplyCardImport(db_conn, ci); err != nil {
+		log.Error("card import failed: ", err)
+		return
+	}
+	fmt.Println("import committed")
+}
+
+// recomputes every card balance from its receipts and payments and reports
+// where the stored balance has drifted; with fix the stored balances are reset
+//
+// $ docker exec -it card bash
+// # ./app CheckBalances
+// # ./app CheckBalances fix
+func checkBalances(db_conn *sql.DB, args []string) {
+
+	drifts, err := db.Db_select_balance_drift(db_conn)
+	if err != nil {
+		log.Error("balance check failed: ", err)
+		return
+	}
+
+	for _, d := range drifts {
+		fmt.Println("card", d.CardId, "stored", d.StoredMsat, "history", d.ComputedMsat,
+			"drift", d.StoredMsat-d.ComputedMsat, "msat")
+	}
+	fmt.Println("cards with drift :", len(drifts))
+
+	if len(drifts) == 0 || len(args) < 2 || args[1] != "fix" {
+		return
+	}
+
+	count, err := db.Db_reset_card_balances(db_conn)
+	if err != nil {
+		log.Error("balance reset failed: ", err)
+		return
+	}
+	fmt.Println("cards reset :", count)
 }
 
 func getBalance(db_conn *sql.DB, cardId int) int {
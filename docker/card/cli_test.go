@@ -4,6 +4,7 @@ import (
 	"card/db"
 	"database/sql"
 	"os"
+	"path/filepath"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -84,6 +85,19 @@ func TestSetupCardAmountForTag_SkipsCardWithExistingReceipts(t *testing.T) {
 	}
 }
 
+func TestSetupCardAmountForTag_LoadsOnTopOfGroupInitialBalance(t *testing.T) {
+	conn := openCliTestDB(t)
+	db.Db_set_card_group(conn, db.CardGroup{GroupTag: "event1", TxLimitSats: 1000000, InitialBalanceSats: 200,
+		LnAddressEnabled: "Y"})
+	db.Db_insert_card_with_uid(conn, "k0", "k1", "k2", "k3", "k4", "l1", "p1", "uid1", "event1")
+
+	setupCardAmountForTag(conn, []string{"SetupCardAmountForTag", "event1", "5000"})
+
+	if bal := getBalance(conn, 1); bal != 5200 {
+		t.Fatalf("expected balance 5200, got %d", bal)
+	}
+}
+
 func TestClearCardBalancesForTag_ZeroesBalances(t *testing.T) {
 	conn := openCliTestDB(t)
 	db.Db_insert_card_with_uid(conn, "k0", "k1", "k2", "k3", "k4", "l1", "p1", "uid1", "event1")
@@ -98,6 +112,12 @@ func TestClearCardBalancesForTag_ZeroesBalances(t *testing.T) {
 	if bal := getBalance(conn, 1); bal > 0 {
 		t.Fatalf("expected balance <= 0 after clear, got %d", bal)
 	}
+
+	// the cleared balance is accounted for on the house account
+	accounts := db.Db_select_house_accounts(conn)
+	if len(accounts) != 1 || accounts[0].BalanceSats != 3000 {
+		t.Fatalf("expected 3000 sats on the house account, got %+v", accounts)
+	}
 }
 
 func TestClearCardBalancesForTag_MissingArgs(t *testing.T) {
@@ -191,3 +211,39 @@ func TestProcessArgs_DispatchesProgramBatch(t *testing.T) {
 		t.Fatalf("expected ProgramBatch dispatch to insert 1 row, got %d", count)
 	}
 }
+
+func TestImportCardsCsv_PreviewThenCommit(t *testing.T) {
+	conn := openCliTestDB(t)
+	db.Db_insert_card_with_uid(conn, "k0", "k1", "k2", "k3", "k4", "l1", "p1", "04AABBCCDDEE01", "event1")
+
+	path := filepath.Join(t.TempDir(), "cards.csv")
+	os.WriteFile(path, []byte("uid,note,allocate_sats\n04AABBCCDDEE01,Guest 1,3000\n"), 0600)
+
+	importCardsCsv(conn, []string{"ImportCardsCsv", path})
+	if bal := getBalance(conn, 1); bal != 0 {
+		t.Fatalf("preview changed the balance to %d", bal)
+	}
+
+	importCardsCsv(conn, []string{"ImportCardsCsv", path, "commit"})
+	if bal := getBalance(conn, 1); bal != 3000 {
+		t.Fatalf("expected balance 3000 after commit, got %d", bal)
+	}
+}
+
+func TestCheckBalances_FixResetsDrift(t *testing.T) {
+	conn := openCliTestDB(t)
+	db.Db_insert_card(conn, "k0", "k1", "k2", "k3", "k4", "login1", "pass")
+	db.Db_add_card_receipt(conn, 1, "inv", "h1", 1000)
+	db.Db_set_receipt_paid(conn, "h1", "test")
+	conn.Exec(`UPDATE cards SET balance_sats = 5 WHERE card_id = 1`)
+
+	checkBalances(conn, []string{"CheckBalances"})
+	if bal := db.Db_get_card_balance(conn, 1); bal != 5 {
+		t.Fatalf("check without fix changed the balance to %d", bal)
+	}
+
+	checkBalances(conn, []string{"CheckBalances", "fix"})
+	if bal := db.Db_get_card_balance(conn, 1); bal != 1000 {
+		t.Fatalf("expected balance reset to 1000, got %d", bal)
+	}
+}
@@ -2,17 +2,40 @@ package db
 
 import (
 	"database/sql"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func Db_add_card_receipt(db_conn *sql.DB, card_id int, payment_request string, payment_hash_hex string, amount_sats int) (card_receipt_id int) {
+	return Db_add_card_receipt_msat(db_conn, card_id, payment_request, payment_hash_hex, SatsToMsat(amount_sats))
+}
+
+// Db_add_card_receipt_msat records a receipt of amount_msat millisatoshis.
+func Db_add_card_receipt_msat(db_conn *sql.DB, card_id int, payment_request string, payment_hash_hex string, amount_msat int64) (card_receipt_id int) {
+	return Db_add_card_receipt_from_payer(db_conn, card_id, payment_request, payment_hash_hex, amount_msat, ReceiptPayer{})
+}
+
+// ReceiptPayer is what the payer sent with a lightning address payment.
+type ReceiptPayer struct {
+	Comment    string // LUD-12
+	PayerData  string // LUD-18, as JSON
+	ZapRequest string // NIP-57 zap request event, as JSON
+}
+
+// Db_add_card_receipt_from_payer records a receipt of amount_msat
+// millisatoshis with what the payer sent.
+func Db_add_card_receipt_from_payer(db_conn *sql.DB, card_id int, payment_request string, payment_hash_hex string,
+	amount_msat int64, payer ReceiptPayer) (card_receipt_id int) {
 
 	// insert a new record
-	sqlStatement := `INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex, amount_sats,` +
-		` timestamp, expire_time)` +
-		` VALUES ($1, $2, $3, $4, unixepoch(), unixepoch() + 86400);`
-	res, err := db_conn.Exec(sqlStatement, card_id, payment_request, payment_hash_hex, amount_sats)
+	sqlStatement := `INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex, amount_sats, amount_msat,` +
+		` timestamp, expire_time, comment, payer_data, zap_request)` +
+		` VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);`
+	now := time.Now().Unix()
+	res, err := db_conn.Exec(sqlStatement, card_id, payment_request, payment_hash_hex,
+		MsatToSatsDown(amount_msat), amount_msat, now, invoiceExpiresAt(payment_request, now),
+		payer.Comment, payer.PayerData, payer.ZapRequest)
 	if err != nil {
 		log.Error("db_add_card_receipt exec error: ", err)
 		return 0
@@ -50,10 +73,10 @@ func Db_add_pay_link_address(db_conn *sql.DB, address string, cardId int, expiry
 func Db_add_card_payment(db_conn *sql.DB, card_id int, amount_sat int, invoice string) (card_payment_id int) {
 
 	// insert a new record
-	sqlStatement := `INSERT INTO card_payments (card_id, amount_sats, ln_invoice,` +
+	sqlStatement := `INSERT INTO card_payments (card_id, amount_sats, amount_msat, ln_invoice,` +
 		` timestamp, expire_time)` +
-		` VALUES ($1, $2, $3, unixepoch(), unixepoch() + 86400);`
-	res, err := db_conn.Exec(sqlStatement, card_id, amount_sat, invoice)
+		` VALUES ($1, $2, $3, $4, unixepoch(), unixepoch() + 86400);`
+	res, err := db_conn.Exec(sqlStatement, card_id, amount_sat, SatsToMsat(amount_sat), invoice)
 	if err != nil {
 		log.Error("db_add_card_payment exec error: ", err)
 		return 0
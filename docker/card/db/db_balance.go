@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cardHistoryBalanceSQL recomputes card c's balance in millisatoshis from its
+// receipts and payments, as cards.balance_msat should hold it.
+const cardHistoryBalanceSQL = `(IFNULL((SELECT SUM(amount_msat) FROM card_receipts` +
+	` WHERE paid_flag='Y' AND card_id=c.card_id), 0) -` +
+	` IFNULL((SELECT SUM(amount_msat + fee_msat) FROM card_payments` +
+	` WHERE paid_flag='Y' AND card_id=c.card_id), 0))`
+
+// BalanceDrift is a card whose stored balance differs from its history.
+type BalanceDrift struct {
+	CardId       int
+	StoredSats   int
+	ComputedSats int
+	StoredMsat   int64
+	ComputedMsat int64
+}
+
+// Db_select_balance_drift recomputes every card's balance from its history
+// and returns the cards whose stored balance does not match.
+func Db_select_balance_drift(db_conn *sql.DB) ([]BalanceDrift, error) {
+	var drifts []BalanceDrift
+
+	sqlStatement := `SELECT card_id, balance_sats, computed / 1000, balance_msat, computed FROM (` +
+		`SELECT c.card_id, c.balance_sats, c.balance_msat, ` + cardHistoryBalanceSQL + ` AS computed FROM cards c)` +
+		` WHERE balance_msat != computed OR balance_sats != computed / 1000 ORDER BY card_id;`
+	rows, err := db_conn.Query(sqlStatement)
+	if err != nil {
+		log.Error("db_select_balance_drift query error: ", err)
+		return drifts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d BalanceDrift
+		if err := rows.Scan(&d.CardId, &d.StoredSats, &d.ComputedSats, &d.StoredMsat, &d.ComputedMsat); err != nil {
+			log.Error("db_select_balance_drift scan error: ", err)
+			return drifts, err
+		}
+		drifts = append(drifts, d)
+	}
+
+	return drifts, rows.Err()
+}
+
+// Db_reset_card_balances sets every stored balance that has drifted back to
+// the balance recomputed from history. Returns the number of cards reset.
+func Db_reset_card_balances(db_conn *sql.DB) (int, error) {
+	sqlStatement := `UPDATE cards AS c SET balance_msat = ` + cardHistoryBalanceSQL +
+		`, balance_sats = ` + cardHistoryBalanceSQL + ` / 1000` +
+		` WHERE balance_msat != ` + cardHistoryBalanceSQL +
+		` OR balance_sats != ` + cardHistoryBalanceSQL + ` / 1000;`
+	res, err := db_conn.Exec(sqlStatement)
+	if err != nil {
+		log.Error("db_reset_card_balances error: ", err)
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Error("db_reset_card_balances rows affected error: ", err)
+		return 0, err
+	}
+	return int(count), nil
+}
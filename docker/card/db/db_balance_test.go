@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"testing"
+)
+
+// TestStoredBalance_FollowsHistory verifies the stored balance follows every
+// kind of change to receipts and payments, and that the checker finds and
+// resets drift.
+func TestStoredBalance_FollowsHistory(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	Db_add_card_receipt(db, id, "lnbc_a", "hash_a", 5000)
+	if b := Db_get_card_balance(db, id); b != 0 {
+		t.Fatalf("unpaid receipt changed the balance to %d", b)
+	}
+	Db_set_receipt_paid(db, "hash_a", "test")
+	if b := Db_get_card_balance(db, id); b != 5000 {
+		t.Fatalf("expected 5000 after receipt paid, got %d", b)
+	}
+
+	_, paymentId, err := Db_reserve_card_payment(db, id, 1000, 1000, "lnbc_pay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Db_update_card_payment_fee(db, paymentId, 7)
+	if b := Db_get_card_balance(db, id); b != 3993 {
+		t.Fatalf("expected 3993 after payment and fee, got %d", b)
+	}
+
+	Db_update_card_payment_unpaid(db, paymentId)
+	if b := Db_get_card_balance(db, id); b != 5000 {
+		t.Fatalf("expected 5000 after reversal, got %d", b)
+	}
+
+	db.Exec(`DELETE FROM card_receipts WHERE r_hash_hex = 'hash_a'`)
+	if b := Db_get_card_balance(db, id); b != 0 {
+		t.Fatalf("expected 0 after receipt deleted, got %d", b)
+	}
+
+	if drifts, err := Db_select_balance_drift(db); err != nil || len(drifts) != 0 {
+		t.Fatalf("expected no drift, got %+v err=%v", drifts, err)
+	}
+
+	db.Exec(`UPDATE cards SET balance_sats = 42 WHERE card_id = $1`, id)
+	drifts, _ := Db_select_balance_drift(db)
+	if len(drifts) != 1 || drifts[0].StoredSats != 42 || drifts[0].ComputedSats != 0 {
+		t.Fatalf("expected drift on card %d, got %+v", id, drifts)
+	}
+	if n, err := Db_reset_card_balances(db); err != nil || n != 1 {
+		t.Fatalf("expected one card reset, got %d err=%v", n, err)
+	}
+	if b := Db_get_card_balance(db, id); b != 0 {
+		t.Fatalf("expected reset balance 0, got %d", b)
+	}
+}
+
+// TestLedger_KeepsMsat verifies sub-sat amounts are kept in the ledger, and
+// that the sat balance is the whole sats of the msat balance.
+func TestLedger_KeepsMsat(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	Db_add_card_receipt_msat(db, id, "lnbc_m", "hash_m", 10_500)
+	Db_set_receipt_paid(db, "hash_m", "test")
+	if b := Db_get_card_balance_msat(db, id); b != 10_500 {
+		t.Fatalf("expected 10500 msat, got %d", b)
+	}
+	if b := Db_get_card_balance(db, id); b != 10 {
+		t.Fatalf("expected 10 sats, got %d", b)
+	}
+
+	balance, paymentId, err := Db_reserve_card_payment_msat(db, id, 2_300, 0, "lnbc_pay")
+	if err != nil || balance != 10_500 {
+		t.Fatalf("expected reservation against 10500 msat, got %d err=%v", balance, err)
+	}
+	Db_update_card_payment_fee_msat(db, paymentId, 1_001)
+	if b := Db_get_card_balance_msat(db, id); b != 7_199 {
+		t.Fatalf("expected 7199 msat after payment and fee, got %d", b)
+	}
+	if b := Db_get_card_balance(db, id); b != 7 {
+		t.Fatalf("expected 7 sats after payment and fee, got %d", b)
+	}
+
+	payments := Db_select_card_payments(db, id)
+	if len(payments) != 1 || payments[0].AmountSats != 3 || payments[0].FeeSats != 2 ||
+		payments[0].AmountMsat != 2_300 || payments[0].FeeMsat != 1_001 {
+		t.Fatalf("unexpected payment row: %+v", payments)
+	}
+
+	if _, _, err := Db_reserve_card_payment_msat(db, id, 7_200, 0, "lnbc_big"); err != ErrInsufficientFunds {
+		t.Fatalf("expected insufficient funds for 1 msat over the balance, got %v", err)
+	}
+	if drifts, err := Db_select_balance_drift(db); err != nil || len(drifts) != 0 {
+		t.Fatalf("expected no drift, got %+v err=%v", drifts, err)
+	}
+}
+
+// BenchmarkReserveCardPayment_100kTxs measures a payment reservation on a
+// card with 100k receipts and payments in its history. With the stored
+// balance its cost does not grow with the history.
+func BenchmarkReserveCardPayment_100kTxs(b *testing.B) {
+	db := openConcurrentTestDB(b)
+	Db_init(db)
+
+	Db_insert_card(db, "bk0", "bk1", "bk2", "bk3", "bk4", "blogin", "bpass")
+	cardId := 1
+	db.Exec(`UPDATE cards SET tx_limit_sats = 0, day_limit_sats = 0 WHERE card_id = $1`, cardId)
+
+	// 50k receipts and 50k payments, all older than the daily limit window
+	err := withImmediateTx(db, func(ctx context.Context, conn *sql.Conn) error {
+		for i := 0; i < 50_000; i++ {
+			_, err := conn.ExecContext(ctx, `INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex,`+
+				` amount_sats, amount_msat, paid_flag, timestamp, expire_time) VALUES ($1, '', $2, 1000, 1000000, 'Y', $3, $3);`,
+				cardId, "bench"+strconv.Itoa(i), 1_000_000+i)
+			if err != nil {
+				return err
+			}
+			_, err = conn.ExecContext(ctx, `INSERT INTO card_payments (card_id, amount_sats, fee_sats, amount_msat, fee_msat,`+
+				` ln_invoice, paid_flag, timestamp, expire_time) VALUES ($1, 10, 1, 10000, 1000, '', 'Y', $2, $2);`,
+				cardId, 1_000_000+i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := Db_reserve_card_payment(db, cardId, 1, 1, "lnbc_bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
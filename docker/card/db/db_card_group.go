@@ -0,0 +1,311 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"card/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCardGroupNotFound is returned when a group_tag has no card_groups row.
+var ErrCardGroupNotFound = errors.New("card group not found")
+
+// ReceiptSettledByGroupInitial marks the receipt that funds a new card with its
+// group's initial balance.
+const ReceiptSettledByGroupInitial = "group_initial"
+
+// CardGroup holds a group's description and the defaults that new cards in the
+// group inherit. ExpiresAt applies to every card in the group that has no
+// expiry of its own. The service fees apply to every card in the group and
+// are credited to FeeHouseAccount, or DefaultServiceFeeHouseAccount when empty.
+type CardGroup struct {
+	GroupTag           string
+	Description        string
+	TxLimitSats        int
+	DayLimitSats       int
+	InitialBalanceSats int
+	ExpiresAt          int
+	LnAddressEnabled   string
+	CreatedAt          int
+	SpendFeeBaseSats   int
+	SpendFeePpm        int
+	TopupFeeBaseSats   int
+	TopupFeePpm        int
+	FeeHouseAccount    string
+	Receive            ReceiveSettings // for every card in the group without its own
+}
+
+type CardGroups []CardGroup
+
+// CardGroupTotals summarises the cards in a group. Liability is the sum of the
+// positive card balances; spend is what the cards have paid out over lightning.
+// The service fees are those charged on spends and on top-ups.
+type CardGroupTotals struct {
+	GroupTag      string
+	Cards         int
+	ActiveCards   int
+	LiabilitySats int
+	SpentSats     int
+	SpendFeeSats  int
+	TopupFeeSats  int
+}
+
+const cardGroupColumns = `group_tag, description, tx_limit_sats, day_limit_sats,` +
+	` initial_balance_sats, expires_at, ln_address_enabled, created_at,` +
+	` spend_fee_base_sats, spend_fee_ppm, topup_fee_base_sats, topup_fee_ppm, fee_house_account,` +
+	` receive_min_sats, receive_max_sats, max_balance_sats, ln_description, ln_image`
+
+func scanCardGroup(row interface{ Scan(...any) error }, g *CardGroup) error {
+	return row.Scan(&g.GroupTag, &g.Description, &g.TxLimitSats, &g.DayLimitSats,
+		&g.InitialBalanceSats, &g.ExpiresAt, &g.LnAddressEnabled, &g.CreatedAt,
+		&g.SpendFeeBaseSats, &g.SpendFeePpm, &g.TopupFeeBaseSats, &g.TopupFeePpm, &g.FeeHouseAccount,
+		&g.Receive.MinSats, &g.Receive.MaxSats, &g.Receive.MaxBalanceSats,
+		&g.Receive.Description, &g.Receive.Image)
+}
+
+// Db_select_card_groups returns every card group, ordered by tag.
+func Db_select_card_groups(db_conn *sql.DB) CardGroups {
+	var groups CardGroups
+
+	sqlStatement := `SELECT ` + cardGroupColumns + ` FROM card_groups ORDER BY group_tag;`
+	rows, err := db_conn.Query(sqlStatement)
+	if err != nil {
+		log.Error("db_select_card_groups query error: ", err)
+		return groups
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g CardGroup
+		if err := scanCardGroup(rows, &g); err != nil {
+			log.Error("db_select_card_groups scan error: ", err)
+			return groups
+		}
+		groups = append(groups, g)
+	}
+
+	return groups
+}
+
+// Db_get_card_group returns one card group, or ErrCardGroupNotFound.
+func Db_get_card_group(db_conn *sql.DB, groupTag string) (CardGroup, error) {
+	var g CardGroup
+
+	sqlStatement := `SELECT ` + cardGroupColumns + ` FROM card_groups WHERE group_tag = $1;`
+	err := scanCardGroup(db_conn.QueryRow(sqlStatement, groupTag), &g)
+	if err == sql.ErrNoRows {
+		return g, ErrCardGroupNotFound
+	}
+	if err != nil {
+		log.Error("db_get_card_group error: ", err)
+	}
+	return g, err
+}
+
+// Db_set_card_group creates or updates a card group. Changing the defaults
+// does not alter cards already in the group, except for the expiry.
+func Db_set_card_group(db_conn *sql.DB, g CardGroup) error {
+	sqlStatement := `INSERT INTO card_groups (` + cardGroupColumns + `)` +
+		` VALUES ($1, $2, $3, $4, $5, $6, $7, unixepoch(), $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)` +
+		` ON CONFLICT(group_tag) DO UPDATE SET description = excluded.description,` +
+		` tx_limit_sats = excluded.tx_limit_sats, day_limit_sats = excluded.day_limit_sats,` +
+		` initial_balance_sats = excluded.initial_balance_sats, expires_at = excluded.expires_at,` +
+		` ln_address_enabled = excluded.ln_address_enabled,` +
+		` spend_fee_base_sats = excluded.spend_fee_base_sats, spend_fee_ppm = excluded.spend_fee_ppm,` +
+		` topup_fee_base_sats = excluded.topup_fee_base_sats, topup_fee_ppm = excluded.topup_fee_ppm,` +
+		` fee_house_account = excluded.fee_house_account,` +
+		` receive_min_sats = excluded.receive_min_sats, receive_max_sats = excluded.receive_max_sats,` +
+		` max_balance_sats = excluded.max_balance_sats, ln_description = excluded.ln_description,` +
+		` ln_image = excluded.ln_image;`
+	_, err := db_conn.Exec(sqlStatement, g.GroupTag, g.Description, g.TxLimitSats, g.DayLimitSats,
+		g.InitialBalanceSats, g.ExpiresAt, g.LnAddressEnabled,
+		g.SpendFeeBaseSats, g.SpendFeePpm, g.TopupFeeBaseSats, g.TopupFeePpm, g.FeeHouseAccount,
+		g.Receive.MinSats, g.Receive.MaxSats, g.Receive.MaxBalanceSats, g.Receive.Description, g.Receive.Image)
+	if err != nil {
+		log.Error("db_set_card_group error: ", err)
+	}
+	return err
+}
+
+// Db_insert_group_card inserts a card that takes its limits and lightning
+// address policy from its group, creating the group with the default settings
+// if it does not exist yet. The card is funded with the group's initial
+// balance, if it has one. Returns the new card_id.
+func Db_insert_group_card(db_conn *sql.DB, keys CardKeys, login string, password string,
+	uid string, groupTag string) (int, error) {
+
+	cardId := 0
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+
+		if groupTag != "" {
+			_, err := conn.ExecContext(ctx, `INSERT OR IGNORE INTO card_groups (group_tag, created_at)`+
+				` VALUES ($1, unixepoch());`, groupTag)
+			if err != nil {
+				return err
+			}
+		}
+
+		txLimit, dayLimit, initialBalanceSats, lnAddressEnabled := 1000000, 0, 0, "Y"
+		err := conn.QueryRowContext(ctx, `SELECT tx_limit_sats, day_limit_sats, initial_balance_sats,`+
+			` ln_address_enabled FROM card_groups WHERE group_tag = $1 AND group_tag != '';`, groupTag).
+			Scan(&txLimit, &dayLimit, &initialBalanceSats, &lnAddressEnabled)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		// lnurlw_enable and status set explicitly — see Db_insert_card.
+		res, err := conn.ExecContext(ctx, `INSERT INTO cards (key0_auth, key1_enc,`+
+			` key2_cmac, key3, key4, login, password, uid, group_tag, ln_address, lnurlw_enable,`+
+			` status, status_changed_at, tx_limit_sats, day_limit_sats, ln_address_enabled)`+
+			` VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'Y', 'pending_programming', unixepoch(),`+
+			` $11, $12, $13);`,
+			keys.Key0, keys.Key1, keys.Key2, keys.Key3, keys.Key4, login, password, uid, groupTag,
+			"c."+randomHex8(), txLimit, dayLimit, lnAddressEnabled)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		cardId = int(id)
+
+		if initialBalanceSats > 0 {
+			// a unique r_hash_hex is required; there is no invoice behind it
+			_, err = conn.ExecContext(ctx, `INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex,`+
+				` amount_sats, amount_msat, paid_flag, timestamp, expire_time, settled_by, settled_at)`+
+				` VALUES ($1, '', $2, $3, $3 * 1000, 'Y', unixepoch(), unixepoch(), $4, unixepoch());`,
+				cardId, util.Random_hex(), initialBalanceSats, ReceiptSettledByGroupInitial)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Error("db_insert_group_card error: ", err)
+		return 0, err
+	}
+
+	return cardId, nil
+}
+
+// Db_select_card_group_totals returns the totals for every group that has
+// cards or a card_groups row.
+func Db_select_card_group_totals(db_conn *sql.DB) []CardGroupTotals {
+	var totals []CardGroupTotals
+
+	sqlStatement := `SELECT t.group_tag,` +
+		` (SELECT COUNT(*) FROM cards c WHERE c.group_tag = t.group_tag),` +
+		` (SELECT COUNT(*) FROM cards c WHERE c.group_tag = t.group_tag AND c.status = 'active'),` +
+		` (SELECT IFNULL(SUM(c.balance_msat), 0) FROM cards c WHERE c.group_tag = t.group_tag AND c.balance_msat > 0),` +
+		` IFNULL((SELECT SUM(p.amount_msat + p.fee_msat) FROM card_payments p JOIN cards c ON c.card_id = p.card_id` +
+		` WHERE c.group_tag = t.group_tag AND p.paid_flag = 'Y' AND p.ln_invoice != ''), 0),` +
+		` IFNULL((SELECT SUM(p.amount_msat) FROM card_payments p JOIN cards c ON c.card_id = p.card_id` +
+		` WHERE c.group_tag = t.group_tag AND p.paid_flag = 'Y' AND p.kind = 'service_fee'` +
+		` AND p.related_payment_id != 0), 0),` +
+		` IFNULL((SELECT SUM(p.amount_msat) FROM card_payments p JOIN cards c ON c.card_id = p.card_id` +
+		` WHERE c.group_tag = t.group_tag AND p.paid_flag = 'Y' AND p.kind = 'service_fee'` +
+		` AND p.related_receipt_id != 0), 0)` +
+		` FROM (SELECT group_tag FROM card_groups UNION SELECT group_tag FROM cards WHERE group_tag != '') t` +
+		` ORDER BY t.group_tag;`
+	rows, err := db_conn.Query(sqlStatement)
+	if err != nil {
+		log.Error("db_select_card_group_totals query error: ", err)
+		return totals
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t CardGroupTotals
+		var liabilityMsat, spentMsat, spendFeeMsat, topupFeeMsat int64
+		if err := rows.Scan(&t.GroupTag, &t.Cards, &t.ActiveCards, &liabilityMsat, &spentMsat,
+			&spendFeeMsat, &topupFeeMsat); err != nil {
+			log.Error("db_select_card_group_totals scan error: ", err)
+			return totals
+		}
+		// summed in msat and converted to sats once
+		t.LiabilitySats = MsatToSatsDown(liabilityMsat)
+		t.SpentSats = MsatToSatsUp(spentMsat)
+		t.SpendFeeSats = MsatToSatsUp(spendFeeMsat)
+		t.TopupFeeSats = MsatToSatsUp(topupFeeMsat)
+		totals = append(totals, t)
+	}
+
+	return totals
+}
+
+// Db_get_card_group_totals returns the totals for one group.
+func Db_get_card_group_totals(db_conn *sql.DB, groupTag string) CardGroupTotals {
+	for _, t := range Db_select_card_group_totals(db_conn) {
+		if t.GroupTag == groupTag {
+			return t
+		}
+	}
+	return CardGroupTotals{GroupTag: groupTag}
+}
+
+// Db_set_group_lnurlw_enable turns withdrawals on or off ("Y"/"N") for every
+// card in a group that has not been wiped or replaced. Returns the number of
+// cards changed.
+func Db_set_group_lnurlw_enable(db_conn *sql.DB, groupTag string, enable string) (int, error) {
+	sqlStatement := `UPDATE cards SET lnurlw_enable = $1` +
+		` WHERE group_tag = $2 AND group_tag != '' AND lnurlw_enable != $1` +
+		` AND status NOT IN ('wiped', 'replaced');`
+	res, err := db_conn.Exec(sqlStatement, enable, groupTag)
+	if err != nil {
+		log.Error("db_set_group_lnurlw_enable error: ", err)
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Error("db_set_group_lnurlw_enable rows affected error: ", err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// Db_allocate_group credits every card in a group that can receive with
+// amountSats, in one transaction. Returns the number of cards credited.
+func Db_allocate_group(db_conn *sql.DB, groupTag string, amountSats int) (int, error) {
+	credited := 0
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		rows, err := conn.QueryContext(ctx, `SELECT card_id FROM cards`+
+			` WHERE group_tag = $1 AND group_tag != ''`+
+			` AND status IN ('pending_programming', 'active', 'suspended') ORDER BY card_id;`, groupTag)
+		if err != nil {
+			return err
+		}
+		var cardIds []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			cardIds = append(cardIds, id)
+		}
+		rows.Close()
+
+		for _, id := range cardIds {
+			_, err := conn.ExecContext(ctx, `INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex,`+
+				` amount_sats, amount_msat, paid_flag, timestamp, expire_time, settled_by, settled_at)`+
+				` VALUES ($1, '', $2, $3, $3 * 1000, 'Y', unixepoch(), unixepoch(), 'group_allocate', unixepoch());`,
+				id, util.Random_hex(), amountSats)
+			if err != nil {
+				return err
+			}
+			credited++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("db_allocate_group error: ", err)
+		return 0, err
+	}
+	return credited, nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import "testing"
+
+// TestInsertGroupCard_InheritsGroupDefaults verifies a new card takes its
+// group's limits, lightning address policy and initial balance.
+func TestInsertGroupCard_InheritsGroupDefaults(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+
+	err := Db_set_card_group(db, CardGroup{GroupTag: "fest", Description: "summer festival",
+		TxLimitSats: 5000, DayLimitSats: 20000, InitialBalanceSats: 1500, LnAddressEnabled: "N"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := CardKeys{Key0: "k0", Key1: "k1", Key2: "k2", Key3: "k3", Key4: "k4"}
+	id, err := Db_insert_group_card(db, keys, "login1", "pass1", "uid1", "fest")
+	if err != nil || id == 0 {
+		t.Fatalf("insert failed: id=%d err=%v", id, err)
+	}
+
+	card, err := Db_get_card(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Tx_limit_sats != 5000 || card.Day_limit_sats != 20000 || card.Ln_address_enabled != "N" {
+		t.Fatalf("card did not inherit group defaults: %+v", card)
+	}
+	if b := Db_get_card_balance(db, id); b != 1500 {
+		t.Fatalf("expected group initial balance 1500, got %d", b)
+	}
+
+	// a new tag gets a group with the default settings
+	id3, _ := Db_insert_group_card(db, keys, "login3", "pass3", "uid3", "newtag")
+	if _, err := Db_get_card_group(db, "newtag"); err != nil {
+		t.Fatalf("expected group created for new tag, got %v", err)
+	}
+	if card, _ := Db_get_card(db, id3); card.Tx_limit_sats != 1000000 || Db_get_card_balance(db, id3) != 0 {
+		t.Fatalf("unexpected defaults for new group card: %+v", card)
+	}
+}
+
+// TestCardGroupBulkOps verifies allocation skips cards that cannot receive,
+// and the totals and enable/disable cover the group's cards only.
+func TestCardGroupBulkOps(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+
+	keys := CardKeys{Key0: "k0", Key1: "k1", Key2: "k2", Key3: "k3", Key4: "k4"}
+	a, _ := Db_insert_group_card(db, keys, "la", "pa", "uida", "fest")
+	b, _ := Db_insert_group_card(db, keys, "lb", "pb", "uidb", "fest")
+	c, _ := Db_insert_group_card(db, keys, "lc", "pc", "uidc", "other")
+	db.Exec(`UPDATE cards SET status='expired' WHERE card_id=$1`, b)
+
+	count, err := Db_allocate_group(db, "fest", 2500)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one card credited, got %d err=%v", count, err)
+	}
+	if Db_get_card_balance(db, a) != 2500 || Db_get_card_balance(db, b) != 0 || Db_get_card_balance(db, c) != 0 {
+		t.Fatal("allocation credited the wrong cards")
+	}
+
+	Db_add_card_payment(db, a, 400, "lnbc_spend")
+	totals := Db_get_card_group_totals(db, "fest")
+	if totals.Cards != 2 || totals.LiabilitySats != 2100 || totals.SpentSats != 400 {
+		t.Fatalf("unexpected totals %+v", totals)
+	}
+
+	// sub-sat payments are summed before converting to sats
+	for _, invoice := range []string{"lnbc_half1", "lnbc_half2"} {
+		if _, _, err := Db_reserve_card_payment_msat(db, a, 500, 0, invoice); err != nil {
+			t.Fatalf("reserve %s: %v", invoice, err)
+		}
+	}
+	totals = Db_get_card_group_totals(db, "fest")
+	if totals.LiabilitySats != 2099 || totals.SpentSats != 401 {
+		t.Fatalf("unexpected totals after sub-sat payments %+v", totals)
+	}
+
+	count, err = Db_set_group_lnurlw_enable(db, "fest", "N")
+	if err != nil || count != 2 {
+		t.Fatalf("expected two cards disabled, got %d err=%v", count, err)
+	}
+	if Db_get_card_lnurlw_enable(db, a) != "N" || Db_get_card_lnurlw_enable(db, c) != "Y" {
+		t.Fatal("disable changed the wrong cards")
+	}
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCardKeyGracePeriodSec is how long a key set replaced through the
+// wallet API is still accepted on taps, unless the card_key_grace_period_sec
+// setting says otherwise. The grace ends early once the card is tapped with
+// its current keys.
+const DefaultCardKeyGracePeriodSec = 7 * 24 * 60 * 60
+
+// card_key_history reasons
+const (
+	KeyRetiredWalletRotation = "wallet key rotation"
+	KeyRetiredRekeyConfirmed = "rekey confirmed"
+)
+
+// cardKeyGracePeriod reads the grace period in seconds.
+func cardKeyGracePeriod(db_conn *sql.DB) int64 {
+	if v := Db_get_setting(db_conn, "card_key_grace_period_sec"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return DefaultCardKeyGracePeriodSec
+}
+
+// archiveCardKeysTx copies a card's current key set, and its pending set when
+// a rotation is in progress, into card_key_history inside a transaction.
+// Both are accepted on taps until acceptUntil (0 for not at all).
+func archiveCardKeysTx(ctx context.Context, conn *sql.Conn, cardId int, withPending bool,
+	acceptUntil int64, reason string, now int64) error {
+
+	_, err := conn.ExecContext(ctx,
+		`INSERT INTO card_key_history (card_id, key_version, key0_auth, key1_enc, key2_cmac,`+
+			` key3, key4, retired_at, accept_until, reason)`+
+			` SELECT card_id, key_version, key0_auth, key1_enc, key2_cmac, key3, key4, $1, $2, $3`+
+			` FROM cards WHERE card_id = $4 AND key1_enc != '';`,
+		now, acceptUntil, reason, cardId)
+	if err != nil || !withPending {
+		return err
+	}
+	_, err = conn.ExecContext(ctx,
+		`INSERT INTO card_key_history (card_id, key_version, key0_auth, key1_enc, key2_cmac,`+
+			` key3, key4, retired_at, accept_until, reason)`+
+			` SELECT card_id, pending_key_version, pending_key0_auth, pending_key1_enc,`+
+			` pending_key2_cmac, pending_key3, pending_key4, $1, $2, $3`+
+			` FROM cards WHERE card_id = $4 AND pending_key1_enc != '';`,
+		now, acceptUntil, reason, cardId)
+	return err
+}
+
+// Db_end_card_key_grace stops accepting a card's previous key sets. It is
+// called on a tap made with the current keys, which proves the chip holds
+// them.
+func Db_end_card_key_grace(db_conn *sql.DB, cardId int) {
+	sqlStatement := `UPDATE card_key_history SET accept_until = 0` +
+		` WHERE card_id = $1 AND accept_until != 0;`
+	_, err := db_conn.Exec(sqlStatement, cardId)
+	if err != nil {
+		log.Error("db_end_card_key_grace error: ", err)
+	}
+}
+
+// Db_get_card_chip_keys returns the key set the card's chip is believed to
+// hold: the set used by its most recent matched tap, or the current keys if
+// that was the current set or the card has never been tapped.
+func Db_get_card_chip_keys(db_conn *sql.DB, cardId int) CardKeys {
+	var keys CardKeys
+
+	sqlStatement := `SELECT h.key0_auth, h.key1_enc, h.key2_cmac, h.key3, h.key4` +
+		` FROM card_key_history h` +
+		` WHERE h.card_id = $1 AND h.key_version =` +
+		` (SELECT key_version FROM card_taps WHERE card_id = $1 AND key_version != 0` +
+		` ORDER BY tap_id DESC LIMIT 1)` +
+		` AND h.key_version != (SELECT key_version FROM cards WHERE card_id = $1)` +
+		` ORDER BY h.card_key_history_id DESC LIMIT 1;`
+	err := db_conn.QueryRow(sqlStatement, cardId).Scan(
+		&keys.Key0, &keys.Key1, &keys.Key2, &keys.Key3, &keys.Key4)
+	if err == nil {
+		return keys
+	}
+	if err != sql.ErrNoRows {
+		log.Error("db_get_card_chip_keys history error: ", err)
+	}
+
+	sqlStatement = `SELECT key0_auth, key1_enc, key2_cmac, key3, key4 FROM cards WHERE card_id = $1;`
+	if err := db_conn.QueryRow(sqlStatement, cardId).Scan(
+		&keys.Key0, &keys.Key1, &keys.Key2, &keys.Key3, &keys.Key4); err != nil {
+		log.Error("db_get_card_chip_keys error: ", err)
+		return CardKeys{}
+	}
+	return keys
+}
+
+// CardKeyHistoryEntry is a key set a card used to have. Key values are not
+// included.
+type CardKeyHistoryEntry struct {
+	KeyVersion  int
+	RetiredAt   int
+	AcceptUntil int // taps with this set are accepted until then, 0 if not at all
+	Reason      string
+}
+
+// Db_select_card_key_history returns a card's previous key sets, most
+// recently retired first.
+func Db_select_card_key_history(db_conn *sql.DB, cardId int) []CardKeyHistoryEntry {
+	var entries []CardKeyHistoryEntry
+
+	sqlStatement := `SELECT key_version, retired_at, accept_until, reason` +
+		` FROM card_key_history WHERE card_id = $1` +
+		` ORDER BY card_key_history_id DESC;`
+	rows, err := db_conn.Query(sqlStatement, cardId)
+	if err != nil {
+		log.Error("db_select_card_key_history query error: ", err)
+		return entries
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e CardKeyHistoryEntry
+		if err := rows.Scan(&e.KeyVersion, &e.RetiredAt, &e.AcceptUntil, &e.Reason); err != nil {
+			log.Error("db_select_card_key_history scan error: ", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Card list sort orders.
+const (
+	CardSortId       = "id"
+	CardSortBalance  = "balance"
+	CardSortActivity = "activity"
+)
+
+// CardListQuery selects a page of cards. Empty fields do not filter; a Limit
+// of 0 returns every matching card.
+type CardListQuery struct {
+	Statuses   []string // default: every card that has not been wiped
+	GroupTag   string
+	MinBalance *int
+	MaxBalance *int
+	Search     string // matches note, uid or lightning address
+	Sort       string // CardSortId (default), CardSortBalance or CardSortActivity
+	Asc        bool   // default is descending
+	Limit      int
+	Offset     int
+}
+
+// cardListSQL is every card with its stored balance and last activity. The
+// last activity is served from the covering indexes on card_receipts and
+// card_payments.
+const cardListSQL = `SELECT c.card_id, c.uid, c.note, c.lnurlw_enable, c.wiped, c.status,` +
+	` IFNULL(c.group_tag, '') AS group_tag, c.tx_limit_sats, c.day_limit_sats, c.ln_address,` +
+	` c.balance_sats,` +
+	` MAX(IFNULL((SELECT MAX(timestamp) FROM card_receipts WHERE paid_flag='Y' AND card_id=c.card_id), 0),` +
+	` IFNULL((SELECT MAX(timestamp) FROM card_payments WHERE paid_flag='Y' AND card_id=c.card_id), 0))` +
+	` AS last_activity_at` +
+	` FROM cards c`
+
+// Db_select_cards_page returns a page of cards matching q and the number of
+// cards that match in total.
+func Db_select_cards_page(db_conn *sql.DB, q CardListQuery) ([]CardSummary, int) {
+	var cards []CardSummary
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(q.Statuses) > 0 {
+		placeholders := make([]string, len(q.Statuses))
+		for i, status := range q.Statuses {
+			placeholders[i] = arg(status)
+		}
+		where = append(where, `c.status IN (`+strings.Join(placeholders, ", ")+`)`)
+	} else {
+		where = append(where, `c.wiped = 'N'`)
+	}
+	if q.GroupTag != "" {
+		where = append(where, `c.group_tag = `+arg(q.GroupTag))
+	}
+	if q.Search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q.Search)
+		pattern := arg("%" + escaped + "%")
+		where = append(where, `(c.note LIKE `+pattern+` ESCAPE '\' OR c.uid LIKE `+pattern+
+			` ESCAPE '\' OR c.ln_address LIKE `+pattern+` ESCAPE '\' OR c.ln_alias LIKE `+pattern+
+			` ESCAPE '\')`)
+	}
+	if q.MinBalance != nil {
+		where = append(where, `c.balance_sats >= `+arg(*q.MinBalance))
+	}
+	if q.MaxBalance != nil {
+		where = append(where, `c.balance_sats <= `+arg(*q.MaxBalance))
+	}
+
+	sqlStatement := `SELECT * FROM (` + cardListSQL + ` WHERE ` + strings.Join(where, " AND ") + `)`
+
+	total := 0
+	if err := db_conn.QueryRow(`SELECT COUNT(*) FROM (`+sqlStatement+`)`, args...).Scan(&total); err != nil {
+		log.Error("db_select_cards_page count error: ", err)
+		return cards, 0
+	}
+
+	direction := " DESC"
+	if q.Asc {
+		direction = " ASC"
+	}
+	switch q.Sort {
+	case CardSortBalance:
+		sqlStatement += ` ORDER BY balance_sats` + direction + `, card_id` + direction
+	case CardSortActivity:
+		sqlStatement += ` ORDER BY last_activity_at` + direction + `, card_id` + direction
+	default:
+		sqlStatement += ` ORDER BY card_id` + direction
+	}
+	if q.Limit > 0 {
+		sqlStatement += ` LIMIT ` + arg(q.Limit) + ` OFFSET ` + arg(q.Offset)
+	}
+
+	rows, err := db_conn.Query(sqlStatement, args...)
+	if err != nil {
+		log.Error("db_select_cards_page query error: ", err)
+		return cards, total
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cs CardSummary
+		err := rows.Scan(
+			&cs.CardId, &cs.Uid, &cs.Note, &cs.LnurlwEnable,
+			&cs.Wiped, &cs.Status, &cs.GroupTag, &cs.TxLimitSats, &cs.DayLimitSats,
+			&cs.LnAddress, &cs.BalanceSats, &cs.LastActivityAt)
+		if err != nil {
+			log.Error("db_select_cards_page scan error: ", err)
+			continue
+		}
+		cards = append(cards, cs)
+	}
+
+	return cards, total
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestSelectCardsPage verifies the filters, sort orders and paging of the
+// card list, and that the total counts every matching card.
+func TestSelectCardsPage(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+
+	keys := CardKeys{Key0: "k0", Key1: "k1", Key2: "k2", Key3: "k3", Key4: "k4"}
+	var ids []int
+	for i, group := range []string{"fest", "fest", "fest", "other"} {
+		id, err := Db_insert_group_card(db, keys, "login"+strconv.Itoa(i), "pass",
+			"04AABBCCDDEE0"+strconv.Itoa(i+1), group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	// balances 300, 100, 200, 0; the second card was used most recently
+	for i, amount := range []int{300, 100, 200} {
+		Db_add_card_receipt(db, ids[i], "", "listfund"+strconv.Itoa(i), amount)
+		Db_set_receipt_paid(db, "listfund"+strconv.Itoa(i), "test")
+	}
+	db.Exec(`UPDATE card_receipts SET timestamp = timestamp + 1000 WHERE card_id = $1`, ids[1])
+	Db_update_card_note(db, ids[2], "Guest 50%_off")
+
+	cards, total := Db_select_cards_page(db, CardListQuery{GroupTag: "fest", Sort: CardSortBalance, Limit: 2})
+	if total != 3 || len(cards) != 2 || cards[0].CardId != ids[0] || cards[1].CardId != ids[2] {
+		t.Fatalf("unexpected first page by balance: total=%d %+v", total, cards)
+	}
+	cards, _ = Db_select_cards_page(db, CardListQuery{GroupTag: "fest", Sort: CardSortBalance, Limit: 2, Offset: 2})
+	if len(cards) != 1 || cards[0].CardId != ids[1] {
+		t.Fatalf("unexpected second page by balance: %+v", cards)
+	}
+
+	cards, _ = Db_select_cards_page(db, CardListQuery{Sort: CardSortActivity})
+	if len(cards) != 4 || cards[0].CardId != ids[1] || cards[0].LastActivityAt == 0 || cards[3].LastActivityAt != 0 {
+		t.Fatalf("unexpected order by activity: %+v", cards)
+	}
+
+	min, max := 150, 250
+	cards, total = Db_select_cards_page(db, CardListQuery{MinBalance: &min, MaxBalance: &max})
+	if total != 1 || cards[0].CardId != ids[2] {
+		t.Fatalf("unexpected balance range result: %+v", cards)
+	}
+
+	// search covers the note, uid and lightning address; % and _ are literal
+	if cards, _ = Db_select_cards_page(db, CardListQuery{Search: "50%_"}); len(cards) != 1 || cards[0].CardId != ids[2] {
+		t.Fatalf("unexpected note search result: %+v", cards)
+	}
+	if cards, _ = Db_select_cards_page(db, CardListQuery{Search: "%"}); len(cards) != 1 {
+		t.Fatalf("expected a literal %% search to match one note, got %+v", cards)
+	}
+	if cards, _ = Db_select_cards_page(db, CardListQuery{Search: "ddee04"}); len(cards) != 1 || cards[0].CardId != ids[3] {
+		t.Fatalf("unexpected uid search result: %+v", cards)
+	}
+	card, _ := Db_get_card(db, ids[0])
+	if cards, _ = Db_select_cards_page(db, CardListQuery{Search: card.Ln_address}); len(cards) != 1 || cards[0].CardId != ids[0] {
+		t.Fatalf("unexpected lightning address search result: %+v", cards)
+	}
+}
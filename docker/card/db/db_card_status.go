@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// card lifecycle statuses
+const (
+	CardStatusPendingProgramming = "pending_programming" // created, not yet tapped
+	CardStatusActive             = "active"
+	CardStatusSuspended          = "suspended"
+	CardStatusLost               = "lost"
+	CardStatusExpired            = "expired"
+	CardStatusWiped              = "wiped"
+	CardStatusReplaced           = "replaced" // superseded by a replacement card
+)
+
+// CardStatuses lists every card status.
+var CardStatuses = []string{
+	CardStatusPendingProgramming, CardStatusActive, CardStatusSuspended,
+	CardStatusLost, CardStatusExpired, CardStatusWiped, CardStatusReplaced,
+}
+
+// ErrCardNotActive is returned when a card's status does not allow the
+// requested operation.
+var ErrCardNotActive = errors.New("card not active")
+
+// ErrCardStatusTransition is returned when a status change is not one of the
+// allowed transitions.
+var ErrCardStatusTransition = errors.New("card status transition not allowed")
+
+// cardStatusTransitions lists the statuses each status may move to. Wiped and
+// replaced are final.
+var cardStatusTransitions = map[string][]string{
+	CardStatusPendingProgramming: {CardStatusActive, CardStatusSuspended, CardStatusLost, CardStatusExpired, CardStatusWiped, CardStatusReplaced},
+	CardStatusActive:             {CardStatusSuspended, CardStatusLost, CardStatusExpired, CardStatusWiped, CardStatusReplaced},
+	CardStatusSuspended:          {CardStatusActive, CardStatusLost, CardStatusExpired, CardStatusWiped, CardStatusReplaced},
+	CardStatusLost:               {CardStatusActive, CardStatusWiped, CardStatusReplaced},
+	CardStatusExpired:            {CardStatusActive, CardStatusWiped, CardStatusReplaced},
+	CardStatusWiped:              {},
+	CardStatusReplaced:           {},
+}
+
+// Card_status_is_valid reports whether status is a known card status.
+func Card_status_is_valid(status string) bool {
+	_, ok := cardStatusTransitions[status]
+	return ok
+}
+
+// Card_status_transition_allowed reports whether a card may move from one
+// status to another.
+func Card_status_transition_allowed(from string, to string) bool {
+	for _, s := range cardStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Card_status_allows_withdraw reports whether a card in this status may spend
+// its balance (card taps, wallet payments).
+func Card_status_allows_withdraw(status string) bool {
+	return status == CardStatusPendingProgramming || status == CardStatusActive
+}
+
+// Card_status_allows_payout reports whether a card in this status may sweep
+// its balance to the holder's own wallet. An expired card may, until its
+// balance is forfeited.
+func Card_status_allows_payout(status string) bool {
+	return Card_status_allows_withdraw(status) || status == CardStatusExpired
+}
+
+// Card_status_allows_receive reports whether a card in this status may be
+// paid (lightning address, wallet invoices, admin allocation). A suspended
+// card still receives so that payments in flight are not refused.
+func Card_status_allows_receive(status string) bool {
+	return status == CardStatusPendingProgramming || status == CardStatusActive ||
+		status == CardStatusSuspended
+}
+
+// CardStatusChange is one entry in a card's status history.
+type CardStatusChange struct {
+	HistoryId  int
+	CardId     int
+	FromStatus string
+	ToStatus   string
+	Reason     string
+	Actor      string
+	Timestamp  int
+}
+
+type CardStatusChanges []CardStatusChange
+
+// Db_get_card_status returns a card's status, or an empty string if the card does not exist.
+func Db_get_card_status(db_conn *sql.DB, cardId int) string {
+	sqlStatement := `SELECT status FROM cards WHERE card_id=$1;`
+	row := db_conn.QueryRow(sqlStatement, cardId)
+	value := ""
+	err := row.Scan(&value)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// Db_set_card_status moves a card to a new status, recording the reason and
+// who made the change (admin, wallet, system, ...) in card_status_history.
+// Setting the status a card already has is a no-op.
+func Db_set_card_status(db_conn *sql.DB, cardId int, status string, reason string, actor string) error {
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+
+		var current string
+		err := conn.QueryRowContext(ctx,
+			`SELECT status FROM cards WHERE card_id=$1;`, cardId).Scan(&current)
+		if err != nil {
+			return err
+		}
+
+		if current == status {
+			return nil
+		}
+		if !Card_status_transition_allowed(current, status) {
+			return ErrCardStatusTransition
+		}
+
+		now := time.Now().Unix()
+
+		_, err = conn.ExecContext(ctx,
+			`UPDATE cards SET status = $1, status_reason = $2, status_changed_at = $3,`+
+				` wiped = CASE WHEN $1 = 'wiped' THEN 'Y' ELSE wiped END`+
+				` WHERE card_id = $4;`, status, reason, now, cardId)
+		if err != nil {
+			return err
+		}
+
+		// a wiped card's wallet sessions end with it, and its alias is
+		// released for others to claim
+		if status == CardStatusWiped {
+			if err := revokeTokensTx(ctx, conn, "card_id", cardId, TokenRevokedWiped, now); err != nil {
+				return err
+			}
+			_, err = conn.ExecContext(ctx, `UPDATE cards SET ln_alias = '' WHERE card_id = $1;`, cardId)
+			if err != nil {
+				return err
+			}
+		}
+
+		return insertCardStatusHistory(ctx, conn, cardId, current, status, reason, actor, now)
+	})
+	if err != nil && !errors.Is(err, ErrCardStatusTransition) {
+		log.Error("db_set_card_status error: ", err)
+	}
+	return err
+}
+
+// insertCardStatusHistory records a status change inside a transaction.
+func insertCardStatusHistory(ctx context.Context, conn *sql.Conn, cardId int,
+	from string, to string, reason string, actor string, timestamp int64) error {
+
+	_, err := conn.ExecContext(ctx,
+		`INSERT INTO card_status_history (card_id, from_status, to_status,`+
+			` reason, actor, timestamp) VALUES ($1, $2, $3, $4, $5, $6);`,
+		cardId, from, to, reason, actor, timestamp)
+	return err
+}
+
+// Db_select_card_status_history returns a card's status changes, most recent
+// first.
+func Db_select_card_status_history(db_conn *sql.DB, cardId int) CardStatusChanges {
+	var changes CardStatusChanges
+
+	sqlStatement := `SELECT history_id, card_id, from_status, to_status,` +
+		` reason, actor, timestamp` +
+		` FROM card_status_history WHERE card_id=$1` +
+		` ORDER BY history_id DESC;`
+	rows, err := db_conn.Query(sqlStatement, cardId)
+	if err != nil {
+		log.Error("db_select_card_status_history query error: ", err)
+		return changes
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ch CardStatusChange
+		err := rows.Scan(
+			&ch.HistoryId,
+			&ch.CardId,
+			&ch.FromStatus,
+			&ch.ToStatus,
+			&ch.Reason,
+			&ch.Actor,
+			&ch.Timestamp,
+		)
+		if err != nil {
+			log.Error("db_select_card_status_history scan error: ", err)
+			return changes
+		}
+		changes = append(changes, ch)
+	}
+
+	return changes
+}
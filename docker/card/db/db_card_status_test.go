@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCardStatus_NewCardPendingProgramming(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	Db_insert_card(db, "k0", "k1", "k2", "k3", "k4", "login1", "pass1")
+
+	if status := Db_get_card_status(db, 1); status != CardStatusPendingProgramming {
+		t.Fatalf("expected pending_programming, got %q", status)
+	}
+}
+
+func TestCardStatus_TransitionsRecordHistory(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	Db_insert_card(db, "k0", "k1", "k2", "k3", "k4", "login1", "pass1")
+
+	if err := Db_set_card_status(db, 1, CardStatusActive, "first tap", "system"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Db_set_card_status(db, 1, CardStatusLost, "holder reported", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	card, err := Db_get_card(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Status != CardStatusLost || card.Status_reason != "holder reported" || card.Status_changed_at == 0 {
+		t.Fatalf("unexpected card status: %q %q %d", card.Status, card.Status_reason, card.Status_changed_at)
+	}
+	if card.Wiped != "N" {
+		t.Fatal("expected a lost card not to be marked wiped")
+	}
+
+	history := Db_select_card_status_history(db, 1)
+	if len(history) != 2 {
+		t.Fatalf("expected 2 history rows, got %+v", history)
+	}
+	if history[0].FromStatus != CardStatusActive || history[0].ToStatus != CardStatusLost ||
+		history[0].Actor != "admin" || history[0].Reason != "holder reported" {
+		t.Fatalf("unexpected latest history row: %+v", history[0])
+	}
+}
+
+func TestCardStatus_DisallowedTransition(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	Db_insert_card(db, "k0", "k1", "k2", "k3", "k4", "login1", "pass1")
+	Db_set_card_status(db, 1, CardStatusLost, "", "admin")
+
+	err := Db_set_card_status(db, 1, CardStatusSuspended, "", "admin")
+	if !errors.Is(err, ErrCardStatusTransition) {
+		t.Fatalf("expected ErrCardStatusTransition, got %v", err)
+	}
+
+	Db_wipe_card(db, 1)
+	err = Db_set_card_status(db, 1, CardStatusActive, "", "admin")
+	if !errors.Is(err, ErrCardStatusTransition) {
+		t.Fatalf("expected wiped to be final, got %v", err)
+	}
+	if history := Db_select_card_status_history(db, 1); len(history) != 2 {
+		t.Fatalf("expected only allowed changes recorded, got %+v", history)
+	}
+}
+
+func TestCardStatus_SelectCardsByStatus(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	Db_insert_card(db, "a0", "a1", "a2", "a3", "a4", "login1", "pass1")
+	Db_insert_card(db, "b0", "b1", "b2", "b3", "b4", "login2", "pass2")
+	Db_insert_card(db, "c0", "c1", "c2", "c3", "c4", "login3", "pass3")
+	Db_set_card_status(db, 2, CardStatusLost, "", "admin")
+	Db_wipe_card(db, 3)
+
+	if cards := Db_select_all_cards(db); len(cards) != 2 {
+		t.Fatalf("expected wiped card left out by default, got %+v", cards)
+	}
+
+	cards := Db_select_cards_by_status(db, []string{CardStatusWiped, CardStatusLost})
+	if len(cards) != 2 || cards[0].CardId != 3 || cards[0].Status != CardStatusWiped ||
+		cards[1].CardId != 2 || cards[1].Status != CardStatusLost {
+		t.Fatalf("unexpected filtered cards: %+v", cards)
+	}
+}
+
+func TestCardStatus_ReserveRejectsInactiveCard(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	Db_add_card_receipt(db, id, "lnbc1", "h1", 10000)
+	Db_set_receipt_paid(db, "h1", "test")
+	Db_set_card_status(db, id, CardStatusSuspended, "", "admin")
+
+	_, _, err := Db_reserve_card_payment(db, id, 100, 100, "lnbc_pay")
+	if !errors.Is(err, ErrCardNotActive) {
+		t.Fatalf("expected ErrCardNotActive, got %v", err)
+	}
+
+	Db_set_card_status(db, id, CardStatusActive, "", "admin")
+	if _, _, err := Db_reserve_card_payment(db, id, 100, 100, "lnbc_pay"); err != nil {
+		t.Fatalf("expected reservation to succeed once active, got %v", err)
+	}
+}
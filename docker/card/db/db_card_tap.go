@@ -0,0 +1,145 @@
+package db
+
+import (
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// card tap outcomes
+const (
+	TapOutcomeOk                    = "ok"
+	TapOutcomeCardNotFound          = "card_not_found"
+	TapOutcomeUidMismatch           = "uid_mismatch"
+	TapOutcomeCounterNotIncremented = "counter_not_incremented"
+	TapOutcomeWithdrawalsDisabled   = "withdrawals_disabled"
+	TapOutcomeCardNotActive         = "card_not_active"
+)
+
+// CardTapLog is a single recorded tap of a card on /ln or /balance-ajax.
+type CardTapLog struct {
+	TapId     int
+	CardId    int
+	Counter   uint32
+	Endpoint  string
+	Outcome   string
+	RemoteIp  string
+	UserAgent string
+	Timestamp int
+
+	KeyVersion   int  // key set the tap matched, 0 if it matched none
+	PreviousKeys bool // matched a replaced key set still in its grace period
+}
+
+type CardTapLogs []CardTapLog
+
+// Db_insert_card_tap records a card tap.
+func Db_insert_card_tap(db_conn *sql.DB, tap CardTapLog) {
+	sqlStatement := `INSERT INTO card_taps (card_id, counter, endpoint, outcome,` +
+		` remote_ip, user_agent, timestamp, key_version, previous_keys)` +
+		` VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+	_, err := db_conn.Exec(sqlStatement, tap.CardId, tap.Counter, tap.Endpoint, tap.Outcome,
+		tap.RemoteIp, tap.UserAgent, tap.Timestamp, tap.KeyVersion, tap.PreviousKeys)
+	if err != nil {
+		log.Error("db_insert_card_tap error: ", err)
+	}
+}
+
+// Db_select_card_taps returns a page of a card's taps, most recent first.
+func Db_select_card_taps(db_conn *sql.DB, cardId int, limit int, offset int) CardTapLogs {
+	var taps CardTapLogs
+
+	sqlStatement := `SELECT tap_id, card_id, counter, endpoint, outcome,` +
+		` remote_ip, user_agent, timestamp, key_version, previous_keys` +
+		` FROM card_taps WHERE card_id=$1` +
+		` ORDER BY tap_id DESC LIMIT $2 OFFSET $3;`
+	rows, err := db_conn.Query(sqlStatement, cardId, limit, offset)
+	if err != nil {
+		log.Error("db_select_card_taps query error: ", err)
+		return taps
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tap CardTapLog
+		err := rows.Scan(
+			&tap.TapId,
+			&tap.CardId,
+			&tap.Counter,
+			&tap.Endpoint,
+			&tap.Outcome,
+			&tap.RemoteIp,
+			&tap.UserAgent,
+			&tap.Timestamp,
+			&tap.KeyVersion,
+			&tap.PreviousKeys,
+		)
+		if err != nil {
+			log.Error("db_select_card_taps scan error: ", err)
+			return taps
+		}
+		taps = append(taps, tap)
+	}
+
+	return taps
+}
+
+// Db_get_card_tap_count returns the number of taps recorded for a card.
+func Db_get_card_tap_count(db_conn *sql.DB, cardId int) int {
+	sqlStatement := `SELECT COUNT(*) FROM card_taps WHERE card_id=$1;`
+	row := db_conn.QueryRow(sqlStatement, cardId)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		log.Error("db_get_card_tap_count error: ", err)
+		return 0
+	}
+	return count
+}
+
+// Db_count_card_taps_since counts a card's taps since the given unix time,
+// limited to one outcome unless outcome is empty.
+func Db_count_card_taps_since(db_conn *sql.DB, cardId int, outcome string, since int64) int {
+	sqlStatement := `SELECT COUNT(*) FROM card_taps WHERE card_id=$1` +
+		` AND timestamp >= $2 AND ($3 = '' OR outcome = $3);`
+	row := db_conn.QueryRow(sqlStatement, cardId, since, outcome)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		log.Error("db_count_card_taps_since error: ", err)
+		return 0
+	}
+	return count
+}
+
+// Db_count_ip_taps_since counts taps from a client address since the given
+// unix time with the given outcome.
+func Db_count_ip_taps_since(db_conn *sql.DB, remoteIp string, outcome string, since int64) int {
+	sqlStatement := `SELECT COUNT(*) FROM card_taps WHERE remote_ip=$1` +
+		` AND timestamp >= $2 AND outcome = $3;`
+	row := db_conn.QueryRow(sqlStatement, remoteIp, since, outcome)
+	count := 0
+	err := row.Scan(&count)
+	if err != nil {
+		log.Error("db_count_ip_taps_since error: ", err)
+		return 0
+	}
+	return count
+}
+
+// Db_delete_card_taps_before removes taps recorded before the given unix
+// time and returns the number removed.
+func Db_delete_card_taps_before(db_conn *sql.DB, timestamp int64) int64 {
+	sqlStatement := `DELETE FROM card_taps WHERE timestamp < $1;`
+	res, err := db_conn.Exec(sqlStatement, timestamp)
+	if err != nil {
+		log.Error("db_delete_card_taps_before error: ", err)
+		return 0
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Error("db_delete_card_taps_before rows affected error: ", err)
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCardTaps_SelectPaginatesMostRecentFirst(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	now := int(time.Now().Unix())
+	for i := 1; i <= 5; i++ {
+		Db_insert_card_tap(db, CardTapLog{CardId: 7, Counter: uint32(i), Endpoint: "/ln",
+			Outcome: TapOutcomeOk, RemoteIp: "10.0.0.1", UserAgent: "pos", Timestamp: now})
+	}
+	Db_insert_card_tap(db, CardTapLog{CardId: 8, Counter: 1, Endpoint: "/ln",
+		Outcome: TapOutcomeOk, Timestamp: now})
+
+	if n := Db_get_card_tap_count(db, 7); n != 5 {
+		t.Fatalf("expected 5 taps, got %d", n)
+	}
+
+	page := Db_select_card_taps(db, 7, 2, 0)
+	if len(page) != 2 || page[0].Counter != 5 || page[1].Counter != 4 {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	page = Db_select_card_taps(db, 7, 2, 4)
+	if len(page) != 1 || page[0].Counter != 1 {
+		t.Fatalf("unexpected last page: %+v", page)
+	}
+	if page[0].RemoteIp != "10.0.0.1" || page[0].UserAgent != "pos" || page[0].Endpoint != "/ln" {
+		t.Fatalf("unexpected tap fields: %+v", page[0])
+	}
+}
+
+func TestCardTaps_DeleteBefore(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	now := time.Now().Unix()
+	Db_insert_card_tap(db, CardTapLog{CardId: 1, Counter: 1, Outcome: TapOutcomeOk, Timestamp: int(now - 3600)})
+	Db_insert_card_tap(db, CardTapLog{CardId: 1, Counter: 2, Outcome: TapOutcomeOk, Timestamp: int(now)})
+
+	if n := Db_delete_card_taps_before(db, now-60); n != 1 {
+		t.Fatalf("expected 1 tap deleted, got %d", n)
+	}
+	taps := Db_select_card_taps(db, 1, 10, 0)
+	if len(taps) != 1 || taps[0].Counter != 2 {
+		t.Fatalf("expected only the recent tap to remain, got %+v", taps)
+	}
+}
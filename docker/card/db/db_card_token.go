@@ -0,0 +1,290 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Wallet API token lifetimes are configured with settings; unset or invalid
+// settings fall back to the defaults.
+//
+//	wallet_access_token_ttl_sec   lifetime of an access token
+//	wallet_refresh_token_ttl_sec  lifetime of a refresh token
+const (
+	DefaultAccessTokenTtlSec  = 86400   // 1 day
+	DefaultRefreshTokenTtlSec = 2592000 // 30 days
+)
+
+// token revocation reasons
+const (
+	TokenRevokedReused   = "refresh token reused"
+	TokenRevokedWiped    = "card wiped"
+	TokenRevokedReplaced = "card replaced"
+	TokenRevokedRekeyed  = "card keys rotated"
+	TokenRevokedAdmin    = "revoked by admin"
+)
+
+// SecurityEventTokenReuse is recorded when a refresh token that was already
+// exchanged is presented again, which means a copy of it has leaked.
+const SecurityEventTokenReuse = "token_reuse"
+
+var ErrTokenNotFound = errors.New("token not found")
+
+var errLoginNotValid = errors.New("login not valid")
+
+// CardToken is an access/refresh token pair issued to a wallet. The token
+// values themselves are not returned.
+type CardToken struct {
+	CardTokenId      int
+	CardId           int
+	FamilyId         int
+	TokenHint        string // first characters of the access token
+	IssuedAt         int
+	AccessExpiresAt  int
+	RefreshExpiresAt int
+	RotatedAt        int // the refresh token was exchanged for a new pair
+	RevokedAt        int
+	RevokedReason    string
+}
+
+type CardTokens []CardToken
+
+// Status is "active", "expired", "rotated" or "revoked" at time now.
+func (t CardToken) Status(now int) string {
+	switch {
+	case t.RevokedAt != 0:
+		return "revoked"
+	case t.RotatedAt != 0:
+		return "rotated"
+	case t.AccessExpiresAt <= now:
+		return "expired"
+	}
+	return "active"
+}
+
+// tokenTtls reads the access and refresh token lifetimes in seconds.
+func tokenTtls(db_conn *sql.DB) (accessTtl int64, refreshTtl int64) {
+	setting := func(name string, defaultValue int64) int64 {
+		if v := Db_get_setting(db_conn, name); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+				return n
+			}
+		}
+		return defaultValue
+	}
+	return setting("wallet_access_token_ttl_sec", DefaultAccessTokenTtlSec),
+		setting("wallet_refresh_token_ttl_sec", DefaultRefreshTokenTtlSec)
+}
+
+// insertCardTokenTx issues a token pair inside a transaction. A familyId of 0
+// starts a new family.
+func insertCardTokenTx(ctx context.Context, conn *sql.Conn, cardId int, familyId int,
+	accessToken string, refreshToken string, now int64, accessTtl int64, refreshTtl int64) error {
+
+	res, err := conn.ExecContext(ctx,
+		`INSERT INTO card_tokens (card_id, family_id, access_token, refresh_token,`+
+			` issued_at, access_expires_at, refresh_expires_at)`+
+			` VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+		cardId, familyId, accessToken, refreshToken, now, now+accessTtl, now+refreshTtl)
+	if err != nil || familyId != 0 {
+		return err
+	}
+	tokenId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx,
+		`UPDATE card_tokens SET family_id = card_token_id WHERE card_token_id = $1;`, tokenId)
+	return err
+}
+
+// Db_set_tokens issues a new token pair, starting a new family, for the card
+// with a matching login and password.
+func Db_set_tokens(db_conn *sql.DB, login string, password string,
+	access_token string, refresh_token string) error {
+
+	accessTtl, refreshTtl := tokenTtls(db_conn)
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		var cardId int
+		err := conn.QueryRowContext(ctx,
+			`SELECT card_id FROM cards WHERE login = $1 AND password = $2 AND wiped = 'N'`+
+				` AND status != 'replaced';`,
+			login, password).Scan(&cardId)
+		if err == sql.ErrNoRows {
+			return errLoginNotValid
+		}
+		if err != nil {
+			return err
+		}
+		return insertCardTokenTx(ctx, conn, cardId, 0, access_token, refresh_token,
+			time.Now().Unix(), accessTtl, refreshTtl)
+	})
+	if err != nil && !errors.Is(err, errLoginNotValid) {
+		log.Error("db_set_tokens error: ", err)
+	}
+	return err
+}
+
+// Db_update_tokens exchanges a refresh token for a new token pair in the same
+// family. The old pair stops working. A refresh token that was already
+// exchanged revokes its whole family and is recorded as a security event.
+func Db_update_tokens(db_conn *sql.DB, initial_refresh_token string, new_refresh_token string, access_token string) (success bool) {
+
+	accessTtl, refreshTtl := tokenTtls(db_conn)
+	reusedCardId := 0
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		var tokenId, cardId, familyId int
+		var refreshExpiresAt, rotatedAt, revokedAt int64
+		var wiped, status string
+		err := conn.QueryRowContext(ctx,
+			`SELECT t.card_token_id, t.card_id, t.family_id, t.refresh_expires_at,`+
+				` t.rotated_at, t.revoked_at, c.wiped, c.status`+
+				` FROM card_tokens t JOIN cards c ON c.card_id = t.card_id`+
+				` WHERE t.refresh_token = $1;`, initial_refresh_token).Scan(
+			&tokenId, &cardId, &familyId, &refreshExpiresAt, &rotatedAt, &revokedAt, &wiped, &status)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Unix()
+
+		if rotatedAt != 0 {
+			// the refresh token was already exchanged: someone else holds a copy
+			reusedCardId = cardId
+			return revokeTokensTx(ctx, conn, "family_id", familyId, TokenRevokedReused, now)
+		}
+		if revokedAt != 0 || refreshExpiresAt <= now || wiped != "N" || status == CardStatusReplaced {
+			return nil
+		}
+
+		_, err = conn.ExecContext(ctx,
+			`UPDATE card_tokens SET rotated_at = $1 WHERE card_token_id = $2;`, now, tokenId)
+		if err != nil {
+			return err
+		}
+		if err := insertCardTokenTx(ctx, conn, cardId, familyId, access_token, new_refresh_token,
+			now, accessTtl, refreshTtl); err != nil {
+			return err
+		}
+		success = true
+		return nil
+	})
+	if err != nil {
+		log.Error("db_update_tokens error: ", err)
+		return false
+	}
+
+	if reusedCardId != 0 {
+		log.Warn("refresh token reused, token family revoked for card_id ", reusedCardId)
+		Db_insert_card_security_event(db_conn, CardSecurityEvent{
+			CardId:    reusedCardId,
+			EventType: SecurityEventTokenReuse,
+			Detail:    "a refresh token was presented after it had been exchanged",
+			Action:    SecurityActionReject,
+		})
+	}
+
+	return success
+}
+
+// Db_get_card_id_from_access_token returns the card for an access token that
+// has not expired, been exchanged or been revoked, or 0. Wiped and replaced
+// cards have none.
+func Db_get_card_id_from_access_token(db_conn *sql.DB, access_token string) (card_id int) {
+
+	// get card id
+	sqlStatement := `SELECT c.card_id FROM card_tokens t JOIN cards c ON c.card_id = t.card_id` +
+		` WHERE t.access_token = $1 AND t.rotated_at = 0 AND t.revoked_at = 0` +
+		` AND t.access_expires_at > unixepoch() AND c.wiped = 'N' AND c.status != 'replaced';`
+	row := db_conn.QueryRow(sqlStatement, access_token)
+
+	value := 0
+	err := row.Scan(&value)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// revokeTokensTx revokes the unrevoked tokens whose column (card_id or
+// family_id) equals id inside a transaction.
+func revokeTokensTx(ctx context.Context, conn *sql.Conn, column string, id int, reason string, now int64) error {
+	_, err := conn.ExecContext(ctx,
+		`UPDATE card_tokens SET revoked_at = $1, revoked_reason = $2`+
+			` WHERE `+column+` = $3 AND revoked_at = 0;`, now, reason, id)
+	return err
+}
+
+// Db_revoke_card_tokens revokes every token issued to a card.
+func Db_revoke_card_tokens(db_conn *sql.DB, cardId int, reason string) {
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		return revokeTokensTx(ctx, conn, "card_id", cardId, reason, time.Now().Unix())
+	})
+	if err != nil {
+		log.Error("db_revoke_card_tokens error: ", err)
+	}
+}
+
+// Db_revoke_card_token revokes a card's token together with the rest of its
+// family, so a refresh issued from it cannot keep the session alive.
+func Db_revoke_card_token(db_conn *sql.DB, cardId int, cardTokenId int, reason string) error {
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		var familyId int
+		err := conn.QueryRowContext(ctx,
+			`SELECT family_id FROM card_tokens WHERE card_token_id = $1 AND card_id = $2;`,
+			cardTokenId, cardId).Scan(&familyId)
+		if err == sql.ErrNoRows {
+			return ErrTokenNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return revokeTokensTx(ctx, conn, "family_id", familyId, reason, time.Now().Unix())
+	})
+	if err != nil && !errors.Is(err, ErrTokenNotFound) {
+		log.Error("db_revoke_card_token error: ", err)
+	}
+	return err
+}
+
+// Db_select_card_tokens returns the tokens issued to a card, most recent
+// first.
+func Db_select_card_tokens(db_conn *sql.DB, cardId int) CardTokens {
+	var tokens CardTokens
+
+	sqlStatement := `SELECT card_token_id, card_id, family_id, substr(access_token, 1, 6),` +
+		` issued_at, access_expires_at, refresh_expires_at, rotated_at, revoked_at, revoked_reason` +
+		` FROM card_tokens WHERE card_id = $1` +
+		` ORDER BY card_token_id DESC;`
+	rows, err := db_conn.Query(sqlStatement, cardId)
+	if err != nil {
+		log.Error("db_select_card_tokens query error: ", err)
+		return tokens
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t CardToken
+		err := rows.Scan(&t.CardTokenId, &t.CardId, &t.FamilyId, &t.TokenHint,
+			&t.IssuedAt, &t.AccessExpiresAt, &t.RefreshExpiresAt,
+			&t.RotatedAt, &t.RevokedAt, &t.RevokedReason)
+		if err != nil {
+			log.Error("db_select_card_tokens scan error: ", err)
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens
+}
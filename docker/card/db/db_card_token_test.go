@@ -0,0 +1,82 @@
+package db
+
+import (
+	"testing"
+)
+
+// TestCardTokens_AccessTokenExpires verifies an access token stops working
+// once its lifetime has passed while the refresh token still renews it.
+func TestCardTokens_AccessTokenExpires(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	db.Exec(`UPDATE card_tokens SET access_expires_at = unixepoch() - 1 WHERE access_token = 'wtok'`)
+	if got := Db_get_card_id_from_access_token(db, "wtok"); got != 0 {
+		t.Fatalf("expected an expired access token to be refused, got card %d", got)
+	}
+
+	if !Db_update_tokens(db, "wref", "wref2", "wtok2") {
+		t.Fatal("expected the refresh token to issue a new pair")
+	}
+	if got := Db_get_card_id_from_access_token(db, "wtok2"); got != id {
+		t.Fatalf("expected the new access token to resolve to card %d, got %d", id, got)
+	}
+
+	db.Exec(`UPDATE card_tokens SET refresh_expires_at = unixepoch() - 1 WHERE refresh_token = 'wref2'`)
+	if Db_update_tokens(db, "wref2", "wref3", "wtok3") {
+		t.Fatal("expected an expired refresh token to be refused")
+	}
+}
+
+// TestCardTokens_RefreshReuseRevokesFamily verifies presenting a refresh
+// token a second time revokes every token refreshed from the same login,
+// leaves other logins alone and records a security event.
+func TestCardTokens_RefreshReuseRevokesFamily(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	if err := Db_set_tokens(db, "wlogin", "wpass", "othertok", "otherref"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !Db_update_tokens(db, "wref", "wref2", "wtok2") {
+		t.Fatal("expected the first refresh to succeed")
+	}
+	if Db_update_tokens(db, "wref", "stolenref", "stolentok") {
+		t.Fatal("expected a reused refresh token to be refused")
+	}
+
+	if got := Db_get_card_id_from_access_token(db, "wtok2"); got != 0 {
+		t.Fatal("expected the family's current access token to be revoked")
+	}
+	if Db_update_tokens(db, "wref2", "wref3", "wtok3") {
+		t.Fatal("expected the family's current refresh token to be revoked")
+	}
+	if got := Db_get_card_id_from_access_token(db, "othertok"); got != id {
+		t.Fatal("expected a separate login to keep working")
+	}
+
+	events := Db_select_card_security_events(db, id, 10)
+	if len(events) != 1 || events[0].EventType != SecurityEventTokenReuse {
+		t.Fatalf("expected a token reuse security event, got %+v", events)
+	}
+}
+
+// TestCardTokens_RevokedOnWipe verifies wiping a card revokes its tokens and
+// that the token listing reports them as revoked.
+func TestCardTokens_RevokedOnWipe(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	Db_wipe_card(db, id)
+
+	tokens := Db_select_card_tokens(db, id)
+	if len(tokens) != 1 || tokens[0].RevokedReason != TokenRevokedWiped || tokens[0].Status(0) != "revoked" {
+		t.Fatalf("expected the token to be revoked as wiped, got %+v", tokens)
+	}
+	if tokens[0].TokenHint != "wtok" {
+		t.Fatalf("expected the token hint to be the start of the access token, got %q", tokens[0].TokenHint)
+	}
+}
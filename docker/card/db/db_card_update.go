@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"card/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CardUpdate is a change to one card from a bulk import. A nil field is left
+// unchanged; AllocateSats > 0 credits the card.
+type CardUpdate struct {
+	CardId       int
+	Note         *string
+	GroupTag     *string
+	TxLimitSats  *int
+	DayLimitSats *int
+	AllocateSats int
+}
+
+// Db_apply_card_updates applies a set of card updates in one transaction, so
+// either every update is made or none is. Allocations are recorded as paid
+// receipts settled by settledBy.
+func Db_apply_card_updates(db_conn *sql.DB, updates []CardUpdate, settledBy string) error {
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		for _, u := range updates {
+			if u.GroupTag != nil && *u.GroupTag != "" {
+				_, err := conn.ExecContext(ctx, `INSERT OR IGNORE INTO card_groups (group_tag, created_at)`+
+					` VALUES ($1, unixepoch());`, *u.GroupTag)
+				if err != nil {
+					return err
+				}
+			}
+
+			res, err := conn.ExecContext(ctx, `UPDATE cards SET note = IFNULL($1, note),`+
+				` group_tag = IFNULL($2, group_tag), tx_limit_sats = IFNULL($3, tx_limit_sats),`+
+				` day_limit_sats = IFNULL($4, day_limit_sats)`+
+				` WHERE card_id = $5 AND wiped = 'N';`,
+				u.Note, u.GroupTag, u.TxLimitSats, u.DayLimitSats, u.CardId)
+			if err != nil {
+				return err
+			}
+			if count, err := res.RowsAffected(); err != nil || count != 1 {
+				return fmt.Errorf("card %d not updated", u.CardId)
+			}
+
+			if u.AllocateSats > 0 {
+				_, err := conn.ExecContext(ctx, `INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex,`+
+					` amount_sats, amount_msat, paid_flag, timestamp, expire_time, settled_by, settled_at)`+
+					` VALUES ($1, '', $2, $3, $3 * 1000, 'Y', unixepoch(), unixepoch(), $4, unixepoch());`,
+					u.CardId, util.Random_hex(), u.AllocateSats, settledBy)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("db_apply_card_updates error: ", err)
+	}
+	return err
+}
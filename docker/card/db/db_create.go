@@ -397,6 +397,681 @@ func update_schema_12(db *sql.DB) {
 	}
 }
 
+func update_schema_13(db *sql.DB) {
+
+	// Card key rotation: key_version counts the key sets a card has had, and
+	// the pending_* columns hold the next key set between the admin issuing a
+	// re-programming deeplink (/rekey?s=<rekey_secret>) and the first tap made
+	// with the new keys, at which point they replace the current set.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE cards ADD COLUMN key_version INT NOT NULL DEFAULT 1;
+		ALTER TABLE cards ADD COLUMN pending_key_version INT NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN pending_key0_auth CHAR(32) NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN pending_key1_enc CHAR(32) NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN pending_key2_cmac CHAR(32) NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN pending_key3 CHAR(32) NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN pending_key4 CHAR(32) NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN rekey_secret CHAR(64) NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN rekey_secret_expiry INT NOT NULL DEFAULT 0;
+		UPDATE settings SET value='14' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_13 alter error: %q", err)
+	}
+}
+
+func update_schema_14(db *sql.DB) {
+
+	// Security events raised while handling card taps, e.g. a tap whose
+	// decrypted UID does not match the UID recorded for the card.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		CREATE TABLE IF NOT EXISTS
+		card_security_events (
+			event_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+			card_id INTEGER NOT NULL,
+			event_type TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			timestamp INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_security_events_card_id ON card_security_events(card_id);
+		UPDATE settings SET value='15' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_14 create table error: %q", err)
+	}
+}
+
+func update_schema_15(db *sql.DB) {
+
+	// One row per card tap on /ln or /balance-ajax with its outcome, so
+	// support staff can see why a card was refused. card_id is 0 when the tap
+	// did not match any card.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		CREATE TABLE IF NOT EXISTS
+		card_taps (
+			tap_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+			card_id INTEGER NOT NULL DEFAULT 0,
+			counter INTEGER NOT NULL DEFAULT 0,
+			endpoint TEXT NOT NULL DEFAULT '',
+			outcome TEXT NOT NULL DEFAULT '',
+			remote_ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			timestamp INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_taps_card_id ON card_taps(card_id, tap_id);
+		CREATE INDEX IF NOT EXISTS idx_card_taps_timestamp ON card_taps(timestamp);
+		UPDATE settings SET value='16' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_15 create table error: %q", err)
+	}
+}
+
+func update_schema_16(db *sql.DB) {
+
+	// Anomaly rule decisions are stored as security events, with the action
+	// taken (alert or suspend) and the client address for per-IP rules, which
+	// count recent taps by remote_ip.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_security_events ADD COLUMN action TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_security_events ADD COLUMN remote_ip TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_card_taps_remote_ip ON card_taps(remote_ip, timestamp);
+		UPDATE settings SET value='17' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_16 alter error: %q", err)
+	}
+}
+
+func update_schema_17(db *sql.DB) {
+
+	// Explicit card lifecycle status replacing the wiped flag as the source of
+	// truth (wiped is kept in step for existing queries), with a history row
+	// for every change.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE cards ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+		ALTER TABLE cards ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN status_changed_at INT NOT NULL DEFAULT 0;
+		UPDATE cards SET status = 'wiped' WHERE wiped = 'Y';
+		CREATE INDEX IF NOT EXISTS idx_cards_status ON cards(status);
+		CREATE TABLE IF NOT EXISTS
+		card_status_history (
+			history_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+			card_id INTEGER NOT NULL,
+			from_status TEXT NOT NULL DEFAULT '',
+			to_status TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT '',
+			timestamp INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_status_history_card_id ON card_status_history(card_id);
+		UPDATE settings SET value='18' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_17 alter error: %q", err)
+	}
+}
+
+func update_schema_18(db *sql.DB) {
+
+	// Lost-card replacement: replace_secret is the one-shot capability in the
+	// /replace?s=<secret> programming deeplink, replaced_by_card_id links the
+	// old record to its replacement, and card_transfers records balance moved
+	// between cards as a linked payment and receipt.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE cards ADD COLUMN replace_secret CHAR(64) NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN replace_secret_expiry INT NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN replaced_by_card_id INT NOT NULL DEFAULT 0;
+		CREATE TABLE IF NOT EXISTS
+		card_transfers (
+			transfer_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+			from_card_id INTEGER NOT NULL,
+			to_card_id INTEGER NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			card_payment_id INTEGER NOT NULL,
+			card_receipt_id INTEGER NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			timestamp INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_transfers_from_card_id ON card_transfers(from_card_id);
+		CREATE INDEX IF NOT EXISTS idx_card_transfers_to_card_id ON card_transfers(to_card_id);
+		UPDATE settings SET value='19' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_18 alter error: %q", err)
+	}
+}
+
+func update_schema_19(db *sql.DB) {
+
+	// Cardholder payouts: one policy per card group_tag, with the empty
+	// group_tag as the default for cards without a policy of their own.
+	// Payouts are off until an admin opens a window.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		CREATE TABLE IF NOT EXISTS
+		payout_policies (
+			group_tag TEXT PRIMARY KEY NOT NULL,
+			enabled CHAR(1) NOT NULL DEFAULT 'N',
+			opens_at INTEGER NOT NULL DEFAULT 0,
+			closes_at INTEGER NOT NULL DEFAULT 0,
+			min_sats INTEGER NOT NULL DEFAULT 0,
+			max_sats INTEGER NOT NULL DEFAULT 0
+		);
+		INSERT OR IGNORE INTO payout_policies (group_tag) VALUES ('');
+		UPDATE settings SET value='20' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_19 alter error: %q", err)
+	}
+}
+
+func update_schema_20(db *sql.DB) {
+
+	// Card expiry and forfeiture: a card expires at its own expires_at, or
+	// its group's when it has none (0 = never). Balances left on expired
+	// cards after the payout grace period move to a house account; each
+	// movement is a house_account_entries row linked to the card payment
+	// that debited the card.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE cards ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN forfeited_at INTEGER NOT NULL DEFAULT 0;
+		CREATE TABLE IF NOT EXISTS
+		card_groups (
+			group_tag TEXT PRIMARY KEY NOT NULL,
+			expires_at INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE IF NOT EXISTS
+		house_accounts (
+			house_account_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+			name TEXT UNIQUE NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS
+		house_account_entries (
+			entry_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+			house_account_id INTEGER NOT NULL,
+			card_id INTEGER NOT NULL DEFAULT 0,
+			card_payment_id INTEGER NOT NULL DEFAULT 0,
+			amount_sats INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			timestamp INTEGER NOT NULL,
+			FOREIGN KEY(house_account_id) REFERENCES house_accounts(house_account_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_house_account_entries_account ON house_account_entries(house_account_id, timestamp);
+		CREATE INDEX IF NOT EXISTS idx_house_account_entries_card_id ON house_account_entries(card_id);
+		UPDATE settings SET value='21' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_20 alter error: %q", err)
+	}
+}
+
+func update_schema_21(db *sql.DB) {
+
+	// First-class card groups: card_groups gains the defaults new cards in
+	// the group inherit, and every group_tag already in use gets a row.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_groups ADD COLUMN description TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_groups ADD COLUMN tx_limit_sats INTEGER NOT NULL DEFAULT 1000000;
+		ALTER TABLE card_groups ADD COLUMN day_limit_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN initial_balance_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN ln_address_enabled CHAR(1) NOT NULL DEFAULT 'Y';
+		ALTER TABLE card_groups ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+		INSERT OR IGNORE INTO card_groups (group_tag, created_at)
+			SELECT DISTINCT group_tag, unixepoch() FROM cards WHERE group_tag != '';
+		INSERT OR IGNORE INTO card_groups (group_tag, created_at)
+			SELECT DISTINCT group_tag, unixepoch() FROM program_cards WHERE group_tag != '';
+		UPDATE settings SET value='22' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_21 alter error: %q", err)
+	}
+}
+
+func update_schema_22(db *sql.DB) {
+
+	// Covering indexes for the card list, which sums each card's receipts and
+	// payments and finds its last activity.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		CREATE INDEX IF NOT EXISTS idx_card_receipts_card_paid ON card_receipts(card_id, paid_flag, amount_sats, timestamp);
+		CREATE INDEX IF NOT EXISTS idx_card_payments_card_paid ON card_payments(card_id, paid_flag, amount_sats, fee_sats, timestamp);
+		UPDATE settings SET value='23' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_22 alter error: %q", err)
+	}
+}
+
+func update_schema_23(db *sql.DB) {
+
+	// Materialised card balances: cards.balance_sats is the paid receipts
+	// less the paid payments and their fees. The triggers keep it in step
+	// with every insert, update and delete of card_receipts and
+	// card_payments, inside the same transaction as the change.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE cards ADD COLUMN balance_sats INTEGER NOT NULL DEFAULT 0;
+		UPDATE cards SET balance_sats =
+			IFNULL((SELECT SUM(amount_sats) FROM card_receipts WHERE paid_flag='Y' AND card_id=cards.card_id), 0) -
+			IFNULL((SELECT SUM(amount_sats + fee_sats) FROM card_payments WHERE paid_flag='Y' AND card_id=cards.card_id), 0);
+		CREATE INDEX IF NOT EXISTS idx_cards_balance_sats ON cards(balance_sats);
+		CREATE INDEX IF NOT EXISTS idx_card_payments_card_timestamp ON card_payments(card_id, timestamp);
+
+		CREATE TRIGGER IF NOT EXISTS trg_card_receipts_balance_insert
+		AFTER INSERT ON card_receipts WHEN NEW.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_sats = balance_sats + NEW.amount_sats WHERE card_id = NEW.card_id;
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_receipts_balance_update
+		AFTER UPDATE OF card_id, amount_sats, paid_flag ON card_receipts
+		BEGIN
+			UPDATE cards SET balance_sats = balance_sats - OLD.amount_sats
+				WHERE card_id = OLD.card_id AND OLD.paid_flag = 'Y';
+			UPDATE cards SET balance_sats = balance_sats + NEW.amount_sats
+				WHERE card_id = NEW.card_id AND NEW.paid_flag = 'Y';
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_receipts_balance_delete
+		AFTER DELETE ON card_receipts WHEN OLD.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_sats = balance_sats - OLD.amount_sats WHERE card_id = OLD.card_id;
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS trg_card_payments_balance_insert
+		AFTER INSERT ON card_payments WHEN NEW.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_sats = balance_sats - NEW.amount_sats - NEW.fee_sats
+				WHERE card_id = NEW.card_id;
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_payments_balance_update
+		AFTER UPDATE OF card_id, amount_sats, fee_sats, paid_flag ON card_payments
+		BEGIN
+			UPDATE cards SET balance_sats = balance_sats + OLD.amount_sats + OLD.fee_sats
+				WHERE card_id = OLD.card_id AND OLD.paid_flag = 'Y';
+			UPDATE cards SET balance_sats = balance_sats - NEW.amount_sats - NEW.fee_sats
+				WHERE card_id = NEW.card_id AND NEW.paid_flag = 'Y';
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_payments_balance_delete
+		AFTER DELETE ON card_payments WHEN OLD.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_sats = balance_sats + OLD.amount_sats + OLD.fee_sats
+				WHERE card_id = OLD.card_id;
+		END;
+
+		UPDATE settings SET value='24' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_23 alter error: %q", err)
+	}
+}
+
+func update_schema_24(db *sql.DB) {
+
+	// Millisatoshi ledger: receipts, payments and balances hold msat values.
+	// The sat columns stay for older readers: a receipt rounds down, and a
+	// payment and its fee round up. The balance triggers are rebuilt on the
+	// msat columns and keep balance_sats at the whole sats of balance_msat.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		DROP TRIGGER IF EXISTS trg_card_receipts_balance_insert;
+		DROP TRIGGER IF EXISTS trg_card_receipts_balance_update;
+		DROP TRIGGER IF EXISTS trg_card_receipts_balance_delete;
+		DROP TRIGGER IF EXISTS trg_card_payments_balance_insert;
+		DROP TRIGGER IF EXISTS trg_card_payments_balance_update;
+		DROP TRIGGER IF EXISTS trg_card_payments_balance_delete;
+
+		ALTER TABLE card_receipts ADD COLUMN amount_msat INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_payments ADD COLUMN amount_msat INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_payments ADD COLUMN fee_msat INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN balance_msat INTEGER NOT NULL DEFAULT 0;
+		UPDATE card_receipts SET amount_msat = amount_sats * 1000;
+		UPDATE card_payments SET amount_msat = amount_sats * 1000, fee_msat = fee_sats * 1000;
+		UPDATE cards SET balance_msat = balance_sats * 1000;
+		CREATE INDEX IF NOT EXISTS idx_card_receipts_card_paid_msat ON card_receipts(card_id, paid_flag, amount_msat, timestamp);
+		CREATE INDEX IF NOT EXISTS idx_card_payments_card_paid_msat ON card_payments(card_id, paid_flag, amount_msat, fee_msat, timestamp);
+
+		CREATE TRIGGER IF NOT EXISTS trg_card_receipts_balance_insert
+		AFTER INSERT ON card_receipts WHEN NEW.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_msat = balance_msat + NEW.amount_msat,
+				balance_sats = (balance_msat + NEW.amount_msat) / 1000
+				WHERE card_id = NEW.card_id;
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_receipts_balance_update
+		AFTER UPDATE OF card_id, amount_msat, paid_flag ON card_receipts
+		BEGIN
+			UPDATE cards SET balance_msat = balance_msat - OLD.amount_msat,
+				balance_sats = (balance_msat - OLD.amount_msat) / 1000
+				WHERE card_id = OLD.card_id AND OLD.paid_flag = 'Y';
+			UPDATE cards SET balance_msat = balance_msat + NEW.amount_msat,
+				balance_sats = (balance_msat + NEW.amount_msat) / 1000
+				WHERE card_id = NEW.card_id AND NEW.paid_flag = 'Y';
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_receipts_balance_delete
+		AFTER DELETE ON card_receipts WHEN OLD.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_msat = balance_msat - OLD.amount_msat,
+				balance_sats = (balance_msat - OLD.amount_msat) / 1000
+				WHERE card_id = OLD.card_id;
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS trg_card_payments_balance_insert
+		AFTER INSERT ON card_payments WHEN NEW.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_msat = balance_msat - NEW.amount_msat - NEW.fee_msat,
+				balance_sats = (balance_msat - NEW.amount_msat - NEW.fee_msat) / 1000
+				WHERE card_id = NEW.card_id;
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_payments_balance_update
+		AFTER UPDATE OF card_id, amount_msat, fee_msat, paid_flag ON card_payments
+		BEGIN
+			UPDATE cards SET balance_msat = balance_msat + OLD.amount_msat + OLD.fee_msat,
+				balance_sats = (balance_msat + OLD.amount_msat + OLD.fee_msat) / 1000
+				WHERE card_id = OLD.card_id AND OLD.paid_flag = 'Y';
+			UPDATE cards SET balance_msat = balance_msat - NEW.amount_msat - NEW.fee_msat,
+				balance_sats = (balance_msat - NEW.amount_msat - NEW.fee_msat) / 1000
+				WHERE card_id = NEW.card_id AND NEW.paid_flag = 'Y';
+		END;
+		CREATE TRIGGER IF NOT EXISTS trg_card_payments_balance_delete
+		AFTER DELETE ON card_payments WHEN OLD.paid_flag = 'Y'
+		BEGIN
+			UPDATE cards SET balance_msat = balance_msat + OLD.amount_msat + OLD.fee_msat,
+				balance_sats = (balance_msat + OLD.amount_msat + OLD.fee_msat) / 1000
+				WHERE card_id = OLD.card_id;
+		END;
+
+		UPDATE settings SET value='25' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_24 alter error: %q", err)
+	}
+}
+
+func update_schema_25(db *sql.DB) {
+
+	// Fee settlement: a reservation holds its fee reserve in fee_msat, and the
+	// payment is settled by replacing the reserve with the actual routing fee.
+	// Payments made before this are treated as settled.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_payments ADD COLUMN fee_reserve_msat INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_payments ADD COLUMN settled_at INTEGER NOT NULL DEFAULT 0;
+		UPDATE card_payments SET settled_at = timestamp WHERE paid_flag = 'Y';
+		UPDATE settings SET value='26' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_25 alter error: %q", err)
+	}
+}
+
+func update_schema_26(db *sql.DB) {
+
+	// Operator service fees: a group may charge a fee on each card payment
+	// and each incoming top-up. A fee is its own card_payments row of kind
+	// 'service_fee' linked to the payment or receipt it was charged on, and
+	// is credited to a house account.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_groups ADD COLUMN spend_fee_base_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN spend_fee_ppm INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN topup_fee_base_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN topup_fee_ppm INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN fee_house_account TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_payments ADD COLUMN kind TEXT NOT NULL DEFAULT 'payment';
+		ALTER TABLE card_payments ADD COLUMN related_payment_id INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_payments ADD COLUMN related_receipt_id INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS idx_card_payments_related_payment ON card_payments(related_payment_id) WHERE related_payment_id != 0;
+		CREATE INDEX IF NOT EXISTS idx_card_payments_related_receipt ON card_payments(related_receipt_id) WHERE related_receipt_id != 0;
+		UPDATE settings SET value='27' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_26 alter error: %q", err)
+	}
+}
+
+func update_schema_27(db *sql.DB) {
+
+	// LndHub compatibility: a settled payment keeps the payment hash and the
+	// preimage returned by Phoenix so wallets can show proof of payment.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_payments ADD COLUMN payment_hash TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_payments ADD COLUMN payment_preimage TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_card_payments_payment_hash ON card_payments(payment_hash) WHERE payment_hash != '';
+		UPDATE settings SET value='28' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_27 alter error: %q", err)
+	}
+}
+
+func update_schema_28(db *sql.DB) {
+
+	// Wallet API tokens move out of the cards row into card_tokens, one row
+	// per issued access/refresh pair. Pairs issued by refreshing share the
+	// family_id of the login that started them, so a reused refresh token
+	// can revoke the whole family. Existing tokens are carried over with
+	// the default lifetimes (1 day access, 30 days refresh).
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		CREATE TABLE IF NOT EXISTS
+		card_tokens (
+			card_token_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			card_id INTEGER NOT NULL REFERENCES cards(card_id),
+			family_id INTEGER NOT NULL DEFAULT 0,
+			access_token TEXT NOT NULL UNIQUE,
+			refresh_token TEXT NOT NULL UNIQUE,
+			issued_at INTEGER NOT NULL,
+			access_expires_at INTEGER NOT NULL,
+			refresh_expires_at INTEGER NOT NULL,
+			rotated_at INTEGER NOT NULL DEFAULT 0,
+			revoked_at INTEGER NOT NULL DEFAULT 0,
+			revoked_reason TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_tokens_card_id ON card_tokens(card_id);
+		CREATE INDEX IF NOT EXISTS idx_card_tokens_family_id ON card_tokens(family_id);
+		INSERT INTO card_tokens (card_id, access_token, refresh_token, issued_at,
+			access_expires_at, refresh_expires_at)
+			SELECT card_id, access_token, refresh_token, unixepoch(),
+			unixepoch() + 86400, unixepoch() + 2592000
+			FROM cards WHERE access_token != '' AND refresh_token != '' AND wiped = 'N';
+		UPDATE card_tokens SET family_id = card_token_id;
+		UPDATE cards SET access_token = '', refresh_token = '';
+		UPDATE settings SET value='29' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_28 alter error: %q", err)
+	}
+}
+
+func update_schema_29(db *sql.DB) {
+
+	// Card key history: a key set replaced before the chip is known to hold
+	// its successor is kept in card_key_history and still accepted on taps
+	// until accept_until, so a card whose re-programming was never finished
+	// keeps working and can still be wiped. card_taps records which key set
+	// a tap used.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		CREATE TABLE IF NOT EXISTS
+		card_key_history (
+			card_key_history_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			card_id INTEGER NOT NULL REFERENCES cards(card_id),
+			key_version INTEGER NOT NULL,
+			key0_auth CHAR(32) NOT NULL,
+			key1_enc CHAR(32) NOT NULL,
+			key2_cmac CHAR(32) NOT NULL,
+			key3 CHAR(32) NOT NULL,
+			key4 CHAR(32) NOT NULL,
+			retired_at INTEGER NOT NULL,
+			accept_until INTEGER NOT NULL DEFAULT 0,
+			reason TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_card_key_history_card_id ON card_key_history(card_id, key_version);
+		CREATE INDEX IF NOT EXISTS idx_card_key_history_accept_until ON card_key_history(accept_until) WHERE accept_until != 0;
+		ALTER TABLE card_taps ADD COLUMN key_version INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_taps ADD COLUMN previous_keys INTEGER NOT NULL DEFAULT 0;
+		UPDATE settings SET value='30' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_29 alter error: %q", err)
+	}
+}
+
+func update_schema_30(db *sql.DB) {
+
+	// LUD-12 payer comments: the comment sent with a lightning address
+	// payment is kept on its receipt.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_receipts ADD COLUMN comment TEXT NOT NULL DEFAULT '';
+		UPDATE settings SET value='31' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_30 alter error: %q", err)
+	}
+}
+
+func update_schema_31(db *sql.DB) {
+
+	// LUD-18 payer data and NIP-57 zaps: the payer data and zap request sent
+	// with a lightning address payment, and the id of the zap receipt
+	// published once it is paid.
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_receipts ADD COLUMN payer_data TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_receipts ADD COLUMN zap_request TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_receipts ADD COLUMN zap_receipt_id TEXT NOT NULL DEFAULT '';
+		UPDATE settings SET value='32' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_31 alter error: %q", err)
+	}
+}
+
+func update_schema_32(db *sql.DB) {
+
+	// vanity lightning addresses: a card can claim a human-readable alias
+	// alongside its generated c. address
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE cards ADD COLUMN ln_alias TEXT NOT NULL DEFAULT '';
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_cards_ln_alias ON cards(ln_alias) WHERE ln_alias != '';
+		UPDATE settings SET value='33' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_32 alter error: %q", err)
+	}
+}
+
+func update_schema_33(db *sql.DB) {
+
+	// lightning address receive limits and metadata, set on a card or, for
+	// every card in it, on its group; 0 and empty fall back to the group and
+	// then to the defaults
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE cards ADD COLUMN receive_min_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN receive_max_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN max_balance_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE cards ADD COLUMN ln_description TEXT NOT NULL DEFAULT '';
+		ALTER TABLE cards ADD COLUMN ln_image TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_groups ADD COLUMN receive_min_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN receive_max_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN max_balance_sats INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE card_groups ADD COLUMN ln_description TEXT NOT NULL DEFAULT '';
+		ALTER TABLE card_groups ADD COLUMN ln_image TEXT NOT NULL DEFAULT '';
+		UPDATE settings SET value='34' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_33 alter error: %q", err)
+	}
+}
+
+func update_schema_34(db *sql.DB) {
+
+	// LUD-21 verify: a settled receipt keeps the preimage of its payment
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_receipts ADD COLUMN payment_preimage TEXT NOT NULL DEFAULT '';
+		UPDATE settings SET value='35' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_34 alter error: %q", err)
+	}
+}
+
+func update_schema_35(db *sql.DB) {
+
+	// receipt expiry: an unpaid receipt is marked expired once its invoice
+	// has expired, so the receipt poller stops asking Phoenix about it
+	sqlStmt := `
+		BEGIN TRANSACTION;
+		ALTER TABLE card_receipts ADD COLUMN expired_at INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS idx_card_receipts_unpaid ON card_receipts(paid_flag, expired_at, expire_time);
+		UPDATE settings SET value='36' WHERE name='schema_version_number';
+		COMMIT TRANSACTION;
+	`
+	_, err := db.Exec(sqlStmt)
+	if err != nil {
+		log.Printf("update_schema_35 alter error: %q", err)
+	}
+}
+
 // randomHex8 generates an 8-character random hex string for lightning addresses.
 func randomHex8() string {
 	b := make([]byte, 4)
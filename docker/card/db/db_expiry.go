@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cardExpirySQL is the effective expiry of card c: its own expires_at, or its
+// group's when it has none. 0 means the card never expires.
+const cardExpirySQL = `CASE WHEN c.expires_at > 0 THEN c.expires_at ELSE` +
+	` IFNULL((SELECT g.expires_at FROM card_groups g` +
+	` WHERE g.group_tag = c.group_tag AND c.group_tag != ''), 0) END`
+
+// CardGroupExpiry is the default expiry for the cards in a group.
+type CardGroupExpiry struct {
+	GroupTag  string
+	ExpiresAt int
+}
+
+// ExpiringCard is a card with an expiry, as listed for forfeiture.
+type ExpiringCard struct {
+	CardId      int
+	GroupTag    string
+	Note        string
+	Status      string
+	ExpiresAt   int
+	BalanceSats int
+}
+
+type ExpiringCards []ExpiringCard
+
+// Db_set_card_expiry sets a card's own expiry (unix time); 0 falls back to
+// its group's expiry.
+func Db_set_card_expiry(db_conn *sql.DB, cardId int, expiresAt int) error {
+	res, err := db_conn.Exec(`UPDATE cards SET expires_at = $1 WHERE card_id = $2;`, expiresAt, cardId)
+	if err != nil {
+		log.Error("db_set_card_expiry error: ", err)
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Error("db_set_card_expiry rows affected error: ", err)
+		return err
+	}
+	if count != 1 {
+		return errors.New("card not found")
+	}
+	return nil
+}
+
+// Db_set_group_expiry sets the default expiry for cards in a group; 0 removes
+// it.
+func Db_set_group_expiry(db_conn *sql.DB, groupTag string, expiresAt int) error {
+	sqlStatement := `INSERT INTO card_groups (group_tag, expires_at, created_at) VALUES ($1, $2, unixepoch())` +
+		` ON CONFLICT(group_tag) DO UPDATE SET expires_at = excluded.expires_at;`
+	_, err := db_conn.Exec(sqlStatement, groupTag, expiresAt)
+	if err != nil {
+		log.Error("db_set_group_expiry error: ", err)
+	}
+	return err
+}
+
+// Db_select_group_expiries returns the groups that have an expiry set.
+func Db_select_group_expiries(db_conn *sql.DB) []CardGroupExpiry {
+	var groups []CardGroupExpiry
+
+	sqlStatement := `SELECT group_tag, expires_at FROM card_groups` +
+		` WHERE expires_at > 0 ORDER BY group_tag;`
+	rows, err := db_conn.Query(sqlStatement)
+	if err != nil {
+		log.Error("db_select_group_expiries query error: ", err)
+		return groups
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g CardGroupExpiry
+		if err := rows.Scan(&g.GroupTag, &g.ExpiresAt); err != nil {
+			log.Error("db_select_group_expiries scan error: ", err)
+			return groups
+		}
+		groups = append(groups, g)
+	}
+
+	return groups
+}
+
+// Db_get_card_expiry returns a card's effective expiry (0 = never).
+func Db_get_card_expiry(db_conn *sql.DB, cardId int) int {
+	sqlStatement := `SELECT ` + cardExpirySQL + ` FROM cards c WHERE c.card_id = $1;`
+	value := 0
+	err := db_conn.QueryRow(sqlStatement, cardId).Scan(&value)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// Db_select_cards_due_to_expire returns the cards whose expiry has passed at
+// time now but whose status has not yet moved to expired.
+func Db_select_cards_due_to_expire(db_conn *sql.DB, now int64) []int {
+	var cardIds []int
+
+	sqlStatement := `SELECT card_id FROM (SELECT c.card_id, c.status, ` + cardExpirySQL +
+		` AS expiry FROM cards c)` +
+		` WHERE expiry > 0 AND expiry <= $1` +
+		` AND status IN ('pending_programming', 'active', 'suspended')` +
+		` ORDER BY card_id;`
+	rows, err := db_conn.Query(sqlStatement, now)
+	if err != nil {
+		log.Error("db_select_cards_due_to_expire query error: ", err)
+		return cardIds
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Error("db_select_cards_due_to_expire scan error: ", err)
+			return cardIds
+		}
+		cardIds = append(cardIds, id)
+	}
+
+	return cardIds
+}
+
+// Db_select_cards_to_forfeit returns the cards with at least a sat of balance
+// that expired at or before expiredBefore. Cards that are lost, wiped or
+// replaced keep their balance for the replacement process.
+func Db_select_cards_to_forfeit(db_conn *sql.DB, expiredBefore int64) ExpiringCards {
+	var cards ExpiringCards
+
+	sqlStatement := `SELECT card_id, group_tag, note, status, expiry, balance FROM (` +
+		`SELECT c.card_id, c.group_tag, c.note, c.status, ` + cardExpirySQL + ` AS expiry,` +
+		` c.balance_sats AS balance FROM cards c)` +
+		` WHERE expiry > 0 AND expiry <= $1 AND balance > 0` +
+		` AND status IN ('pending_programming', 'active', 'suspended', 'expired')` +
+		` ORDER BY card_id;`
+	rows, err := db_conn.Query(sqlStatement, expiredBefore)
+	if err != nil {
+		log.Error("db_select_cards_to_forfeit query error: ", err)
+		return cards
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c ExpiringCard
+		err := rows.Scan(&c.CardId, &c.GroupTag, &c.Note, &c.Status, &c.ExpiresAt, &c.BalanceSats)
+		if err != nil {
+			log.Error("db_select_cards_to_forfeit scan error: ", err)
+			return cards
+		}
+		cards = append(cards, c)
+	}
+
+	return cards
+}
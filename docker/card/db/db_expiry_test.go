@@ -0,0 +1,107 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCardExpiry_GroupDefault verifies a card without its own expiry takes its
+// group's, and that its own expiry wins when set.
+func TestCardExpiry_GroupDefault(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	if got := Db_get_card_expiry(db, id); got != 0 {
+		t.Fatalf("expected no expiry, got %d", got)
+	}
+
+	db.Exec(`UPDATE cards SET group_tag='fest' WHERE card_id=$1`, id)
+	Db_set_group_expiry(db, "fest", 1000)
+	if got := Db_get_card_expiry(db, id); got != 1000 {
+		t.Fatalf("expected group expiry 1000, got %d", got)
+	}
+
+	Db_set_card_expiry(db, id, 2000)
+	if got := Db_get_card_expiry(db, id); got != 2000 {
+		t.Fatalf("expected card expiry 2000, got %d", got)
+	}
+
+	if due := Db_select_cards_due_to_expire(db, 1999); len(due) != 0 {
+		t.Fatalf("expected no cards due before expiry, got %v", due)
+	}
+	if due := Db_select_cards_due_to_expire(db, 2000); len(due) != 1 || due[0] != id {
+		t.Fatalf("expected card %d due at expiry, got %v", id, due)
+	}
+}
+
+// TestForfeitCardBalance_CreditsHouseAccount verifies a forfeit empties the
+// card and leaves an entry on the house account linked to the debit.
+func TestForfeitCardBalance_CreditsHouseAccount(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	Db_add_card_receipt(db, id, "lnbc_fund", "fundhash", 1200)
+	Db_set_receipt_paid(db, "fundhash", "test")
+	Db_set_card_expiry(db, id, int(time.Now().Unix())-10)
+
+	if cards := Db_select_cards_to_forfeit(db, time.Now().Unix()); len(cards) != 1 || cards[0].BalanceSats != 1200 {
+		t.Fatalf("expected one card with 1200 sats to forfeit, got %+v", cards)
+	}
+
+	amount, err := Db_forfeit_card_balance(db, id, "house", "expired")
+	if err != nil || amount != 1200 {
+		t.Fatalf("expected 1200 forfeited, got %d err=%v", amount, err)
+	}
+	if b := Db_get_card_balance(db, id); b != 0 {
+		t.Fatalf("expected empty card, got %d", b)
+	}
+
+	accounts := Db_select_house_accounts(db)
+	if len(accounts) != 1 || accounts[0].Name != "house" || accounts[0].BalanceSats != 1200 {
+		t.Fatalf("unexpected house accounts %+v", accounts)
+	}
+	entries := Db_select_house_account_entries(db, "house", 10)
+	if len(entries) != 1 || entries[0].CardId != id || entries[0].CardPaymentId == 0 ||
+		entries[0].Kind != HouseEntryForfeit {
+		t.Fatalf("unexpected house entries %+v", entries)
+	}
+
+	// nothing left to take
+	if amount, _ := Db_forfeit_card_balance(db, id, "house", "expired"); amount != 0 {
+		t.Fatalf("expected nothing forfeited from an empty card, got %d", amount)
+	}
+	if cards := Db_select_cards_to_forfeit(db, time.Now().Unix()); len(cards) != 0 {
+		t.Fatalf("expected nothing left to forfeit, got %+v", cards)
+	}
+}
+
+// TestForfeitCardBalance_LeavesSubSatRemainder verifies only whole sats are
+// forfeited: the house account gets exactly what the card loses, and a card
+// with less than a sat left is not selected again.
+func TestForfeitCardBalance_LeavesSubSatRemainder(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	Db_add_card_receipt_from_payer(db, id, "lnbc_fund", "fundhash", 1200_700, ReceiptPayer{})
+	Db_set_receipt_paid(db, "fundhash", "test")
+	Db_set_card_expiry(db, id, int(time.Now().Unix())-10)
+
+	amount, err := Db_forfeit_card_balance(db, id, "house", "expired")
+	if err != nil || amount != 1200 {
+		t.Fatalf("expected 1200 forfeited, got %d err=%v", amount, err)
+	}
+	if b := Db_get_card_balance_msat(db, id); b != 700 {
+		t.Fatalf("expected 700 msat left on the card, got %d", b)
+	}
+	if accounts := Db_select_house_accounts(db); len(accounts) != 1 || accounts[0].BalanceSats != 1200 {
+		t.Fatalf("unexpected house accounts %+v", accounts)
+	}
+
+	if cards := Db_select_cards_to_forfeit(db, time.Now().Unix()); len(cards) != 0 {
+		t.Fatalf("expected a sub-sat balance not to be forfeited, got %+v", cards)
+	}
+	if amount, _ := Db_forfeit_card_balance(db, id, "house", "expired"); amount != 0 {
+		t.Fatalf("expected nothing forfeited from a sub-sat balance, got %d", amount)
+	}
+}
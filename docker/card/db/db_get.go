@@ -35,14 +35,8 @@ func Db_get_card_count(db_conn *sql.DB) (count int, err error) {
 }
 
 func Db_get_total_card_balance(db_conn *sql.DB) int {
-	sqlStatement := `SELECT IFNULL(SUM(balance_sats), 0) FROM (` +
-		` SELECT` +
-		` IFNULL((SELECT SUM(r.amount_sats) FROM card_receipts r WHERE r.paid_flag='Y' AND r.card_id=c.card_id), 0)` +
-		` - IFNULL((SELECT SUM(p.amount_sats + p.fee_sats) FROM card_payments p WHERE p.paid_flag='Y' AND p.card_id=c.card_id), 0)` +
-		` AS balance_sats` +
-		` FROM cards c` +
-		` WHERE c.wiped = 'N' AND c.lnurlw_enable = 'Y'` +
-		` );`
+	sqlStatement := `SELECT IFNULL(SUM(balance_sats), 0) FROM cards` +
+		` WHERE wiped = 'N' AND lnurlw_enable = 'Y';`
 
 	var total int
 	row := db_conn.QueryRow(sqlStatement)
@@ -50,41 +44,43 @@ func Db_get_total_card_balance(db_conn *sql.DB) int {
 	return total
 }
 
-func Db_get_card_id_from_access_token(db_conn *sql.DB, access_token string) (card_id int) {
+func Db_get_total_paid_receipts(db_conn *sql.DB, card_id int) int {
 
 	// get card id
-	sqlStatement := `SELECT card_id FROM cards WHERE access_token=$1 AND wiped = 'N';`
-	row := db_conn.QueryRow(sqlStatement, access_token)
+	sqlStatement := `SELECT IFNULL(SUM(amount_msat),0) FROM card_receipts` +
+		` WHERE paid_flag='Y' AND card_id=$1;`
+	row := db_conn.QueryRow(sqlStatement, card_id)
 
-	value := 0
-	err := row.Scan(&value)
+	var valueMsat int64
+	err := row.Scan(&valueMsat)
 	if err != nil {
 		return 0
 	}
 
-	return value
+	return MsatToSatsDown(valueMsat)
 }
 
-func Db_get_total_paid_receipts(db_conn *sql.DB, card_id int) int {
+// Db_get_total_loaded_receipts is Db_get_total_paid_receipts without the
+// receipt that funded the card with its group's initial balance.
+func Db_get_total_loaded_receipts(db_conn *sql.DB, card_id int) int {
 
-	// get card id
-	sqlStatement := `SELECT IFNULL(SUM(amount_sats),0) FROM card_receipts` +
-		` WHERE paid_flag='Y' AND card_id=$1;`
-	row := db_conn.QueryRow(sqlStatement, card_id)
+	sqlStatement := `SELECT IFNULL(SUM(amount_msat),0) FROM card_receipts` +
+		` WHERE paid_flag='Y' AND card_id=$1 AND settled_by != $2;`
+	row := db_conn.QueryRow(sqlStatement, card_id, ReceiptSettledByGroupInitial)
 
-	value := 0
-	err := row.Scan(&value)
+	var valueMsat int64
+	err := row.Scan(&valueMsat)
 	if err != nil {
 		return 0
 	}
 
-	return value
+	return MsatToSatsDown(valueMsat)
 }
 
+// Db_get_card_balance returns the card's stored balance, which the
+// card_receipts and card_payments triggers keep up to date.
 func Db_get_card_balance(db_conn *sql.DB, card_id int) int {
-	sqlStatement := `SELECT
-		IFNULL((SELECT SUM(amount_sats) FROM card_receipts WHERE paid_flag='Y' AND card_id=$1), 0) -
-		IFNULL((SELECT SUM(amount_sats) + SUM(fee_sats) FROM card_payments WHERE paid_flag='Y' AND card_id=$1), 0)`
+	sqlStatement := `SELECT balance_sats FROM cards WHERE card_id=$1;`
 	row := db_conn.QueryRow(sqlStatement, card_id)
 	value := 0
 	err := row.Scan(&value)
@@ -94,15 +90,34 @@ func Db_get_card_balance(db_conn *sql.DB, card_id int) int {
 	return value
 }
 
+// Db_get_card_balance_msat returns a card's balance in millisatoshis.
+func Db_get_card_balance_msat(db_conn *sql.DB, card_id int) int64 {
+	sqlStatement := `SELECT balance_msat FROM cards WHERE card_id=$1;`
+	row := db_conn.QueryRow(sqlStatement, card_id)
+	var value int64
+	err := row.Scan(&value)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
 type CardLookup struct {
-	CardId int
-	Key1   string
-	Key2   string
-	UID    string
+	CardId     int
+	Key1       string
+	Key2       string
+	UID        string
+	KeyVersion int
+	Pending    bool // the card's pending (not yet confirmed) key set
+	Previous   bool // a replaced key set still in its grace period
 }
 
 type CardLookups []CardLookup
 
+// Db_get_card_keys returns the key sets a tap may be matched against: the
+// current keys of every active card, plus the pending keys of any card with a
+// key rotation in progress and any replaced key sets still in their grace
+// period, so the card keeps working whichever set the chip holds.
 func Db_get_card_keys(db_conn *sql.DB) CardLookups {
 
 	var cardLookups CardLookups
@@ -110,9 +125,21 @@ func Db_get_card_keys(db_conn *sql.DB) CardLookups {
 	// get card id
 	sqlStatement := `SELECT card_id,` +
 		` key1_enc, key2_cmac,` +
-		` uid` +
+		` uid, key_version, 0` +
+		` FROM cards` +
+		` WHERE wiped = 'N'` +
+		` UNION ALL` +
+		` SELECT card_id,` +
+		` pending_key1_enc, pending_key2_cmac,` +
+		` uid, pending_key_version, 1` +
 		` FROM cards` +
-		` WHERE wiped = 'N';`
+		` WHERE wiped = 'N' AND pending_key1_enc != ''` +
+		` UNION ALL` +
+		` SELECT h.card_id,` +
+		` h.key1_enc, h.key2_cmac,` +
+		` c.uid, h.key_version, 2` +
+		` FROM card_key_history h JOIN cards c ON c.card_id = h.card_id` +
+		` WHERE c.wiped = 'N' AND h.accept_until > unixepoch();`
 	rows, err := db_conn.Query(sqlStatement)
 	if err != nil {
 		log.Error("db_get_card_keys query error: ", err)
@@ -122,16 +149,21 @@ func Db_get_card_keys(db_conn *sql.DB) CardLookups {
 
 	for rows.Next() {
 		var cardLookup CardLookup
+		var keySet int
 
 		err := rows.Scan(
 			&cardLookup.CardId,
 			&cardLookup.Key1,
 			&cardLookup.Key2,
-			&cardLookup.UID)
+			&cardLookup.UID,
+			&cardLookup.KeyVersion,
+			&keySet)
 		if err != nil {
 			log.Error("db_get_card_keys scan error: ", err)
 			continue
 		}
+		cardLookup.Pending = keySet == 1
+		cardLookup.Previous = keySet == 2
 
 		cardLookups = append(cardLookups, cardLookup)
 	}
@@ -170,25 +202,27 @@ func Db_get_lnurlw_k1(db_conn *sql.DB, lnurlw_k1 string) (card_id int, lnurlw_k1
 	return card_id, lnurlw_k1_expiry
 }
 
-// Db_get_card_keys_for_wipe_secret returns the card's keys for a valid,
-// unexpired wipe secret so the Bolt Card app can reset the physical chip.
+// Db_get_card_keys_for_wipe_secret returns the keys the card's chip holds for
+// a valid, unexpired wipe secret so the Bolt Card app can reset the physical
+// chip. If the card was last tapped with a replaced key set, that set is
+// returned rather than the current one.
 // Deliberately does NOT filter wiped='N' — the card has just been wiped.
 // Returns an empty CardKeys if the secret is empty, unknown, or expired.
 func Db_get_card_keys_for_wipe_secret(db_conn *sql.DB, wipeSecret string) CardKeys {
 
-	var keys CardKeys
 	if wipeSecret == "" {
-		return keys
+		return CardKeys{}
 	}
 
-	sqlStatement := `SELECT key0_auth, key1_enc, key2_cmac, key3, key4 FROM cards` +
+	var cardId int
+	sqlStatement := `SELECT card_id FROM cards` +
 		` WHERE wipe_secret = $1 AND wipe_secret_expiry > unixepoch();`
 	row := db_conn.QueryRow(sqlStatement, wipeSecret)
 
-	if err := row.Scan(&keys.Key0, &keys.Key1, &keys.Key2, &keys.Key3, &keys.Key4); err != nil {
+	if err := row.Scan(&cardId); err != nil {
 		return CardKeys{}
 	}
-	return keys
+	return Db_get_card_chip_keys(db_conn, cardId)
 }
 
 type Card struct {
@@ -200,8 +234,6 @@ type Card struct {
 	Key4                       string
 	Login                      string
 	Password                   string
-	Access_token               string
-	Refresh_token              string
 	Uid                        string
 	Last_counter_value         int
 	Lnurlw_request_timeout_sec int
@@ -217,21 +249,32 @@ type Card struct {
 	Wiped                      string
 	Note                       string
 	Ln_address                 string
+	Ln_alias                   string
 	Ln_address_enabled         string
 	Pay_link_enabled           string
+	Key_version                int
+	Pending_key_version        int
+	Status                     string
+	Status_reason              string
+	Status_changed_at          int
+	Replaced_by_card_id        int
 }
 
+// Db_get_card returns a card in any status, including wiped; callers decide
+// what the status allows.
 func Db_get_card(db_conn *sql.DB, card_id int) (card *Card, err error) {
 
 	c := Card{}
 
 	sqlStatement := `SELECT card_id, key0_auth, key1_enc, ` +
-		`key2_cmac, key3, key4, login, password, access_token, ` +
-		`refresh_token, uid, last_counter_value, ` +
+		`key2_cmac, key3, key4, login, password, uid, last_counter_value, ` +
 		`lnurlw_request_timeout_sec, lnurlw_enable, ` +
 		`lnurlw_k1, lnurlw_k1_expiry, tx_limit_sats, ` +
 		`day_limit_sats, uid_privacy, pin_enable, pin_number, ` +
-		`pin_limit_sats, wiped, note, ln_address, ln_address_enabled, pay_link_enabled FROM cards WHERE card_id=$1 AND wiped = 'N';`
+		`pin_limit_sats, wiped, note, ln_address, ln_alias, ln_address_enabled, pay_link_enabled, ` +
+		`key_version, pending_key_version, status, status_reason, status_changed_at, ` +
+		`replaced_by_card_id ` +
+		`FROM cards WHERE card_id=$1;`
 	row := db_conn.QueryRow(sqlStatement, card_id)
 	err = row.Scan(
 		&c.Card_id,
@@ -242,8 +285,6 @@ func Db_get_card(db_conn *sql.DB, card_id int) (card *Card, err error) {
 		&c.Key4,
 		&c.Login,
 		&c.Password,
-		&c.Access_token,
-		&c.Refresh_token,
 		&c.Uid,
 		&c.Last_counter_value,
 		&c.Lnurlw_request_timeout_sec,
@@ -259,8 +300,15 @@ func Db_get_card(db_conn *sql.DB, card_id int) (card *Card, err error) {
 		&c.Wiped,
 		&c.Note,
 		&c.Ln_address,
+		&c.Ln_alias,
 		&c.Ln_address_enabled,
-		&c.Pay_link_enabled)
+		&c.Pay_link_enabled,
+		&c.Key_version,
+		&c.Pending_key_version,
+		&c.Status,
+		&c.Status_reason,
+		&c.Status_changed_at,
+		&c.Replaced_by_card_id)
 
 	return &c, err
 }
@@ -278,14 +326,8 @@ func Db_get_top_cards_by_balance(db_conn *sql.DB, limit int) TopCards {
 
 	var topCards TopCards
 
-	sqlStatement := `SELECT card_id, note, lnurlw_enable, balance_sats FROM (` +
-		` SELECT c.card_id, c.note, c.lnurlw_enable,` +
-		` IFNULL((SELECT SUM(r.amount_sats) FROM card_receipts r WHERE r.paid_flag='Y' AND r.card_id=c.card_id), 0)` +
-		` - IFNULL((SELECT SUM(p.amount_sats + p.fee_sats) FROM card_payments p WHERE p.paid_flag='Y' AND p.card_id=c.card_id), 0)` +
-		` AS balance_sats` +
-		` FROM cards c` +
-		` WHERE c.wiped = 'N'` +
-		` ) WHERE balance_sats > 0` +
+	sqlStatement := `SELECT card_id, note, lnurlw_enable, balance_sats FROM cards` +
+		` WHERE wiped = 'N' AND balance_sats > 0` +
 		` ORDER BY balance_sats DESC` +
 		` LIMIT $1;`
 
@@ -387,10 +429,28 @@ func Db_get_card_lnurlw_enable(db_conn *sql.DB, card_id int) string {
 	return value
 }
 
+// Db_get_card_uid returns the UID recorded for a card (empty if not yet known).
+func Db_get_card_uid(db_conn *sql.DB, cardId int) string {
+
+	sqlStatement := `SELECT uid FROM cards WHERE card_id=$1 AND wiped = 'N';`
+	row := db_conn.QueryRow(sqlStatement, cardId)
+	value := ""
+	err := row.Scan(&value)
+	if err != nil {
+		return ""
+	}
+
+	return value
+}
+
+// Db_get_card_id_from_card_uid looks a card up by UID, ignoring hex case. A
+// physical card re-programmed after a wipe has several records, so the active
+// (then most recent) one is returned.
 func Db_get_card_id_from_card_uid(db_conn *sql.DB, card_uid string) (card_id int) {
 
 	// get card id
-	sqlStatement := `SELECT card_id FROM cards WHERE uid=$1;`
+	sqlStatement := `SELECT card_id FROM cards WHERE LOWER(uid)=LOWER($1) AND uid != ''` +
+		` ORDER BY wiped = 'N' DESC, card_id DESC LIMIT 1;`
 	row := db_conn.QueryRow(sqlStatement, card_uid)
 	value := 0
 	err := row.Scan(&value)
@@ -407,7 +467,9 @@ type TableCount struct {
 }
 
 func Db_get_table_counts(db_conn *sql.DB) ([]TableCount, error) {
-	tables := []string{"cards", "card_payments", "card_receipts", "settings", "program_cards", "pay_link_addresses"}
+	tables := []string{"cards", "card_payments", "card_receipts", "settings", "program_cards", "pay_link_addresses",
+		"card_security_events", "card_taps", "card_transfers", "payout_policies",
+		"card_groups", "house_accounts", "house_account_entries"}
 	counts := make([]TableCount, 0, len(tables))
 	for _, t := range tables {
 		var count int
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// house account entry kinds
+const (
+	HouseEntryForfeit    = "forfeit"     // unclaimed balance taken from a card
+	HouseEntryServiceFee = "service_fee" // operator fee on a card spend or top-up
+)
+
+// HouseAccount is an operator-owned account that receives value taken from
+// cards, such as forfeited balances. Its balance is not card liability.
+type HouseAccount struct {
+	HouseAccountId int
+	Name           string
+	CreatedAt      int
+	BalanceSats    int
+}
+
+type HouseAccounts []HouseAccount
+
+// HouseAccountEntry is one credit to a house account.
+type HouseAccountEntry struct {
+	EntryId       int
+	CardId        int
+	CardPaymentId int
+	AmountSats    int
+	Kind          string
+	Reason        string
+	Timestamp     int
+}
+
+type HouseAccountEntries []HouseAccountEntry
+
+// creditHouseAccount records a credit to the named house account, creating the
+// account on first use, inside a transaction.
+func creditHouseAccount(ctx context.Context, conn *sql.Conn, name string, cardId int,
+	cardPaymentId int, amountSats int, kind string, reason string) error {
+
+	now := time.Now().Unix()
+
+	_, err := conn.ExecContext(ctx,
+		`INSERT OR IGNORE INTO house_accounts (name, created_at) VALUES ($1, $2);`, name, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx,
+		`INSERT INTO house_account_entries (house_account_id, card_id, card_payment_id,`+
+			` amount_sats, kind, reason, timestamp)`+
+			` SELECT house_account_id, $1, $2, $3, $4, $5, $6 FROM house_accounts WHERE name = $7;`,
+		cardId, cardPaymentId, amountSats, kind, reason, now, name)
+	return err
+}
+
+// Db_forfeit_card_balance moves the whole sats of a card's balance to the named
+// house account in one transaction: a paid card_payments row debits the card
+// and a house_account_entries row records where the value went. A sub-sat
+// remainder stays on the card, as house accounts are kept in sats. Returns the
+// amount moved, which is 0 if the card had less than a sat.
+func Db_forfeit_card_balance(db_conn *sql.DB, cardId int, houseAccount string, reason string) (int, error) {
+
+	amount := 0
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+
+		balance, err := cardBalanceTx(ctx, conn, cardId)
+		if err != nil {
+			return err
+		}
+		forfeitSats := MsatToSatsDown(balance)
+		if forfeitSats <= 0 {
+			return nil
+		}
+
+		now := time.Now().Unix()
+
+		res, err := conn.ExecContext(ctx,
+			`INSERT INTO card_payments (card_id, amount_sats, amount_msat, ln_invoice, paid_flag,`+
+				` timestamp, expire_time, settled_at) VALUES ($1, $2, $3, '', 'Y', $4, $4, $4);`,
+			cardId, forfeitSats, SatsToMsat(forfeitSats), now)
+		if err != nil {
+			return err
+		}
+		paymentId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		err = creditHouseAccount(ctx, conn, houseAccount, cardId, int(paymentId),
+			forfeitSats, HouseEntryForfeit, reason)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.ExecContext(ctx,
+			`UPDATE cards SET forfeited_at = $1 WHERE card_id = $2;`, now, cardId)
+		if err != nil {
+			return err
+		}
+
+		amount = forfeitSats
+		return nil
+	})
+	if err != nil {
+		log.Error("db_forfeit_card_balance error: ", err)
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+// Db_select_house_accounts returns every house account with its balance.
+func Db_select_house_accounts(db_conn *sql.DB) HouseAccounts {
+	var accounts HouseAccounts
+
+	sqlStatement := `SELECT h.house_account_id, h.name, h.created_at,` +
+		` IFNULL((SELECT SUM(amount_sats) FROM house_account_entries e` +
+		` WHERE e.house_account_id = h.house_account_id), 0)` +
+		` FROM house_accounts h ORDER BY h.name;`
+	rows, err := db_conn.Query(sqlStatement)
+	if err != nil {
+		log.Error("db_select_house_accounts query error: ", err)
+		return accounts
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a HouseAccount
+		err := rows.Scan(&a.HouseAccountId, &a.Name, &a.CreatedAt, &a.BalanceSats)
+		if err != nil {
+			log.Error("db_select_house_accounts scan error: ", err)
+			return accounts
+		}
+		accounts = append(accounts, a)
+	}
+
+	return accounts
+}
+
+// Db_select_house_account_entries returns the most recent credits to the named
+// house account.
+func Db_select_house_account_entries(db_conn *sql.DB, name string, limit int) HouseAccountEntries {
+	var entries HouseAccountEntries
+
+	sqlStatement := `SELECT e.entry_id, e.card_id, e.card_payment_id, e.amount_sats,` +
+		` e.kind, e.reason, e.timestamp` +
+		` FROM house_account_entries e` +
+		` JOIN house_accounts h ON h.house_account_id = e.house_account_id` +
+		` WHERE h.name = $1 ORDER BY e.entry_id DESC LIMIT $2;`
+	rows, err := db_conn.Query(sqlStatement, name, limit)
+	if err != nil {
+		log.Error("db_select_house_account_entries query error: ", err)
+		return entries
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e HouseAccountEntry
+		err := rows.Scan(&e.EntryId, &e.CardId, &e.CardPaymentId, &e.AmountSats,
+			&e.Kind, &e.Reason, &e.Timestamp)
+		if err != nil {
+			log.Error("db_select_house_account_entries scan error: ", err)
+			return entries
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
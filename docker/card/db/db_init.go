@@ -69,7 +69,99 @@ func Db_init(db_conn *sql.DB) {
 		update_schema_12(db_conn) // wipe_secret columns (admin wipe deeplink)
 	}
 
-	if Db_get_setting(db_conn, "schema_version_number") != "13" {
+	if Db_get_setting(db_conn, "schema_version_number") == "13" {
+		update_schema_13(db_conn) // key_version and pending key columns
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "14" {
+		update_schema_14(db_conn) // card_security_events table
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "15" {
+		update_schema_15(db_conn) // card_taps table
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "16" {
+		update_schema_16(db_conn) // security event action and remote_ip
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "17" {
+		update_schema_17(db_conn) // card status and card_status_history table
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "18" {
+		update_schema_18(db_conn) // card replacement and card_transfers table
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "19" {
+		update_schema_19(db_conn) // payout_policies table
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "20" {
+		update_schema_20(db_conn) // card expiry, card_groups and house accounts
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "21" {
+		update_schema_21(db_conn) // card group defaults
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "22" {
+		update_schema_22(db_conn) // card list indexes
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "23" {
+		update_schema_23(db_conn) // materialised card balances
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "24" {
+		update_schema_24(db_conn) // millisatoshi ledger
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "25" {
+		update_schema_25(db_conn) // payment fee reserve and settlement
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "26" {
+		update_schema_26(db_conn) // operator service fees
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "27" {
+		update_schema_27(db_conn) // payment hashes and preimages
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "28" {
+		update_schema_28(db_conn) // wallet tokens with expiry and rotation
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "29" {
+		update_schema_29(db_conn) // card key history
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "30" {
+		update_schema_30(db_conn) // receipt comments
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "31" {
+		update_schema_31(db_conn) // payer data and zaps
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "32" {
+		update_schema_32(db_conn) // lightning address aliases
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "33" {
+		update_schema_33(db_conn) // receive limits and metadata
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "34" {
+		update_schema_34(db_conn) // receipt preimages
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") == "35" {
+		update_schema_35(db_conn) // receipt expiry
+	}
+
+	if Db_get_setting(db_conn, "schema_version_number") != "36" {
 		panic("database schema is not as expected")
 	}
 
@@ -96,4 +188,9 @@ func Db_init(db_conn *sql.DB) {
 
 		add_test_data(db_conn)
 	}
+
+	// key for signing NIP-57 zap receipts, also set on an existing database
+	if Db_get_setting(db_conn, "nostr_zap_secret") == "" {
+		Db_set_setting(db_conn, "nostr_zap_secret", util.Random_hex()+util.Random_hex())
+	}
 }
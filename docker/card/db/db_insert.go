@@ -15,9 +15,11 @@ func Db_insert_card(db_conn *sql.DB, key0 string, key1 string, k2 string, key3 s
 	// default: hubs deployed before the default was changed from 'N' to 'Y'
 	// still have 'N' as the column default (CREATE TABLE IF NOT EXISTS never
 	// re-applies it), which left newly programmed cards disabled.
+	// New cards are pending_programming until their first tap.
 	sqlStatement := `INSERT INTO cards (key0_auth, key1_enc,` +
-		` key2_cmac, key3, key4, login, password, ln_address, lnurlw_enable)` +
-		` VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'Y');`
+		` key2_cmac, key3, key4, login, password, ln_address, lnurlw_enable,` +
+		` status, status_changed_at)` +
+		` VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'Y', 'pending_programming', unixepoch());`
 	res, err := db_conn.Exec(sqlStatement, key0, key1, k2, key3, key4, login, password, lnAddress)
 	if err != nil {
 		log.Error("db_insert_card error: ", err)
@@ -33,28 +35,12 @@ func Db_insert_card(db_conn *sql.DB, key0 string, key1 string, k2 string, key3 s
 	}
 }
 
+// Db_insert_card_with_uid inserts a card for a group; see Db_insert_group_card.
 func Db_insert_card_with_uid(db_conn *sql.DB, key0 string, key1 string, k2 string, key3 string, key4 string,
 	login string, password string, uid string, group_tag string) {
 
-	lnAddress := "c." + randomHex8()
-
-	// lnurlw_enable set explicitly ('Y') — see Db_insert_card for why.
-	sqlStatement := `INSERT INTO cards (key0_auth, key1_enc,` +
-		` key2_cmac, key3, key4, login, password, uid, group_tag, ln_address, lnurlw_enable)` +
-		` VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'Y');`
-	res, err := db_conn.Exec(sqlStatement, key0, key1, k2, key3, key4, login, password, uid, group_tag, lnAddress)
-	if err != nil {
-		log.Error("db_insert_card_with_uid error: ", err)
-		return
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		log.Error("db_insert_card_with_uid rows affected error: ", err)
-		return
-	}
-	if count != 1 {
-		log.Error("db_insert_card_with_uid: expected one record to be inserted")
-	}
+	keys := CardKeys{Key0: key0, Key1: key1, Key2: k2, Key3: key3, Key4: key4}
+	Db_insert_group_card(db_conn, keys, login, password, uid, group_tag)
 }
 
 func Db_insert_program_cards(db_conn *sql.DB, secret string,
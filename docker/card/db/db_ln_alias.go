@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrLnAliasInvalid is returned for an alias outside the allowed charset or
+// length.
+var ErrLnAliasInvalid = errors.New("alias must be 3 to 32 characters of a-z, 0-9, - and _")
+
+// ErrLnAliasReserved is returned for an alias kept for the service itself.
+var ErrLnAliasReserved = errors.New("alias is reserved")
+
+// ErrLnAliasTaken is returned for an alias already used as an address.
+var ErrLnAliasTaken = errors.New("alias is already taken")
+
+// An alias has no dot, so it cannot be mistaken for a generated c. card
+// address or pl. pay link address.
+var lnAliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,31}$`)
+
+var lnAliasReserved = map[string]bool{
+	"abuse": true, "admin": true, "administrator": true, "api": true, "billing": true,
+	"card": true, "cards": true, "help": true, "hostmaster": true, "info": true,
+	"lnurl": true, "lnurlp": true, "mail": true, "no-reply": true, "noreply": true,
+	"pay": true, "postmaster": true, "root": true, "security": true, "support": true,
+	"system": true, "wallet": true, "webmaster": true, "www": true,
+}
+
+// Normalize_ln_alias lowercases an alias and checks its charset and that it
+// is not reserved.
+func Normalize_ln_alias(alias string) (string, error) {
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	if !lnAliasPattern.MatchString(alias) {
+		return "", ErrLnAliasInvalid
+	}
+	if lnAliasReserved[alias] {
+		return "", ErrLnAliasReserved
+	}
+	return alias, nil
+}
+
+// Db_set_card_ln_alias claims alias for a card, replacing any alias it had.
+// An empty alias releases the card's alias. The alias must not be in use as
+// another card's alias, any card's c. address or a pay link address. A wiped
+// or replaced card cannot claim one.
+func Db_set_card_ln_alias(db_conn *sql.DB, card_id int, alias string) (string, error) {
+	if alias != "" {
+		var err error
+		if alias, err = Normalize_ln_alias(alias); err != nil {
+			return "", err
+		}
+	}
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		var wiped, status string
+		err := conn.QueryRowContext(ctx, `SELECT wiped, status FROM cards WHERE card_id = $1;`,
+			card_id).Scan(&wiped, &status)
+		if err != nil {
+			return err
+		}
+		if wiped != "N" || status == CardStatusReplaced {
+			return ErrCardNotActive
+		}
+
+		if alias != "" {
+			var taken int
+			err = conn.QueryRowContext(ctx,
+				`SELECT (SELECT COUNT(*) FROM cards WHERE (ln_alias = $1 AND card_id != $2) OR ln_address = $1)`+
+					` + (SELECT COUNT(*) FROM pay_link_addresses WHERE address = $1);`,
+				alias, card_id).Scan(&taken)
+			if err != nil {
+				return err
+			}
+			if taken > 0 {
+				return ErrLnAliasTaken
+			}
+		}
+
+		_, err = conn.ExecContext(ctx, `UPDATE cards SET ln_alias = $1 WHERE card_id = $2;`, alias, card_id)
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, ErrLnAliasTaken) && !errors.Is(err, ErrCardNotActive) && err != sql.ErrNoRows {
+			log.Error("db_set_card_ln_alias error: ", err)
+		}
+		return "", err
+	}
+	return alias, nil
+}
+
+// Db_get_card_by_ln_alias finds the card an alias belongs to, if its
+// lightning address is enabled.
+func Db_get_card_by_ln_alias(db_conn *sql.DB, alias string) (card_id int) {
+	sqlStatement := `SELECT card_id FROM cards WHERE ln_alias = $1 AND ln_alias != ''` +
+		` AND ln_address_enabled = 'Y' AND wiped = 'N';`
+	err := db_conn.QueryRow(sqlStatement, strings.ToLower(alias)).Scan(&card_id)
+	if err != nil {
+		return 0
+	}
+	return card_id
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func insertCardWithToken(t *testing.T, db *sql.DB, login string, token string) int {
+	t.Helper()
+	Db_insert_card(db, "k0", "k1", "k2", "k3", "k4", login, "pass")
+	if err := Db_set_tokens(db, login, "pass", token, token+"ref"); err != nil {
+		t.Fatalf("set tokens: %v", err)
+	}
+	return Db_get_card_id_from_access_token(db, token)
+}
+
+func TestSetCardLnAlias_ValidatesAndIsUnique(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	cardId := insertUnfundedCard(t, db)
+	otherId := insertCardWithToken(t, db, "other", "otok")
+
+	for alias, want := range map[string]error{
+		"al":          ErrLnAliasInvalid,
+		"alice.smith": ErrLnAliasInvalid,
+		"-alice":      ErrLnAliasInvalid,
+		"admin":       ErrLnAliasReserved,
+	} {
+		if _, err := Db_set_card_ln_alias(db, cardId, alias); !errors.Is(err, want) {
+			t.Errorf("%q: expected %v, got %v", alias, want, err)
+		}
+	}
+
+	alias, err := Db_set_card_ln_alias(db, cardId, " Alice ")
+	if err != nil || alias != "alice" {
+		t.Fatalf("expected alice claimed, got %q, %v", alias, err)
+	}
+	if got := Db_get_card_by_ln_alias(db, "ALICE"); got != cardId {
+		t.Fatalf("expected the alias to resolve to card %d, got %d", cardId, got)
+	}
+	if _, err := Db_set_card_ln_alias(db, otherId, "alice"); !errors.Is(err, ErrLnAliasTaken) {
+		t.Fatalf("expected the alias taken for another card, got %v", err)
+	}
+	if _, err := Db_set_card_ln_alias(db, cardId, "alice"); err != nil {
+		t.Fatalf("expected a card to reclaim its own alias, got %v", err)
+	}
+
+	Db_add_pay_link_address(db, "bobpay", otherId, 1)
+	if _, err := Db_set_card_ln_alias(db, cardId, "bobpay"); !errors.Is(err, ErrLnAliasTaken) {
+		t.Fatalf("expected a pay link address to be taken, got %v", err)
+	}
+}
+
+func TestSetCardLnAlias_ReleasedOnWipe(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	cardId := insertUnfundedCard(t, db)
+
+	if _, err := Db_set_card_ln_alias(db, cardId, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	Db_wipe_card(db, cardId)
+
+	if got := Db_get_card_by_ln_alias(db, "alice"); got != 0 {
+		t.Fatalf("expected the alias released, still resolves to %d", got)
+	}
+	card, _ := Db_get_card(db, cardId)
+	if card.Ln_alias != "" {
+		t.Fatalf("expected the wiped card to have no alias, got %q", card.Ln_alias)
+	}
+	if _, err := Db_set_card_ln_alias(db, cardId, "alice"); !errors.Is(err, ErrCardNotActive) {
+		t.Fatalf("expected a wiped card not to claim an alias, got %v", err)
+	}
+
+	if _, err := Db_set_card_ln_alias(db, insertCardWithToken(t, db, "newcard", "ntok"), "alice"); err != nil {
+		t.Fatalf("expected the released alias to be claimable, got %v", err)
+	}
+}
+
+func TestSetCardLnAlias_RefusedForReplacedCard(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	cardId := insertUnfundedCard(t, db)
+
+	Db_set_card_replace_secret(db, cardId, "replAAA", time.Now().Unix()+3600)
+	if _, err := Db_replace_card(db, "replAAA", replaceTestKeys, "", "rlogin", "rpass"); err != nil {
+		t.Fatalf("replace card: %v", err)
+	}
+
+	if _, err := Db_set_card_ln_alias(db, cardId, "lostcard"); !errors.Is(err, ErrCardNotActive) {
+		t.Fatalf("expected a replaced card not to claim an alias, got %v", err)
+	}
+}
@@ -250,7 +250,9 @@ func TestDbInsertCard_EnablesWithdrawalsOnLegacyDefault(t *testing.T) {
 		password CHAR(32) NOT NULL,
 		ln_address CHAR(32) NOT NULL DEFAULT '',
 		lnurlw_enable CHAR(1) NOT NULL DEFAULT 'N',
-		wiped CHAR(1) NOT NULL DEFAULT 'N'
+		wiped CHAR(1) NOT NULL DEFAULT 'N',
+		status TEXT NOT NULL DEFAULT 'active',
+		status_changed_at INT NOT NULL DEFAULT 0
 	);`)
 	if err != nil {
 		t.Fatal(err)
@@ -284,7 +286,20 @@ func TestDbInsertCardWithUid_EnablesWithdrawalsOnLegacyDefault(t *testing.T) {
 		group_tag TEXT NOT NULL DEFAULT '',
 		ln_address CHAR(32) NOT NULL DEFAULT '',
 		lnurlw_enable CHAR(1) NOT NULL DEFAULT 'N',
-		wiped CHAR(1) NOT NULL DEFAULT 'N'
+		wiped CHAR(1) NOT NULL DEFAULT 'N',
+		status TEXT NOT NULL DEFAULT 'active',
+		status_changed_at INT NOT NULL DEFAULT 0,
+		tx_limit_sats INT NOT NULL DEFAULT 1000000,
+		day_limit_sats INT NOT NULL DEFAULT 0,
+		ln_address_enabled CHAR(1) NOT NULL DEFAULT 'Y'
+	);
+	CREATE TABLE card_groups (
+		group_tag TEXT PRIMARY KEY NOT NULL,
+		tx_limit_sats INTEGER NOT NULL DEFAULT 1000000,
+		day_limit_sats INTEGER NOT NULL DEFAULT 0,
+		initial_balance_sats INTEGER NOT NULL DEFAULT 0,
+		ln_address_enabled CHAR(1) NOT NULL DEFAULT 'Y',
+		created_at INTEGER NOT NULL DEFAULT 0
 	);`)
 	if err != nil {
 		t.Fatal(err)
@@ -396,3 +411,26 @@ func TestAdminWithdrawalLifecycle(t *testing.T) {
 		t.Fatalf("expected most recent to be failed, got %+v", rows[0])
 	}
 }
+
+func TestDbGetCardIdFromCardUid_PrefersActiveCard(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	Db_insert_card_with_uid(db, "a0", "a1", "a2", "a3", "a4", "old", "oldpass", "04AABBCCDDEEFF", "")
+	Db_set_tokens(db, "old", "oldpass", "oldtok", "oldref")
+	oldId := Db_get_card_id_from_access_token(db, "oldtok")
+	Db_insert_card_with_uid(db, "b0", "b1", "b2", "b3", "b4", "new", "newpass", "04aabbccddeeff", "")
+	Db_set_tokens(db, "new", "newpass", "newtok", "newref")
+	newId := Db_get_card_id_from_access_token(db, "newtok")
+
+	if got := Db_get_card_id_from_card_uid(db, "04aabbccddeeff"); got != newId {
+		t.Fatalf("expected most recent card %d, got %d", newId, got)
+	}
+
+	Db_wipe_card(db, newId)
+	if got := Db_get_card_id_from_card_uid(db, "04AABBCCDDEEFF"); got != oldId {
+		t.Fatalf("expected active card %d, got %d", oldId, got)
+	}
+	if got := Db_get_card_id_from_card_uid(db, ""); got != 0 {
+		t.Fatalf("expected no match for empty uid, got %d", got)
+	}
+}
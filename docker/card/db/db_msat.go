@@ -0,0 +1,26 @@
+package db
+
+// The ledger holds millisatoshi amounts. The sat columns beside them are kept
+// for older readers: a credit rounds down to whole sats and a debit rounds up,
+// so the sat columns never show more value than the card holds.
+
+// SatsToMsat converts whole sats to millisatoshis.
+func SatsToMsat(sats int) int64 {
+	return int64(sats) * 1000
+}
+
+// MsatToSatsDown converts millisatoshis to whole sats, rounding down.
+func MsatToSatsDown(msat int64) int {
+	if msat < 0 {
+		return -MsatToSatsUp(-msat)
+	}
+	return int(msat / 1000)
+}
+
+// MsatToSatsUp converts millisatoshis to whole sats, rounding up.
+func MsatToSatsUp(msat int64) int {
+	if msat < 0 {
+		return -MsatToSatsDown(-msat)
+	}
+	return int((msat + 999) / 1000)
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PayoutPolicy controls cardholder payouts for one card group. The policy with
+// an empty GroupTag applies to cards whose group has no policy of its own.
+type PayoutPolicy struct {
+	GroupTag string
+	Enabled  string
+	OpensAt  int // unix time; 0 = no start
+	ClosesAt int // unix time; 0 = no cutoff
+	MinSats  int // 0 = no minimum
+	MaxSats  int // 0 = no maximum
+}
+
+type PayoutPolicies []PayoutPolicy
+
+// Open reports whether the policy allows payouts at time now.
+func (p PayoutPolicy) Open(now int64) bool {
+	if p.Enabled != "Y" {
+		return false
+	}
+	if p.OpensAt != 0 && now < int64(p.OpensAt) {
+		return false
+	}
+	if p.ClosesAt != 0 && now >= int64(p.ClosesAt) {
+		return false
+	}
+	return true
+}
+
+// Db_get_payout_policy_for_card returns the payout policy for the card's
+// group, falling back to the default policy. A card with no applicable policy
+// gets a disabled one.
+func Db_get_payout_policy_for_card(db_conn *sql.DB, cardId int) PayoutPolicy {
+	var p PayoutPolicy
+
+	sqlStatement := `SELECT group_tag, enabled, opens_at, closes_at, min_sats, max_sats` +
+		` FROM payout_policies` +
+		` WHERE group_tag IN (IFNULL((SELECT group_tag FROM cards WHERE card_id=$1), ''), '')` +
+		` ORDER BY group_tag = '' LIMIT 1;`
+	err := db_conn.QueryRow(sqlStatement, cardId).Scan(
+		&p.GroupTag, &p.Enabled, &p.OpensAt, &p.ClosesAt, &p.MinSats, &p.MaxSats)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Error("db_get_payout_policy_for_card error: ", err)
+		}
+		return PayoutPolicy{Enabled: "N"}
+	}
+	return p
+}
+
+// Db_select_payout_policies returns every payout policy, default first.
+func Db_select_payout_policies(db_conn *sql.DB) PayoutPolicies {
+	var policies PayoutPolicies
+
+	sqlStatement := `SELECT group_tag, enabled, opens_at, closes_at, min_sats, max_sats` +
+		` FROM payout_policies ORDER BY group_tag;`
+	rows, err := db_conn.Query(sqlStatement)
+	if err != nil {
+		log.Error("db_select_payout_policies query error: ", err)
+		return policies
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p PayoutPolicy
+		err := rows.Scan(&p.GroupTag, &p.Enabled, &p.OpensAt, &p.ClosesAt, &p.MinSats, &p.MaxSats)
+		if err != nil {
+			log.Error("db_select_payout_policies scan error: ", err)
+			return policies
+		}
+		policies = append(policies, p)
+	}
+
+	return policies
+}
+
+// Db_set_payout_policy creates or replaces the payout policy for a group.
+func Db_set_payout_policy(db_conn *sql.DB, p PayoutPolicy) error {
+	sqlStatement := `INSERT INTO payout_policies (group_tag, enabled, opens_at, closes_at, min_sats, max_sats)` +
+		` VALUES ($1, $2, $3, $4, $5, $6)` +
+		` ON CONFLICT(group_tag) DO UPDATE SET enabled=excluded.enabled, opens_at=excluded.opens_at,` +
+		` closes_at=excluded.closes_at, min_sats=excluded.min_sats, max_sats=excluded.max_sats;`
+	_, err := db_conn.Exec(sqlStatement, p.GroupTag, p.Enabled, p.OpensAt, p.ClosesAt, p.MinSats, p.MaxSats)
+	if err != nil {
+		log.Error("db_set_payout_policy error: ", err)
+	}
+	return err
+}
+
+// Db_delete_payout_policy removes a group's payout policy so the default
+// applies again. The default policy itself cannot be deleted.
+func Db_delete_payout_policy(db_conn *sql.DB, groupTag string) error {
+	if groupTag == "" {
+		return nil
+	}
+	_, err := db_conn.Exec(`DELETE FROM payout_policies WHERE group_tag=$1;`, groupTag)
+	if err != nil {
+		log.Error("db_delete_payout_policy error: ", err)
+	}
+	return err
+}
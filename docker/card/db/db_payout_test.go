@@ -0,0 +1,40 @@
+package db
+
+import "testing"
+
+// TestPayoutPolicy_GroupOverridesDefault verifies a card uses its group's
+// payout policy when there is one and the default policy otherwise.
+func TestPayoutPolicy_GroupOverridesDefault(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	if p := Db_get_payout_policy_for_card(db, id); p.GroupTag != "" || p.Enabled != "N" {
+		t.Fatalf("expected the disabled default policy, got %+v", p)
+	}
+
+	Db_set_payout_policy(db, PayoutPolicy{GroupTag: "vip", Enabled: "Y", MaxSats: 500})
+	db.Exec(`UPDATE cards SET group_tag='vip' WHERE card_id=$1`, id)
+	if p := Db_get_payout_policy_for_card(db, id); p.GroupTag != "vip" || p.MaxSats != 500 {
+		t.Fatalf("expected the vip policy, got %+v", p)
+	}
+
+	Db_delete_payout_policy(db, "vip")
+	if p := Db_get_payout_policy_for_card(db, id); p.GroupTag != "" {
+		t.Fatalf("expected the default policy after delete, got %+v", p)
+	}
+	if len(Db_select_payout_policies(db)) != 1 {
+		t.Fatal("expected only the default policy to remain")
+	}
+}
+
+func TestPayoutPolicy_Open(t *testing.T) {
+	p := PayoutPolicy{Enabled: "Y", OpensAt: 100, ClosesAt: 200}
+	if p.Open(99) || !p.Open(100) || !p.Open(199) || p.Open(200) {
+		t.Fatal("unexpected window boundaries")
+	}
+	p.Enabled = "N"
+	if p.Open(150) {
+		t.Fatal("expected a disabled policy to be closed")
+	}
+}
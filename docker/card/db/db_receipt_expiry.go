@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultReceiptExpiryGraceSec is how long after its invoice expires an
+// unpaid receipt is still polled for, unless the receipt_expiry_grace_sec
+// setting says otherwise. It covers a payment in flight at the expiry.
+const DefaultReceiptExpiryGraceSec = 10 * 60
+
+// the expiry of a receipt whose invoice cannot be decoded
+const defaultReceiptExpirySec = 24 * 60 * 60
+
+// receiptExpiryGrace reads the grace period in seconds.
+func receiptExpiryGrace(db_conn *sql.DB) int64 {
+	if v := Db_get_setting(db_conn, "receipt_expiry_grace_sec"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return DefaultReceiptExpiryGraceSec
+}
+
+// invoiceExpiresAt is when a bolt11 invoice expires, or a day from now when
+// it cannot be decoded.
+func invoiceExpiresAt(payment_request string, now int64) int64 {
+	bolt11, err := decodepay.Decodepay(payment_request)
+	if err != nil || bolt11.CreatedAt == 0 {
+		return now + defaultReceiptExpirySec
+	}
+	return int64(bolt11.CreatedAt) + int64(bolt11.Expiry)
+}
+
+// Db_expire_receipts marks expired the unpaid receipts whose invoice expired
+// more than the grace period ago, and returns how many it marked.
+func Db_expire_receipts(db_conn *sql.DB) int {
+	sqlStatement := `UPDATE card_receipts SET expired_at = unixepoch()` +
+		` WHERE paid_flag = 'N' AND expired_at = 0 AND expire_time + $1 <= unixepoch();`
+	res, err := db_conn.Exec(sqlStatement, receiptExpiryGrace(db_conn))
+	if err != nil {
+		log.Error("db_expire_receipts error: ", err)
+		return 0
+	}
+	count, _ := res.RowsAffected()
+	return int(count)
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// a real invoice created at 1651105770 with a 600s expiry
+const expiryTestInvoice = "lnbc15u1p3xnhl2pp5jptserfk3zk4qy42tlucycrfwxhydvlemu9pqr93tuzlv9cc7g3sdqsvfhkcap3xyhx7un8cqzpgxqzjcsp5f8c52y2stc300gl6s4xswtjpc37hrnnr3c9wvtgjfuvqmpm35evq9qyyssqy4lgd8tj637qcjp05rdpxxykjenthxftej7a2zzmwrmrl70fyj9hvj0rewhzj7jfyuwkwcg9g2jpwtk3wkjtwnkdks84hsnu8xps5vsq4gj5hs"
+
+func TestAddCardReceipt_ExpireTimeFromInvoice(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	cardId := insertUnfundedCard(t, db)
+
+	Db_add_card_receipt(db, cardId, expiryTestInvoice, "hash_real", 1500)
+	receipt, found := Db_select_card_receipt_by_hash(db, cardId, "hash_real")
+	if !found || receipt.ExpireTime != 1651105770+600 {
+		t.Fatalf("expected expire_time from invoice, got %+v", receipt)
+	}
+
+	before := time.Now().Unix()
+	Db_add_card_receipt(db, cardId, "not-an-invoice", "hash_fake", 10)
+	receipt, _ = Db_select_card_receipt_by_hash(db, cardId, "hash_fake")
+	if int64(receipt.ExpireTime) < before+defaultReceiptExpirySec {
+		t.Fatalf("expected a day's expiry for an undecodable invoice, got %d", receipt.ExpireTime)
+	}
+}
+
+func TestExpireReceipts(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+	cardId := insertUnfundedCard(t, db)
+	now := time.Now().Unix()
+
+	insert := func(hash string, expireTime int64, paid string) {
+		t.Helper()
+		_, err := db.Exec(`INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex, amount_sats,`+
+			` amount_msat, paid_flag, timestamp, expire_time) VALUES ($1, '', $2, 10, 10000, $3, $4, $5);`,
+			cardId, hash, paid, expireTime-600, expireTime)
+		if err != nil {
+			t.Fatalf("insert receipt: %v", err)
+		}
+	}
+	insert("stale", now-DefaultReceiptExpiryGraceSec-1, "N")
+	insert("in_grace", now-60, "N")
+	insert("fresh", now+600, "N")
+	insert("paid", now-DefaultReceiptExpiryGraceSec-1, "Y")
+
+	if n := Db_expire_receipts(db); n != 1 {
+		t.Fatalf("expected 1 receipt expired, got %d", n)
+	}
+	if n := Db_expire_receipts(db); n != 0 {
+		t.Fatalf("expected nothing left to expire, got %d", n)
+	}
+
+	unpaid := map[string]bool{}
+	for _, r := range Db_select_unpaid_receipts(db) {
+		unpaid[r.PaymentHash] = true
+	}
+	if len(unpaid) != 2 || !unpaid["in_grace"] || !unpaid["fresh"] {
+		t.Fatalf("expected in_grace and fresh to be polled, got %v", unpaid)
+	}
+
+	// a shorter grace period expires the receipt still in grace
+	Db_set_setting(db, "receipt_expiry_grace_sec", "0")
+	if n := Db_expire_receipts(db); n != 1 {
+		t.Fatalf("expected in_grace expired with no grace, got %d", n)
+	}
+
+	// an expired receipt Phoenix reports paid is still settled
+	Db_set_receipt_paid_with_preimage(db, "stale", "pre", "poller")
+	receipt, _ := Db_select_card_receipt_by_hash(db, cardId, "stale")
+	if receipt.IsPaid != "Y" || receipt.Preimage != "pre" {
+		t.Fatalf("expected expired receipt settled, got %+v", receipt)
+	}
+	var expiredAt int64
+	db.QueryRow(`SELECT expired_at FROM card_receipts WHERE r_hash_hex = 'stale';`).Scan(&expiredAt)
+	if expiredAt != 0 {
+		t.Fatalf("expected expired_at cleared on settle, got %d", expiredAt)
+	}
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lightning address receive limits when neither a card nor its group sets
+// them
+const (
+	DefaultReceiveMinSats = 1
+	DefaultReceiveMaxSats = 100_000_000
+)
+
+// ReceiveSettings are the lightning address receive limits and the metadata
+// shown to payers. On a card or group, 0 and empty mean not set. A
+// MaxBalanceSats of 0 puts no cap on the card balance. Image is a data URL
+// of a PNG or JPEG.
+type ReceiveSettings struct {
+	MinSats        int
+	MaxSats        int
+	MaxBalanceSats int
+	Description    string
+	Image          string
+}
+
+// Db_get_card_receive_settings returns the receive settings set on a card
+// itself.
+func Db_get_card_receive_settings(db_conn *sql.DB, card_id int) (ReceiveSettings, error) {
+	var s ReceiveSettings
+	sqlStatement := `SELECT receive_min_sats, receive_max_sats, max_balance_sats, ln_description, ln_image` +
+		` FROM cards WHERE card_id = $1;`
+	err := db_conn.QueryRow(sqlStatement, card_id).
+		Scan(&s.MinSats, &s.MaxSats, &s.MaxBalanceSats, &s.Description, &s.Image)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error("db_get_card_receive_settings error: ", err)
+	}
+	return s, err
+}
+
+// Db_set_card_receive_settings replaces the receive settings set on a card.
+func Db_set_card_receive_settings(db_conn *sql.DB, card_id int, s ReceiveSettings) error {
+	sqlStatement := `UPDATE cards SET receive_min_sats = $1, receive_max_sats = $2, max_balance_sats = $3,` +
+		` ln_description = $4, ln_image = $5 WHERE card_id = $6 AND wiped = 'N';`
+	res, err := db_conn.Exec(sqlStatement, s.MinSats, s.MaxSats, s.MaxBalanceSats, s.Description, s.Image, card_id)
+	if err != nil {
+		log.Error("db_set_card_receive_settings error: ", err)
+		return err
+	}
+	if count, _ := res.RowsAffected(); count != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Db_get_card_receive_policy returns the receive settings that apply to a
+// card: each one the card's own if set, else its group's, else the default.
+func Db_get_card_receive_policy(db_conn *sql.DB, card_id int) ReceiveSettings {
+	s := ReceiveSettings{}
+	sqlStatement := `SELECT` +
+		` COALESCE(NULLIF(c.receive_min_sats, 0), NULLIF(g.receive_min_sats, 0), $1),` +
+		` COALESCE(NULLIF(c.receive_max_sats, 0), NULLIF(g.receive_max_sats, 0), $2),` +
+		` COALESCE(NULLIF(c.max_balance_sats, 0), NULLIF(g.max_balance_sats, 0), 0),` +
+		` COALESCE(NULLIF(c.ln_description, ''), NULLIF(g.ln_description, ''), ''),` +
+		` COALESCE(NULLIF(c.ln_image, ''), NULLIF(g.ln_image, ''), '')` +
+		` FROM cards c LEFT JOIN card_groups g ON g.group_tag = c.group_tag AND c.group_tag != ''` +
+		` WHERE c.card_id = $3;`
+	err := db_conn.QueryRow(sqlStatement, DefaultReceiveMinSats, DefaultReceiveMaxSats, card_id).
+		Scan(&s.MinSats, &s.MaxSats, &s.MaxBalanceSats, &s.Description, &s.Image)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Error("db_get_card_receive_policy error: ", err)
+		}
+		return ReceiveSettings{MinSats: DefaultReceiveMinSats, MaxSats: DefaultReceiveMaxSats}
+	}
+	return s
+}
+
+// Db_get_card_committed_balance_msat is the card's balance plus its unpaid
+// receipts whose invoices can still be paid, which is what the balance could
+// reach and what the balance cap is checked against.
+func Db_get_card_committed_balance_msat(db_conn *sql.DB, card_id int) int64 {
+	sqlStatement := `SELECT c.balance_msat + IFNULL((SELECT SUM(r.amount_msat) FROM card_receipts r` +
+		` WHERE r.card_id = c.card_id AND r.paid_flag = 'N' AND r.expired_at = 0` +
+		` AND r.expire_time > unixepoch()), 0)` +
+		` FROM cards c WHERE c.card_id = $1;`
+	var total int64
+	if err := db_conn.QueryRow(sqlStatement, card_id).Scan(&total); err != nil {
+		log.Error("db_get_card_committed_balance_msat error: ", err)
+		return 0
+	}
+	return total
+}
@@ -0,0 +1,35 @@
+package db
+
+import "testing"
+
+func TestCardReceivePolicy_CardOverridesGroup(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+
+	err := Db_set_card_group(db, CardGroup{GroupTag: "fest", TxLimitSats: 1000000, LnAddressEnabled: "Y",
+		Receive: ReceiveSettings{MinSats: 100, MaxSats: 50000, MaxBalanceSats: 200000, Description: "Festival wallet"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := CardKeys{Key0: "k0", Key1: "k1", Key2: "k2", Key3: "k3", Key4: "k4"}
+	cardId, _ := Db_insert_group_card(db, keys, "login1", "pass1", "uid1", "fest")
+	plainId := insertUnfundedCard(t, db)
+
+	policy := Db_get_card_receive_policy(db, cardId)
+	if policy != (ReceiveSettings{MinSats: 100, MaxSats: 50000, MaxBalanceSats: 200000, Description: "Festival wallet"}) {
+		t.Fatalf("expected the group's settings, got %+v", policy)
+	}
+
+	if err := Db_set_card_receive_settings(db, cardId, ReceiveSettings{MaxSats: 9000, Description: "Stall 4"}); err != nil {
+		t.Fatal(err)
+	}
+	policy = Db_get_card_receive_policy(db, cardId)
+	if policy != (ReceiveSettings{MinSats: 100, MaxSats: 9000, MaxBalanceSats: 200000, Description: "Stall 4"}) {
+		t.Fatalf("expected the card's settings over the group's, got %+v", policy)
+	}
+
+	policy = Db_get_card_receive_policy(db, plainId)
+	if policy != (ReceiveSettings{MinSats: DefaultReceiveMinSats, MaxSats: DefaultReceiveMaxSats}) {
+		t.Fatalf("expected the defaults for a card without a group, got %+v", policy)
+	}
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Db_set_card_pending_keys stores a new key set for an active card alongside
+// its current keys and issues the capability secret for the re-programming
+// deeplink. The pending set gets the next key version; issuing again before
+// the card is re-programmed replaces the pending set rather than skipping a
+// version. Returns the pending key version.
+func Db_set_card_pending_keys(db_conn *sql.DB, cardId int, keys CardKeys,
+	rekeySecret string, rekeySecretExpiry int64) (keyVersion int, err error) {
+
+	sqlStatement := `UPDATE cards SET pending_key0_auth = $1, pending_key1_enc = $2,` +
+		` pending_key2_cmac = $3, pending_key3 = $4, pending_key4 = $5,` +
+		` pending_key_version = key_version + 1,` +
+		` rekey_secret = $6, rekey_secret_expiry = $7` +
+		` WHERE card_id = $8 AND wiped = 'N';`
+	res, err := db_conn.Exec(sqlStatement, keys.Key0, keys.Key1, keys.Key2, keys.Key3, keys.Key4,
+		rekeySecret, rekeySecretExpiry, cardId)
+	if err != nil {
+		log.Error("db_set_card_pending_keys error: ", err)
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if count != 1 {
+		return 0, errors.New("card not found")
+	}
+
+	err = db_conn.QueryRow(`SELECT pending_key_version FROM cards WHERE card_id = $1;`,
+		cardId).Scan(&keyVersion)
+	return keyVersion, err
+}
+
+// Db_get_card_pending_keys_for_rekey_secret returns the pending key set for a
+// valid, unexpired re-programming secret, together with the card id. Returns
+// an empty CardKeys if the secret is empty, unknown, expired, or the rotation
+// has already completed.
+func Db_get_card_pending_keys_for_rekey_secret(db_conn *sql.DB, rekeySecret string) (cardId int, keys CardKeys) {
+
+	if rekeySecret == "" {
+		return 0, keys
+	}
+
+	sqlStatement := `SELECT card_id, pending_key0_auth, pending_key1_enc,` +
+		` pending_key2_cmac, pending_key3, pending_key4 FROM cards` +
+		` WHERE rekey_secret = $1 AND rekey_secret_expiry > unixepoch()` +
+		` AND pending_key1_enc != '' AND wiped = 'N';`
+	row := db_conn.QueryRow(sqlStatement, rekeySecret)
+
+	if err := row.Scan(&cardId, &keys.Key0, &keys.Key1, &keys.Key2, &keys.Key3, &keys.Key4); err != nil {
+		return 0, CardKeys{}
+	}
+	return cardId, keys
+}
+
+// Db_promote_card_pending_keys makes a card's pending key set current. It is
+// called on the first tap made with the pending keys, which proves the chip
+// was re-programmed, so the old keys stop being accepted from then on; they
+// are kept in card_key_history for the record only. The stored counter is
+// reset because taps made with the retired keys can no longer match, and a
+// re-programmed chip may restart its counter.
+func Db_promote_card_pending_keys(db_conn *sql.DB, cardId int) {
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		var pending int
+		err := conn.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM cards WHERE card_id = $1 AND pending_key1_enc != '';`, cardId).Scan(&pending)
+		if err != nil || pending == 0 {
+			return err
+		}
+
+		now := time.Now().Unix()
+		if err := archiveCardKeysTx(ctx, conn, cardId, false, 0, KeyRetiredRekeyConfirmed, now); err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx,
+			`UPDATE card_key_history SET accept_until = 0 WHERE card_id = $1 AND accept_until != 0;`, cardId)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.ExecContext(ctx, `UPDATE cards SET key0_auth = pending_key0_auth,`+
+			` key1_enc = pending_key1_enc, key2_cmac = pending_key2_cmac,`+
+			` key3 = pending_key3, key4 = pending_key4,`+
+			` key_version = pending_key_version, pending_key_version = 0,`+
+			` pending_key0_auth = '', pending_key1_enc = '', pending_key2_cmac = '',`+
+			` pending_key3 = '', pending_key4 = '',`+
+			` rekey_secret = '', rekey_secret_expiry = 0, last_counter_value = 0`+
+			` WHERE card_id = $1 AND pending_key1_enc != '';`, cardId)
+		return err
+	})
+	if err != nil {
+		log.Error("db_promote_card_pending_keys error: ", err)
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+var rekeyTestKeys = CardKeys{Key0: "nk0", Key1: "nk1enc", Key2: "nk2cmac", Key3: "nk3", Key4: "nk4"}
+
+// TestCardPendingKeys_LookupIncludesBothSets verifies a card with a rotation in
+// progress is offered to Find_card with both its current and pending keys.
+func TestCardPendingKeys_LookupIncludesBothSets(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	version, err := Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyAAA", time.Now().Unix()+3600)
+	if err != nil {
+		t.Fatalf("set pending keys: %v", err)
+	}
+	if version != 2 {
+		t.Fatalf("expected pending key version 2, got %d", version)
+	}
+
+	var current, pending *CardLookup
+	lookups := Db_get_card_keys(db)
+	for i := range lookups {
+		if lookups[i].CardId != id {
+			continue
+		}
+		if lookups[i].Pending {
+			pending = &lookups[i]
+		} else {
+			current = &lookups[i]
+		}
+	}
+	if current == nil || current.Key1 != "wk1enc" || current.KeyVersion != 1 {
+		t.Fatalf("expected current key set at version 1, got %+v", current)
+	}
+	if pending == nil || pending.Key1 != "nk1enc" || pending.Key2 != "nk2cmac" || pending.KeyVersion != 2 {
+		t.Fatalf("expected pending key set at version 2, got %+v", pending)
+	}
+}
+
+// TestCardPendingKeys_ReissueKeepsVersion verifies issuing a second rotation
+// before the first completes replaces the pending set without skipping a
+// version.
+func TestCardPendingKeys_ReissueKeepsVersion(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyAAA", time.Now().Unix()+3600)
+	version, err := Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyBBB", time.Now().Unix()+3600)
+	if err != nil {
+		t.Fatalf("set pending keys: %v", err)
+	}
+	if version != 2 {
+		t.Fatalf("expected pending key version 2 after reissue, got %d", version)
+	}
+	if cardId, _ := Db_get_card_pending_keys_for_rekey_secret(db, "rekeyAAA"); cardId != 0 {
+		t.Fatal("expected the superseded rekey secret to be invalid")
+	}
+}
+
+// TestCardPendingKeys_SecretLookup verifies the rekey secret resolves the
+// pending keys and is rejected once expired or unknown.
+func TestCardPendingKeys_SecretLookup(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyAAA", time.Now().Unix()+3600)
+
+	cardId, keys := Db_get_card_pending_keys_for_rekey_secret(db, "rekeyAAA")
+	if cardId != id || keys != rekeyTestKeys {
+		t.Fatalf("unexpected rekey lookup: card=%d keys=%+v", cardId, keys)
+	}
+	if cardId, _ := Db_get_card_pending_keys_for_rekey_secret(db, ""); cardId != 0 {
+		t.Fatal("expected empty secret never to match")
+	}
+
+	Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyEXP", time.Now().Unix()-10)
+	if cardId, _ := Db_get_card_pending_keys_for_rekey_secret(db, "rekeyEXP"); cardId != 0 {
+		t.Fatal("expected expired secret not to match")
+	}
+}
+
+// TestCardPendingKeys_PromoteRetiresOldKeys verifies promotion makes the
+// pending keys current, bumps the key version and drops the old set.
+func TestCardPendingKeys_PromoteRetiresOldKeys(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	Db_set_card_counter(db, id, 40)
+	Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyAAA", time.Now().Unix()+3600)
+
+	Db_promote_card_pending_keys(db, id)
+
+	card, err := Db_get_card(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Key0_auth != "nk0" || card.Key1_enc != "nk1enc" || card.Key2_cmac != "nk2cmac" {
+		t.Fatalf("expected pending keys to become current, got %+v", card)
+	}
+	if card.Key_version != 2 || card.Pending_key_version != 0 {
+		t.Fatalf("expected key version 2 with nothing pending, got %d/%d",
+			card.Key_version, card.Pending_key_version)
+	}
+	if card.Last_counter_value != 0 {
+		t.Fatalf("expected counter reset on promotion, got %d", card.Last_counter_value)
+	}
+	for _, l := range Db_get_card_keys(db) {
+		if l.CardId == id && (l.Pending || l.Key1 == "wk1enc") {
+			t.Fatalf("expected only the promoted key set, got %+v", l)
+		}
+	}
+	if cardId, _ := Db_get_card_pending_keys_for_rekey_secret(db, "rekeyAAA"); cardId != 0 {
+		t.Fatal("expected rekey secret to be cleared after promotion")
+	}
+}
+
+// TestDbSetCardKeys_BumpsKeyVersion verifies the wallet /getcardkeys path
+// counts as a new key version and cancels any pending rotation.
+func TestDbSetCardKeys_BumpsKeyVersion(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyAAA", time.Now().Unix()+3600)
+
+	Db_set_card_keys(db, id, "a0", "a1", "a2", "a3", "a4")
+
+	card, err := Db_get_card(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Key_version != 3 || card.Pending_key_version != 0 {
+		t.Fatalf("expected key version 3 with nothing pending, got %d/%d",
+			card.Key_version, card.Pending_key_version)
+	}
+}
+
+// TestDbSetCardKeys_PreviousKeysInGrace verifies keys replaced through the
+// wallet API stay in the lookup as a previous set until the card is tapped
+// with its current keys.
+func TestDbSetCardKeys_PreviousKeysInGrace(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	Db_set_card_keys(db, id, "a0", "a1", "a2", "a3", "a4")
+
+	var previous *CardLookup
+	lookups := Db_get_card_keys(db)
+	for i := range lookups {
+		if lookups[i].CardId == id && lookups[i].Previous {
+			previous = &lookups[i]
+		}
+	}
+	if previous == nil || previous.Key1 != "wk1enc" || previous.KeyVersion != 1 {
+		t.Fatalf("expected the replaced key set at version 1, got %+v", previous)
+	}
+
+	history := Db_select_card_key_history(db, id)
+	if len(history) != 1 || history[0].Reason != KeyRetiredWalletRotation || history[0].AcceptUntil == 0 {
+		t.Fatalf("unexpected key history: %+v", history)
+	}
+
+	Db_end_card_key_grace(db, id)
+	for _, l := range Db_get_card_keys(db) {
+		if l.CardId == id && l.Previous {
+			t.Fatalf("expected the grace to end, got %+v", l)
+		}
+	}
+}
+
+// TestDbGetCardChipKeys_FollowsLastTap verifies the wipe flow is given the
+// key set the card was last tapped with.
+func TestDbGetCardChipKeys_FollowsLastTap(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	Db_set_card_keys(db, id, "a0", "a1", "a2", "a3", "a4")
+	if keys := Db_get_card_chip_keys(db, id); keys.Key1 != "a1" {
+		t.Fatalf("expected current keys for an untapped card, got %+v", keys)
+	}
+
+	Db_insert_card_tap(db, CardTapLog{CardId: id, Counter: 5, Outcome: TapOutcomeOk,
+		Timestamp: int(time.Now().Unix()), KeyVersion: 1, PreviousKeys: true})
+	if keys := Db_get_card_chip_keys(db, id); keys.Key0 != "wk0" || keys.Key1 != "wk1enc" {
+		t.Fatalf("expected the previous keys after a previous-key tap, got %+v", keys)
+	}
+
+	Db_insert_card_tap(db, CardTapLog{CardId: id, Counter: 6, Outcome: TapOutcomeOk,
+		Timestamp: int(time.Now().Unix()), KeyVersion: 2})
+	if keys := Db_get_card_chip_keys(db, id); keys.Key1 != "a1" {
+		t.Fatalf("expected current keys after a current-key tap, got %+v", keys)
+	}
+}
+
+// TestCardPendingKeys_PromoteArchivesWithoutGrace verifies a confirmed
+// rotation keeps the old set in the history without accepting it.
+func TestCardPendingKeys_PromoteArchivesWithoutGrace(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+	Db_set_card_pending_keys(db, id, rekeyTestKeys, "rekeyAAA", time.Now().Unix()+3600)
+
+	Db_promote_card_pending_keys(db, id)
+
+	history := Db_select_card_key_history(db, id)
+	if len(history) != 1 || history[0].KeyVersion != 1 ||
+		history[0].Reason != KeyRetiredRekeyConfirmed || history[0].AcceptUntil != 0 {
+		t.Fatalf("unexpected key history: %+v", history)
+	}
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrReplaceSecretNotFound is returned when a replacement deeplink secret is
+// unknown, expired or already used.
+var ErrReplaceSecretNotFound = errors.New("replace secret not found")
+
+// ErrReplacePaymentInFlight is returned when the card to replace has a
+// reserved payment that has not settled. If it failed, its refund would land
+// on the replaced card, so the replacement waits for it.
+var ErrReplacePaymentInFlight = errors.New("card has a payment in flight")
+
+// CardReplacement is the outcome of programming a replacement card.
+type CardReplacement struct {
+	OldCardId        int
+	NewCardId        int
+	TransferredSats  int
+	LnAddressCarried string
+}
+
+// Db_set_card_replace_secret stores the one-shot secret for the admin
+// "replace card" deeplink. Wiped and replaced cards cannot be replaced.
+func Db_set_card_replace_secret(db_conn *sql.DB, cardId int, replaceSecret string, replaceSecretExpiry int64) error {
+
+	sqlStatement := `UPDATE cards SET replace_secret = $1, replace_secret_expiry = $2` +
+		` WHERE card_id = $3 AND status NOT IN ('wiped', 'replaced');`
+	res, err := db_conn.Exec(sqlStatement, replaceSecret, replaceSecretExpiry, cardId)
+	if err != nil {
+		log.Error("db_set_card_replace_secret error: ", err)
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Error("db_set_card_replace_secret rows affected error: ", err)
+		return err
+	}
+	if count != 1 {
+		return errors.New("card not found")
+	}
+	return nil
+}
+
+// Db_replace_card creates the replacement for the card holding replaceSecret,
+// in one transaction: the new card takes over the old card's lightning
+// address, pay links, limits, receive settings, PIN, note, group and expiry,
+// the old card's balance is moved across as a single transfer, and the old
+// card is marked replaced and linked to the new one. The new card is
+// pending_programming until its first tap.
+func Db_replace_card(db_conn *sql.DB, replaceSecret string, keys CardKeys, uid string,
+	login string, password string) (CardReplacement, error) {
+
+	var result CardReplacement
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+
+		if replaceSecret == "" {
+			return ErrReplaceSecretNotFound
+		}
+
+		var oldStatus, lnAddress, lnAlias string
+		err := conn.QueryRowContext(ctx,
+			`SELECT card_id, status, ln_address, ln_alias FROM cards`+
+				` WHERE replace_secret = $1 AND replace_secret_expiry > $2;`,
+			replaceSecret, time.Now().Unix()).Scan(&result.OldCardId, &oldStatus, &lnAddress, &lnAlias)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReplaceSecretNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !Card_status_transition_allowed(oldStatus, CardStatusReplaced) {
+			return ErrCardStatusTransition
+		}
+
+		var inFlight int
+		err = conn.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM card_payments WHERE card_id = $1 AND paid_flag = 'Y' AND settled_at = 0;`,
+			result.OldCardId).Scan(&inFlight)
+		if err != nil {
+			return err
+		}
+		if inFlight > 0 {
+			return ErrReplacePaymentInFlight
+		}
+
+		now := time.Now().Unix()
+
+		// ln_address and ln_alias are unique, so release them before the
+		// new card takes them
+		_, err = conn.ExecContext(ctx,
+			`UPDATE cards SET ln_address = '', ln_alias = '' WHERE card_id = $1;`, result.OldCardId)
+		if err != nil {
+			return err
+		}
+
+		res, err := conn.ExecContext(ctx,
+			`INSERT INTO cards (key0_auth, key1_enc, key2_cmac, key3, key4,`+
+				` login, password, uid, ln_address, ln_alias, status, status_reason, status_changed_at,`+
+				` group_tag, note, lnurlw_enable, ln_address_enabled, pay_link_enabled,`+
+				` tx_limit_sats, day_limit_sats, pin_enable, pin_number, pin_limit_sats, expires_at,`+
+				` receive_min_sats, receive_max_sats, max_balance_sats, ln_description, ln_image)`+
+				` SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'pending_programming', $11, $12,`+
+				` group_tag, note, lnurlw_enable, ln_address_enabled, pay_link_enabled,`+
+				` tx_limit_sats, day_limit_sats, pin_enable, pin_number, pin_limit_sats, expires_at,`+
+				` receive_min_sats, receive_max_sats, max_balance_sats, ln_description, ln_image`+
+				` FROM cards WHERE card_id = $13;`,
+			keys.Key0, keys.Key1, keys.Key2, keys.Key3, keys.Key4,
+			login, password, uid, lnAddress, lnAlias,
+			"replacement for card "+strconv.Itoa(result.OldCardId), now, result.OldCardId)
+		if err != nil {
+			return err
+		}
+		newCardId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		result.NewCardId = int(newCardId)
+		result.LnAddressCarried = lnAddress
+
+		// outstanding pay links keep working
+		_, err = conn.ExecContext(ctx,
+			`UPDATE pay_link_addresses SET card_id = $1 WHERE card_id = $2;`,
+			result.NewCardId, result.OldCardId)
+		if err != nil {
+			return err
+		}
+
+		balance, err := cardBalanceTx(ctx, conn, result.OldCardId)
+		if err != nil {
+			return err
+		}
+		if balance > 0 {
+			_, err = transferCardBalance(ctx, conn, result.OldCardId, result.NewCardId,
+				balance, "card replacement")
+			if err != nil {
+				return err
+			}
+			result.TransferredSats = MsatToSatsDown(balance)
+		}
+
+		// whoever has the lost card's wallet session must not keep it
+		err = revokeTokensTx(ctx, conn, "card_id", result.OldCardId, TokenRevokedReplaced, now)
+		if err != nil {
+			return err
+		}
+
+		reason := "replaced by card " + strconv.Itoa(result.NewCardId)
+		_, err = conn.ExecContext(ctx,
+			`UPDATE cards SET status = 'replaced', status_reason = $1, status_changed_at = $2,`+
+				` replaced_by_card_id = $3, replace_secret = '', replace_secret_expiry = 0`+
+				` WHERE card_id = $4;`,
+			reason, now, result.NewCardId, result.OldCardId)
+		if err != nil {
+			return err
+		}
+
+		err = insertCardStatusHistory(ctx, conn, result.OldCardId,
+			oldStatus, CardStatusReplaced, reason, "admin", now)
+		if err != nil {
+			return err
+		}
+		return insertCardStatusHistory(ctx, conn, result.NewCardId,
+			"", CardStatusPendingProgramming, "replacement for card "+strconv.Itoa(result.OldCardId), "admin", now)
+	})
+	if err != nil && !errors.Is(err, ErrReplaceSecretNotFound) && !errors.Is(err, ErrReplacePaymentInFlight) {
+		log.Error("db_replace_card error: ", err)
+	}
+
+	return result, err
+}
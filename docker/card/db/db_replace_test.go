@@ -0,0 +1,201 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var replaceTestKeys = CardKeys{Key0: "rk0", Key1: "rk1enc", Key2: "rk2cmac", Key3: "rk3", Key4: "rk4"}
+
+// TestReplaceCard_MovesBalanceAndSettings verifies the replacement takes over
+// the old card's balance, lightning address, limits, receive settings, note,
+// group and expiry, and that the old card ends up replaced and linked to the
+// new one.
+func TestReplaceCard_MovesBalanceAndSettings(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	oldId := insertUnfundedCard(t, db)
+
+	Db_update_card_without_pin(db, oldId, 5000, 20000, "Y", 0, "Y")
+	Db_update_card_note(db, oldId, "front desk")
+	db.Exec(`UPDATE cards SET group_tag='staff' WHERE card_id=$1`, oldId)
+	Db_set_card_expiry(db, oldId, 4102444800)
+	receive := ReceiveSettings{MinSats: 10, MaxSats: 9000, MaxBalanceSats: 50000,
+		Description: "Front desk", Image: "data:image/png;base64,AAAA"}
+	if err := Db_set_card_receive_settings(db, oldId, receive); err != nil {
+		t.Fatalf("set receive settings: %v", err)
+	}
+	Db_add_card_receipt(db, oldId, "lnbc_fund", "fundhash", 1500)
+	Db_set_receipt_paid(db, "fundhash", "test")
+	oldCard, _ := Db_get_card(db, oldId)
+
+	if err := Db_set_card_replace_secret(db, oldId, "replAAA", time.Now().Unix()+3600); err != nil {
+		t.Fatalf("set replace secret: %v", err)
+	}
+	res, err := Db_replace_card(db, "replAAA", replaceTestKeys, "04AABBCCDDEEFF", "rlogin", "rpass")
+	if err != nil {
+		t.Fatalf("replace card: %v", err)
+	}
+	if res.OldCardId != oldId || res.NewCardId == oldId || res.TransferredSats != 1500 {
+		t.Fatalf("unexpected replacement result %+v", res)
+	}
+
+	if b := Db_get_card_balance(db, oldId); b != 0 {
+		t.Fatalf("expected old card balance 0, got %d", b)
+	}
+	if b := Db_get_card_balance(db, res.NewCardId); b != 1500 {
+		t.Fatalf("expected new card balance 1500, got %d", b)
+	}
+
+	newCard, err := Db_get_card(db, res.NewCardId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newCard.Ln_address != oldCard.Ln_address || newCard.Tx_limit_sats != 5000 ||
+		newCard.Day_limit_sats != 20000 || newCard.Note != "front desk" ||
+		newCard.Uid != "04AABBCCDDEEFF" || newCard.Key1_enc != "rk1enc" ||
+		newCard.Status != CardStatusPendingProgramming {
+		t.Fatalf("settings not carried over: %+v", newCard)
+	}
+	var groupTag string
+	db.QueryRow(`SELECT group_tag FROM cards WHERE card_id=$1`, res.NewCardId).Scan(&groupTag)
+	if groupTag != "staff" {
+		t.Fatalf("expected group_tag staff, got %q", groupTag)
+	}
+	if got := Db_get_card_expiry(db, res.NewCardId); got != 4102444800 {
+		t.Fatalf("expected card expiry carried over, got %d", got)
+	}
+	if got, _ := Db_get_card_receive_settings(db, res.NewCardId); got != receive {
+		t.Fatalf("expected receive settings carried over, got %+v", got)
+	}
+
+	oldCard, _ = Db_get_card(db, oldId)
+	if oldCard.Status != CardStatusReplaced || oldCard.Replaced_by_card_id != res.NewCardId ||
+		oldCard.Ln_address != "" {
+		t.Fatalf("unexpected old card after replacement: %+v", oldCard)
+	}
+
+	transfers := Db_select_card_transfers(db, oldId)
+	if len(transfers) != 1 || transfers[0].FromCardId != oldId ||
+		transfers[0].ToCardId != res.NewCardId || transfers[0].AmountSats != 1500 {
+		t.Fatalf("unexpected transfers %+v", transfers)
+	}
+
+	history := Db_select_card_status_history(db, oldId)
+	if len(history) == 0 || history[0].ToStatus != CardStatusReplaced {
+		t.Fatalf("expected replaced status history, got %+v", history)
+	}
+}
+
+// TestReplaceCard_OldCardLoginRefused verifies the lost card's wallet session
+// and login stop working once it is replaced, and its tokens are revoked.
+func TestReplaceCard_OldCardLoginRefused(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	oldId := insertUnfundedCard(t, db)
+
+	Db_set_card_replace_secret(db, oldId, "replAAA", time.Now().Unix()+3600)
+	if _, err := Db_replace_card(db, "replAAA", replaceTestKeys, "", "rlogin", "rpass"); err != nil {
+		t.Fatalf("replace card: %v", err)
+	}
+
+	if id := Db_get_card_id_from_access_token(db, "wtok"); id != 0 {
+		t.Fatalf("expected old access token refused, got card %d", id)
+	}
+	if Db_update_tokens(db, "wref", "wref2", "wtok2") {
+		t.Fatal("expected old refresh token refused")
+	}
+	if err := Db_set_tokens(db, "wlogin", "wpass", "wtok3", "wref3"); err == nil {
+		t.Fatal("expected old login refused")
+	}
+
+	tokens := Db_select_card_tokens(db, oldId)
+	if len(tokens) != 1 || tokens[0].RevokedReason != TokenRevokedReplaced {
+		t.Fatalf("expected old token revoked as replaced, got %+v", tokens)
+	}
+}
+
+// TestReplaceCard_OldCardKeysUnchanged verifies a replaced card cannot be
+// re-keyed.
+func TestReplaceCard_OldCardKeysUnchanged(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	oldId := insertUnfundedCard(t, db)
+
+	Db_set_card_replace_secret(db, oldId, "replAAA", time.Now().Unix()+3600)
+	if _, err := Db_replace_card(db, "replAAA", replaceTestKeys, "", "rlogin", "rpass"); err != nil {
+		t.Fatalf("replace card: %v", err)
+	}
+
+	Db_set_card_keys(db, oldId, "x0", "x1", "x2", "x3", "x4")
+	var key1 string
+	db.QueryRow(`SELECT key1_enc FROM cards WHERE card_id=$1`, oldId).Scan(&key1)
+	if key1 != "wk1enc" {
+		t.Fatalf("expected old card keys unchanged, got %q", key1)
+	}
+}
+
+// TestReplaceCard_WaitsForPaymentInFlight verifies a card with a reserved
+// payment that has not settled is not replaced, so a refund cannot land on
+// the replaced card, and that the replacement goes ahead once it has.
+func TestReplaceCard_WaitsForPaymentInFlight(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	oldId := insertUnfundedCard(t, db)
+	Db_update_card_without_pin(db, oldId, 5000, 20000, "Y", 0, "Y")
+	Db_add_card_receipt(db, oldId, "lnbc_fund", "fundhash", 1500)
+	Db_set_receipt_paid(db, "fundhash", "test")
+
+	_, paymentId, err := Db_reserve_card_payment_msat(db, oldId, 500_000, 10_000, "lnbc_out")
+	if err != nil {
+		t.Fatalf("reserve payment: %v", err)
+	}
+
+	Db_set_card_replace_secret(db, oldId, "replAAA", time.Now().Unix()+3600)
+	_, err = Db_replace_card(db, "replAAA", replaceTestKeys, "", "rlogin", "rpass")
+	if !errors.Is(err, ErrReplacePaymentInFlight) {
+		t.Fatalf("expected ErrReplacePaymentInFlight, got %v", err)
+	}
+	if card, _ := Db_get_card(db, oldId); card.Status == CardStatusReplaced {
+		t.Fatal("expected the card not to be replaced")
+	}
+
+	// the payment fails and is refunded to the card, which can then be replaced
+	Db_update_card_payment_unpaid(db, paymentId)
+	res, err := Db_replace_card(db, "replAAA", replaceTestKeys, "", "rlogin", "rpass")
+	if err != nil || res.TransferredSats != 1500 {
+		t.Fatalf("expected replacement with 1500 sats, got %+v err=%v", res, err)
+	}
+}
+
+// TestReplaceCard_SecretIsOneShot verifies a replace secret cannot be used
+// twice, and that an expired secret is refused.
+func TestReplaceCard_SecretIsOneShot(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+	id := insertUnfundedCard(t, db)
+
+	Db_set_card_replace_secret(db, id, "replAAA", time.Now().Unix()+3600)
+	if _, err := Db_replace_card(db, "replAAA", replaceTestKeys, "", "rlogin", "rpass"); err != nil {
+		t.Fatalf("replace card: %v", err)
+	}
+	_, err := Db_replace_card(db, "replAAA", replaceTestKeys, "", "rlogin2", "rpass2")
+	if !errors.Is(err, ErrReplaceSecretNotFound) {
+		t.Fatalf("expected ErrReplaceSecretNotFound on reuse, got %v", err)
+	}
+
+	// a replaced card cannot be replaced again
+	if err := Db_set_card_replace_secret(db, id, "replBBB", time.Now().Unix()+3600); err == nil {
+		t.Fatal("expected replace secret to be refused for a replaced card")
+	}
+
+	Db_insert_card(db, "ok0", "ok1", "ok2", "ok3", "ok4", "ologin", "opass")
+	var other int
+	db.QueryRow(`SELECT MAX(card_id) FROM cards`).Scan(&other)
+	Db_set_card_replace_secret(db, other, "replCCC", time.Now().Unix()-1)
+	_, err = Db_replace_card(db, "replCCC", replaceTestKeys, "", "rlogin3", "rpass3")
+	if !errors.Is(err, ErrReplaceSecretNotFound) {
+		t.Fatalf("expected ErrReplaceSecretNotFound for expired secret, got %v", err)
+	}
+}
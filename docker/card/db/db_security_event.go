@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// security event types
+const (
+	SecurityEventUidMismatch    = "uid_mismatch"
+	SecurityEventCounterJump    = "counter_jump"
+	SecurityEventReplayAttempts = "replay_attempts"
+	SecurityEventTapStorm       = "tap_storm"
+	SecurityEventFailedMatchIp  = "failed_match_ip"
+)
+
+// security event actions
+const (
+	SecurityActionAlert   = "alert"
+	SecurityActionReject  = "reject"
+	SecurityActionSuspend = "suspend"
+)
+
+// CardSecurityEvent is a suspicious occurrence recorded against a card.
+// CardId is 0 for events not tied to a card (e.g. per-IP rules).
+type CardSecurityEvent struct {
+	EventId   int
+	CardId    int
+	EventType string
+	Detail    string
+	Action    string
+	RemoteIp  string
+	Timestamp int
+}
+
+type CardSecurityEvents []CardSecurityEvent
+
+// Db_insert_card_security_event records a security event. The timestamp is
+// set to the current time if not given.
+func Db_insert_card_security_event(db_conn *sql.DB, ev CardSecurityEvent) {
+	if ev.Timestamp == 0 {
+		ev.Timestamp = int(time.Now().Unix())
+	}
+	sqlStatement := `INSERT INTO card_security_events (card_id, event_type, detail,` +
+		` action, remote_ip, timestamp) VALUES ($1, $2, $3, $4, $5, $6);`
+	_, err := db_conn.Exec(sqlStatement, ev.CardId, ev.EventType, ev.Detail,
+		ev.Action, ev.RemoteIp, ev.Timestamp)
+	if err != nil {
+		log.Error("db_insert_card_security_event error: ", err)
+	}
+}
+
+// Db_select_card_security_events returns a card's security events, most
+// recent first.
+func Db_select_card_security_events(db_conn *sql.DB, cardId int, limit int) CardSecurityEvents {
+	var events CardSecurityEvents
+
+	sqlStatement := `SELECT event_id, card_id, event_type, detail, action,` +
+		` remote_ip, timestamp` +
+		` FROM card_security_events WHERE card_id=$1` +
+		` ORDER BY event_id DESC LIMIT $2;`
+	rows, err := db_conn.Query(sqlStatement, cardId, limit)
+	if err != nil {
+		log.Error("db_select_card_security_events query error: ", err)
+		return events
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ev CardSecurityEvent
+		err := rows.Scan(
+			&ev.EventId,
+			&ev.CardId,
+			&ev.EventType,
+			&ev.Detail,
+			&ev.Action,
+			&ev.RemoteIp,
+			&ev.Timestamp,
+		)
+		if err != nil {
+			log.Error("db_select_card_security_events scan error: ", err)
+			return events
+		}
+		events = append(events, ev)
+	}
+
+	return events
+}
@@ -11,9 +11,12 @@ type CardReceipt struct {
 	PaymentRequest string
 	PaymentHash    string
 	AmountSats     int
+	AmountMsat     int64
 	IsPaid         string
 	Timestamp      int
 	ExpireTime     int
+	Comment        string // sent by the payer of a lightning address (LUD-12)
+	Preimage       string // once paid, if Phoenix reported it
 }
 
 type CardReceipts []CardReceipt
@@ -28,16 +31,16 @@ func Db_select_card_receipts(db_conn *sql.DB, card_id int, limit int) (result Ca
 
 	if limit > 0 {
 		sqlStatement := `SELECT card_receipt_id, ln_invoice,` +
-			` r_hash_hex, amount_sats, paid_flag,` +
-			` timestamp, expire_time` +
+			` r_hash_hex, amount_sats, amount_msat, paid_flag,` +
+			` timestamp, expire_time, comment` +
 			` FROM card_receipts` +
 			` WHERE card_receipts.card_id = $1` +
 			` ORDER BY card_receipt_id DESC LIMIT $2;`
 		rows, err = db_conn.Query(sqlStatement, card_id, limit)
 	} else {
 		sqlStatement := `SELECT card_receipt_id, ln_invoice,` +
-			` r_hash_hex, amount_sats, paid_flag,` +
-			` timestamp, expire_time` +
+			` r_hash_hex, amount_sats, amount_msat, paid_flag,` +
+			` timestamp, expire_time, comment` +
 			` FROM card_receipts` +
 			` WHERE card_receipts.card_id = $1` +
 			` ORDER BY card_receipt_id DESC;`
@@ -57,9 +60,11 @@ func Db_select_card_receipts(db_conn *sql.DB, card_id int, limit int) (result Ca
 			&cardReceipt.PaymentRequest,
 			&cardReceipt.PaymentHash,
 			&cardReceipt.AmountSats,
+			&cardReceipt.AmountMsat,
 			&cardReceipt.IsPaid,
 			&cardReceipt.Timestamp,
-			&cardReceipt.ExpireTime)
+			&cardReceipt.ExpireTime,
+			&cardReceipt.Comment)
 		if err != nil {
 			log.Error("db_select_card_receipts scan error: ", err)
 			continue
@@ -71,16 +76,32 @@ func Db_select_card_receipts(db_conn *sql.DB, card_id int, limit int) (result Ca
 	return cardReceipts
 }
 
+// Db_get_receipt_comment returns the payer comment on the receipt for a
+// payment hash, or "".
+func Db_get_receipt_comment(db_conn *sql.DB, payment_hash string) string {
+	comment := ""
+	sqlStatement := `SELECT comment FROM card_receipts WHERE r_hash_hex = $1;`
+	err := db_conn.QueryRow(sqlStatement, payment_hash).Scan(&comment)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error("db_get_receipt_comment error: ", err)
+	}
+	return comment
+}
+
 type UnpaidReceipt struct {
 	PaymentHash string
+	Timestamp   int64 // when the invoice was created
 }
 
+// Db_select_unpaid_receipts returns the unpaid receipts still worth asking
+// Phoenix about: not marked expired, and with an invoice that expired no
+// longer ago than the grace period.
 func Db_select_unpaid_receipts(db_conn *sql.DB) []UnpaidReceipt {
 	var receipts []UnpaidReceipt
 
-	sqlStatement := `SELECT r_hash_hex FROM card_receipts` +
-		` WHERE paid_flag = 'N' AND expire_time > strftime('%s', 'now');`
-	rows, err := db_conn.Query(sqlStatement)
+	sqlStatement := `SELECT r_hash_hex, timestamp FROM card_receipts` +
+		` WHERE paid_flag = 'N' AND expired_at = 0 AND expire_time + $1 > unixepoch();`
+	rows, err := db_conn.Query(sqlStatement, receiptExpiryGrace(db_conn))
 	if err != nil {
 		log.Error("db_select_unpaid_receipts query error: ", err)
 		return receipts
@@ -89,7 +110,7 @@ func Db_select_unpaid_receipts(db_conn *sql.DB) []UnpaidReceipt {
 
 	for rows.Next() {
 		var r UnpaidReceipt
-		if err := rows.Scan(&r.PaymentHash); err != nil {
+		if err := rows.Scan(&r.PaymentHash, &r.Timestamp); err != nil {
 			log.Error("db_select_unpaid_receipts scan error: ", err)
 			continue
 		}
@@ -100,51 +121,186 @@ func Db_select_unpaid_receipts(db_conn *sql.DB) []UnpaidReceipt {
 }
 
 type CardPayment struct {
-	CardPaymentId int
-	AmountSats    int
-	FeeSats       int
-	IsPaid        string
-	Timestamp     int
-	ExpireTime    int
+	CardPaymentId   int
+	AmountSats      int
+	FeeSats         int
+	AmountMsat      int64
+	FeeMsat         int64
+	Kind            string // PaymentKindPayment or PaymentKindServiceFee
+	IsPaid          string
+	Timestamp       int
+	ExpireTime      int
+	PaymentRequest  string
+	PaymentHash     string // set when the payment settles
+	PaymentPreimage string // set when the payment settles
+	SettledAt       int    // 0 while the payment is in flight
 }
 
 type CardPayments []CardPayment
 
-func Db_select_card_payments(db_conn *sql.DB, card_id int) (result CardPayments) {
-	var cardPayments CardPayments
+const cardPaymentColumns = `card_payment_id,` +
+	` amount_sats, fee_sats, amount_msat, fee_msat, kind, paid_flag,` +
+	` timestamp, expire_time, ln_invoice, payment_hash, payment_preimage, settled_at`
+
+func scanCardPayments(rows *sql.Rows, name string) (cardPayments CardPayments) {
+	for rows.Next() {
+		var cardPayment CardPayment
+
+		err := rows.Scan(
+			&cardPayment.CardPaymentId,
+			&cardPayment.AmountSats,
+			&cardPayment.FeeSats,
+			&cardPayment.AmountMsat,
+			&cardPayment.FeeMsat,
+			&cardPayment.Kind,
+			&cardPayment.IsPaid,
+			&cardPayment.Timestamp,
+			&cardPayment.ExpireTime,
+			&cardPayment.PaymentRequest,
+			&cardPayment.PaymentHash,
+			&cardPayment.PaymentPreimage,
+			&cardPayment.SettledAt)
+		if err != nil {
+			log.Error(name, " scan error: ", err)
+			continue
+		}
+
+		cardPayments = append(cardPayments, cardPayment)
+	}
+
+	return cardPayments
+}
 
-	sqlStatement := `SELECT card_payment_id,` +
-		` amount_sats, fee_sats, paid_flag,` +
-		` timestamp, expire_time` +
+func Db_select_card_payments(db_conn *sql.DB, card_id int) (result CardPayments) {
+	sqlStatement := `SELECT ` + cardPaymentColumns +
 		` FROM card_payments` +
 		` WHERE card_payments.card_id = $1` +
 		` ORDER BY card_payment_id DESC;`
 	rows, err := db_conn.Query(sqlStatement, card_id)
 	if err != nil {
 		log.Error("db_select_card_payments query error: ", err)
-		return cardPayments
+		return nil
+	}
+	defer rows.Close()
+
+	return scanCardPayments(rows, "db_select_card_payments")
+}
+
+// Db_select_card_pending_payments returns a card's payments that hold funds
+// but have not yet settled, most recent first.
+func Db_select_card_pending_payments(db_conn *sql.DB, card_id int) (result CardPayments) {
+	sqlStatement := `SELECT ` + cardPaymentColumns +
+		` FROM card_payments` +
+		` WHERE card_id = $1 AND paid_flag = 'Y' AND settled_at = 0 AND kind = $2` +
+		` ORDER BY card_payment_id DESC;`
+	rows, err := db_conn.Query(sqlStatement, card_id, PaymentKindPayment)
+	if err != nil {
+		log.Error("db_select_card_pending_payments query error: ", err)
+		return nil
+	}
+	defer rows.Close()
+
+	return scanCardPayments(rows, "db_select_card_pending_payments")
+}
+
+// Db_select_card_receipt_by_hash returns the card's receipt for a payment
+// hash. found is false when the card has no such receipt.
+func Db_select_card_receipt_by_hash(db_conn *sql.DB, card_id int, payment_hash string) (receipt CardReceipt, found bool) {
+	sqlStatement := `SELECT card_receipt_id, ln_invoice,` +
+		` r_hash_hex, amount_sats, amount_msat, paid_flag,` +
+		` timestamp, expire_time, comment, payment_preimage` +
+		` FROM card_receipts` +
+		` WHERE card_id = $1 AND r_hash_hex = $2` +
+		` ORDER BY card_receipt_id DESC LIMIT 1;`
+	err := db_conn.QueryRow(sqlStatement, card_id, payment_hash).Scan(
+		&receipt.CardReceiptId,
+		&receipt.PaymentRequest,
+		&receipt.PaymentHash,
+		&receipt.AmountSats,
+		&receipt.AmountMsat,
+		&receipt.IsPaid,
+		&receipt.Timestamp,
+		&receipt.ExpireTime,
+		&receipt.Comment,
+		&receipt.Preimage)
+	if err == sql.ErrNoRows {
+		return receipt, false
+	}
+	if err != nil {
+		log.Error("db_select_card_receipt_by_hash error: ", err)
+		return receipt, false
+	}
+	return receipt, true
+}
+
+// WalletTx is a line of a card's LndHub transaction history: a paid receipt
+// (Incoming) or a payment made from the card.
+type WalletTx struct {
+	Incoming        bool
+	Kind            string // PaymentKindPayment or PaymentKindServiceFee for a payment
+	Timestamp       int
+	AmountSats      int
+	FeeSats         int
+	AmountMsat      int64
+	FeeMsat         int64
+	PaymentRequest  string
+	PaymentHash     string
+	PaymentPreimage string
+	Comment         string // payer comment on a receipt
+}
+
+// Db_select_card_wallet_txs returns a page of a card's paid receipts and
+// payments, most recent first. Pass limit=0 to return every line from offset.
+func Db_select_card_wallet_txs(db_conn *sql.DB, card_id int, limit int, offset int) (result []WalletTx) {
+	if limit <= 0 {
+		limit = -1 // no limit in SQLite
+	}
+
+	sqlStatement := `SELECT 1, '', timestamp, amount_sats, 0, amount_msat, 0,` +
+		` ln_invoice, r_hash_hex, '', card_receipt_id, 0, comment` +
+		` FROM card_receipts` +
+		` WHERE card_id = $1 AND paid_flag = 'Y'` +
+		` UNION ALL` +
+		` SELECT 0, kind, timestamp, amount_sats, fee_sats, amount_msat, fee_msat,` +
+		` ln_invoice, payment_hash, payment_preimage, 0, card_payment_id, ''` +
+		` FROM card_payments` +
+		` WHERE card_id = $1 AND paid_flag = 'Y'` +
+		` ORDER BY 3 DESC, 12 DESC, 11 DESC` +
+		` LIMIT $2 OFFSET $3;`
+	rows, err := db_conn.Query(sqlStatement, card_id, limit, max(offset, 0))
+	if err != nil {
+		log.Error("db_select_card_wallet_txs query error: ", err)
+		return nil
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var cardPayment CardPayment
+		var tx WalletTx
+		var receiptId, paymentId int
 
 		err := rows.Scan(
-			&cardPayment.CardPaymentId,
-			&cardPayment.AmountSats,
-			&cardPayment.FeeSats,
-			&cardPayment.IsPaid,
-			&cardPayment.Timestamp,
-			&cardPayment.ExpireTime)
+			&tx.Incoming,
+			&tx.Kind,
+			&tx.Timestamp,
+			&tx.AmountSats,
+			&tx.FeeSats,
+			&tx.AmountMsat,
+			&tx.FeeMsat,
+			&tx.PaymentRequest,
+			&tx.PaymentHash,
+			&tx.PaymentPreimage,
+			&receiptId,
+			&paymentId,
+			&tx.Comment)
 		if err != nil {
-			log.Error("db_select_card_payments scan error: ", err)
+			log.Error("db_select_card_wallet_txs scan error: ", err)
 			continue
 		}
 
-		cardPayments = append(cardPayments, cardPayment)
+		result = append(result, tx)
 	}
 
-	return cardPayments
+	return result
 }
 
 type CardTx struct {
@@ -153,7 +309,11 @@ type CardTx struct {
 	Timestamp  int
 	AmountSats int
 	FeeSats    int
+	AmountMsat int64
+	FeeMsat    int64
 	Allocated  bool
+	ServiceFee bool   // an operator fee, shown as its own line
+	Comment    string // payer comment on a receipt
 }
 
 type CardTxs []CardTx
@@ -164,11 +324,11 @@ func Db_select_card_txs(db_conn *sql.DB, card_id int) (result CardTxs) {
 	// get card txs
 	// receipts with an empty ln_invoice are manual admin allocations
 	// (no real Lightning invoice behind them); flag them as allocated
-	sqlStatement := `SELECT card_receipt_id, 0, timestamp, amount_sats, fee_sats, (ln_invoice = '')` +
+	sqlStatement := `SELECT card_receipt_id, 0, timestamp, amount_sats, fee_sats, amount_msat, 0, (ln_invoice = ''), 0, comment` +
 		` FROM card_receipts` +
 		` WHERE card_receipts.card_id = $1 AND card_receipts.paid_flag='Y'` +
 		` UNION` +
-		` SELECT 0, card_payment_id, timestamp, -amount_sats, -fee_sats, 0` +
+		` SELECT 0, card_payment_id, timestamp, -amount_sats, -fee_sats, -amount_msat, -fee_msat, 0, (kind = 'service_fee'), ''` +
 		` FROM card_payments` +
 		` WHERE card_payments.card_id = $1 AND card_payments.paid_flag='Y'` +
 		` ORDER BY timestamp DESC;`
@@ -188,7 +348,11 @@ func Db_select_card_txs(db_conn *sql.DB, card_id int) (result CardTxs) {
 			&cardTx.Timestamp,
 			&cardTx.AmountSats,
 			&cardTx.FeeSats,
-			&cardTx.Allocated)
+			&cardTx.AmountMsat,
+			&cardTx.FeeMsat,
+			&cardTx.Allocated,
+			&cardTx.ServiceFee,
+			&cardTx.Comment)
 		if err != nil {
 			log.Error("db_select_card_txs scan error: ", err)
 			continue
@@ -201,48 +365,29 @@ func Db_select_card_txs(db_conn *sql.DB, card_id int) (result CardTxs) {
 }
 
 type CardSummary struct {
-	CardId       int
-	Uid          string
-	Note         string
-	BalanceSats  int
-	LnurlwEnable string
-	Wiped        string
-	GroupTag     string
-	TxLimitSats  int
-	DayLimitSats int
+	CardId         int
+	Uid            string
+	Note           string
+	BalanceSats    int
+	LnurlwEnable   string
+	Wiped          string
+	Status         string
+	GroupTag       string
+	TxLimitSats    int
+	DayLimitSats   int
+	LnAddress      string
+	LastActivityAt int // latest paid receipt or payment, 0 if none
 }
 
+// Db_select_all_cards returns every card that has not been wiped.
 func Db_select_all_cards(db_conn *sql.DB) []CardSummary {
-	var cards []CardSummary
-
-	sqlStatement := `SELECT c.card_id, c.uid, c.note, c.lnurlw_enable, c.wiped,` +
-		` IFNULL(c.group_tag, ''), c.tx_limit_sats, c.day_limit_sats,` +
-		` IFNULL((SELECT SUM(amount_sats) FROM card_receipts WHERE paid_flag='Y' AND card_id=c.card_id), 0) -` +
-		` IFNULL((SELECT SUM(amount_sats) + SUM(fee_sats) FROM card_payments WHERE paid_flag='Y' AND card_id=c.card_id), 0)` +
-		` AS balance_sats` +
-		` FROM cards c WHERE c.wiped = 'N'` +
-		` ORDER BY c.card_id DESC;`
-
-	rows, err := db_conn.Query(sqlStatement)
-	if err != nil {
-		log.Error("db_select_all_cards query error: ", err)
-		return cards
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var cs CardSummary
-		err := rows.Scan(
-			&cs.CardId, &cs.Uid, &cs.Note, &cs.LnurlwEnable,
-			&cs.Wiped, &cs.GroupTag, &cs.TxLimitSats, &cs.DayLimitSats,
-			&cs.BalanceSats)
-		if err != nil {
-			log.Error("db_select_all_cards scan error: ", err)
-			continue
-		}
-		cards = append(cards, cs)
-	}
+	return Db_select_cards_by_status(db_conn, nil)
+}
 
+// Db_select_cards_by_status returns the cards in any of the given statuses,
+// or every card that has not been wiped when statuses is empty.
+func Db_select_cards_by_status(db_conn *sql.DB, statuses []string) []CardSummary {
+	cards, _ := Db_select_cards_page(db_conn, CardListQuery{Statuses: statuses})
 	return cards
 }
 
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// card_payments kinds
+const (
+	PaymentKindPayment    = "payment"     // value leaving the card
+	PaymentKindServiceFee = "service_fee" // operator fee charged on a payment or top-up
+)
+
+// DefaultServiceFeeHouseAccount receives service fees for groups that do not
+// name a house account of their own.
+const DefaultServiceFeeHouseAccount = "service fees"
+
+// serviceFeeRule is a group's fee on card spends or on top-ups: a fixed part
+// plus a proportional part in parts per million of the amount.
+type serviceFeeRule struct {
+	BaseSats     int
+	Ppm          int
+	HouseAccount string
+}
+
+// feeSats is the whole-sat fee on amountMsat, with the proportional part
+// rounded down. A rule with no base and no proportional part charges nothing.
+func (r serviceFeeRule) feeSats(amountMsat int64) int {
+	if r.BaseSats == 0 && r.Ppm == 0 {
+		return 0
+	}
+	return r.BaseSats + int(amountMsat*int64(r.Ppm)/1_000_000_000)
+}
+
+// cardServiceFeeRuleTx reads the spend (topup false) or top-up (topup true)
+// fee rule of a card's group inside a transaction. A card without a group, or
+// whose group has no card_groups row, pays no fee.
+func cardServiceFeeRuleTx(ctx context.Context, conn *sql.Conn, cardId int, topup bool) (serviceFeeRule, error) {
+	var r serviceFeeRule
+
+	columns := `g.spend_fee_base_sats, g.spend_fee_ppm`
+	if topup {
+		columns = `g.topup_fee_base_sats, g.topup_fee_ppm`
+	}
+	sqlStatement := `SELECT ` + columns + `, g.fee_house_account` +
+		` FROM cards c JOIN card_groups g ON g.group_tag = c.group_tag WHERE c.card_id = $1;`
+	err := conn.QueryRowContext(ctx, sqlStatement, cardId).Scan(&r.BaseSats, &r.Ppm, &r.HouseAccount)
+	if err == sql.ErrNoRows {
+		return serviceFeeRule{}, nil
+	}
+	if r.HouseAccount == "" {
+		r.HouseAccount = DefaultServiceFeeHouseAccount
+	}
+	return r, err
+}
+
+// chargeServiceFeeTx debits feeSats from a card as a service_fee payment linked
+// to the payment or receipt it was charged on, and credits the fee to the
+// rule's house account, inside a transaction.
+func chargeServiceFeeTx(ctx context.Context, conn *sql.Conn, cardId int, feeSats int, rule serviceFeeRule,
+	relatedPaymentId int, relatedReceiptId int, reason string) error {
+
+	now := time.Now().Unix()
+
+	res, err := conn.ExecContext(ctx,
+		`INSERT INTO card_payments (card_id, amount_sats, amount_msat, ln_invoice, paid_flag,`+
+			` timestamp, expire_time, settled_at, kind, related_payment_id, related_receipt_id)`+
+			` VALUES ($1, $2, $3, '', 'Y', $4, $4, $4, $5, $6, $7);`,
+		cardId, feeSats, SatsToMsat(feeSats), now, PaymentKindServiceFee, relatedPaymentId, relatedReceiptId)
+	if err != nil {
+		return err
+	}
+	feePaymentId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return creditHouseAccount(ctx, conn, rule.HouseAccount, cardId, int(feePaymentId),
+		feeSats, HouseEntryServiceFee, reason)
+}
+
+// reverseServiceFeesTx refunds the service fees charged on a payment that has
+// been reversed, and takes them back from the house account.
+func reverseServiceFeesTx(ctx context.Context, conn *sql.Conn, cardPaymentId int) error {
+	_, err := conn.ExecContext(ctx,
+		`DELETE FROM house_account_entries WHERE card_payment_id IN`+
+			` (SELECT card_payment_id FROM card_payments WHERE related_payment_id = $1 AND kind = $2);`,
+		cardPaymentId, PaymentKindServiceFee)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx,
+		`UPDATE card_payments SET paid_flag = 'N' WHERE related_payment_id = $1 AND kind = $2;`,
+		cardPaymentId, PaymentKindServiceFee)
+	return err
+}
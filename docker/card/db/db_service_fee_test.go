@@ -0,0 +1,76 @@
+package db
+
+import "testing"
+
+// TestServiceFees_ChargedRefundedAndReported verifies a group's top-up and
+// spend fees are debited as their own lines and credited to the house
+// account, that a reversed payment refunds its fee, and that the fees show
+// in the group totals.
+func TestServiceFees_ChargedRefundedAndReported(t *testing.T) {
+	db := openTestDB(t)
+	Db_init(db)
+
+	err := Db_set_card_group(db, CardGroup{GroupTag: "fest", TxLimitSats: 1000000, LnAddressEnabled: "Y",
+		SpendFeeBaseSats: 1, SpendFeePpm: 10_000, TopupFeeBaseSats: 2, FeeHouseAccount: "festival"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := CardKeys{Key0: "k0", Key1: "k1", Key2: "k2", Key3: "k3", Key4: "k4"}
+	id, err := Db_insert_group_card(db, keys, "login1", "pass1", "uid1", "fest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a lightning top-up of 1000 pays the 2 sat top-up fee
+	Db_add_card_receipt(db, id, "lnbc_topup", "hash_topup", 1000)
+	Db_set_receipt_paid(db, "hash_topup", "test")
+	Db_set_receipt_paid(db, "hash_topup", "test")
+	if b := Db_get_card_balance(db, id); b != 998 {
+		t.Fatalf("expected 998 after the top-up fee, got %d", b)
+	}
+
+	// a payment of 500 pays 1 sat + 1% = 6 sats
+	_, paymentId, err := Db_reserve_card_payment(db, id, 500, 500, "lnbc_pay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := Db_get_card_balance(db, id); b != 492 {
+		t.Fatalf("expected 492 after the payment and its fee, got %d", b)
+	}
+
+	// the fee counts towards the balance needed
+	if _, _, err := Db_reserve_card_payment(db, id, 490, 490, "lnbc_big"); err != ErrInsufficientFunds {
+		t.Fatalf("expected insufficient funds with the service fee, got %v", err)
+	}
+
+	txs := Db_select_card_txs(db, id)
+	fees := 0
+	for _, tx := range txs {
+		if tx.ServiceFee {
+			fees += -tx.AmountSats
+		}
+	}
+	if fees != 8 {
+		t.Fatalf("expected 8 sats of service fee lines, got %d in %+v", fees, txs)
+	}
+
+	totals := Db_get_card_group_totals(db, "fest")
+	if totals.SpendFeeSats != 6 || totals.TopupFeeSats != 2 {
+		t.Fatalf("unexpected group fee totals: %+v", totals)
+	}
+	if entries := Db_select_house_account_entries(db, "festival", 10); len(entries) != 2 {
+		t.Fatalf("expected two house account entries, got %+v", entries)
+	}
+
+	// a reversed payment refunds its fee and takes it back from the house
+	Db_update_card_payment_unpaid(db, paymentId)
+	if b := Db_get_card_balance(db, id); b != 998 {
+		t.Fatalf("expected 998 after the reversal, got %d", b)
+	}
+	if totals := Db_get_card_group_totals(db, "fest"); totals.SpendFeeSats != 0 {
+		t.Fatalf("expected the spend fee refunded, got %+v", totals)
+	}
+	if entries := Db_select_house_account_entries(db, "festival", 10); len(entries) != 1 {
+		t.Fatalf("expected one house account entry after the reversal, got %+v", entries)
+	}
+}
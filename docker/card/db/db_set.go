@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -39,49 +41,92 @@ func Db_set_setting(db_conn *sql.DB, name string, value string) {
 	}
 }
 
-func Db_set_tokens(db_conn *sql.DB, login string, password string,
-	access_token string, refresh_token string) error {
-
-	// update card record
-	sqlStatement := `UPDATE cards` +
-		` SET access_token = $1, refresh_token = $2` +
-		` WHERE login = $3 AND password = $4 AND wiped = 'N';`
-	res, err := db_conn.Exec(sqlStatement, access_token, refresh_token, login, password)
-	if err != nil {
-		log.Error("db_set_tokens exec error: ", err)
-		return err
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		log.Error("db_set_tokens rows affected error: ", err)
-		return err
-	}
-
-	if count != 1 {
-		return errors.New("login not valid")
-	}
-
-	return nil
+// Db_set_receipt_paid settles a receipt whose preimage is not known.
+func Db_set_receipt_paid(db_conn *sql.DB, paymentHash string, settledBy string) {
+	Db_set_receipt_paid_with_preimage(db_conn, paymentHash, "", settledBy)
 }
 
-func Db_set_receipt_paid(db_conn *sql.DB, paymentHash string, settledBy string) {
+// Db_set_receipt_paid_with_preimage settles a receipt, keeping the preimage
+// of its payment, and for a lightning top-up charges the card group's top-up
+// fee in the same transaction. A receipt already paid is left unchanged; one
+// marked expired is still settled, as Phoenix says it was paid.
+func Db_set_receipt_paid_with_preimage(db_conn *sql.DB, paymentHash string, preimage string, settledBy string) {
 
-	sqlStatement := `UPDATE card_receipts SET paid_flag = 'Y',` +
-		` settled_by = $1, settled_at = strftime('%s', 'now')` +
-		` WHERE r_hash_hex = $2 AND paid_flag = 'N';`
-	_, err := db_conn.Exec(sqlStatement, settledBy, paymentHash)
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		var receiptId, cardId int
+		var amountMsat int64
+		var invoice string
+		err := conn.QueryRowContext(ctx,
+			`UPDATE card_receipts SET paid_flag = 'Y',`+
+				` settled_by = $1, settled_at = strftime('%s', 'now'), payment_preimage = $2, expired_at = 0`+
+				` WHERE r_hash_hex = $3 AND paid_flag = 'N'`+
+				` RETURNING card_receipt_id, card_id, amount_msat, ln_invoice;`,
+			settledBy, preimage, paymentHash).Scan(&receiptId, &cardId, &amountMsat, &invoice)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// allocations have no invoice behind them and are not charged
+		if invoice == "" {
+			return nil
+		}
+		rule, err := cardServiceFeeRuleTx(ctx, conn, cardId, true)
+		if err != nil {
+			return err
+		}
+		feeSats := min(rule.feeSats(amountMsat), MsatToSatsDown(amountMsat))
+		if feeSats <= 0 {
+			return nil
+		}
+		return chargeServiceFeeTx(ctx, conn, cardId, feeSats, rule, 0, receiptId,
+			"top-up fee on receipt "+strconv.Itoa(receiptId))
+	})
 	if err != nil {
 		log.Error("db_set_receipt_paid error: ", err)
 	}
 }
 
+// Db_set_card_keys replaces a card's keys. The chip may not be re-programmed
+// yet, so the replaced set, and a pending set if a rotation was in progress,
+// are archived in card_key_history and accepted for the grace period. The keys
+// of a wiped or replaced card are left unchanged.
 func Db_set_card_keys(db_conn *sql.DB, card_id int, key0 string, key1 string, k2 string, key3 string, key4 string) {
 
-	// update card record
-	sqlStatement := `UPDATE cards SET key0_auth = $1, key1_enc = $2,` +
-		` key2_cmac = $3, key3 = $4, key4 = $5` +
-		` WHERE card_id = $6 AND wiped = 'N';`
-	_, err := db_conn.Exec(sqlStatement, key0, key1, k2, key3, key4, card_id)
+	gracePeriod := cardKeyGracePeriod(db_conn)
+
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		var wiped, status string
+		err := conn.QueryRowContext(ctx, `SELECT wiped, status FROM cards WHERE card_id = $1;`,
+			card_id).Scan(&wiped, &status)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if wiped != "N" || status == CardStatusReplaced {
+			return nil
+		}
+
+		now := time.Now().Unix()
+		if err := archiveCardKeysTx(ctx, conn, card_id, true, now+gracePeriod,
+			KeyRetiredWalletRotation, now); err != nil {
+			return err
+		}
+
+		// update card record; a direct key change supersedes any pending rotation
+		_, err = conn.ExecContext(ctx, `UPDATE cards SET key0_auth = $1, key1_enc = $2,`+
+			` key2_cmac = $3, key3 = $4, key4 = $5,`+
+			` key_version = MAX(key_version, pending_key_version) + 1,`+
+			` pending_key_version = 0, pending_key0_auth = '', pending_key1_enc = '',`+
+			` pending_key2_cmac = '', pending_key3 = '', pending_key4 = '',`+
+			` rekey_secret = '', rekey_secret_expiry = 0`+
+			` WHERE card_id = $6 AND wiped = 'N' AND status != 'replaced';`, key0, key1, k2, key3, key4, card_id)
+		return err
+	})
 	if err != nil {
 		log.Error("db_set_card_keys error: ", err)
 	}
@@ -98,6 +143,18 @@ func Db_set_card_counter(db_conn *sql.DB, cardId int, counter_value uint32) {
 	}
 }
 
+// Db_set_card_uid_if_empty records the UID for a card created without one
+// (e.g. via the wallet API); a UID already on record is never overwritten.
+func Db_set_card_uid_if_empty(db_conn *sql.DB, cardId int, uid string) {
+
+	sqlStatement := `UPDATE cards SET uid = $1` +
+		` WHERE card_id = $2 AND uid = '' AND wiped = 'N';`
+	_, err := db_conn.Exec(sqlStatement, uid, cardId)
+	if err != nil {
+		log.Error("db_set_card_uid_if_empty error: ", err)
+	}
+}
+
 func Db_set_lnurlw_k1(db_conn *sql.DB, cardId int, lnurlwK1 string, lnurlwK1Expiry int64) {
 
 	// update card record
@@ -23,8 +23,8 @@ func TestDbInit_SchemaMigratesToLatest(t *testing.T) {
 	Db_init(db)
 
 	version := Db_get_setting(db, "schema_version_number")
-	if version != "13" {
-		t.Fatalf("expected schema version 13, got %q", version)
+	if version != "36" {
+		t.Fatalf("expected schema version 30, got %q", version)
 	}
 }
 
@@ -375,6 +375,17 @@ func TestDbGetTotalPaidReceipts(t *testing.T) {
 	if total != 1000 {
 		t.Fatalf("expected 1000 total paid receipts, got %d", total)
 	}
+
+	// sub-sat receipts are summed before converting to sats
+	Db_add_card_receipt_msat(db, 1, "lnbc4...", "hash4", 1500)
+	Db_set_receipt_paid(db, "hash4", "test")
+	Db_add_card_receipt_msat(db, 1, "lnbc5...", "hash5", 1500)
+	Db_set_receipt_paid(db, "hash5", "test")
+
+	total = Db_get_total_paid_receipts(db, 1)
+	if total != 1003 {
+		t.Fatalf("expected 1003 total paid receipts, got %d", total)
+	}
 }
 
 // --- Counter operations tests ---
@@ -451,10 +462,13 @@ func TestDbWipeCard_SetsWipedAndReturnsKeys(t *testing.T) {
 		t.Fatalf("expected original keys, got %+v", keys)
 	}
 
-	// Card should be wiped — Db_get_card excludes wiped cards
-	_, err := Db_get_card(db, 1)
-	if err == nil {
-		t.Fatal("expected error getting wiped card")
+	// Card should be wiped — Db_get_card still returns it, with its status
+	card, err := Db_get_card(db, 1)
+	if err != nil {
+		t.Fatalf("expected wiped card to be returned: %v", err)
+	}
+	if card.Status != CardStatusWiped || card.Wiped != "Y" {
+		t.Fatalf("expected wiped status, got status=%q wiped=%q", card.Status, card.Wiped)
 	}
 }
 
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"card/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CardTransfer is a movement of balance from one card to another, booked as a
+// paid card_payments row on the source and a paid card_receipts row on the
+// destination.
+type CardTransfer struct {
+	TransferId    int
+	FromCardId    int
+	ToCardId      int
+	AmountSats    int
+	CardPaymentId int
+	CardReceiptId int
+	Reason        string
+	Timestamp     int
+}
+
+type CardTransfers []CardTransfer
+
+// transferCardBalance books a transfer of amountMsat millisatoshis inside a
+// transaction and returns its id.
+func transferCardBalance(ctx context.Context, conn *sql.Conn, fromCardId int, toCardId int,
+	amountMsat int64, reason string) (int, error) {
+
+	now := time.Now().Unix()
+	amountSats := MsatToSatsDown(amountMsat)
+
+	res, err := conn.ExecContext(ctx,
+		`INSERT INTO card_payments (card_id, amount_sats, amount_msat, ln_invoice, paid_flag,`+
+			` timestamp, expire_time, settled_at) VALUES ($1, $2, $3, '', 'Y', $4, $4, $4);`,
+		fromCardId, MsatToSatsUp(amountMsat), amountMsat, now)
+	if err != nil {
+		return 0, err
+	}
+	paymentId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// r_hash_hex is UNIQUE; there is no lightning payment behind a transfer
+	res, err = conn.ExecContext(ctx,
+		`INSERT INTO card_receipts (card_id, ln_invoice, r_hash_hex, amount_sats, amount_msat, paid_flag,`+
+			` timestamp, expire_time, settled_by, settled_at)`+
+			` VALUES ($1, '', $2, $3, $4, 'Y', $5, $5, 'transfer', $5);`,
+		toCardId, util.Random_hex()+util.Random_hex(), amountSats, amountMsat, now)
+	if err != nil {
+		return 0, err
+	}
+	receiptId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err = conn.ExecContext(ctx,
+		`INSERT INTO card_transfers (from_card_id, to_card_id, amount_sats,`+
+			` card_payment_id, card_receipt_id, reason, timestamp)`+
+			` VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+		fromCardId, toCardId, amountSats, paymentId, receiptId, reason, now)
+	if err != nil {
+		return 0, err
+	}
+	transferId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(transferId), nil
+}
+
+// cardBalanceTx reads a card's balance in millisatoshis inside a transaction.
+func cardBalanceTx(ctx context.Context, conn *sql.Conn, cardId int) (int64, error) {
+	balanceSQL := `SELECT balance_msat FROM cards WHERE card_id=$1`
+	var balance int64
+	err := conn.QueryRowContext(ctx, balanceSQL, cardId).Scan(&balance)
+	return balance, err
+}
+
+// Db_select_card_transfers returns the transfers into or out of a card, most
+// recent first.
+func Db_select_card_transfers(db_conn *sql.DB, cardId int) CardTransfers {
+	var transfers CardTransfers
+
+	sqlStatement := `SELECT transfer_id, from_card_id, to_card_id, amount_sats,` +
+		` card_payment_id, card_receipt_id, reason, timestamp` +
+		` FROM card_transfers WHERE from_card_id=$1 OR to_card_id=$1` +
+		` ORDER BY transfer_id DESC;`
+	rows, err := db_conn.Query(sqlStatement, cardId)
+	if err != nil {
+		log.Error("db_select_card_transfers query error: ", err)
+		return transfers
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tr CardTransfer
+		err := rows.Scan(
+			&tr.TransferId,
+			&tr.FromCardId,
+			&tr.ToCardId,
+			&tr.AmountSats,
+			&tr.CardPaymentId,
+			&tr.CardReceiptId,
+			&tr.Reason,
+			&tr.Timestamp,
+		)
+		if err != nil {
+			log.Error("db_select_card_transfers scan error: ", err)
+			return transfers
+		}
+		transfers = append(transfers, tr)
+	}
+
+	return transfers
+}
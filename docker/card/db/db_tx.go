@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -21,6 +22,10 @@ var ErrTxLimitExceeded = errors.New("transaction limit exceeded")
 // means no limit.
 var ErrDayLimitExceeded = errors.New("daily limit exceeded")
 
+// ErrPaymentNotPending is returned when settling a payment that has already
+// been settled or reversed.
+var ErrPaymentNotPending = errors.New("payment not pending")
+
 // withImmediateTx runs fn inside a BEGIN IMMEDIATE transaction on a
 // single pinned connection. BEGIN IMMEDIATE acquires the SQLite write
 // lock at transaction start, preventing other writers from interleaving
@@ -61,25 +66,86 @@ func withImmediateTx(db_conn *sql.DB, fn func(ctx context.Context, conn *sql.Con
 // balance and both pass the sufficiency check.
 //
 // requiredBalance is the minimum balance needed (e.g. amount + fee headroom).
-// paymentAmount is the amount recorded in the card_payments row.
+// paymentAmount is the amount recorded in the card_payments row. No fee is
+// held; Db_reserve_card_payment_msat holds a fee reserve until settlement.
 //
-// Returns the actual balance, payment ID, and any error.
+// Returns the actual balance, payment ID, and any error. ErrCardNotActive is
+// returned if the card's status does not allow spending.
 // On ErrInsufficientFunds the balance is still returned so the caller
 // can choose an appropriate error message.
 func Db_reserve_card_payment(db_conn *sql.DB, cardId int, requiredBalance int, paymentAmount int, invoice string) (balance int, paymentID int, err error) {
+	balanceMsat, paymentID, err := reserveCardPayment(db_conn, cardId, SatsToMsat(requiredBalance),
+		SatsToMsat(paymentAmount), 0, invoice, Card_status_allows_withdraw, true)
+	return MsatToSatsDown(balanceMsat), paymentID, err
+}
+
+// Db_reserve_card_payment_msat reserves a payment of paymentMsat and holds
+// feeReserveMsat against the card as its fee until Db_settle_card_payment
+// records the actual fee. The balance is returned in millisatoshis.
+func Db_reserve_card_payment_msat(db_conn *sql.DB, cardId int, paymentMsat int64, feeReserveMsat int64, invoice string) (balanceMsat int64, paymentID int, err error) {
+	return reserveCardPayment(db_conn, cardId, paymentMsat+feeReserveMsat, paymentMsat, feeReserveMsat,
+		invoice, Card_status_allows_withdraw, true)
+}
+
+// Db_reserve_card_payout is Db_reserve_card_payment_msat for a holder payout,
+// which an expired card may still make during its grace period. The caller
+// checks the grace period. A payout is not charged a service fee.
+func Db_reserve_card_payout(db_conn *sql.DB, cardId int, paymentMsat int64, feeReserveMsat int64, destination string) (balanceMsat int64, paymentID int, err error) {
+	return reserveCardPayment(db_conn, cardId, paymentMsat+feeReserveMsat,
+		paymentMsat, feeReserveMsat, destination, Card_status_allows_payout, false)
+}
+
+// Db_settle_card_payment finalises a reserved payment: the fee reserve held
+// in fee_msat is replaced by the actual routing fee, the payment hash and
+// preimage are recorded and the payment is marked settled, in one statement.
+// The fee charged is capped at the reserve. A payment already settled or
+// reversed is left unchanged and ErrPaymentNotPending is returned.
+func Db_settle_card_payment(db_conn *sql.DB, cardPaymentId int, feeMsat int64, paymentHash string, preimage string) error {
+	sqlStatement := `UPDATE card_payments SET fee_msat = MIN($1, fee_reserve_msat),` +
+		` fee_sats = (MIN($1, fee_reserve_msat) + 999) / 1000, settled_at = unixepoch(),` +
+		` payment_hash = $2, payment_preimage = $3` +
+		` WHERE card_payment_id = $4 AND paid_flag = 'Y' AND settled_at = 0` +
+		` RETURNING fee_reserve_msat;`
+	var feeReserveMsat int64
+	err := db_conn.QueryRow(sqlStatement, feeMsat, paymentHash, preimage, cardPaymentId).Scan(&feeReserveMsat)
+	if err == sql.ErrNoRows {
+		return ErrPaymentNotPending
+	}
+	if err != nil {
+		log.Error("db_settle_card_payment error: ", err)
+		return err
+	}
+	if feeMsat > feeReserveMsat {
+		log.Warn("routing fee ", feeMsat, " msat over the fee reserve ", feeReserveMsat,
+			" msat, card_payment_id = ", cardPaymentId, "; charged the reserve")
+	}
+	return nil
+}
+
+// reserveCardPayment reserves a payment, charging the group's spend fee when
+// serviceFee is set. The service fee counts towards the balance required.
+func reserveCardPayment(db_conn *sql.DB, cardId int, requiredMsat int64, paymentMsat int64, feeReserveMsat int64, invoice string,
+	statusAllows func(string) bool, serviceFee bool) (balanceMsat int64, paymentID int, err error) {
 
 	err = withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
 
-		// read the card's spending limits under the write lock so the
-		// checks below cannot race with a concurrent reservation
+		// read the card's spending limits and balance under the write lock so
+		// the checks below cannot race with a concurrent reservation
 		var txLimit, dayLimit int
-		limitsSQL := `SELECT tx_limit_sats, day_limit_sats FROM cards WHERE card_id=$1`
-		if err := conn.QueryRowContext(ctx, limitsSQL, cardId).Scan(&txLimit, &dayLimit); err != nil {
+		var storedBalance int64
+		var status string
+		limitsSQL := `SELECT tx_limit_sats, day_limit_sats, status, balance_msat FROM cards WHERE card_id=$1`
+		if err := conn.QueryRowContext(ctx, limitsSQL, cardId).Scan(&txLimit, &dayLimit, &status, &storedBalance); err != nil {
 			return err
 		}
 
+		// the card's status must allow spending
+		if !statusAllows(status) {
+			return ErrCardNotActive
+		}
+
 		// per-transaction limit (0 = no limit)
-		if txLimit > 0 && paymentAmount > txLimit {
+		if txLimit > 0 && paymentMsat > SatsToMsat(txLimit) {
 			return ErrTxLimitExceeded
 		}
 
@@ -87,35 +153,38 @@ func Db_reserve_card_payment(db_conn *sql.DB, cardId int, requiredBalance int, p
 		// payments count immediately (paid_flag defaults to 'Y') and are
 		// reversed to 'N' on failure, so this sum matches spent value.
 		if dayLimit > 0 {
-			var daySpent int
-			daySQL := `SELECT IFNULL(SUM(amount_sats), 0) FROM card_payments
-				WHERE paid_flag='Y' AND card_id=$1 AND timestamp >= unixepoch() - 86400`
-			if err := conn.QueryRowContext(ctx, daySQL, cardId).Scan(&daySpent); err != nil {
+			var daySpent int64
+			daySQL := `SELECT IFNULL(SUM(amount_msat), 0) FROM card_payments
+				WHERE paid_flag='Y' AND card_id=$1 AND timestamp >= unixepoch() - 86400 AND kind=$2`
+			if err := conn.QueryRowContext(ctx, daySQL, cardId, PaymentKindPayment).Scan(&daySpent); err != nil {
 				return err
 			}
-			if daySpent+paymentAmount > dayLimit {
+			if daySpent+paymentMsat > SatsToMsat(dayLimit) {
 				return ErrDayLimitExceeded
 			}
 		}
 
-		// read balance under write lock
-		balanceSQL := `SELECT
-			IFNULL((SELECT SUM(amount_sats) FROM card_receipts WHERE paid_flag='Y' AND card_id=$1), 0) -
-			IFNULL((SELECT SUM(amount_sats) + SUM(fee_sats) FROM card_payments WHERE paid_flag='Y' AND card_id=$1), 0)`
-		row := conn.QueryRowContext(ctx, balanceSQL, cardId)
-		if err := row.Scan(&balance); err != nil {
-			return err
+		var feeRule serviceFeeRule
+		if serviceFee {
+			rule, err := cardServiceFeeRuleTx(ctx, conn, cardId, false)
+			if err != nil {
+				return err
+			}
+			feeRule = rule
 		}
+		serviceFeeSats := feeRule.feeSats(paymentMsat)
 
-		if balance < requiredBalance {
+		balanceMsat = storedBalance
+		if balanceMsat < requiredMsat+SatsToMsat(serviceFeeSats) {
 			return ErrInsufficientFunds
 		}
 
-		// reserve funds
-		insertSQL := `INSERT INTO card_payments (card_id, amount_sats, ln_invoice,
-			timestamp, expire_time)
-			VALUES ($1, $2, $3, unixepoch(), unixepoch() + 86400);`
-		res, err := conn.ExecContext(ctx, insertSQL, cardId, paymentAmount, invoice)
+		// reserve funds, holding the fee reserve as the fee until settlement
+		insertSQL := `INSERT INTO card_payments (card_id, amount_sats, amount_msat, ln_invoice,
+			fee_sats, fee_msat, fee_reserve_msat, timestamp, expire_time)
+			VALUES ($1, $2, $3, $4, $5, $6, $6, unixepoch(), unixepoch() + 86400);`
+		res, err := conn.ExecContext(ctx, insertSQL, cardId, MsatToSatsUp(paymentMsat), paymentMsat, invoice,
+			MsatToSatsUp(feeReserveMsat), feeReserveMsat)
 		if err != nil {
 			return err
 		}
@@ -134,8 +203,12 @@ func Db_reserve_card_payment(db_conn *sql.DB, cardId int, requiredBalance int, p
 		}
 		paymentID = int(id)
 
+		if serviceFeeSats > 0 {
+			return chargeServiceFeeTx(ctx, conn, cardId, serviceFeeSats, feeRule, paymentID, 0,
+				"spend fee on payment "+strconv.Itoa(paymentID))
+		}
 		return nil
 	})
 
-	return balance, paymentID, err
+	return balanceMsat, paymentID, err
 }
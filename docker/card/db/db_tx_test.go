@@ -16,7 +16,7 @@ import (
 // Db_reserve_card_payment relies on. A temp file with a busy timeout lets
 // contending BEGIN IMMEDIATE transactions queue on the SQLite write lock
 // instead of failing with SQLITE_BUSY.
-func openConcurrentTestDB(t *testing.T) *sql.DB {
+func openConcurrentTestDB(t testing.TB) *sql.DB {
 	t.Helper()
 	os.Setenv("HOST_DOMAIN", "test.example.com")
 
@@ -288,3 +288,62 @@ func TestReserveCardPayment_ZeroLimitsAllowLargePayment(t *testing.T) {
 		t.Fatalf("expected large reservation to succeed with zero limits, got %v", err)
 	}
 }
+
+// TestSettleCardPayment_ReplacesFeeReserve verifies a reservation holds its
+// fee reserve, that settling replaces it with the actual fee, and that a
+// payment can only be settled once.
+func TestSettleCardPayment_ReplacesFeeReserve(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+
+	cardId := fundCard(t, db, 1000)
+
+	// the fee reserve counts towards the balance needed
+	if _, _, err := Db_reserve_card_payment_msat(db, cardId, 990_000, 20_000, "lnbcbig"); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("expected insufficient funds with the fee reserve, got %v", err)
+	}
+
+	_, paymentID, err := Db_reserve_card_payment_msat(db, cardId, 500_000, 20_000, "lnbcpay")
+	if err != nil {
+		t.Fatalf("expected reservation to succeed, got %v", err)
+	}
+	if bal := Db_get_card_balance(db, cardId); bal != 480 {
+		t.Fatalf("expected balance 480 while the fee reserve is held, got %d", bal)
+	}
+
+	if err := Db_settle_card_payment(db, paymentID, 3_500, "", ""); err != nil {
+		t.Fatalf("expected settlement to succeed, got %v", err)
+	}
+	if bal := Db_get_card_balance_msat(db, cardId); bal != 496_500 {
+		t.Fatalf("expected balance 496500 msat after settlement, got %d", bal)
+	}
+	if err := Db_settle_card_payment(db, paymentID, 0, "", ""); !errors.Is(err, ErrPaymentNotPending) {
+		t.Fatalf("expected a second settlement to be refused, got %v", err)
+	}
+}
+
+// TestSettleCardPayment_CapsFeeAtReserve verifies a routing fee reported
+// over the fee reserve charges the card only the reserve.
+func TestSettleCardPayment_CapsFeeAtReserve(t *testing.T) {
+	db := openConcurrentTestDB(t)
+	Db_init(db)
+
+	cardId := fundCard(t, db, 1000)
+	_, paymentID, err := Db_reserve_card_payment_msat(db, cardId, 500_000, 10_000, "lnbcpay")
+	if err != nil {
+		t.Fatalf("expected reservation to succeed, got %v", err)
+	}
+
+	if err := Db_settle_card_payment(db, paymentID, 50_000, "", ""); err != nil {
+		t.Fatalf("expected settlement to succeed, got %v", err)
+	}
+	if bal := Db_get_card_balance_msat(db, cardId); bal != 490_000 {
+		t.Fatalf("expected balance 490000 msat with the fee capped, got %d", bal)
+	}
+	var feeMsat, feeSats int64
+	db.QueryRow(`SELECT fee_msat, fee_sats FROM card_payments WHERE card_payment_id = $1`, paymentID).
+		Scan(&feeMsat, &feeSats)
+	if feeMsat != 10_000 || feeSats != 10 {
+		t.Fatalf("expected the fee recorded as 10000 msat / 10 sats, got %d / %d", feeMsat, feeSats)
+	}
+}
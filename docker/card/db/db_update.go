@@ -1,30 +1,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func Db_update_tokens(db_conn *sql.DB, initial_refresh_token string, new_refresh_token string, access_token string) (success bool) {
-
-	// update record
-	sqlStatement := `UPDATE cards SET access_token = $1, refresh_token = $2` +
-		` WHERE refresh_token = $3 AND wiped = 'N';`
-	res, err := db_conn.Exec(sqlStatement, access_token, new_refresh_token, initial_refresh_token)
-	if err != nil {
-		log.Error("db_update_tokens exec error: ", err)
-		return false
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		log.Error("db_update_tokens rows affected error: ", err)
-		return false
-	}
-
-	return (count == 1)
-}
-
 func Db_update_card_with_pin(db_conn *sql.DB, card_id int, tx_limit_sats int, day_limit_sats int, pin_enable string, pin_number string, pin_limit_sats int, lnurlw_enable string) {
 
 	// update record
@@ -48,20 +30,32 @@ func Db_update_card_without_pin(db_conn *sql.DB, card_id int, tx_limit_sats int,
 }
 
 func Db_update_card_payment_fee(db_conn *sql.DB, card_payment_id int, fee_sats int) {
+	Db_update_card_payment_fee_msat(db_conn, card_payment_id, SatsToMsat(fee_sats))
+}
+
+// Db_update_card_payment_fee_msat sets a payment's fee in millisatoshis.
+func Db_update_card_payment_fee_msat(db_conn *sql.DB, card_payment_id int, fee_msat int64) {
 
 	// update record
-	sqlStatement := `UPDATE card_payments SET fee_sats = $1 WHERE card_payment_id = $2;`
-	_, err := db_conn.Exec(sqlStatement, fee_sats, card_payment_id)
+	sqlStatement := `UPDATE card_payments SET fee_sats = $1, fee_msat = $2 WHERE card_payment_id = $3;`
+	_, err := db_conn.Exec(sqlStatement, MsatToSatsUp(fee_msat), fee_msat, card_payment_id)
 	if err != nil {
 		log.Error("db_update_card_payment_fee error: ", err)
 	}
 }
 
+// Db_update_card_payment_unpaid reverses a failed payment together with any
+// service fee charged on it.
 func Db_update_card_payment_unpaid(db_conn *sql.DB, card_payment_id int) {
 
-	// update record
-	sqlStatement := `UPDATE card_payments SET paid_flag = 'N' WHERE card_payment_id = $1;`
-	_, err := db_conn.Exec(sqlStatement, card_payment_id)
+	err := withImmediateTx(db_conn, func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx,
+			`UPDATE card_payments SET paid_flag = 'N' WHERE card_payment_id = $1;`, card_payment_id)
+		if err != nil {
+			return err
+		}
+		return reverseServiceFeesTx(ctx, conn, card_payment_id)
+	})
 	if err != nil {
 		log.Error("db_update_card_payment_unpaid error: ", err)
 	}
@@ -14,29 +14,16 @@ type CardKeys struct {
 	Key4 string
 }
 
+// Db_wipe_card wipes a card and returns the key set its chip holds, which is
+// the previous set if the keys were rotated but the chip not yet re-written.
 func Db_wipe_card(db_conn *sql.DB, card_id int) CardKeys {
 
-	var cardKeys CardKeys
-
-	// update card record
-	sqlStatement := `UPDATE cards SET wiped = 'Y'` +
-		` WHERE card_id = $1;`
-	_, err := db_conn.Exec(sqlStatement, card_id)
+	// update card record (wiping an already wiped card is a no-op)
+	err := Db_set_card_status(db_conn, card_id, CardStatusWiped, "card wiped", "")
 	if err != nil {
 		log.Error("db_wipe_card update error: ", err)
-		return cardKeys
-	}
-
-	// get keys
-	sqlStatement = `SELECT key0_auth, key1_enc, key2_cmac, key3, key4 FROM cards` +
-		` WHERE card_id=$1;`
-	row := db_conn.QueryRow(sqlStatement, card_id)
-
-	err = row.Scan(&cardKeys.Key0, &cardKeys.Key1, &cardKeys.Key2, &cardKeys.Key3, &cardKeys.Key4)
-	if err != nil {
-		log.Error("db_wipe_card scan error: ", err)
-		return cardKeys
+		return CardKeys{}
 	}
 
-	return cardKeys
+	return Db_get_card_chip_keys(db_conn, card_id)
 }
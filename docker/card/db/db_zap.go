@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// zap_receipt_id while the zap receipt for a receipt is being published
+const zapReceiptClaimed = "-"
+
+// ZapToPublish is a paid receipt whose zap receipt is to be published.
+type ZapToPublish struct {
+	ZapRequest string // the zap request event, as JSON
+	Invoice    string
+	SettledAt  int64
+}
+
+// Db_claim_zap_receipt claims the publishing of the zap receipt for a paid
+// receipt that came with a zap request. Only one caller gets found = true,
+// so a receipt settled by both the websocket listener and the poller is
+// zapped once.
+func Db_claim_zap_receipt(db_conn *sql.DB, payment_hash string) (zap ZapToPublish, found bool) {
+	sqlStatement := `UPDATE card_receipts SET zap_receipt_id = $1` +
+		` WHERE r_hash_hex = $2 AND paid_flag = 'Y' AND zap_request != '' AND zap_receipt_id = ''` +
+		` RETURNING zap_request, ln_invoice, settled_at;`
+	err := db_conn.QueryRow(sqlStatement, zapReceiptClaimed, payment_hash).
+		Scan(&zap.ZapRequest, &zap.Invoice, &zap.SettledAt)
+	if err == sql.ErrNoRows {
+		return zap, false
+	}
+	if err != nil {
+		log.Error("db_claim_zap_receipt error: ", err)
+		return zap, false
+	}
+	return zap, true
+}
+
+// Db_set_zap_receipt_id records the id of the zap receipt event published
+// for a receipt.
+func Db_set_zap_receipt_id(db_conn *sql.DB, payment_hash string, event_id string) {
+	sqlStatement := `UPDATE card_receipts SET zap_receipt_id = $1 WHERE r_hash_hex = $2;`
+	_, err := db_conn.Exec(sqlStatement, event_id, payment_hash)
+	if err != nil {
+		log.Error("db_set_zap_receipt_id error: ", err)
+	}
+}
@@ -0,0 +1,180 @@
+package nostr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// event kinds used for zaps (NIP-57)
+const (
+	KindZapRequest = 9734
+	KindZapReceipt = 9735
+)
+
+// Event is a Nostr event (NIP-01).
+type Event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Serialize is the canonical form of the event that its id is the hash of:
+// [0,pubkey,created_at,kind,tags,content] with NIP-01 string escaping.
+func (e Event) Serialize() []byte {
+	var b strings.Builder
+	b.WriteString(`[0,`)
+	writeString(&b, e.PubKey)
+	b.WriteString(`,` + strconv.FormatInt(e.CreatedAt, 10) + `,` + strconv.Itoa(e.Kind) + `,[`)
+	for i, tag := range e.Tags {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('[')
+		for j, value := range tag {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			writeString(&b, value)
+		}
+		b.WriteByte(']')
+	}
+	b.WriteString(`],`)
+	writeString(&b, e.Content)
+	b.WriteByte(']')
+	return []byte(b.String())
+}
+
+// writeString writes s as a JSON string escaped as NIP-01 requires: only
+// quotes, backslashes and control characters are escaped.
+func writeString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 {
+				b.WriteString(`\u00`)
+				b.WriteString(hex.EncodeToString([]byte{byte(r)}))
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// Hash is the sha256 of the serialized event, the event id in binary.
+func (e Event) Hash() [32]byte {
+	return sha256.Sum256(e.Serialize())
+}
+
+// Sign sets the event's pubkey, id and signature for a hex private key.
+func (e *Event) Sign(secretKeyHex string) error {
+	secretKey, err := hex.DecodeString(secretKeyHex)
+	if err != nil || len(secretKey) != 32 {
+		return errors.New("invalid secret key")
+	}
+	privKey, pubKey := btcec.PrivKeyFromBytes(secretKey)
+
+	e.PubKey = hex.EncodeToString(schnorr.SerializePubKey(pubKey))
+	hash := e.Hash()
+	sig, err := schnorr.Sign(privKey, hash[:])
+	if err != nil {
+		return err
+	}
+	e.ID = hex.EncodeToString(hash[:])
+	e.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
+
+// Verify checks the event's id matches its content and its signature is
+// valid for its pubkey.
+func (e Event) Verify() error {
+	hash := e.Hash()
+	if e.ID != hex.EncodeToString(hash[:]) {
+		return errors.New("event id does not match")
+	}
+	pubKeyBytes, err := hex.DecodeString(e.PubKey)
+	if err != nil {
+		return errors.New("invalid pubkey")
+	}
+	pubKey, err := schnorr.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return errors.New("invalid pubkey")
+	}
+	sigBytes, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+	if !sig.Verify(hash[:], pubKey) {
+		return errors.New("signature does not verify")
+	}
+	return nil
+}
+
+// PublicKey returns the hex x-only public key for a hex private key.
+func PublicKey(secretKeyHex string) (string, error) {
+	secretKey, err := hex.DecodeString(secretKeyHex)
+	if err != nil || len(secretKey) != 32 {
+		return "", errors.New("invalid secret key")
+	}
+	_, pubKey := btcec.PrivKeyFromBytes(secretKey)
+	return hex.EncodeToString(schnorr.SerializePubKey(pubKey)), nil
+}
+
+// TagValues returns the first value of each of the event's tags named name.
+func (e Event) TagValues(name string) []string {
+	var values []string
+	for _, tag := range e.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			values = append(values, tag[1])
+		}
+	}
+	return values
+}
+
+// Tag returns the first tag named name, or nil.
+func (e Event) Tag(name string) []string {
+	for _, tag := range e.Tags {
+		if len(tag) >= 1 && tag[0] == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+// IsHexKey reports whether s is a 32 byte key or id in lowercase hex.
+func IsHexKey(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
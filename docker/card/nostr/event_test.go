@@ -0,0 +1,78 @@
+package nostr
+
+import (
+	"strings"
+	"testing"
+)
+
+// BIP-340 test vector 0: secret key 3
+const testSecretKey = "0000000000000000000000000000000000000000000000000000000000000003"
+const testPubKey = "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+
+func TestPublicKey_Bip340Vector(t *testing.T) {
+	pubKey, err := PublicKey(testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pubKey != testPubKey {
+		t.Fatalf("expected %s, got %s", testPubKey, pubKey)
+	}
+	if _, err := PublicKey("abcd"); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+}
+
+func TestEventSerialize_Nip01Escaping(t *testing.T) {
+	e := Event{
+		PubKey:    testPubKey,
+		CreatedAt: 1700000000,
+		Kind:      1,
+		Tags:      [][]string{{"p", "abc"}, {"relays", "wss://a", "wss://b"}},
+		Content:   "line\n\"quoted\" <b>&\u0001",
+	}
+	want := `[0,"` + testPubKey + `",1700000000,1,[["p","abc"],["relays","wss://a","wss://b"]],` +
+		`"line\n\"quoted\" <b>&\u0001"]`
+	if got := string(e.Serialize()); got != want {
+		t.Fatalf("unexpected serialization:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestEventSign_VerifiesAndDetectsTampering(t *testing.T) {
+	e := Event{CreatedAt: 1700000000, Kind: KindZapRequest, Tags: [][]string{{"p", testPubKey}}, Content: "zap"}
+	if err := e.Sign(testSecretKey); err != nil {
+		t.Fatal(err)
+	}
+	if e.PubKey != testPubKey || !IsHexKey(e.ID) || len(e.Sig) != 128 {
+		t.Fatalf("unexpected signed event: %+v", e)
+	}
+	if err := e.Verify(); err != nil {
+		t.Fatalf("expected a valid signature: %v", err)
+	}
+
+	tampered := e
+	tampered.Content = "zap!"
+	if err := tampered.Verify(); err == nil || !strings.Contains(err.Error(), "id") {
+		t.Fatalf("expected an id mismatch, got %v", err)
+	}
+
+	tampered = e
+	tampered.Content = "zap!"
+	hash := tampered.Hash()
+	tampered.ID = strings.ToLower(hexString(hash[:]))
+	if err := tampered.Verify(); err == nil {
+		t.Fatal("expected the signature not to verify for changed content")
+	}
+}
+
+func TestEventTags(t *testing.T) {
+	e := Event{Tags: [][]string{{"p", "one"}, {"e", "ev"}, {"p", "two"}, {"relays", "wss://a", "wss://b"}}}
+	if got := e.TagValues("p"); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Fatalf("unexpected p tags: %v", got)
+	}
+	if got := e.Tag("relays"); len(got) != 3 {
+		t.Fatalf("unexpected relays tag: %v", got)
+	}
+	if e.Tag("a") != nil {
+		t.Fatal("expected no a tag")
+	}
+}
@@ -0,0 +1,121 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Publisher sends a signed event to a relay.
+type Publisher interface {
+	Publish(relayURL string, event Event) error
+}
+
+// ErrPrivateRelay is returned for a relay whose host resolves to a loopback,
+// private or link-local address.
+var ErrPrivateRelay = errors.New("relay address is not public")
+
+// RelayPublisher publishes events to relays over websocket as NIP-01
+// describes: it sends ["EVENT", event] and waits for the relay's
+// ["OK", id, accepted, message]. Relay URLs come from untrusted events, so
+// only public addresses are dialled unless AllowPrivateHosts is set.
+type RelayPublisher struct {
+	Timeout           time.Duration // for the whole exchange with one relay
+	AllowPrivateHosts bool          // for tests against a local relay
+}
+
+// ValidRelayURL reports whether s is a ws:// or wss:// URL.
+func ValidRelayURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss") && u.Host != ""
+}
+
+// publicIP reports whether ip may be reached from the public internet.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// dialPublic connects to address only if its host resolves to public
+// addresses, and dials the address it checked so a second lookup cannot
+// point the connection elsewhere.
+func dialPublic(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("relay host has no address")
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return nil, ErrPrivateRelay
+		}
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+func (p RelayPublisher) Publish(relayURL string, event Event) error {
+	if !ValidRelayURL(relayURL) {
+		return errors.New("invalid relay url")
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+
+	dialer := websocket.Dialer{HandshakeTimeout: timeout}
+	if !p.AllowPrivateHosts {
+		dialer.NetDialContext = dialPublic
+	}
+	conn, _, err := dialer.Dial(relayURL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+
+	if err := conn.WriteJSON([]any{"EVENT", event}); err != nil {
+		return err
+	}
+
+	for {
+		var message []json.RawMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return err
+		}
+		var messageType, eventId string
+		if len(message) < 3 || json.Unmarshal(message[0], &messageType) != nil || messageType != "OK" {
+			continue // NOTICE or other traffic
+		}
+		if json.Unmarshal(message[1], &eventId) != nil || eventId != event.ID {
+			continue
+		}
+		var accepted bool
+		if err := json.Unmarshal(message[2], &accepted); err != nil {
+			return errors.New("malformed OK from relay")
+		}
+		if !accepted {
+			reason := ""
+			if len(message) > 3 {
+				json.Unmarshal(message[3], &reason)
+			}
+			return errors.New("relay rejected event: " + reason)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func hexString(b []byte) string { return hex.EncodeToString(b) }
+
+// testRelay is a local relay stand-in that answers each EVENT with OK,
+// accepting it unless reject is set, and hands received events to events.
+func testRelay(t *testing.T, reject string) (relayURL string, events chan Event) {
+	t.Helper()
+	events = make(chan Event, 4)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var message []json.RawMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			var event Event
+			if len(message) != 2 || json.Unmarshal(message[1], &event) != nil {
+				continue
+			}
+			events <- event
+			conn.WriteJSON([]any{"NOTICE", "hello"})
+			conn.WriteJSON([]any{"OK", event.ID, reject == "", reject})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), events
+}
+
+func TestRelayPublisher_Publish(t *testing.T) {
+	relayURL, events := testRelay(t, "")
+
+	e := Event{CreatedAt: 1700000000, Kind: KindZapReceipt, Content: ""}
+	if err := e.Sign(testSecretKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := (RelayPublisher{Timeout: 5 * time.Second, AllowPrivateHosts: true}).Publish(relayURL, e); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	got := <-events
+	if got.ID != e.ID || got.Verify() != nil {
+		t.Fatalf("relay received an unexpected event: %+v", got)
+	}
+}
+
+func TestRelayPublisher_Rejected(t *testing.T) {
+	relayURL, _ := testRelay(t, "blocked: spam")
+
+	e := Event{CreatedAt: 1700000000, Kind: KindZapReceipt}
+	e.Sign(testSecretKey)
+	err := (RelayPublisher{Timeout: 5 * time.Second, AllowPrivateHosts: true}).Publish(relayURL, e)
+	if err == nil || !strings.Contains(err.Error(), "blocked: spam") {
+		t.Fatalf("expected the relay rejection, got %v", err)
+	}
+}
+
+func TestRelayPublisher_InvalidURL(t *testing.T) {
+	for _, u := range []string{"https://relay.example.com", "ws://", "relay"} {
+		if err := (RelayPublisher{}).Publish(u, Event{}); err == nil {
+			t.Fatalf("expected %q to be rejected", u)
+		}
+	}
+}
+
+func TestRelayPublisher_RefusesPrivateHosts(t *testing.T) {
+	relayURL, events := testRelay(t, "")
+
+	for _, u := range []string{relayURL, "ws://localhost:7000", "ws://10.1.2.3:80",
+		"ws://169.254.169.254", "ws://[::1]:9740"} {
+		err := (RelayPublisher{Timeout: 5 * time.Second}).Publish(u, Event{})
+		if !errors.Is(err, ErrPrivateRelay) {
+			t.Errorf("expected %s refused as private, got %v", u, err)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("expected nothing sent to the local relay, got %+v", e)
+	default:
+	}
+}
@@ -0,0 +1,29 @@
+package phoenix
+
+import (
+	"encoding/json"
+)
+
+type NodeInfo struct {
+	NodeId      string            `json:"nodeId"`
+	Channels    []json.RawMessage `json:"channels"`
+	Chain       string            `json:"chain"`
+	BlockHeight int               `json:"blockHeight"`
+	Version     string            `json:"version"`
+}
+
+func GetInfo() (NodeInfo, error) {
+	var info NodeInfo
+
+	body, err := doGet("/getinfo", "GetInfo")
+	if err != nil {
+		return info, err
+	}
+
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
@@ -3,6 +3,7 @@ package phoenix
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
@@ -21,17 +22,30 @@ type IncomingPayments []struct {
 }
 
 func ListIncomingPayments(limit int, offset int) (IncomingPayments, error) {
+	q := url.Values{}
+	q.Add("limit", strconv.Itoa(limit))
+	q.Add("offset", strconv.Itoa(offset))
+	q.Add("all", "true") // include unpaid invoices
+	return listIncomingPayments(q)
+}
+
+// ListPaidIncomingPaymentsSince lists the paid incoming payments for
+// invoices created from fromMs, a unix time in milliseconds.
+func ListPaidIncomingPaymentsSince(fromMs int64, limit int, offset int) (IncomingPayments, error) {
+	q := url.Values{}
+	q.Add("from", strconv.FormatInt(fromMs, 10))
+	q.Add("limit", strconv.Itoa(limit))
+	q.Add("offset", strconv.Itoa(offset))
+	return listIncomingPayments(q)
+}
+
+func listIncomingPayments(q url.Values) (IncomingPayments, error) {
 	var incomingPayments IncomingPayments
 
 	req, err := http.NewRequest(http.MethodGet, phoenixBaseURL+"/payments/incoming", http.NoBody)
 	if err != nil {
 		return incomingPayments, err
 	}
-
-	q := req.URL.Query()
-	q.Add("limit", strconv.Itoa(limit))
-	q.Add("offset", strconv.Itoa(offset))
-	q.Add("all", "true") // include unpaid invoices
 	req.URL.RawQuery = q.Encode()
 
 	body, err := doRequest(req, defaultTimeout, "ListIncomingPayments")
@@ -16,6 +16,7 @@ type PayLightningAddressRequest struct {
 	AmountSat string
 	Address   string
 	Message   string
+	MaxFeeSat string // routing fee cap, omitted when empty
 }
 
 type PayLightningAddressResponse struct {
@@ -56,6 +57,9 @@ func PayLightningAddress(
 	if payLightningAddressRequest.Message != "" {
 		formBody.Set("message", payLightningAddressRequest.Message)
 	}
+	if payLightningAddressRequest.MaxFeeSat != "" {
+		formBody.Set("maxFeeSat", payLightningAddressRequest.MaxFeeSat)
+	}
 	reader := strings.NewReader(formBody.Encode())
 
 	req, err := http.NewRequest(http.MethodPost, phoenixBaseURL+"/paylnaddress", reader)
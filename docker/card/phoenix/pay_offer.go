@@ -0,0 +1,111 @@
+package phoenix
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type PayOfferRequest struct {
+	AmountSat string
+	Offer     string
+	Message   string
+	MaxFeeSat string // routing fee cap, omitted when empty
+}
+
+type PayOfferResponse struct {
+	RecipientAmountSat int    `json:"recipientAmountSat"`
+	RoutingFeeSat      int    `json:"routingFeeSat"`
+	PaymentId          string `json:"paymentId"`
+	PaymentHash        string `json:"paymentHash"`
+	PaymentPreimage    string `json:"paymentPreimage"`
+	Reason             string `json:"reason"`
+}
+
+// PayOffer pays a BOLT12 offer (lno...) via phoenixd's /payoffer endpoint. It
+// mirrors SendLightningPayment: the second return value is a machine-readable
+// reason string for the outcome.
+func PayOffer(
+	payOfferRequest PayOfferRequest,
+) (
+	PayOfferResponse,
+	string,
+	error,
+) {
+	var payOfferResponse PayOfferResponse
+
+	password, err := getPassword()
+	if err != nil {
+		// Don't log err: see PayLightningAddress.
+		log.Warn("PayOffer: could not load phoenix config")
+		return payOfferResponse,
+			"no_config",
+			errors.New("could not load config for PayOffer")
+	}
+
+	formBody := url.Values{
+		"amountSat": []string{payOfferRequest.AmountSat},
+		"offer":     []string{payOfferRequest.Offer},
+	}
+	if payOfferRequest.Message != "" {
+		formBody.Set("message", payOfferRequest.Message)
+	}
+	if payOfferRequest.MaxFeeSat != "" {
+		formBody.Set("maxFeeSat", payOfferRequest.MaxFeeSat)
+	}
+	reader := strings.NewReader(formBody.Encode())
+
+	req, err := http.NewRequest(http.MethodPost, phoenixBaseURL+"/payoffer", reader)
+	if err != nil {
+		log.Warn(err)
+		return payOfferResponse,
+			"failed_request_creation",
+			errors.New("could not create request for PayOffer")
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("", password)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		// a timeout is ambiguous — the payment may or may not have been made
+		log.Error(err)
+		return payOfferResponse,
+			"phoenix_api_timeout",
+			errors.New("no response to PayOffer")
+	}
+
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		log.Error(err)
+		return payOfferResponse,
+			"failed_read_response",
+			errors.New("could not read response to PayOffer")
+	}
+
+	if res.StatusCode != 200 {
+		log.Warn("PayOffer StatusCode ", res.StatusCode)
+		return payOfferResponse,
+			"fail_status_code",
+			errors.New("fail status code returned for PayOffer")
+	}
+
+	err = json.Unmarshal(resBody, &payOfferResponse)
+	if err != nil {
+		log.Error(err)
+		return payOfferResponse,
+			"failed_decode_response",
+			errors.New("could not decode response to PayOffer")
+	}
+
+	return payOfferResponse, "no_error", nil
+}
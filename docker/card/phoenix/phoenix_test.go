@@ -72,6 +72,23 @@ func TestGetBalance_PasswordEmpty(t *testing.T) {
 	}
 }
 
+func TestGetInfo_Success(t *testing.T) {
+	withTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/getinfo" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		w.Write([]byte(`{"nodeId":"02abc","channels":[{"state":"Normal"}],"chain":"mainnet","blockHeight":850000,"version":"0.4.2"}`))
+	})
+
+	info, err := GetInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.NodeId != "02abc" || len(info.Channels) != 1 || info.Chain != "mainnet" || info.BlockHeight != 850000 {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+}
+
 func TestCreateInvoice_WithDescription(t *testing.T) {
 	withTestServer(t, func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/createinvoice" {
@@ -234,7 +251,8 @@ func TestSendLightningPayment_Success(t *testing.T) {
 		if err := r.ParseForm(); err != nil {
 			t.Errorf("parse form: %v", err)
 		}
-		if r.PostForm.Get("invoice") != "lnbc1..." || r.PostForm.Get("amountSat") != "250" {
+		if r.PostForm.Get("invoice") != "lnbc1..." || r.PostForm.Get("amountSat") != "250" ||
+			r.PostForm.Get("maxFeeSat") != "5" {
 			t.Errorf("unexpected form: %v", r.PostForm)
 		}
 		w.Write([]byte(`{"recipientAmountSat":250,"routingFeeSat":1,"paymentId":"pid","paymentHash":"ph","paymentPreimage":"pre"}`))
@@ -243,6 +261,7 @@ func TestSendLightningPayment_Success(t *testing.T) {
 	resp, reason, err := SendLightningPayment(SendLightningPaymentRequest{
 		AmountSat: "250",
 		Invoice:   "lnbc1...",
+		MaxFeeSat: "5",
 	})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -314,6 +333,22 @@ func TestListIncomingPayments_Success(t *testing.T) {
 	}
 }
 
+func TestListPaidIncomingPaymentsSince_SendsRange(t *testing.T) {
+	withTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/payments/incoming" || q.Get("from") != "1700000000000" ||
+			q.Get("limit") != "100" || q.Get("offset") != "200" || q.Has("all") {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		w.Write([]byte(`[{"paymentHash":"h1","isPaid":true,"receivedSat":100}]`))
+	})
+
+	payments, err := ListPaidIncomingPaymentsSince(1700000000000, 100, 200)
+	if err != nil || len(payments) != 1 || payments[0].PaymentHash != "h1" {
+		t.Fatalf("unexpected result: %+v, %v", payments, err)
+	}
+}
+
 func TestListOutgoingPayments_Success(t *testing.T) {
 	withTestServer(t, func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/payments/outgoing" {
@@ -453,3 +488,81 @@ func TestPayLightningAddress_NoConfig(t *testing.T) {
 		t.Fatalf("expected reason no_config, got %q", reason)
 	}
 }
+
+func TestPayOffer_Success(t *testing.T) {
+	withTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/payoffer" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if r.PostForm.Get("amountSat") != "2000" {
+			t.Errorf("expected amountSat=2000, got %q", r.PostForm.Get("amountSat"))
+		}
+		if r.PostForm.Get("offer") != "lno1qtest" {
+			t.Errorf("expected offer=lno1qtest, got %q", r.PostForm.Get("offer"))
+		}
+		if r.PostForm.Has("message") {
+			t.Errorf("message should not be set when empty")
+		}
+		w.Write([]byte(`{"recipientAmountSat":2000,"routingFeeSat":3,"paymentId":"pid","paymentHash":"ohash","paymentPreimage":"pre"}`))
+	})
+
+	resp, reason, err := PayOffer(PayOfferRequest{
+		AmountSat: "2000",
+		Offer:     "lno1qtest",
+	})
+	if err != nil || reason != "no_error" {
+		t.Fatalf("unexpected outcome: reason=%q err=%v", reason, err)
+	}
+	if resp.RoutingFeeSat != 3 || resp.PaymentHash != "ohash" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestPayOffer_SendsMessageAndFeeCap(t *testing.T) {
+	withTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if r.PostForm.Get("message") != "thanks" {
+			t.Errorf("expected message=thanks, got %q", r.PostForm.Get("message"))
+		}
+		if r.PostForm.Get("maxFeeSat") != "6" {
+			t.Errorf("expected maxFeeSat=6, got %q", r.PostForm.Get("maxFeeSat"))
+		}
+		w.Write([]byte(`{"routingFeeSat":1,"paymentHash":"h"}`))
+	})
+
+	_, reason, err := PayOffer(PayOfferRequest{
+		AmountSat: "500",
+		Offer:     "lno1qtest",
+		Message:   "thanks",
+		MaxFeeSat: "6",
+	})
+	if err != nil || reason != "no_error" {
+		t.Fatalf("unexpected outcome: reason=%q err=%v", reason, err)
+	}
+}
+
+func TestPayOffer_NoConfig(t *testing.T) {
+	primePassword("")
+	defer primePassword("testpass")
+
+	_, reason, err := PayOffer(PayOfferRequest{AmountSat: "500", Offer: "lno1qtest"})
+	if err == nil || reason != "no_config" {
+		t.Fatalf("expected no_config, got reason=%q err=%v", reason, err)
+	}
+}
+
+func TestPayOffer_Non200(t *testing.T) {
+	withTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	_, reason, err := PayOffer(PayOfferRequest{AmountSat: "500", Offer: "lno1qtest"})
+	if err == nil || reason != "fail_status_code" {
+		t.Fatalf("expected fail_status_code, got reason=%q err=%v", reason, err)
+	}
+}
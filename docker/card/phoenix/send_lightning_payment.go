@@ -15,6 +15,7 @@ import (
 type SendLightningPaymentRequest struct {
 	AmountSat string
 	Invoice   string
+	MaxFeeSat string // routing fee cap, omitted when empty
 }
 
 type SendLightningPaymentResponse struct {
@@ -52,6 +53,9 @@ func SendLightningPayment(
 		"amountSat": []string{sendLightningPaymentRequest.AmountSat},
 		"invoice":   []string{sendLightningPaymentRequest.Invoice},
 	}
+	if sendLightningPaymentRequest.MaxFeeSat != "" {
+		formBody.Set("maxFeeSat", sendLightningPaymentRequest.MaxFeeSat)
+	}
 	reader := strings.NewReader(formBody.Encode())
 
 	req, err := http.NewRequest(http.MethodPost, phoenixBaseURL+"/payinvoice", reader)
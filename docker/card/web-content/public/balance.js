@@ -44,6 +44,13 @@ function render(data) {
 
     results.appendChild(el('div', 'balance', data.AvailableBalance.toLocaleString() + ' sats'));
     results.appendChild(el('div', 'balance-label', 'Available Balance'));
+    if (data.ExpiresAt) {
+        const expired = data.ExpiresAt * 1000 <= Date.now();
+        results.appendChild(el('div', 'balance-label',
+            (expired ? 'Expired ' : 'Expires ') + formatDate(data.ExpiresAt)));
+    }
+
+    if (data.PayoutOpen) results.appendChild(renderPayout());
 
     if (data.txs && data.txs.length > 0) {
         const table = el('table');
@@ -65,6 +72,14 @@ function render(data) {
             tr.appendChild(el('td', amtCls, tx.AmountSats.toLocaleString()));
             tr.appendChild(el('td', 'num', tx.FeeSats.toLocaleString()));
             tbody.appendChild(tr);
+            if (tx.Comment) {
+                // the payer's comment on a lightning address payment
+                const cr = el('tr', 'tx-comment');
+                const td = el('td', null, tx.Comment);
+                td.colSpan = 4;
+                cr.appendChild(td);
+                tbody.appendChild(cr);
+            }
         }
         table.appendChild(tbody);
         results.appendChild(table);
@@ -73,34 +88,85 @@ function render(data) {
     results.style.display = 'block';
 }
 
+// scanCard resolves with the URL on the next card tapped.
+async function scanCard() {
+    const ac = new AbortController();
+    const reader = new NDEFReader();
+    await reader.scan({ signal: ac.signal });
+
+    return new Promise(resolve => {
+        reader.addEventListener('reading', ({ message }) => {
+            if (message.records.length === 0) return;
+            ac.abort();
+            resolve(new TextDecoder('utf-8').decode(message.records[0].data));
+        }, { signal: ac.signal });
+    });
+}
+
+// renderPayout builds the form that sweeps the balance to the holder's own
+// wallet. The payout is authorised by a fresh tap of the card.
+function renderPayout() {
+    const payout = el('div', 'payout');
+    payout.appendChild(el('div', 'balance-label', 'Pay out your balance'));
+
+    const input = el('input');
+    input.type = 'text';
+    input.placeholder = 'Lightning address, invoice or offer';
+    input.autocomplete = 'off';
+    payout.appendChild(input);
+
+    const payBtn = el('button', null, 'Tap Card to Pay Out');
+    payout.appendChild(payBtn);
+
+    const status = el('div', 'payout-status');
+    payout.appendChild(status);
+
+    payBtn.addEventListener('click', async () => {
+        const destination = input.value.trim();
+        if (!destination) {
+            status.textContent = 'Enter where to send your sats.';
+            return;
+        }
+        payBtn.textContent = 'Tap card\u2026';
+        payBtn.disabled = true;
+        status.textContent = '';
+
+        try {
+            const card = await scanCard();
+            payBtn.textContent = 'Paying\u2026';
+            const r = await fetch('/balance-payout', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ card, destination }),
+            });
+            const data = await r.json();
+            if (data.status === 'OK') {
+                payout.replaceChildren(el('div', 'payout-status positive',
+                    'Paid out ' + data.amountSats.toLocaleString() + ' sats'));
+                return;
+            }
+            status.textContent = data.reason || 'Payout failed';
+        } catch {
+            status.textContent = 'Payout failed';
+        }
+        payBtn.textContent = 'Tap Card to Pay Out';
+        payBtn.disabled = false;
+    });
+
+    return payout;
+}
+
 btn.addEventListener('click', async () => {
     btn.textContent = 'Scanning\u2026';
     btn.disabled = true;
     results.style.display = 'none';
 
     try {
-        const ac = new AbortController();
-        const reader = new NDEFReader();
-        await reader.scan({ signal: ac.signal });
-
-        reader.addEventListener('reading', ({ message }) => {
-            if (message.records.length === 0) return;
-            ac.abort();
-
-            const url = new TextDecoder('utf-8').decode(message.records[0].data);
-
-            fetch('/balance-ajax?card=' + encodeURIComponent(url))
-                .then(r => r.json())
-                .then(data => {
-                    render(data);
-                    btn.textContent = 'Scan Again';
-                    btn.disabled = false;
-                })
-                .catch(() => {
-                    btn.textContent = 'Scan Card';
-                    btn.disabled = false;
-                });
-        }, { signal: ac.signal });
+        const url = await scanCard();
+        const data = await fetch('/balance-ajax?card=' + encodeURIComponent(url)).then(r => r.json());
+        render(data);
+        btn.textContent = 'Scan Again';
+        btn.disabled = false;
     } catch {
         btn.textContent = 'Scan Card';
         btn.disabled = false;
@@ -123,6 +123,12 @@ func (app *App) CreateHandler_AdminApi() http.HandlerFunc {
 		case path == "/admin/api/cards" && r.Method == "GET":
 			app.adminApiAuth(app.adminApiListCards)(w, r)
 
+		case path == "/admin/api/cards/export" && r.Method == "GET":
+			app.adminApiAuth(app.adminApiExportCardsCSV)(w, r)
+
+		case path == "/admin/api/cards/import" && r.Method == "POST":
+			app.adminApiAuth(app.adminApiImportCardsCSV)(w, r)
+
 		case strings.HasPrefix(path, "/admin/api/cards/"):
 			app.adminApiAuth(app.adminApiCardRouter)(w, r)
 
@@ -162,6 +168,36 @@ func (app *App) CreateHandler_AdminApi() http.HandlerFunc {
 		case path == "/admin/api/withdraw" && r.Method == "POST":
 			app.adminApiAuth(app.adminApiWithdraw)(w, r)
 
+		case path == "/admin/api/payout-policies" && r.Method == "GET":
+			app.adminApiAuth(app.adminApiListPayoutPolicies)(w, r)
+
+		case path == "/admin/api/payout-policies" && r.Method == "PUT":
+			app.adminApiAuth(app.adminApiSetPayoutPolicy)(w, r)
+
+		case path == "/admin/api/payout-policies" && r.Method == "DELETE":
+			app.adminApiAuth(app.adminApiDeletePayoutPolicy)(w, r)
+
+		case path == "/admin/api/group-expiry" && r.Method == "GET":
+			app.adminApiAuth(app.adminApiListGroupExpiry)(w, r)
+
+		case path == "/admin/api/group-expiry" && r.Method == "PUT":
+			app.adminApiAuth(app.adminApiSetGroupExpiry)(w, r)
+
+		case path == "/admin/api/expiry/report" && r.Method == "GET":
+			app.adminApiAuth(app.adminApiExpiryReport)(w, r)
+
+		case path == "/admin/api/expiry/run" && r.Method == "POST":
+			app.adminApiAuth(app.adminApiRunExpiry)(w, r)
+
+		case path == "/admin/api/groups" && r.Method == "GET":
+			app.adminApiAuth(app.adminApiListGroups)(w, r)
+
+		case strings.HasPrefix(path, "/admin/api/groups/"):
+			app.adminApiAuth(app.adminApiGroupRouter)(w, r)
+
+		case path == "/admin/api/house-accounts" && r.Method == "GET":
+			app.adminApiAuth(app.adminApiListHouseAccounts)(w, r)
+
 		default:
 			w.WriteHeader(http.StatusNotFound)
 			writeJSON(w, map[string]string{"error": "not found"})
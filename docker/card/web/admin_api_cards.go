@@ -3,7 +3,9 @@ package web
 import (
 	"card/db"
 	"card/util"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -13,37 +15,131 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// adminApiListCards lists cards. Wiped cards are left out unless asked for
+// with ?status=<status>[,<status>...] (e.g. wiped,lost) or ?status=all.
+//
+// Other filters are ?group=, ?minBalance=, ?maxBalance= and ?q=, which
+// searches the note, UID and lightning address. ?sort=id|balance|activity
+// and ?order=asc|desc set the order (default id, descending). With ?page=
+// and/or ?pageSize= (default 50, at most 500) one page is returned;
+// without them every matching card is.
 func (app *App) adminApiListCards(w http.ResponseWriter, r *http.Request) {
-	cards := db.Db_select_all_cards(app.db_read)
+	query := r.URL.Query()
+	badRequest := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": msg})
+	}
+
+	var q db.CardListQuery
+	switch filter := query.Get("status"); filter {
+	case "":
+	case "all":
+		q.Statuses = db.CardStatuses
+	default:
+		for _, status := range strings.Split(filter, ",") {
+			if !db.Card_status_is_valid(status) {
+				badRequest("invalid status")
+				return
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+
+	q.GroupTag = strings.TrimSpace(query.Get("group"))
+	q.Search = strings.TrimSpace(query.Get("q"))
+
+	for name, bound := range map[string]**int{"minBalance": &q.MinBalance, "maxBalance": &q.MaxBalance} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				badRequest("invalid " + name)
+				return
+			}
+			*bound = &n
+		}
+	}
+
+	switch sort := query.Get("sort"); sort {
+	case "", db.CardSortId, db.CardSortBalance, db.CardSortActivity:
+		q.Sort = sort
+	default:
+		badRequest("sort must be id, balance or activity")
+		return
+	}
+	switch order := query.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		q.Asc = true
+	default:
+		badRequest("order must be asc or desc")
+		return
+	}
+
+	paged := query.Has("page") || query.Has("pageSize")
+	page, pageSize := 1, 50
+	if paged {
+		if v := query.Get("page"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				badRequest("invalid page")
+				return
+			}
+			page = n
+		}
+		if v := query.Get("pageSize"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 500 {
+				badRequest("pageSize must be between 1 and 500")
+				return
+			}
+			pageSize = n
+		}
+		q.Limit = pageSize
+		q.Offset = (page - 1) * pageSize
+	}
+
+	cards, total := db.Db_select_cards_page(app.db_read, q)
 
 	type cardJSON struct {
-		CardId       int    `json:"cardId"`
-		Uid          string `json:"uid"`
-		Note         string `json:"note"`
-		BalanceSats  int    `json:"balanceSats"`
-		LnurlwEnable string `json:"lnurlwEnable"`
-		GroupTag     string `json:"groupTag"`
-		TxLimitSats  int    `json:"txLimitSats"`
-		DayLimitSats int    `json:"dayLimitSats"`
+		CardId         int    `json:"cardId"`
+		Uid            string `json:"uid"`
+		Note           string `json:"note"`
+		BalanceSats    int    `json:"balanceSats"`
+		LnurlwEnable   string `json:"lnurlwEnable"`
+		Status         string `json:"status"`
+		GroupTag       string `json:"groupTag"`
+		TxLimitSats    int    `json:"txLimitSats"`
+		DayLimitSats   int    `json:"dayLimitSats"`
+		LnAddress      string `json:"lnAddress"`
+		LastActivityAt int    `json:"lastActivityAt"`
 	}
 
 	result := make([]cardJSON, 0, len(cards))
 	for _, c := range cards {
 		result = append(result, cardJSON{
-			CardId:       c.CardId,
-			Uid:          c.Uid,
-			Note:         c.Note,
-			BalanceSats:  c.BalanceSats,
-			LnurlwEnable: c.LnurlwEnable,
-			GroupTag:     c.GroupTag,
-			TxLimitSats:  c.TxLimitSats,
-			DayLimitSats: c.DayLimitSats,
+			CardId:         c.CardId,
+			Uid:            c.Uid,
+			Note:           c.Note,
+			BalanceSats:    c.BalanceSats,
+			LnurlwEnable:   c.LnurlwEnable,
+			Status:         c.Status,
+			GroupTag:       c.GroupTag,
+			TxLimitSats:    c.TxLimitSats,
+			DayLimitSats:   c.DayLimitSats,
+			LnAddress:      c.LnAddress,
+			LastActivityAt: c.LastActivityAt,
 		})
 	}
 
-	writeJSON(w, map[string]any{
+	resp := map[string]any{
 		"cards": result,
-	})
+		"total": total,
+	}
+	if paged {
+		resp["page"] = page
+		resp["pageSize"] = pageSize
+	}
+	writeJSON(w, resp)
 }
 
 // adminApiCardRouter dispatches /admin/api/cards/{id}[/action] requests.
@@ -52,6 +148,15 @@ func (app *App) adminApiCardRouter(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/admin/api/cards/")
 	parts := strings.SplitN(path, "/", 2)
 
+	if parts[0] == "by-uid" && r.Method == "GET" {
+		uid := ""
+		if len(parts) > 1 {
+			uid = parts[1]
+		}
+		app.adminApiGetCardByUid(w, r, uid)
+		return
+	}
+
 	cardId, err := strconv.Atoi(parts[0])
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -71,12 +176,36 @@ func (app *App) adminApiCardRouter(w http.ResponseWriter, r *http.Request) {
 		app.adminApiUpdateCardNote(w, r, cardId)
 	case action == "limits" && r.Method == "PUT":
 		app.adminApiUpdateCardLimits(w, r, cardId)
+	case action == "ln-alias" && r.Method == "PUT":
+		app.adminApiSetCardLnAlias(w, r, cardId)
+	case action == "receive" && r.Method == "PUT":
+		app.adminApiSetCardReceive(w, r, cardId)
 	case action == "allocate" && r.Method == "POST":
 		app.adminApiAllocateFunds(w, r, cardId)
+	case action == "status" && r.Method == "PUT":
+		app.adminApiSetCardStatus(w, r, cardId)
+	case action == "expiry" && r.Method == "PUT":
+		app.adminApiSetCardExpiry(w, r, cardId)
+	case action == "status-history" && r.Method == "GET":
+		app.adminApiCardStatusHistory(w, r, cardId)
 	case action == "wipe" && r.Method == "POST":
 		app.adminApiWipeCard(w, r, cardId)
+	case action == "rotate-keys" && r.Method == "POST":
+		app.adminApiRotateCardKeys(w, r, cardId)
+	case action == "replace" && r.Method == "POST":
+		app.adminApiReplaceCard(w, r, cardId)
 	case action == "txs" && r.Method == "GET":
 		app.adminApiCardTxs(w, r, cardId)
+	case action == "taps" && r.Method == "GET":
+		app.adminApiCardTaps(w, r, cardId)
+	case action == "security-events" && r.Method == "GET":
+		app.adminApiCardSecurityEvents(w, r, cardId)
+	case action == "tokens" && r.Method == "GET":
+		app.adminApiCardTokens(w, r, cardId)
+	case action == "tokens" && r.Method == "DELETE":
+		app.adminApiRevokeCardTokens(w, r, cardId)
+	case strings.HasPrefix(action, "tokens/") && r.Method == "DELETE":
+		app.adminApiRevokeCardToken(w, r, cardId, strings.TrimPrefix(action, "tokens/"))
 	default:
 		w.WriteHeader(http.StatusNotFound)
 		writeJSON(w, map[string]string{"error": "not found"})
@@ -95,24 +224,63 @@ func (app *App) adminApiGetCard(w http.ResponseWriter, _ *http.Request, cardId i
 
 	hostDomain := db.Db_get_setting(app.db_read, "host_domain")
 
+	type keyHistoryJSON struct {
+		KeyVersion  int    `json:"keyVersion"`
+		RetiredAt   int    `json:"retiredAt"`
+		AcceptUntil int    `json:"acceptUntil"`
+		Reason      string `json:"reason"`
+	}
+	receive, _ := db.Db_get_card_receive_settings(app.db_read, cardId)
+
+	keyHistory := []keyHistoryJSON{}
+	for _, e := range db.Db_select_card_key_history(app.db_read, cardId) {
+		keyHistory = append(keyHistory, keyHistoryJSON(e))
+	}
+
 	writeJSON(w, map[string]any{
-		"cardId":           card.Card_id,
-		"uid":              card.Uid,
-		"note":             card.Note,
-		"balanceSats":      balance,
-		"lnurlwEnable":     card.Lnurlw_enable,
-		"txLimitSats":      card.Tx_limit_sats,
-		"dayLimitSats":     card.Day_limit_sats,
-		"pinEnable":        card.Pin_enable,
-		"pinLimitSats":     card.Pin_limit_sats,
-		"wiped":            card.Wiped,
-		"lnAddress":        card.Ln_address,
-		"lnAddressEnabled": card.Ln_address_enabled,
-		"payLinkEnabled":   card.Pay_link_enabled,
-		"hostDomain":       hostDomain,
+		"cardId":             card.Card_id,
+		"uid":                card.Uid,
+		"note":               card.Note,
+		"balanceSats":        balance,
+		"lnurlwEnable":       card.Lnurlw_enable,
+		"txLimitSats":        card.Tx_limit_sats,
+		"dayLimitSats":       card.Day_limit_sats,
+		"pinEnable":          card.Pin_enable,
+		"pinLimitSats":       card.Pin_limit_sats,
+		"wiped":              card.Wiped,
+		"status":             card.Status,
+		"statusReason":       card.Status_reason,
+		"statusChangedAt":    card.Status_changed_at,
+		"replacedByCardId":   card.Replaced_by_card_id,
+		"expiresAt":          db.Db_get_card_expiry(app.db_read, cardId),
+		"lnAddress":          card.Ln_address,
+		"lnAlias":            card.Ln_alias,
+		"lnAddressEnabled":   card.Ln_address_enabled,
+		"payLinkEnabled":     card.Pay_link_enabled,
+		"keyVersion":         card.Key_version,
+		"keyRotationPending": card.Pending_key_version != 0,
+		"keyHistory":         keyHistory,
+		"receive":            receiveSettingsJSON(receive),
+		"receivePolicy":      receiveSettingsJSON(db.Db_get_card_receive_policy(app.db_read, cardId)),
+		"hostDomain":         hostDomain,
 	})
 }
 
+// adminApiGetCardByUid looks a card up by the UID of its NFC chip.
+func (app *App) adminApiGetCardByUid(w http.ResponseWriter, r *http.Request, uid string) {
+	cardId := 0
+	if uid != "" {
+		cardId = db.Db_get_card_id_from_card_uid(app.db_read, uid)
+	}
+	if cardId == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "card not found"})
+		return
+	}
+
+	app.adminApiGetCard(w, r, cardId)
+}
+
 func (app *App) adminApiUpdateCardNote(w http.ResponseWriter, r *http.Request, cardId int) {
 	var req struct {
 		Note string `json:"note"`
@@ -177,6 +345,95 @@ func (app *App) adminApiUpdateCardLimits(w http.ResponseWriter, r *http.Request,
 	writeJSON(w, map[string]bool{"ok": true})
 }
 
+// adminApiSetCardLnAlias claims a vanity lightning address for a card, or
+// releases it when the alias is empty.
+func (app *App) adminApiSetCardLnAlias(w http.ResponseWriter, r *http.Request, cardId int) {
+	var req struct {
+		Alias string `json:"alias"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	alias, err := db.Db_set_card_ln_alias(app.db_write, cardId, req.Alias)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "card not found"})
+		return
+	case errors.Is(err, db.ErrCardNotActive):
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, map[string]string{"error": "card is wiped"})
+		return
+	case errors.Is(err, db.ErrLnAliasTaken):
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, db.ErrLnAliasInvalid), errors.Is(err, db.ErrLnAliasReserved):
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "failed to set alias"})
+		return
+	}
+
+	writeJSON(w, map[string]any{"ok": true, "lnAlias": alias})
+}
+
+type receiveSettingsView struct {
+	ReceiveMinSats int    `json:"receiveMinSats"`
+	ReceiveMaxSats int    `json:"receiveMaxSats"`
+	MaxBalanceSats int    `json:"maxBalanceSats"`
+	LnDescription  string `json:"lnDescription"`
+	LnImage        string `json:"lnImage"`
+}
+
+func receiveSettingsJSON(s db.ReceiveSettings) receiveSettingsView {
+	return receiveSettingsView{s.MinSats, s.MaxSats, s.MaxBalanceSats, s.Description, s.Image}
+}
+
+// adminApiSetCardReceive sets a card's own lightning address receive limits
+// and metadata; 0 and empty fall back to the card's group.
+func (app *App) adminApiSetCardReceive(w http.ResponseWriter, r *http.Request, cardId int) {
+	var req receiveSettingsView
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	receive := db.ReceiveSettings{
+		MinSats:        req.ReceiveMinSats,
+		MaxSats:        req.ReceiveMaxSats,
+		MaxBalanceSats: req.MaxBalanceSats,
+		Description:    strings.TrimSpace(req.LnDescription),
+		Image:          strings.TrimSpace(req.LnImage),
+	}
+	if err := validReceiveSettings(receive); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	}
+
+	err := db.Db_set_card_receive_settings(app.db_write, cardId, receive)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "card not found"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "failed to save receive settings"})
+		return
+	}
+
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
 // adminApiAllocateFunds credits a card with a manual balance top-up. It records
 // a paid card_receipt (mirroring the SetupCardAmountForTag CLI command) so the
 // allocation shows up in the card's transaction history and balance.
@@ -197,12 +454,17 @@ func (app *App) adminApiAllocateFunds(w http.ResponseWriter, r *http.Request, ca
 		return
 	}
 
-	// Db_get_card filters wiped='N', so this also rejects wiped/unknown cards
-	if _, err := db.Db_get_card(app.db_read, cardId); err != nil {
+	card, err := db.Db_get_card(app.db_read, cardId)
+	if err != nil || card.Status == db.CardStatusWiped {
 		w.WriteHeader(http.StatusNotFound)
 		writeJSON(w, map[string]string{"error": "card not found"})
 		return
 	}
+	if !db.Card_status_allows_receive(card.Status) {
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, map[string]string{"error": "card is " + card.Status})
+		return
+	}
 
 	// a unique r_hash_hex is required (UNIQUE constraint); use a random value
 	// since there is no real Lightning invoice behind a manual allocation
@@ -223,6 +485,72 @@ func (app *App) adminApiAllocateFunds(w http.ResponseWriter, r *http.Request, ca
 	})
 }
 
+// adminApiSetCardStatus moves a card to a new lifecycle status. Wiping and
+// replacing have their own endpoints, which also program the physical cards.
+func (app *App) adminApiSetCardStatus(w http.ResponseWriter, r *http.Request, cardId int) {
+	var req struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if !db.Card_status_is_valid(req.Status) || req.Status == db.CardStatusWiped ||
+		req.Status == db.CardStatusReplaced || req.Status == db.CardStatusPendingProgramming {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid status"})
+		return
+	}
+
+	err := db.Db_set_card_status(app.db_write, cardId, req.Status, req.Reason, "admin")
+	if errors.Is(err, db.ErrCardStatusTransition) {
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, map[string]string{"error": "status change not allowed"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "card not found"})
+		return
+	}
+
+	log.Info("admin set card ", cardId, " status to ", req.Status)
+	writeJSON(w, map[string]any{
+		"ok":     true,
+		"status": req.Status,
+	})
+}
+
+func (app *App) adminApiCardStatusHistory(w http.ResponseWriter, _ *http.Request, cardId int) {
+	changes := db.Db_select_card_status_history(app.db_read, cardId)
+
+	type changeJSON struct {
+		FromStatus string `json:"fromStatus"`
+		ToStatus   string `json:"toStatus"`
+		Reason     string `json:"reason"`
+		Actor      string `json:"actor"`
+		Timestamp  int    `json:"timestamp"`
+	}
+
+	result := make([]changeJSON, 0, len(changes))
+	for _, ch := range changes {
+		result = append(result, changeJSON{
+			FromStatus: ch.FromStatus,
+			ToStatus:   ch.ToStatus,
+			Reason:     ch.Reason,
+			Actor:      ch.Actor,
+			Timestamp:  ch.Timestamp,
+		})
+	}
+
+	writeJSON(w, map[string]any{
+		"history": result,
+	})
+}
+
 func (app *App) adminApiWipeCard(w http.ResponseWriter, _ *http.Request, cardId int) {
 	keys := db.Db_wipe_card(app.db_write, cardId)
 	if keys.Key0 == "" {
@@ -253,16 +581,80 @@ func (app *App) adminApiWipeCard(w http.ResponseWriter, _ *http.Request, cardId
 	})
 }
 
+// adminApiRotateCardKeys generates a new key set for an existing card and
+// returns a Bolt Card programmer deeplink to /rekey?s=<secret> that writes it
+// to the chip. Unlike wipe-and-replace, the card keeps its card_id, history and
+// lightning address. Both key sets are accepted until the first tap with the
+// new keys; re-issuing before then replaces the pending set.
+func (app *App) adminApiRotateCardKeys(w http.ResponseWriter, _ *http.Request, cardId int) {
+	var keys db.CardKeys
+	keys.Key0, keys.Key1, keys.Key2, keys.Key3, keys.Key4 = generateCardKeys()
+
+	secret := util.Random_hex()
+	expiry := time.Now().Unix() + 24*60*60 // 24h to re-program the card
+
+	keyVersion, err := db.Db_set_card_pending_keys(app.db_write, cardId, keys, secret, expiry)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "card not found"})
+		return
+	}
+
+	hostDomain := db.Db_get_setting(app.db_read, "host_domain")
+	programUrl := "https://" + hostDomain + "/rekey?s=" + secret
+	boltcardLink := "boltcard://program?url=" + url.QueryEscape(programUrl)
+
+	log.Info("admin issued key rotation: card=", cardId, " keyVersion=", keyVersion)
+	writeJSON(w, map[string]any{
+		"ok":           true,
+		"keyVersion":   keyVersion,
+		"boltcardLink": boltcardLink,
+		"programUrl":   programUrl,
+		"qr":           util.QrPngBase64Encode(boltcardLink),
+	})
+}
+
+// adminApiReplaceCard issues a replacement for a lost or damaged card. It
+// returns a Bolt Card programmer deeplink to /replace?s=<secret>; programming a
+// blank card with it creates a new card record that takes over the old card's
+// balance, lightning address, limits and settings, and marks the old card
+// replaced. Nothing moves until the new card is programmed.
+func (app *App) adminApiReplaceCard(w http.ResponseWriter, _ *http.Request, cardId int) {
+	secret := util.Random_hex()
+	expiry := time.Now().Unix() + 24*60*60 // 24h to program the replacement
+
+	err := db.Db_set_card_replace_secret(app.db_write, cardId, secret, expiry)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "card not found"})
+		return
+	}
+
+	hostDomain := db.Db_get_setting(app.db_read, "host_domain")
+	programUrl := "https://" + hostDomain + "/replace?s=" + secret
+	boltcardLink := "boltcard://program?url=" + url.QueryEscape(programUrl)
+
+	log.Info("admin issued card replacement: card=", cardId)
+	writeJSON(w, map[string]any{
+		"ok":           true,
+		"boltcardLink": boltcardLink,
+		"programUrl":   programUrl,
+		"qr":           util.QrPngBase64Encode(boltcardLink),
+	})
+}
+
 func (app *App) adminApiCardTxs(w http.ResponseWriter, _ *http.Request, cardId int) {
 	txs := db.Db_select_card_txs(app.db_read, cardId)
 
 	type txJSON struct {
-		ReceiptId  int  `json:"receiptId"`
-		PaymentId  int  `json:"paymentId"`
-		Timestamp  int  `json:"timestamp"`
-		AmountSats int  `json:"amountSats"`
-		FeeSats    int  `json:"feeSats"`
-		Allocated  bool `json:"allocated"`
+		ReceiptId  int    `json:"receiptId"`
+		PaymentId  int    `json:"paymentId"`
+		Timestamp  int    `json:"timestamp"`
+		AmountSats int    `json:"amountSats"`
+		FeeSats    int    `json:"feeSats"`
+		Allocated  bool   `json:"allocated"`
+		ServiceFee bool   `json:"serviceFee"`
+		Comment    string `json:"comment"`
 	}
 
 	result := make([]txJSON, 0, len(txs))
@@ -274,6 +666,8 @@ func (app *App) adminApiCardTxs(w http.ResponseWriter, _ *http.Request, cardId i
 			AmountSats: tx.AmountSats,
 			FeeSats:    tx.FeeSats,
 			Allocated:  tx.Allocated,
+			ServiceFee: tx.ServiceFee,
+			Comment:    tx.Comment,
 		})
 	}
 
@@ -282,6 +676,157 @@ func (app *App) adminApiCardTxs(w http.ResponseWriter, _ *http.Request, cardId i
 	})
 }
 
+// adminApiCardTaps returns a page of the card's tap history, most recent
+// first: /admin/api/cards/{id}/taps?limit=50&offset=0
+func (app *App) adminApiCardTaps(w http.ResponseWriter, r *http.Request, cardId int) {
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, 500)
+	}
+	offset := 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+
+	taps := db.Db_select_card_taps(app.db_read, cardId, limit, offset)
+
+	type tapJSON struct {
+		TapId     int    `json:"tapId"`
+		Timestamp int    `json:"timestamp"`
+		Counter   uint32 `json:"counter"`
+		Endpoint  string `json:"endpoint"`
+		Outcome   string `json:"outcome"`
+		RemoteIp  string `json:"remoteIp"`
+		UserAgent string `json:"userAgent"`
+
+		KeyVersion   int  `json:"keyVersion"`
+		PreviousKeys bool `json:"previousKeys"`
+	}
+
+	result := make([]tapJSON, 0, len(taps))
+	for _, tap := range taps {
+		result = append(result, tapJSON{
+			TapId:     tap.TapId,
+			Timestamp: tap.Timestamp,
+			Counter:   tap.Counter,
+			Endpoint:  tap.Endpoint,
+			Outcome:   tap.Outcome,
+			RemoteIp:  tap.RemoteIp,
+			UserAgent: tap.UserAgent,
+
+			KeyVersion:   tap.KeyVersion,
+			PreviousKeys: tap.PreviousKeys,
+		})
+	}
+
+	writeJSON(w, map[string]any{
+		"taps":   result,
+		"total":  db.Db_get_card_tap_count(app.db_read, cardId),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (app *App) adminApiCardSecurityEvents(w http.ResponseWriter, _ *http.Request, cardId int) {
+	events := db.Db_select_card_security_events(app.db_read, cardId, 100)
+
+	type eventJSON struct {
+		EventId   int    `json:"eventId"`
+		EventType string `json:"eventType"`
+		Detail    string `json:"detail"`
+		Action    string `json:"action"`
+		RemoteIp  string `json:"remoteIp"`
+		Timestamp int    `json:"timestamp"`
+	}
+
+	result := make([]eventJSON, 0, len(events))
+	for _, ev := range events {
+		result = append(result, eventJSON{
+			EventId:   ev.EventId,
+			EventType: ev.EventType,
+			Detail:    ev.Detail,
+			Action:    ev.Action,
+			RemoteIp:  ev.RemoteIp,
+			Timestamp: ev.Timestamp,
+		})
+	}
+
+	writeJSON(w, map[string]any{
+		"events": result,
+	})
+}
+
+// adminApiCardTokens lists the wallet API tokens issued to a card, most
+// recent first. Token values are not returned.
+func (app *App) adminApiCardTokens(w http.ResponseWriter, _ *http.Request, cardId int) {
+	tokens := db.Db_select_card_tokens(app.db_read, cardId)
+	now := int(time.Now().Unix())
+
+	type tokenJSON struct {
+		TokenId          int    `json:"tokenId"`
+		FamilyId         int    `json:"familyId"`
+		TokenHint        string `json:"tokenHint"`
+		Status           string `json:"status"`
+		IssuedAt         int    `json:"issuedAt"`
+		AccessExpiresAt  int    `json:"accessExpiresAt"`
+		RefreshExpiresAt int    `json:"refreshExpiresAt"`
+		RotatedAt        int    `json:"rotatedAt"`
+		RevokedAt        int    `json:"revokedAt"`
+		RevokedReason    string `json:"revokedReason"`
+	}
+
+	result := make([]tokenJSON, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, tokenJSON{
+			TokenId:          t.CardTokenId,
+			FamilyId:         t.FamilyId,
+			TokenHint:        t.TokenHint,
+			Status:           t.Status(now),
+			IssuedAt:         t.IssuedAt,
+			AccessExpiresAt:  t.AccessExpiresAt,
+			RefreshExpiresAt: t.RefreshExpiresAt,
+			RotatedAt:        t.RotatedAt,
+			RevokedAt:        t.RevokedAt,
+			RevokedReason:    t.RevokedReason,
+		})
+	}
+
+	writeJSON(w, map[string]any{
+		"tokens": result,
+	})
+}
+
+// adminApiRevokeCardTokens revokes every wallet API token issued to a card.
+func (app *App) adminApiRevokeCardTokens(w http.ResponseWriter, _ *http.Request, cardId int) {
+	db.Db_revoke_card_tokens(app.db_write, cardId, db.TokenRevokedAdmin)
+	writeJSON(w, map[string]string{"status": "OK"})
+}
+
+// adminApiRevokeCardToken revokes a wallet API token and the tokens refreshed
+// from the same login.
+func (app *App) adminApiRevokeCardToken(w http.ResponseWriter, _ *http.Request, cardId int, tokenIdStr string) {
+	tokenId, err := strconv.Atoi(tokenIdStr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid token id"})
+		return
+	}
+
+	err = db.Db_revoke_card_token(app.db_write, cardId, tokenId, db.TokenRevokedAdmin)
+	if errors.Is(err, db.ErrTokenNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "token not found"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "failed to revoke token"})
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "OK"})
+}
+
 func (app *App) adminApiBatchCreate(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		GroupTag       string `json:"groupTag"`
@@ -0,0 +1,81 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// adminApiExportCardsCSV downloads every card that has not been wiped as CSV.
+func (app *App) adminApiExportCardsCSV(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if err := ExportCardsCSV(app.db_read, &buf); err != nil {
+		log.Warn("card csv export: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "could not export cards"})
+		return
+	}
+
+	filename := fmt.Sprintf("cards_%s.csv", time.Now().Format("20060102_150405"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Write(buf.Bytes())
+}
+
+// adminApiImportCardsCSV checks a CSV of card updates, sent as the request
+// body or as a csv_file form upload, and returns the per-row report and diff.
+// With ?commit=true the changes are made, but only if every row is valid.
+func (app *App) adminApiImportCardsCSV(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 5<<20)
+	var in io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.ParseMultipartForm(5 << 20)
+		file, _, err := r.FormFile("csv_file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "failed to get uploaded file"})
+			return
+		}
+		defer file.Close()
+		in = file
+	}
+
+	ci := PlanCardImport(app.db_read, in)
+	commit := r.URL.Query().Get("commit") == "true"
+
+	committed := false
+	if commit {
+		if !ci.Valid() {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else if err := ApplyCardImport(app.db_write, ci); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSON(w, map[string]string{"error": "could not apply import"})
+			return
+		} else {
+			committed = true
+			log.Info("admin imported card csv: ", len(ci.Updates), " cards updated")
+		}
+	}
+
+	rows := ci.Rows
+	if rows == nil {
+		rows = []CardImportRow{}
+	}
+	fileErrors := ci.Errors
+	if fileErrors == nil {
+		fileErrors = []string{}
+	}
+
+	writeJSON(w, map[string]any{
+		"valid":     ci.Valid(),
+		"committed": committed,
+		"updates":   len(ci.Updates),
+		"errors":    fileErrors,
+		"rows":      rows,
+	})
+}
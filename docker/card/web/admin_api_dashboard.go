@@ -4,6 +4,7 @@ import (
 	"card/db"
 	"card/phoenix"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -53,5 +54,6 @@ func (app *App) adminApiDashboard(w http.ResponseWriter, r *http.Request) {
 		"phoenixConnected": phoenixConnected,
 		"phoenixBalance":   phoenixBalance,
 		"phoenixFeeCredit": phoenixFeeCredit,
+		"receiptPoller":    app.receiptPoller.view(time.Now()),
 	})
 }
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type expiringCardJSON struct {
+	CardId      int    `json:"cardId"`
+	GroupTag    string `json:"groupTag"`
+	Note        string `json:"note"`
+	Status      string `json:"status"`
+	ExpiresAt   int    `json:"expiresAt"`
+	BalanceSats int    `json:"balanceSats"`
+}
+
+func expiryRunJSON(run ExpiryRun) map[string]any {
+	cards := make([]expiringCardJSON, 0, len(run.Forfeited))
+	for _, c := range run.Forfeited {
+		cards = append(cards, expiringCardJSON{
+			CardId:      c.CardId,
+			GroupTag:    c.GroupTag,
+			Note:        c.Note,
+			Status:      c.Status,
+			ExpiresAt:   c.ExpiresAt,
+			BalanceSats: c.BalanceSats,
+		})
+	}
+	expired := run.Expired
+	if expired == nil {
+		expired = []int{}
+	}
+
+	return map[string]any{
+		"dryRun":        run.DryRun,
+		"graceDays":     run.GraceDays,
+		"houseAccount":  run.HouseAccount,
+		"cutoff":        run.Cutoff,
+		"expired":       expired,
+		"forfeited":     cards,
+		"forfeitedSats": run.ForfeitedSats,
+	}
+}
+
+// adminApiExpiryReport is a dry run of card expiry: the cards that would be
+// expired and the balances that would be forfeited, now or at ?at=<unix time>.
+func (app *App) adminApiExpiryReport(w http.ResponseWriter, r *http.Request) {
+	at := time.Now().Unix()
+	if v := r.URL.Query().Get("at"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid at"})
+			return
+		}
+		at = n
+	}
+
+	writeJSON(w, expiryRunJSON(RunCardExpiry(app.db_read, app.db_write, at, true)))
+}
+
+// adminApiRunExpiry runs card expiry now rather than waiting for the hourly
+// check.
+func (app *App) adminApiRunExpiry(w http.ResponseWriter, _ *http.Request) {
+	run := RunCardExpiry(app.db_read, app.db_write, time.Now().Unix(), false)
+	log.Info("admin ran card expiry: expired ", len(run.Expired), " forfeited ", run.ForfeitedSats, " sats")
+	writeJSON(w, expiryRunJSON(run))
+}
+
+// adminApiListGroupExpiry lists the groups that have an expiry.
+func (app *App) adminApiListGroupExpiry(w http.ResponseWriter, _ *http.Request) {
+	type groupJSON struct {
+		GroupTag  string `json:"groupTag"`
+		ExpiresAt int    `json:"expiresAt"`
+	}
+	groups := db.Db_select_group_expiries(app.db_read)
+	views := make([]groupJSON, 0, len(groups))
+	for _, g := range groups {
+		views = append(views, groupJSON{GroupTag: g.GroupTag, ExpiresAt: g.ExpiresAt})
+	}
+	writeJSON(w, map[string]any{"groups": views})
+}
+
+// adminApiSetGroupExpiry sets the default expiry for a group's cards
+// ({groupTag, expiresAt}, 0 removes it).
+func (app *App) adminApiSetGroupExpiry(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		GroupTag  string `json:"groupTag"`
+		ExpiresAt int    `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+	req.GroupTag = strings.TrimSpace(req.GroupTag)
+	if req.GroupTag == "" || req.ExpiresAt < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "groupTag and a valid expiresAt are required"})
+		return
+	}
+
+	if err := db.Db_set_group_expiry(app.db_write, req.GroupTag, req.ExpiresAt); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "could not set group expiry"})
+		return
+	}
+
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+// adminApiSetCardExpiry sets a card's own expiry ({expiresAt}, 0 falls back to
+// the group's).
+func (app *App) adminApiSetCardExpiry(w http.ResponseWriter, r *http.Request, cardId int) {
+	var req struct {
+		ExpiresAt int `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpiresAt < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if err := db.Db_set_card_expiry(app.db_write, cardId, req.ExpiresAt); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "card not found"})
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"ok":        true,
+		"expiresAt": db.Db_get_card_expiry(app.db_read, cardId),
+	})
+}
+
+// adminApiListHouseAccounts lists the house accounts with their balances and
+// most recent entries.
+func (app *App) adminApiListHouseAccounts(w http.ResponseWriter, _ *http.Request) {
+	type entryJSON struct {
+		CardId        int    `json:"cardId"`
+		CardPaymentId int    `json:"cardPaymentId"`
+		AmountSats    int    `json:"amountSats"`
+		Kind          string `json:"kind"`
+		Reason        string `json:"reason"`
+		Timestamp     int    `json:"timestamp"`
+	}
+	type accountJSON struct {
+		Name        string      `json:"name"`
+		BalanceSats int         `json:"balanceSats"`
+		CreatedAt   int         `json:"createdAt"`
+		Recent      []entryJSON `json:"recent"`
+	}
+
+	accounts := db.Db_select_house_accounts(app.db_read)
+	views := make([]accountJSON, 0, len(accounts))
+	for _, a := range accounts {
+		entries := db.Db_select_house_account_entries(app.db_read, a.Name, 20)
+		recent := make([]entryJSON, 0, len(entries))
+		for _, e := range entries {
+			recent = append(recent, entryJSON{
+				CardId:        e.CardId,
+				CardPaymentId: e.CardPaymentId,
+				AmountSats:    e.AmountSats,
+				Kind:          e.Kind,
+				Reason:        e.Reason,
+				Timestamp:     e.Timestamp,
+			})
+		}
+		views = append(views, accountJSON{
+			Name:        a.Name,
+			BalanceSats: a.BalanceSats,
+			CreatedAt:   a.CreatedAt,
+			Recent:      recent,
+		})
+	}
+
+	writeJSON(w, map[string]any{"accounts": views})
+}
@@ -0,0 +1,286 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type cardGroupJSON struct {
+	GroupTag           string `json:"groupTag"`
+	Description        string `json:"description"`
+	TxLimitSats        int    `json:"txLimitSats"`
+	DayLimitSats       int    `json:"dayLimitSats"`
+	InitialBalanceSats int    `json:"initialBalanceSats"`
+	ExpiresAt          int    `json:"expiresAt"`
+	LnAddressEnabled   bool   `json:"lnAddressEnabled"`
+	CreatedAt          int    `json:"createdAt"`
+	SpendFeeBaseSats   int    `json:"spendFeeBaseSats"`
+	SpendFeePpm        int    `json:"spendFeePpm"`
+	TopupFeeBaseSats   int    `json:"topupFeeBaseSats"`
+	TopupFeePpm        int    `json:"topupFeePpm"`
+	FeeHouseAccount    string `json:"feeHouseAccount"`
+	ReceiveMinSats     int    `json:"receiveMinSats"`
+	ReceiveMaxSats     int    `json:"receiveMaxSats"`
+	MaxBalanceSats     int    `json:"maxBalanceSats"`
+	LnDescription      string `json:"lnDescription"`
+	LnImage            string `json:"lnImage"`
+	Totals             any    `json:"totals,omitempty"`
+}
+
+func cardGroupTotalsJSON(t db.CardGroupTotals) map[string]any {
+	return map[string]any{
+		"cards":         t.Cards,
+		"activeCards":   t.ActiveCards,
+		"liabilitySats": t.LiabilitySats,
+		"spentSats":     t.SpentSats,
+		"spendFeeSats":  t.SpendFeeSats,
+		"topupFeeSats":  t.TopupFeeSats,
+	}
+}
+
+// adminApiListGroups lists the card groups with their totals. A group_tag that
+// is on cards but has no card_groups row is listed with the default settings.
+func (app *App) adminApiListGroups(w http.ResponseWriter, _ *http.Request) {
+	groups := map[string]db.CardGroup{}
+	for _, g := range db.Db_select_card_groups(app.db_read) {
+		groups[g.GroupTag] = g
+	}
+
+	totals := db.Db_select_card_group_totals(app.db_read)
+	views := make([]cardGroupJSON, 0, len(totals))
+	for _, t := range totals {
+		g, ok := groups[t.GroupTag]
+		if !ok {
+			g = db.CardGroup{GroupTag: t.GroupTag, TxLimitSats: 1000000, LnAddressEnabled: "Y"}
+		}
+		view := cardGroupView(g)
+		view.Totals = cardGroupTotalsJSON(t)
+		views = append(views, view)
+	}
+
+	writeJSON(w, map[string]any{"groups": views})
+}
+
+func cardGroupView(g db.CardGroup) cardGroupJSON {
+	return cardGroupJSON{
+		GroupTag:           g.GroupTag,
+		Description:        g.Description,
+		TxLimitSats:        g.TxLimitSats,
+		DayLimitSats:       g.DayLimitSats,
+		InitialBalanceSats: g.InitialBalanceSats,
+		ExpiresAt:          g.ExpiresAt,
+		LnAddressEnabled:   g.LnAddressEnabled == "Y",
+		CreatedAt:          g.CreatedAt,
+		SpendFeeBaseSats:   g.SpendFeeBaseSats,
+		SpendFeePpm:        g.SpendFeePpm,
+		TopupFeeBaseSats:   g.TopupFeeBaseSats,
+		TopupFeePpm:        g.TopupFeePpm,
+		FeeHouseAccount:    g.FeeHouseAccount,
+		ReceiveMinSats:     g.Receive.MinSats,
+		ReceiveMaxSats:     g.Receive.MaxSats,
+		MaxBalanceSats:     g.Receive.MaxBalanceSats,
+		LnDescription:      g.Receive.Description,
+		LnImage:            g.Receive.Image,
+	}
+}
+
+// adminApiGroupRouter dispatches /admin/api/groups/{tag}[/action] requests.
+func (app *App) adminApiGroupRouter(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/admin/api/groups/")
+	parts := strings.SplitN(path, "/", 2)
+
+	groupTag := strings.TrimSpace(parts[0])
+	if groupTag == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid group tag"})
+		return
+	}
+
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == "GET":
+		app.adminApiGetGroup(w, r, groupTag)
+	case action == "" && r.Method == "PUT":
+		app.adminApiSetGroup(w, r, groupTag)
+	case action == "totals" && r.Method == "GET":
+		writeJSON(w, cardGroupTotalsJSON(db.Db_get_card_group_totals(app.db_read, groupTag)))
+	case action == "allocate" && r.Method == "POST":
+		app.adminApiAllocateGroup(w, r, groupTag)
+	case action == "clear" && r.Method == "POST":
+		app.adminApiClearGroup(w, r, groupTag)
+	case action == "enable" && r.Method == "POST":
+		app.adminApiEnableGroup(w, groupTag, "Y")
+	case action == "disable" && r.Method == "POST":
+		app.adminApiEnableGroup(w, groupTag, "N")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "not found"})
+	}
+}
+
+func (app *App) adminApiGetGroup(w http.ResponseWriter, _ *http.Request, groupTag string) {
+	g, err := db.Db_get_card_group(app.db_read, groupTag)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"error": "group not found"})
+		return
+	}
+
+	view := cardGroupView(g)
+	view.Totals = cardGroupTotalsJSON(db.Db_get_card_group_totals(app.db_read, groupTag))
+	writeJSON(w, view)
+}
+
+// adminApiSetGroup creates or replaces a group's settings. The limits and
+// initial balance apply to cards created in the group from now on; the
+// expiry and the lightning address receive settings apply to every card in
+// the group without its own, and the service fees to every later payment
+// and top-up of a card in the group.
+func (app *App) adminApiSetGroup(w http.ResponseWriter, r *http.Request, groupTag string) {
+	var req cardGroupJSON
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.TxLimitSats < 0 || req.DayLimitSats < 0 || req.InitialBalanceSats < 0 || req.ExpiresAt < 0 ||
+		req.SpendFeeBaseSats < 0 || req.SpendFeePpm < 0 || req.SpendFeePpm > 1_000_000 ||
+		req.TopupFeeBaseSats < 0 || req.TopupFeePpm < 0 || req.TopupFeePpm > 1_000_000 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid group settings"})
+		return
+	}
+
+	receive := db.ReceiveSettings{
+		MinSats:        req.ReceiveMinSats,
+		MaxSats:        req.ReceiveMaxSats,
+		MaxBalanceSats: req.MaxBalanceSats,
+		Description:    strings.TrimSpace(req.LnDescription),
+		Image:          strings.TrimSpace(req.LnImage),
+	}
+	if err := validReceiveSettings(receive); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": err.Error()})
+		return
+	}
+
+	lnAddressEnabled := "N"
+	if req.LnAddressEnabled {
+		lnAddressEnabled = "Y"
+	}
+
+	err := db.Db_set_card_group(app.db_write, db.CardGroup{
+		GroupTag:           groupTag,
+		Description:        strings.TrimSpace(req.Description),
+		TxLimitSats:        req.TxLimitSats,
+		DayLimitSats:       req.DayLimitSats,
+		InitialBalanceSats: req.InitialBalanceSats,
+		ExpiresAt:          req.ExpiresAt,
+		LnAddressEnabled:   lnAddressEnabled,
+		SpendFeeBaseSats:   req.SpendFeeBaseSats,
+		SpendFeePpm:        req.SpendFeePpm,
+		TopupFeeBaseSats:   req.TopupFeeBaseSats,
+		TopupFeePpm:        req.TopupFeePpm,
+		FeeHouseAccount:    strings.TrimSpace(req.FeeHouseAccount),
+		Receive:            receive,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "could not save group"})
+		return
+	}
+
+	log.Info("admin set card group: ", groupTag)
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+// adminApiAllocateGroup credits every card in the group that can receive
+// (see Card_status_allows_receive) with amountSats.
+func (app *App) adminApiAllocateGroup(w http.ResponseWriter, r *http.Request, groupTag string) {
+	var req struct {
+		AmountSats int `json:"amountSats"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	// card_receipts has a CHECK (amount_sats > 0) constraint
+	if req.AmountSats <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "amountSats must be greater than 0"})
+		return
+	}
+
+	count, err := db.Db_allocate_group(app.db_write, groupTag, req.AmountSats)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "failed to allocate funds"})
+		return
+	}
+
+	log.Info("admin allocated funds: group=", groupTag, " cards=", count, " amount=", req.AmountSats)
+	writeJSON(w, map[string]any{
+		"ok":         true,
+		"cards":      count,
+		"amountSats": count * req.AmountSats,
+	})
+}
+
+// adminApiClearGroup moves the balance of every card in the group to the
+// forfeit house account, as the ClearCardBalancesForTag CLI command does.
+func (app *App) adminApiClearGroup(w http.ResponseWriter, _ *http.Request, groupTag string) {
+	houseAccount := ForfeitHouseAccount(app.db_read)
+
+	cleared, total := 0, 0
+	for _, card := range db.Db_select_cards_with_group_tag(app.db_read, groupTag) {
+		amount, err := db.Db_forfeit_card_balance(app.db_write, card.CardId, houseAccount,
+			"cleared for group "+groupTag)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSON(w, map[string]any{
+				"error":        "could not clear balance for card",
+				"cardId":       card.CardId,
+				"cards":        cleared,
+				"clearedSats":  total,
+				"houseAccount": houseAccount,
+			})
+			return
+		}
+		if amount > 0 {
+			cleared++
+			total += amount
+		}
+	}
+
+	log.Info("admin cleared group balances: group=", groupTag, " cards=", cleared, " amount=", total)
+	writeJSON(w, map[string]any{
+		"ok":           true,
+		"cards":        cleared,
+		"clearedSats":  total,
+		"houseAccount": houseAccount,
+	})
+}
+
+// adminApiEnableGroup turns withdrawals on or off for every card in the group.
+func (app *App) adminApiEnableGroup(w http.ResponseWriter, groupTag string, enable string) {
+	count, err := db.Db_set_group_lnurlw_enable(app.db_write, groupTag, enable)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "could not update cards"})
+		return
+	}
+
+	log.Info("admin set group lnurlw_enable: group=", groupTag, " enable=", enable, " cards=", count)
+	writeJSON(w, map[string]any{"ok": true, "cards": count})
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// groupRequest calls the admin API for a /admin/api/groups path.
+func groupRequest(t *testing.T, app *App, token string, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	app.CreateHandler_AdminApi().ServeHTTP(w, r)
+	return w
+}
+
+// TestAdminApiGroups_BulkOps verifies group settings are saved, and that
+// allocate, disable and clear act on every card in the group and show in the
+// group totals.
+func TestAdminApiGroups_BulkOps(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+
+	w := groupRequest(t, app, token, "PUT", "/admin/api/groups/fest",
+		`{"description":"summer festival","txLimitSats":5000,"lnAddressEnabled":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	keys := db.CardKeys{Key0: "k0", Key1: "k1", Key2: "k2", Key3: "k3", Key4: "k4"}
+	a, _ := db.Db_insert_group_card(app.db_write, keys, "la", "pa", "uida", "fest")
+	b, _ := db.Db_insert_group_card(app.db_write, keys, "lb", "pb", "uidb", "fest")
+	if card, _ := db.Db_get_card(app.db_read, a); card.Tx_limit_sats != 5000 {
+		t.Fatalf("expected new card to inherit tx limit 5000, got %d", card.Tx_limit_sats)
+	}
+
+	w = groupRequest(t, app, token, "POST", "/admin/api/groups/fest/allocate", `{"amountSats":3000}`)
+	var allocResp struct {
+		Cards      int `json:"cards"`
+		AmountSats int `json:"amountSats"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &allocResp)
+	if w.Code != http.StatusOK || allocResp.Cards != 2 || allocResp.AmountSats != 6000 {
+		t.Fatalf("unexpected allocate response %d: %s", w.Code, w.Body.String())
+	}
+
+	w = groupRequest(t, app, token, "POST", "/admin/api/groups/fest/disable", "")
+	if w.Code != http.StatusOK || db.Db_get_card_lnurlw_enable(app.db_read, b) != "N" {
+		t.Fatalf("expected group disabled, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = groupRequest(t, app, token, "GET", "/admin/api/groups", "")
+	var listResp struct {
+		Groups []struct {
+			GroupTag    string `json:"groupTag"`
+			Description string `json:"description"`
+			Totals      struct {
+				Cards         int `json:"cards"`
+				LiabilitySats int `json:"liabilitySats"`
+			} `json:"totals"`
+		} `json:"groups"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listResp)
+	if len(listResp.Groups) != 1 || listResp.Groups[0].Description != "summer festival" ||
+		listResp.Groups[0].Totals.Cards != 2 || listResp.Groups[0].Totals.LiabilitySats != 6000 {
+		t.Fatalf("unexpected group list: %s", w.Body.String())
+	}
+
+	w = groupRequest(t, app, token, "POST", "/admin/api/groups/fest/clear", "")
+	var clearResp struct {
+		ClearedSats  int    `json:"clearedSats"`
+		HouseAccount string `json:"houseAccount"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &clearResp)
+	if clearResp.ClearedSats != 6000 || clearResp.HouseAccount != "house" {
+		t.Fatalf("unexpected clear response: %s", w.Body.String())
+	}
+	if bal := db.Db_get_card_balance(app.db_read, a); bal != 0 {
+		t.Fatalf("expected card cleared, got %d", bal)
+	}
+}
+
+// TestAdminApiGroups_ServiceFees verifies a group's service fees are saved,
+// charged on a card spend, listed as their own line in /gettxs and reported
+// in the group totals.
+func TestAdminApiGroups_ServiceFees(t *testing.T) {
+	app := setupEnabledApp(t)
+	token := setupAdminSession(t, app)
+
+	w := groupRequest(t, app, token, "PUT", "/admin/api/groups/fest",
+		`{"txLimitSats":5000,"lnAddressEnabled":true,"spendFeeBaseSats":3,"feeHouseAccount":"festival"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := groupRequest(t, app, token, "PUT", "/admin/api/groups/fest", `{"spendFeePpm":2000000}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a fee over 100%%, got %d", w.Code)
+	}
+
+	cardId := insertFundedCard(t, app.db_write, 1000)
+	app.db_write.Exec(`UPDATE cards SET group_tag = 'fest' WHERE card_id = $1`, cardId)
+	if _, _, err := db.Db_reserve_card_payment(app.db_write, cardId, 100, 100, "lnbc_pay"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/gettxs", nil)
+	r.Header.Set("Authorization", "Bearer lnaccess")
+	w = httptest.NewRecorder()
+	app.CreateHandler_GetTxs().ServeHTTP(w, r)
+	var txs []Transaction
+	json.Unmarshal(w.Body.Bytes(), &txs)
+	// the funding receipt is listed after the payment and its fee
+	if len(txs) != 3 || txs[0].Type != "service_fee" || txs[0].Value != 3 || txs[1].Value != 100 ||
+		txs[2].Type != "user_invoice" {
+		t.Fatalf("expected a payment, a service fee line and the funding receipt, got %s", w.Body.String())
+	}
+
+	w = groupRequest(t, app, token, "GET", "/admin/api/groups/fest/totals", "")
+	var totals struct {
+		SpendFeeSats int `json:"spendFeeSats"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &totals)
+	if totals.SpendFeeSats != 3 {
+		t.Fatalf("expected 3 sats of spend fees, got %s", w.Body.String())
+	}
+}
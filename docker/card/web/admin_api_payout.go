@@ -0,0 +1,98 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type payoutPolicyJSON struct {
+	GroupTag string `json:"groupTag"` // empty for the default policy
+	Enabled  bool   `json:"enabled"`
+	OpensAt  int    `json:"opensAt"`
+	ClosesAt int    `json:"closesAt"`
+	MinSats  int    `json:"minSats"`
+	MaxSats  int    `json:"maxSats"`
+	Open     bool   `json:"open"` // payouts are allowed right now
+}
+
+// adminApiListPayoutPolicies lists the cardholder payout policies per group.
+func (app *App) adminApiListPayoutPolicies(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now().Unix()
+	policies := db.Db_select_payout_policies(app.db_read)
+	views := make([]payoutPolicyJSON, 0, len(policies))
+	for _, p := range policies {
+		views = append(views, payoutPolicyJSON{
+			GroupTag: p.GroupTag,
+			Enabled:  p.Enabled == "Y",
+			OpensAt:  p.OpensAt,
+			ClosesAt: p.ClosesAt,
+			MinSats:  p.MinSats,
+			MaxSats:  p.MaxSats,
+			Open:     p.Open(now),
+		})
+	}
+
+	writeJSON(w, map[string]any{"policies": views})
+}
+
+// adminApiSetPayoutPolicy creates or replaces a group's payout policy. The
+// window is opensAt..closesAt (unix seconds, 0 = unbounded) and minSats /
+// maxSats bound each payout (0 = no bound).
+func (app *App) adminApiSetPayoutPolicy(w http.ResponseWriter, r *http.Request) {
+	var req payoutPolicyJSON
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.OpensAt < 0 || req.ClosesAt < 0 || req.MinSats < 0 || req.MaxSats < 0 ||
+		(req.ClosesAt != 0 && req.ClosesAt <= req.OpensAt) ||
+		(req.MaxSats != 0 && req.MaxSats < req.MinSats) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid payout policy"})
+		return
+	}
+
+	enabled := "N"
+	if req.Enabled {
+		enabled = "Y"
+	}
+	err := db.Db_set_payout_policy(app.db_write, db.PayoutPolicy{
+		GroupTag: strings.TrimSpace(req.GroupTag),
+		Enabled:  enabled,
+		OpensAt:  req.OpensAt,
+		ClosesAt: req.ClosesAt,
+		MinSats:  req.MinSats,
+		MaxSats:  req.MaxSats,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "could not save payout policy"})
+		return
+	}
+
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+// adminApiDeletePayoutPolicy removes a group's policy (?groupTag=) so the
+// default policy applies to the group again.
+func (app *App) adminApiDeletePayoutPolicy(w http.ResponseWriter, r *http.Request) {
+	groupTag := r.URL.Query().Get("groupTag")
+	if groupTag == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "the default policy cannot be deleted"})
+		return
+	}
+
+	if err := db.Db_delete_payout_policy(app.db_write, groupTag); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]string{"error": "could not delete payout policy"})
+		return
+	}
+
+	writeJSON(w, map[string]bool{"ok": true})
+}
@@ -567,6 +567,40 @@ func TestAdminApiUpdateCardLimits(t *testing.T) {
 	}
 }
 
+func TestAdminApiSetCardLnAlias(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_insert_card(app.db_write, "ok0", "ok1", "ok2", "ok3", "ok4", "other", "opass")
+	db.Db_set_tokens(app.db_write, "other", "opass", "otok", "oref")
+	otherId := db.Db_get_card_id_from_access_token(app.db_read, "otok")
+
+	handler := app.CreateHandler_AdminApi()
+	setAlias := func(cardId int, alias string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/admin/api/cards/"+strconv.Itoa(cardId)+"/ln-alias",
+			strings.NewReader(`{"alias":"`+alias+`"}`))
+		r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := setAlias(cardId, "shop"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := setAlias(otherId, "shop"); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a taken alias, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := setAlias(otherId, "no.dots"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid alias, got %d: %s", w.Code, w.Body.String())
+	}
+
+	card, _ := db.Db_get_card(app.db_read, cardId)
+	if card.Ln_alias != "shop" || resolveCardByAddress(app.db_read, "shop") != cardId {
+		t.Fatalf("expected the alias on card %d, got %q", cardId, card.Ln_alias)
+	}
+}
+
 func TestAdminApiUpdateCardLimits_InvalidEnable(t *testing.T) {
 	app := openTestApp(t)
 	token := setupAdminSession(t, app)
@@ -726,10 +760,13 @@ func TestAdminApiWipeCard(t *testing.T) {
 		t.Fatalf("expected wipe URL with /wipe?s=, got %q", resp.WipeUrl)
 	}
 
-	// Db_get_card filters wiped='N', so a wiped card returns error
-	_, err := db.Db_get_card(app.db_read, cardId)
-	if err == nil {
-		t.Fatal("expected error for wiped card (Db_get_card filters wiped='N')")
+	// the wiped card is still visible, with its status
+	card, err := db.Db_get_card(app.db_read, cardId)
+	if err != nil {
+		t.Fatalf("expected wiped card to be returned: %v", err)
+	}
+	if card.Status != db.CardStatusWiped {
+		t.Fatalf("expected status wiped, got %q", card.Status)
 	}
 }
 
@@ -1144,3 +1181,121 @@ func TestAdminApiWithdraw_ZeroAmount(t *testing.T) {
 		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
 	}
 }
+
+func TestAdminApiGetCardByUid(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	db.Db_insert_card_with_uid(app.db_write, "k0", "k1", "k2", "k3", "k4", "uidlogin", "uidpass",
+		"04AABBCCDDEEFF", "")
+	db.Db_set_tokens(app.db_write, "uidlogin", "uidpass", "uidaccess", "uidrefresh")
+	cardId := db.Db_get_card_id_from_access_token(app.db_read, "uidaccess")
+
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("GET", "/admin/api/cards/by-uid/04aabbccddeeff", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]any
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if int(resp["cardId"].(float64)) != cardId {
+		t.Fatalf("expected card %d, got %v", cardId, resp["cardId"])
+	}
+}
+
+func TestAdminApiGetCardByUid_NotFound(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("GET", "/admin/api/cards/by-uid/04000000000000", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestAdminApiCardSecurityEvents(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_insert_card_security_event(app.db_write, db.CardSecurityEvent{
+		CardId:    cardId,
+		EventType: db.SecurityEventUidMismatch,
+		Detail:    "expected a, tapped b",
+		Action:    db.SecurityActionReject,
+	})
+
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("GET", "/admin/api/cards/"+strconv.Itoa(cardId)+"/security-events", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Events []struct {
+			EventType string `json:"eventType"`
+			Detail    string `json:"detail"`
+		} `json:"events"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Events) != 1 || resp.Events[0].EventType != "uid_mismatch" {
+		t.Fatalf("unexpected events: %+v", resp.Events)
+	}
+}
+
+// TestAdminApiCardTokens_ListAndRevoke verifies a card's wallet tokens are
+// listed without their values and that revoking one ends the session, and
+// that /getcardkeys revokes the tokens of the rotated card.
+func TestAdminApiCardTokens_ListAndRevoke(t *testing.T) {
+	app := setupEnabledApp(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	path := "/admin/api/cards/" + strconv.Itoa(cardId) + "/tokens"
+
+	w := groupRequest(t, app, token, "GET", path, "")
+	var resp struct {
+		Tokens []struct {
+			TokenId   int    `json:"tokenId"`
+			TokenHint string `json:"tokenHint"`
+			Status    string `json:"status"`
+		} `json:"tokens"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Tokens) != 1 || resp.Tokens[0].Status != "active" || strings.Contains(w.Body.String(), "lnaccess") {
+		t.Fatalf("expected one active token without its value, got %s", w.Body.String())
+	}
+
+	w = groupRequest(t, app, token, "DELETE", path+"/"+strconv.Itoa(resp.Tokens[0].TokenId), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if db.Db_get_card_id_from_access_token(app.db_read, "lnaccess") != 0 {
+		t.Fatal("expected the revoked access token to be refused")
+	}
+	if w := groupRequest(t, app, token, "DELETE", path+"/99999", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown token, got %d", w.Code)
+	}
+
+	// rotating the card keys from the wallet ends the wallet's session
+	db.Db_set_tokens(app.db_write, "lnlogin", "lnpass", "keystok", "keysref")
+	r := httptest.NewRequest("POST", "/getcardkeys", nil)
+	r.Header.Set("Authorization", "Bearer keystok")
+	w = httptest.NewRecorder()
+	app.CreateHandler_WalletApi_GetCardKeys().ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "create_bolt_card_response") {
+		t.Fatalf("expected card keys, got %s", w.Body.String())
+	}
+	if db.Db_get_card_id_from_access_token(app.db_read, "keystok") != 0 {
+		t.Fatal("expected the token to be revoked after the keys were rotated")
+	}
+}
@@ -5,20 +5,28 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type Tx struct {
-	AmountSats int `json:"AmountSats"`
-	FeeSats    int `json:"FeeSats"`
-	Timestamp  int `json:"Timestamp"`
+	AmountSats int    `json:"AmountSats"`
+	FeeSats    int    `json:"FeeSats"`
+	AmountMsat int64  `json:"AmountMsat"`
+	FeeMsat    int64  `json:"FeeMsat"`
+	ServiceFee bool   `json:"ServiceFee"`
+	Timestamp  int    `json:"Timestamp"`
+	Comment    string `json:"Comment,omitempty"`
 }
 
 type AjaxBalanceResponse struct {
 	CardId           int    `json:"CardId"`
 	Note             string `json:"Note"`
 	AvailableBalance int    `json:"AvailableBalance"`
+	AvailableMsat    int64  `json:"AvailableMsat"`
+	PayoutOpen       bool   `json:"PayoutOpen"` // the holder may sweep the balance via /balance-payout
+	ExpiresAt        int    `json:"ExpiresAt"`  // unix time, 0 if the card does not expire
 	Txs              []Tx   `json:"txs"`
 	Error            string `json:"error,omitempty"`
 }
@@ -52,22 +60,28 @@ func (app *App) CreateHandler_BalanceAjaxPage() http.HandlerFunc {
 			return
 		}
 
-		cardMatch, cardId, cardCounter := Find_card(app.db_read, p, c)
+		cardTap, cardMatch := app.findCard(p, c)
 
 		if !cardMatch {
+			app.recordTap(r, tapEndpointBalanceAjax, cardTap, tapNotMatchedOutcome(cardTap))
 			writeJSON(w, AjaxBalanceResponse{Error: "card not found"})
 			return
 		}
 
+		cardId, cardCounter := cardTap.CardId, cardTap.Counter
+
 		// check counter is incremented
 		cardLastCounter := db.Db_get_card_counter(app.db_read, cardId)
 		if cardCounter <= cardLastCounter {
+			app.recordTap(r, tapEndpointBalanceAjax, cardTap, db.TapOutcomeCounterNotIncremented)
 			writeJSON(w, AjaxBalanceResponse{Error: "card already scanned, tap again"})
 			return
 		}
 
 		// store new counter value
 		db.Db_set_card_counter(app.db_write, cardId, cardCounter)
+		app.checkCounterJump(r, cardTap, cardLastCounter)
+		app.recordTap(r, tapEndpointBalanceAjax, cardTap, db.TapOutcomeOk)
 
 		log.Info("card_id = " + strconv.Itoa(cardId))
 
@@ -83,6 +97,16 @@ func (app *App) CreateHandler_BalanceAjaxPage() http.HandlerFunc {
 		// check the card balance
 		total_card_balance := db.Db_get_card_balance(app.db_read, cardId)
 		resObj.AvailableBalance = total_card_balance
+		resObj.AvailableMsat = db.Db_get_card_balance_msat(app.db_read, cardId)
+
+		now := time.Now().Unix()
+		resObj.ExpiresAt = db.Db_get_card_expiry(app.db_read, cardId)
+		policy := db.Db_get_payout_policy_for_card(app.db_read, cardId)
+		payoutOpen := policy.Open(now)
+		if card.Status == db.CardStatusExpired {
+			payoutOpen = payoutGraceOpen(app.db_read, cardId, now)
+		}
+		resObj.PayoutOpen = payoutOpen && total_card_balance > 0
 
 		// get card transactions
 		cardTxs := db.Db_select_card_txs(app.db_read, cardId)
@@ -90,7 +114,11 @@ func (app *App) CreateHandler_BalanceAjaxPage() http.HandlerFunc {
 			var cardTxAppend Tx
 			cardTxAppend.AmountSats = cardTx.AmountSats
 			cardTxAppend.FeeSats = cardTx.FeeSats
+			cardTxAppend.AmountMsat = cardTx.AmountMsat
+			cardTxAppend.FeeMsat = cardTx.FeeMsat
+			cardTxAppend.ServiceFee = cardTx.ServiceFee
 			cardTxAppend.Timestamp = cardTx.Timestamp
+			cardTxAppend.Comment = cardTx.Comment
 			resObj.Txs = append(resObj.Txs, cardTxAppend)
 		}
 
@@ -2,6 +2,7 @@ package web
 
 import (
 	"card/db"
+	"card/nostr"
 	"database/sql"
 
 	"github.com/gorilla/mux"
@@ -12,6 +13,9 @@ type App struct {
 	db_write *sql.DB // single-connection writer (serialised via SetMaxOpenConns(1))
 	hub      *wsHub
 	stop     chan struct{} // closed to signal background goroutines (e.g. Phoenix listener) to exit
+
+	zapPublisher  nostr.Publisher // publishes zap receipts; nil for relays over websocket
+	receiptPoller receiptPollerStats
 }
 
 func NewApp(db_read, db_write *sql.DB) *App {
@@ -19,6 +23,8 @@ func NewApp(db_read, db_write *sql.DB) *App {
 	app.startPhoenixListener()
 	app.startChannelPoller()
 	app.startReceiptPoller()
+	app.startTapPruner()
+	app.startCardExpiry()
 	return app
 }
 
@@ -36,6 +42,7 @@ func (app *App) SetupRoutes() *mux.Router {
 
 	// AJAX
 	router.Path("/balance-ajax").Methods("GET").HandlerFunc(app.CreateHandler_BalanceAjaxPage())
+	router.Path("/balance-payout").Methods("POST").HandlerFunc(app.CreateHandler_BalancePayout())
 
 	// websocket
 	router.Path("/admin/api/websocket").HandlerFunc(app.CreateHandler_Websocket())
@@ -52,11 +59,14 @@ func (app *App) SetupRoutes() *mux.Router {
 	// Lightning Address (LNURL-pay)
 	router.Path("/.well-known/lnurlp/{username}").Methods("GET").HandlerFunc(app.CreateHandler_LnurlpRequest())
 	router.Path("/.well-known/lnurlp/{username}/callback").Methods("GET").HandlerFunc(app.CreateHandler_LnurlpCallback())
+	router.Path("/.well-known/lnurlp/{username}/verify/{hash}").Methods("GET").HandlerFunc(app.CreateHandler_LnurlpVerify())
 
 	// for Bolt Card Programmer app
 	router.Path("/new").Methods("GET", "POST").HandlerFunc(app.CreateHandler_CreateCard())
 	router.Path("/batch").Methods("POST").HandlerFunc(app.CreateHandler_BatchCreateCard())
-	router.Path("/wipe").Methods("POST").HandlerFunc(app.CreateHandler_WipeCard()) // reset physical card (admin wipe deeplink)
+	router.Path("/wipe").Methods("POST").HandlerFunc(app.CreateHandler_WipeCard())       // reset physical card (admin wipe deeplink)
+	router.Path("/rekey").Methods("POST").HandlerFunc(app.CreateHandler_RekeyCard())     // re-program existing card with rotated keys
+	router.Path("/replace").Methods("POST").HandlerFunc(app.CreateHandler_ReplaceCard()) // program a replacement for a lost card
 
 	// Bolt Card interface (hit from PoS when a card is tapped)
 	router.Path("/ln").Methods("GET").HandlerFunc(app.CreateHandler_LnurlwRequest())
@@ -68,14 +78,21 @@ func (app *App) SetupRoutes() *mux.Router {
 		router.Path("/create").Methods("POST").HandlerFunc(app.CreateHandler_Create())
 		router.Path("/auth").Methods("POST").HandlerFunc(app.CreateHandler_Auth())
 		router.Path("/balance").Methods("GET").HandlerFunc(app.CreateHandler_Balance())
-		router.Path("/gettxs").Methods("GET").HandlerFunc(app.CreateHandler_GetTxs())         // /gettxs?limit=10&offset=0 (onchain & lightning)
-		router.Path("/getpending").Methods("GET").HandlerFunc(app.CreateHandler_GetPending()) // for onchain txs only
+		router.Path("/gettxs").Methods("GET").HandlerFunc(app.CreateHandler_GetTxs())         // /gettxs?limit=10&offset=0 (payments & receipts)
+		router.Path("/getpending").Methods("GET").HandlerFunc(app.CreateHandler_GetPending()) // payments in flight
+		router.Path("/checkpayment/{payment_hash}").Methods("GET").HandlerFunc(app.CreateHandler_WalletApi_CheckPayment())
+		router.Path("/decodeinvoice").Methods("GET").HandlerFunc(app.CreateHandler_WalletApi_DecodeInvoice()) // /decodeinvoice?invoice=lnbc...
+		router.Path("/getinfo").Methods("GET").HandlerFunc(app.CreateHandler_WalletApi_GetInfo())
+		router.Path("/getbtc").Methods("GET").HandlerFunc(app.CreateHandler_WalletApi_GetBtc()) // no on-chain deposits
 		router.Path("/getuserinvoices").Methods("GET").HandlerFunc(app.CreateHandler_WalletApi_GetUserInvoices())
 		router.Path("/getcardkeys").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_GetCardKeys()) // creating a new card
 		router.Path("/addinvoice").Methods("POST").HandlerFunc(app.CreateHandler_AddInvoice())
 		router.Path("/payinvoice").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_PayInvoice())
-		router.Path("/getcard").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_GetCard())   // get card details
-		router.Path("/wipecard").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_WipeCard()) // return keys and deactivate card
+		router.Path("/paylnaddress").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_PayLnAddress()) // pay a lightning address
+		router.Path("/payoffer").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_PayOffer())         // pay a BOLT12 offer
+		router.Path("/getcard").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_GetCard())           // get card details
+		router.Path("/setlnalias").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_SetLnAlias())     // claim a vanity lightning address
+		router.Path("/wipecard").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_WipeCard())         // return keys and deactivate card
 		router.Path("/updatecardwithpin").Methods("POST").HandlerFunc(app.CreateHandler_WalletApi_UpdateCardWithPin())
 	}
 
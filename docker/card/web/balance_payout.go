@@ -0,0 +1,250 @@
+package web
+
+import (
+	"card/db"
+	"card/phoenix"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+)
+
+// payout destination kinds
+const (
+	payoutToLnAddress = "ln_address"
+	payoutToInvoice   = "bolt11"
+	payoutToOffer     = "bolt12"
+)
+
+type BalancePayoutRequest struct {
+	Card        string `json:"card"`        // the NDEF URL read from a fresh tap
+	Destination string `json:"destination"` // lightning address, BOLT11 invoice or BOLT12 offer
+}
+
+type BalancePayoutResponse struct {
+	Status      string `json:"status"`
+	AmountSats  int    `json:"amountSats"`
+	FeeSats     int    `json:"feeSats"`
+	PaymentHash string `json:"paymentHash"`
+}
+
+// payoutDestinationKind classifies a payout destination, after any
+// "lightning:" prefix has been removed.
+func payoutDestinationKind(destination string) string {
+	lower := strings.ToLower(destination)
+	switch {
+	case strings.Count(destination, "@") == 1 && !strings.HasPrefix(destination, "@") &&
+		!strings.HasSuffix(destination, "@"):
+		return payoutToLnAddress
+	case strings.HasPrefix(lower, "lno1"):
+		return payoutToOffer
+	case strings.HasPrefix(lower, "lnbc") || strings.HasPrefix(lower, "lntb") ||
+		strings.HasPrefix(lower, "lnbcrt") || strings.HasPrefix(lower, "lntbs"):
+		return payoutToInvoice
+	}
+	return ""
+}
+
+// CreateHandler_BalancePayout lets a cardholder sweep their balance to a
+// lightning address, BOLT11 invoice or BOLT12 offer from the balance page. The
+// request must carry a fresh tap of the card (its counter is consumed as for
+// /ln), and the card's group payout policy must be open, or the card must be
+// in its post-expiry grace period. Lightning addresses
+// and offers are paid the whole balance less the network fee reserve; an
+// invoice with an amount is paid as issued.
+func (app *App) CreateHandler_BalancePayout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("balancePayout request received")
+
+		var req BalancePayoutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lnurlError(w, "invalid request")
+			return
+		}
+
+		destination := trimLightningPrefix(req.Destination)
+		kind := payoutDestinationKind(destination)
+		if kind == "" {
+			lnurlError(w, "enter a lightning address, invoice or offer")
+			return
+		}
+
+		u, err := url.Parse(req.Card)
+		if err != nil {
+			lnurlError(w, "invalid card data")
+			return
+		}
+		p, c, err := Get_p_c(u)
+		if err != nil {
+			lnurlError(w, "card not recognised")
+			return
+		}
+
+		cardTap, cardMatch := app.findCard(p, c)
+		if !cardMatch {
+			app.recordTap(r, tapEndpointBalancePayout, cardTap, tapNotMatchedOutcome(cardTap))
+			lnurlError(w, "card not found")
+			return
+		}
+		cardId := cardTap.CardId
+
+		// a payout needs a tap the card has not presented before
+		cardLastCounter := db.Db_get_card_counter(app.db_read, cardId)
+		if cardTap.Counter <= cardLastCounter {
+			app.recordTap(r, tapEndpointBalancePayout, cardTap, db.TapOutcomeCounterNotIncremented)
+			lnurlError(w, "card already scanned, tap again")
+			return
+		}
+		db.Db_set_card_counter(app.db_write, cardId, cardTap.Counter)
+		app.checkCounterJump(r, cardTap, cardLastCounter)
+
+		// an expired card may still pay out during its grace period,
+		// whatever its group's payout window
+		now := time.Now().Unix()
+		cardStatus := db.Db_get_card_status(app.db_read, cardId)
+		inGrace := cardStatus == db.CardStatusExpired && payoutGraceOpen(app.db_read, cardId, now)
+		if !db.Card_status_allows_withdraw(cardStatus) && !inGrace {
+			app.recordTap(r, tapEndpointBalancePayout, cardTap, db.TapOutcomeCardNotActive)
+			lnurlError(w, "card "+cardStatus)
+			return
+		}
+		app.recordTap(r, tapEndpointBalancePayout, cardTap, db.TapOutcomeOk)
+
+		policy := db.Db_get_payout_policy_for_card(app.db_read, cardId)
+		if !inGrace && !policy.Open(now) {
+			lnurlError(w, "payouts are not open for this card")
+			return
+		}
+
+		// work out the amount to pay
+		balance := db.Db_get_card_balance(app.db_read, cardId)
+		feePolicy := loadFeePolicy(app.db_read)
+		amountSats := feePolicy.MaxAmountSats(balance)
+		var amountMsat int64 // set for an invoice with an amount
+		fixedAmount := false
+		paymentHash := ""
+		if kind == payoutToInvoice {
+			bolt11, err := decodepay.Decodepay(destination)
+			if err != nil {
+				lnurlError(w, "invalid invoice")
+				return
+			}
+			if bolt11.MSatoshi < 0 {
+				lnurlError(w, "invalid invoice amount")
+				return
+			}
+			if bolt11.MSatoshi > 0 {
+				// the card is debited the exact invoice amount
+				amountMsat = bolt11.MSatoshi
+				amountSats = db.MsatToSatsUp(amountMsat)
+				fixedAmount = true
+			}
+			paymentHash = bolt11.PaymentHash
+		}
+		if policy.MaxSats > 0 && amountSats > policy.MaxSats {
+			if fixedAmount {
+				lnurlError(w, "amount exceeds payout limit")
+				return
+			}
+			amountSats = policy.MaxSats
+		}
+		if amountSats <= 0 || amountSats < policy.MinSats {
+			lnurlError(w, "balance too low to pay out")
+			return
+		}
+
+		if !fixedAmount {
+			amountMsat = db.SatsToMsat(amountSats)
+		}
+		feeReserveSats := feePolicy.ReserveSats(amountSats)
+		_, cardPaymentId, err := db.Db_reserve_card_payout(
+			app.db_write, cardId, amountMsat, db.SatsToMsat(feeReserveSats), destination)
+		if errors.Is(err, db.ErrCardNotActive) {
+			lnurlError(w, "card not active")
+			return
+		}
+		if errors.Is(err, db.ErrTxLimitExceeded) {
+			lnurlError(w, "amount exceeds card limit")
+			return
+		}
+		if errors.Is(err, db.ErrDayLimitExceeded) {
+			lnurlError(w, "daily limit exceeded")
+			return
+		}
+		if errors.Is(err, db.ErrInsufficientFunds) {
+			lnurlError(w, insufficientFeeReserveMessage(feePolicy, amountSats))
+			return
+		}
+		if err != nil {
+			log.Error("reserve payout error: ", err)
+			lnurlError(w, "payment reservation failed")
+			return
+		}
+
+		log.Info("paying out card_id = ", cardId, " amount = ", amountSats, " to ", kind)
+
+		var payResult, payReason, preimage string
+		var feeSats int
+		switch kind {
+		case payoutToLnAddress:
+			res, result, err := phoenix.PayLightningAddress(phoenix.PayLightningAddressRequest{
+				AmountSat: strconv.Itoa(amountSats),
+				Address:   destination,
+				Message:   "card payout",
+				MaxFeeSat: strconv.Itoa(feeReserveSats),
+			})
+			if err != nil {
+				log.Error(err)
+			}
+			payResult, payReason, feeSats, paymentHash, preimage = result, res.Reason, res.RoutingFeeSat, res.PaymentHash, res.PaymentPreimage
+		case payoutToOffer:
+			res, result, err := phoenix.PayOffer(phoenix.PayOfferRequest{
+				AmountSat: strconv.Itoa(amountSats),
+				Offer:     destination,
+				Message:   "card payout",
+				MaxFeeSat: strconv.Itoa(feeReserveSats),
+			})
+			if err != nil {
+				log.Error(err)
+			}
+			payResult, payReason, feeSats, paymentHash, preimage = result, res.Reason, res.RoutingFeeSat, res.PaymentHash, res.PaymentPreimage
+		case payoutToInvoice:
+			res, result, err := phoenix.SendLightningPayment(phoenix.SendLightningPaymentRequest{
+				AmountSat: strconv.Itoa(amountSats),
+				Invoice:   destination,
+				MaxFeeSat: strconv.Itoa(feeReserveSats),
+			})
+			if err != nil {
+				log.Error(err)
+			}
+			payResult, payReason, feeSats, preimage = result, res.Reason, res.RoutingFeeSat, res.PaymentPreimage
+		}
+
+		if handlePaymentResult(w, app.db_write, payResult, cardPaymentId) {
+			return
+		}
+		if handlePaymentReason(w, app.db_write, payReason, cardPaymentId) {
+			return
+		}
+
+		if err := db.Db_settle_card_payment(app.db_write, cardPaymentId, db.SatsToMsat(feeSats), paymentHash, preimage); err != nil {
+			log.Error("settle payout error, card_payment_id = ", cardPaymentId, ": ", err)
+		}
+
+		app.broadcastPaymentSent(amountSats, paymentHash, time.Now().Unix())
+
+		writeJSON(w, BalancePayoutResponse{
+			Status:      "OK",
+			AmountSats:  amountSats,
+			FeeSats:     feeSats,
+			PaymentHash: paymentHash,
+		})
+	}
+}
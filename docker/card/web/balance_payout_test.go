@@ -0,0 +1,169 @@
+package web
+
+import (
+	"card/db"
+	"card/phoenix"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// payoutTapUrl builds the NDEF URL the balance page reads from a card tap.
+func payoutTapUrl(t *testing.T, counter uint32) string {
+	t.Helper()
+	p, c := buildNfcTap(t, nfcTestKey1, nfcTestKey2, nfcTestUID, counter)
+	return "lnurlw://test.example.com/ln?p=" + hex.EncodeToString(p) + "&c=" + hex.EncodeToString(c)
+}
+
+// postPayout calls /balance-payout and returns the decoded response and, for a
+// refused payout, the LNURL error reason.
+func postPayout(t *testing.T, app *App, card string, destination string) (BalancePayoutResponse, string) {
+	t.Helper()
+	body, _ := json.Marshal(BalancePayoutRequest{Card: card, Destination: destination})
+	r := httptest.NewRequest("POST", "/balance-payout", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	app.CreateHandler_BalancePayout().ServeHTTP(w, r)
+	var resp struct {
+		BalancePayoutResponse
+		Reason string `json:"reason"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp.BalancePayoutResponse, resp.Reason
+}
+
+func openPayouts(t *testing.T, app *App, p db.PayoutPolicy) {
+	t.Helper()
+	p.Enabled = "Y"
+	if err := db.Db_set_payout_policy(app.db_write, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeePolicyMaxAmountSats_LeavesFeeReserve(t *testing.T) {
+	policies := []FeePolicy{
+		{BaseSats: defaultFeeReserveBaseSats, Ppm: defaultFeeReservePpm},
+		{BaseSats: 1, Ppm: 10_000, MinSats: 10, MaxSats: 50},
+	}
+	for _, p := range policies {
+		for _, balance := range []int{0, 3, 5, 100, 1004, 10000, 123457} {
+			amount := p.MaxAmountSats(balance)
+			if amount > 0 && amount+p.ReserveSats(amount) > balance {
+				t.Fatalf("%+v balance %d: amount %d leaves no fee reserve", p, balance, amount)
+			}
+			if amount+1+p.ReserveSats(amount+1) <= balance {
+				t.Fatalf("%+v balance %d: amount %d is not the largest payable", p, balance, amount)
+			}
+		}
+	}
+}
+
+// TestBalancePayout_LightningAddress sweeps a card to a lightning address
+// through the mock Phoenix server and checks the card is left with only the
+// unused fee reserve.
+func TestBalancePayout_LightningAddress(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 10000)
+	openPayouts(t, app, db.PayoutPolicy{})
+
+	var gotAmount, gotAddress string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/paylnaddress" {
+			t.Errorf("unexpected phoenix path: %s", r.URL.Path)
+		}
+		r.ParseForm()
+		gotAmount, gotAddress = r.FormValue("amountSat"), r.FormValue("address")
+		w.Write([]byte(`{"routingFeeSat":7,"paymentHash":"payouthash"}`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	resp, reason := postPayout(t, app, payoutTapUrl(t, 1), "lightning:holder@example.com")
+	if resp.Status != "OK" {
+		t.Fatalf("expected payout OK, got %q", reason)
+	}
+	want := loadFeePolicy(app.db_read).MaxAmountSats(10000)
+	if gotAmount != strconv.Itoa(want) || gotAddress != "holder@example.com" {
+		t.Fatalf("unexpected phoenix request amount=%q address=%q", gotAmount, gotAddress)
+	}
+	if resp.AmountSats != want || resp.FeeSats != 7 || resp.PaymentHash != "payouthash" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 10000-want-7 {
+		t.Fatalf("expected balance %d after payout, got %d", 10000-want-7, b)
+	}
+
+	// the same tap cannot authorise a second payout
+	if resp, _ := postPayout(t, app, payoutTapUrl(t, 1), "holder@example.com"); resp.Status == "OK" {
+		t.Fatal("expected a replayed tap to be refused")
+	}
+}
+
+// subSatBolt11 is an invoice for 1500 msat.
+const subSatBolt11 = "lnbc15n1pj48ugqpp5z5tpwxqergd3c8g7ruszzg3rysjjvfeg9y4zktpd9chnqvfjxv6qdqhwd6kyttnv96zqurp09hh2aqxqrrsssp5gpq5ys6yg4rywjzfff95cn2wfag9z5jn2324v46ct9d9khzate0sa27unmhhr5l7j26mscqrcd3twtxyk9j9rr8cj7aw8kh9n272cyj56z5xldnxs2htuk4a797q7vhxyywsy4y08vpfs5r3wgq4qukgzrsp4ms4rw"
+
+// TestBalancePayout_InvoiceDebitsExactMsat verifies a payout to an invoice
+// with a sub-sat amount debits the card the exact invoice amount.
+func TestBalancePayout_InvoiceDebitsExactMsat(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 10000)
+	openPayouts(t, app, db.PayoutPolicy{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/payinvoice" {
+			t.Errorf("unexpected phoenix path: %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"routingFeeSat":1,"paymentHash":"subsathash"}`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	resp, reason := postPayout(t, app, payoutTapUrl(t, 1), subSatBolt11)
+	if resp.Status != "OK" {
+		t.Fatalf("expected payout OK, got %q", reason)
+	}
+	if b := db.Db_get_card_balance_msat(app.db_read, cardId); b != 10000_000-1500-1000 {
+		t.Fatalf("expected balance %d msat after payout, got %d", 10000_000-1500-1000, b)
+	}
+}
+
+// TestBalancePayout_PolicyWindow verifies payouts are refused while the card's
+// payout policy is closed, and that a group policy overrides the default.
+func TestBalancePayout_PolicyWindow(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 10000)
+
+	// payouts are off by default
+	if _, reason := postPayout(t, app, payoutTapUrl(t, 1), "holder@example.com"); reason != "payouts are not open for this card" {
+		t.Fatalf("expected payouts closed by default, got %q", reason)
+	}
+
+	// the default is open, but the card's group closed an hour ago
+	openPayouts(t, app, db.PayoutPolicy{})
+	app.db_write.Exec(`UPDATE cards SET group_tag='vip' WHERE card_id=$1`, cardId)
+	now := int(time.Now().Unix())
+	openPayouts(t, app, db.PayoutPolicy{GroupTag: "vip", OpensAt: now - 7200, ClosesAt: now - 3600})
+
+	if _, reason := postPayout(t, app, payoutTapUrl(t, 2), "holder@example.com"); reason != "payouts are not open for this card" {
+		t.Fatalf("expected group cutoff to apply, got %q", reason)
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 10000 {
+		t.Fatalf("expected balance untouched, got %d", b)
+	}
+}
+
+// TestBalancePayout_RejectsUnknownDestination verifies input that is not a
+// lightning address, invoice or offer is refused before the tap is used.
+func TestBalancePayout_RejectsUnknownDestination(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	insertFundedCard(t, app.db_write, 10000)
+	openPayouts(t, app, db.PayoutPolicy{})
+
+	if _, reason := postPayout(t, app, payoutTapUrl(t, 1), "bc1qnotlightning"); reason != "enter a lightning address, invoice or offer" {
+		t.Fatalf("unexpected status %q", reason)
+	}
+}
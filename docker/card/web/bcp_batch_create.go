@@ -61,7 +61,15 @@ func (app *App) CreateHandler_BatchCreateCard() http.HandlerFunc {
 		k0, k1, k2, k3, k4 := generateCardKeys()
 		login := util.Random_hex()
 		password := util.Random_hex()
-		db.Db_insert_card_with_uid(app.db_write, k0, k1, k2, k3, k4, login, password, t.Uid, programCard.GroupTag)
+		// the card takes its limits and any initial balance from its group;
+		// a batch's own initial balance is loaded with SetupCardAmountForTag
+		keys := db.CardKeys{Key0: k0, Key1: k1, Key2: k2, Key3: k3, Key4: k4}
+		_, err = db.Db_insert_group_card(app.db_write, keys, login, password, t.Uid,
+			programCard.GroupTag)
+		if err != nil {
+			http.Error(w, "could not create card", http.StatusInternalServerError)
+			return
+		}
 
 		var bcpBatchResponse BcpBatchResponse
 
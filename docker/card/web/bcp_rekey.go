@@ -0,0 +1,48 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateHandler_RekeyCard serves a card's pending key set for a valid
+// re-programming secret. It is reached via the boltcard://program deeplink
+// produced by the admin "Rotate Keys" action; the Bolt Card app POSTs the
+// card's UID (as for /batch) and writes the returned keys to the chip. The card
+// keeps its card_id, history and lightning address, and its previous keys stay
+// valid until the first tap made with the new ones.
+func (app *App) CreateHandler_RekeyCard() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("RekeyCard request received")
+
+		secret := r.URL.Query().Get("s")
+		cardId, keys := db.Db_get_card_pending_keys_for_rekey_secret(app.db_read, secret)
+		if cardId == 0 {
+			log.Info("rekey secret not found or expired")
+			http.Error(w, "program link expired or not found", http.StatusBadRequest)
+			return
+		}
+
+		// the UID is informational only; the body may be empty
+		t := struct {
+			Uid string `json:"UID"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&t)
+
+		log.Info("rekey card_id = ", cardId, " Uid : ", t.Uid)
+
+		writeJSON(w, BcpBatchResponse{
+			Lnurlw:     "lnurlw://" + db.Db_get_setting(app.db_read, "host_domain") + "/ln",
+			K0:         keys.Key0,
+			K1:         keys.Key1,
+			K2:         keys.Key2,
+			K3:         keys.Key3,
+			K4:         keys.Key4,
+			UIDPrivacy: "Y",
+		})
+	}
+}
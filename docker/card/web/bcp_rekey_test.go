@@ -0,0 +1,239 @@
+package web
+
+import (
+	"card/db"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rotateCardKeys issues a key rotation via the admin API and returns the
+// rekey secret embedded in the returned /rekey URL.
+func rotateCardKeys(t *testing.T, app *App, token string, cardId int) string {
+	t.Helper()
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("POST", "/admin/api/cards/"+strconv.Itoa(cardId)+"/rotate-keys", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate-keys failed: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		KeyVersion   int    `json:"keyVersion"`
+		BoltcardLink string `json:"boltcardLink"`
+		ProgramUrl   string `json:"programUrl"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.KeyVersion != 2 {
+		t.Fatalf("expected key version 2, got %d", resp.KeyVersion)
+	}
+	if !strings.HasPrefix(resp.BoltcardLink, "boltcard://program?url=") {
+		t.Fatalf("expected boltcard program deeplink, got %q", resp.BoltcardLink)
+	}
+	idx := strings.Index(resp.ProgramUrl, "/rekey?s=")
+	if idx < 0 {
+		t.Fatalf("no secret in program url %q", resp.ProgramUrl)
+	}
+	return resp.ProgramUrl[idx+len("/rekey?s="):]
+}
+
+// fetchRekeyKeys POSTs to the /rekey capability URL as the Bolt Card app does
+// and returns the decoded K1/K2 keys.
+func fetchRekeyKeys(t *testing.T, app *App, secret string) (key1, key2 []byte) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/rekey?s="+secret, strings.NewReader(`{"UID":"04010203040506"}`))
+	w := httptest.NewRecorder()
+	app.CreateHandler_RekeyCard().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /rekey, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BcpBatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Lnurlw != "lnurlw://test.example.com/ln" {
+		t.Fatalf("unexpected LNURLW %q", resp.Lnurlw)
+	}
+	key1, err := hex.DecodeString(resp.K1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err = hex.DecodeString(resp.K2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key1, key2
+}
+
+// tapLn performs a /ln tap with the given keys and counter and returns the
+// parsed LNURL status (empty Status on a successful withdrawRequest).
+func tapLn(t *testing.T, app *App, key1, key2 []byte, counter uint32) lnurlStatus {
+	t.Helper()
+	p, c := buildNfcTap(t, key1, key2, nfcTestUID, counter)
+	r := httptest.NewRequest("GET", "/ln?p="+hex.EncodeToString(p)+"&c="+hex.EncodeToString(c), nil)
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlwRequest().ServeHTTP(w, r)
+	var resp lnurlStatus
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp
+}
+
+// TestRekeyCard_BothKeySetsAcceptedUntilNewKeysSeen walks the full rotation:
+// old keys keep working after the deeplink is issued, the first tap with the
+// new keys completes the rotation, and the old keys are then rejected. The
+// card keeps its id throughout.
+func TestRekeyCard_BothKeySetsAcceptedUntilNewKeysSeen(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 10000)
+
+	secret := rotateCardKeys(t, app, token, cardId)
+	newKey1, newKey2 := fetchRekeyKeys(t, app, secret)
+
+	// old keys still accepted while the rotation is pending
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1); resp.Status == "ERROR" {
+		t.Fatalf("expected old keys to work during rotation, got %q", resp.Reason)
+	}
+
+	// first tap with the new keys
+	if resp := tapLn(t, app, newKey1, newKey2, 2); resp.Status == "ERROR" {
+		t.Fatalf("expected new keys to work, got %q", resp.Reason)
+	}
+
+	card, err := db.Db_get_card(app.db_read, cardId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Key_version != 2 || card.Pending_key_version != 0 {
+		t.Fatalf("expected rotation complete at key version 2, got %d/%d",
+			card.Key_version, card.Pending_key_version)
+	}
+	if card.Key1_enc != hex.EncodeToString(newKey1) {
+		t.Fatal("expected new key1 to be current")
+	}
+
+	// old keys now retired
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 3); resp.Reason != "card not found" {
+		t.Fatalf("expected old keys to be rejected, got status=%q reason=%q", resp.Status, resp.Reason)
+	}
+
+	// the program link is one rotation only
+	r := httptest.NewRequest("POST", "/rekey?s="+secret, nil)
+	w := httptest.NewRecorder()
+	app.CreateHandler_RekeyCard().ServeHTTP(w, r)
+	if w.Code == http.StatusOK {
+		t.Fatal("expected /rekey to reject a secret after the rotation completed")
+	}
+}
+
+// TestRekeyCard_UnknownSecret verifies an unknown secret is rejected rather
+// than leaking keys.
+func TestRekeyCard_UnknownSecret(t *testing.T) {
+	app := openTestApp(t)
+	r := httptest.NewRequest("POST", "/rekey?s=deadbeef", nil)
+	w := httptest.NewRecorder()
+	app.CreateHandler_RekeyCard().ServeHTTP(w, r)
+
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected error for unknown secret, got 200: %s", w.Body.String())
+	}
+}
+
+// TestAdminApiRotateCardKeys_NotFound verifies a rotation cannot be issued for
+// an unknown card.
+func TestAdminApiRotateCardKeys_NotFound(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("POST", "/admin/api/cards/99999/rotate-keys", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+// TestGetCardKeys_PreviousKeysAcceptedUntilNewKeysSeen verifies keys replaced
+// through /getcardkeys keep working, with the taps flagged, until the chip is
+// tapped with the new keys.
+func TestGetCardKeys_PreviousKeysAcceptedUntilNewKeysSeen(t *testing.T) {
+	app := setupEnabledApp(t)
+	cardId := insertFundedCard(t, app.db_write, 10000)
+
+	r := httptest.NewRequest("POST", "/getcardkeys", nil)
+	r.Header.Set("Authorization", "Bearer lnaccess")
+	w := httptest.NewRecorder()
+	app.CreateHandler_WalletApi_GetCardKeys().ServeHTTP(w, r)
+	var keys CardKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("expected JSON, got: %s", w.Body.String())
+	}
+	newKey1, _ := hex.DecodeString(keys.Key1)
+	newKey2, _ := hex.DecodeString(keys.Key2)
+
+	// the chip still holds the old keys
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1); resp.Status == "ERROR" {
+		t.Fatalf("expected previous keys to work during the grace period, got %q", resp.Reason)
+	}
+	taps := db.Db_select_card_taps(app.db_read, cardId, 1, 0)
+	if len(taps) != 1 || !taps[0].PreviousKeys || taps[0].KeyVersion != 1 {
+		t.Fatalf("expected the tap to be flagged as using the previous keys, got %+v", taps)
+	}
+
+	// the chip has been re-programmed
+	if resp := tapLn(t, app, newKey1, newKey2, 2); resp.Status == "ERROR" {
+		t.Fatalf("expected new keys to work, got %q", resp.Reason)
+	}
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 3); resp.Reason != "card not found" {
+		t.Fatalf("expected previous keys to be rejected, got status=%q reason=%q", resp.Status, resp.Reason)
+	}
+}
+
+// TestWipeCard_ReturnsKeysTheChipHolds verifies /wipecard returns the archived
+// keys of a card whose keys were rotated but whose chip was never re-written.
+func TestWipeCard_ReturnsKeysTheChipHolds(t *testing.T) {
+	app := setupEnabledApp(t)
+	insertFundedCard(t, app.db_write, 10000)
+
+	r := httptest.NewRequest("POST", "/getcardkeys", nil)
+	r.Header.Set("Authorization", "Bearer lnaccess")
+	w := httptest.NewRecorder()
+	app.CreateHandler_WalletApi_GetCardKeys().ServeHTTP(w, r)
+	var keys CardKeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("expected JSON, got: %s", w.Body.String())
+	}
+
+	// the chip still holds the old keys
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1); resp.Status == "ERROR" {
+		t.Fatalf("expected previous keys to work during the grace period, got %q", resp.Reason)
+	}
+
+	// rotating the keys ended the session, so log in again
+	if err := db.Db_set_tokens(app.db_write, "lnlogin", "lnpass", "lnaccess2", "lnrefresh2"); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("POST", "/wipecard", nil)
+	r.Header.Set("Authorization", "Bearer lnaccess2")
+	w = httptest.NewRecorder()
+	app.CreateHandler_WalletApi_WipeCard().ServeHTTP(w, r)
+
+	var resp WipeCardResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Status != "OK" {
+		t.Fatalf("expected wipe OK, got: %s", w.Body.String())
+	}
+	if resp.Key1 != hex.EncodeToString(nfcTestKey1) || resp.Key2 != hex.EncodeToString(nfcTestKey2) {
+		t.Fatalf("expected the keys the chip holds, got key1=%q key2=%q (new key1=%q)",
+			resp.Key1, resp.Key2, keys.Key1)
+	}
+}
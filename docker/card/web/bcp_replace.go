@@ -0,0 +1,69 @@
+package web
+
+import (
+	"card/db"
+	"card/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateHandler_ReplaceCard programs a replacement for a lost or damaged card.
+// It is reached via the boltcard://program deeplink produced by the admin
+// "Replace Card" action; the Bolt Card app POSTs the new chip's UID (as for
+// /batch) and writes the returned keys to it. The secret is single use: the
+// new card takes over the old card's balance, lightning address and settings,
+// and the old card is marked replaced so its keys stop working.
+func (app *App) CreateHandler_ReplaceCard() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("ReplaceCard request received")
+
+		secret := r.URL.Query().Get("s")
+
+		t := struct {
+			Uid string `json:"UID"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&t)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var keys db.CardKeys
+		keys.Key0, keys.Key1, keys.Key2, keys.Key3, keys.Key4 = generateCardKeys()
+		login := util.Random_hex()
+		password := util.Random_hex()
+
+		replacement, err := db.Db_replace_card(app.db_write, secret, keys, t.Uid, login, password)
+		if errors.Is(err, db.ErrReplaceSecretNotFound) || errors.Is(err, db.ErrCardStatusTransition) {
+			log.Info("replace secret not found or expired")
+			http.Error(w, "replace link expired or not found", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, db.ErrReplacePaymentInFlight) {
+			log.Info("replacement waiting for a payment in flight")
+			http.Error(w, "card has a payment in progress, try again shortly", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("replaced card_id = ", replacement.OldCardId, " with card_id = ", replacement.NewCardId,
+			" transferred_sats = ", replacement.TransferredSats, " Uid : ", t.Uid)
+
+		writeJSON(w, BcpBatchResponse{
+			Lnurlw:     "lnurlw://" + db.Db_get_setting(app.db_read, "host_domain") + "/ln",
+			K0:         keys.Key0,
+			K1:         keys.Key1,
+			K2:         keys.Key2,
+			K3:         keys.Key3,
+			K4:         keys.Key4,
+			UIDPrivacy: "Y",
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"card/db"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// replaceCard issues a replacement via the admin API and returns the replace
+// secret embedded in the returned /replace URL.
+func replaceCard(t *testing.T, app *App, token string, cardId int) string {
+	t.Helper()
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("POST", "/admin/api/cards/"+strconv.Itoa(cardId)+"/replace", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("replace failed: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		BoltcardLink string `json:"boltcardLink"`
+		ProgramUrl   string `json:"programUrl"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.BoltcardLink, "boltcard://program?url=") {
+		t.Fatalf("expected boltcard program deeplink, got %q", resp.BoltcardLink)
+	}
+	idx := strings.Index(resp.ProgramUrl, "/replace?s=")
+	if idx < 0 {
+		t.Fatalf("no secret in program url %q", resp.ProgramUrl)
+	}
+	return resp.ProgramUrl[idx+len("/replace?s="):]
+}
+
+// TestReplaceCard_NewCardTakesOver walks a lost-card replacement: the new
+// chip is programmed from the deeplink, its keys work on /ln with the old
+// balance, the old card's keys are refused, and the link cannot be reused.
+func TestReplaceCard_NewCardTakesOver(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	oldId := insertFundedCard(t, app.db_write, 10000)
+	db.Db_set_card_status(app.db_write, oldId, db.CardStatusLost, "left on bus", "admin")
+
+	secret := replaceCard(t, app, token, oldId)
+
+	r := httptest.NewRequest("POST", "/replace?s="+secret, strings.NewReader(`{"UID":"04010203040506"}`))
+	w := httptest.NewRecorder()
+	app.CreateHandler_ReplaceCard().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /replace, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BcpBatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	newKey1, _ := hex.DecodeString(resp.K1)
+	newKey2, _ := hex.DecodeString(resp.K2)
+
+	oldCard, _ := db.Db_get_card(app.db_read, oldId)
+	if oldCard.Status != db.CardStatusReplaced || oldCard.Replaced_by_card_id == 0 {
+		t.Fatalf("expected old card replaced, got status=%q replacedBy=%d",
+			oldCard.Status, oldCard.Replaced_by_card_id)
+	}
+	newId := oldCard.Replaced_by_card_id
+	if b := db.Db_get_card_balance(app.db_read, newId); b != 10000 {
+		t.Fatalf("expected balance 10000 on new card, got %d", b)
+	}
+
+	if st := tapLn(t, app, newKey1, newKey2, 1); st.Status == "ERROR" {
+		t.Fatalf("expected new card to tap, got %q", st.Reason)
+	}
+	if st := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1); st.Status != "ERROR" {
+		t.Fatal("expected old card keys to be refused")
+	}
+
+	r = httptest.NewRequest("POST", "/replace?s="+secret, strings.NewReader(`{"UID":"04010203040507"}`))
+	w = httptest.NewRecorder()
+	app.CreateHandler_ReplaceCard().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 on reused replace link, got %d", w.Code)
+	}
+}
+
+// TestAdminApiReplaceCard_NotFound verifies a replacement cannot be issued
+// for an unknown card.
+func TestAdminApiReplaceCard_NotFound(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("POST", "/admin/api/cards/99999/replace", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
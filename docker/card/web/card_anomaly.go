@@ -0,0 +1,159 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Anomaly rules are configured with settings; a limit of 0 disables a rule.
+//
+//	anomaly_counter_jump_limit         largest accepted forward counter jump
+//	anomaly_stale_counter_limit        stale (replayed) counter taps per card in 10 minutes
+//	anomaly_taps_per_minute_limit      taps per card in one minute
+//	anomaly_failed_match_per_ip_limit  taps matching no card per client address in 10 minutes
+//	anomaly_auto_suspend               'Y' to suspend a card that trips a rule
+const (
+	defaultCounterJumpLimit       = 100
+	defaultStaleCounterLimit      = 3
+	defaultTapsPerMinuteLimit     = 10
+	defaultFailedMatchPerIpLimit  = 20
+	anomalyStaleCounterWindow     = 10 * time.Minute
+	anomalyTapStormWindow         = time.Minute
+	anomalyFailedMatchPerIpWindow = 10 * time.Minute
+)
+
+// wsSecurityEvent is sent to admin websocket clients when a card tap raises a
+// security event.
+type wsSecurityEvent struct {
+	Type      string `json:"type"`
+	CardId    int    `json:"cardId"`
+	EventType string `json:"eventType"`
+	Detail    string `json:"detail"`
+	Action    string `json:"action"`
+	RemoteIp  string `json:"remoteIp,omitempty"`
+	Timestamp int    `json:"timestamp"`
+}
+
+// anomalyLimit reads a rule limit setting, falling back to the default when
+// unset or invalid.
+func (app *App) anomalyLimit(name string, defaultLimit int) int {
+	if v := db.Db_get_setting(app.db_read, name); v != "" {
+		if limit, err := strconv.Atoi(v); err == nil && limit >= 0 {
+			return limit
+		}
+	}
+	return defaultLimit
+}
+
+// raiseSecurityEvent stores a security event and alerts admin websocket
+// clients.
+func (app *App) raiseSecurityEvent(ev db.CardSecurityEvent) {
+	if ev.Timestamp == 0 {
+		ev.Timestamp = int(time.Now().Unix())
+	}
+
+	log.Warn("security event ", ev.EventType, " card_id = ", ev.CardId,
+		": ", ev.Detail, " (", ev.Action, ")")
+	db.Db_insert_card_security_event(app.db_write, ev)
+
+	eventJSON, err := json.Marshal(wsSecurityEvent{
+		Type:      "security_event",
+		CardId:    ev.CardId,
+		EventType: ev.EventType,
+		Detail:    ev.Detail,
+		Action:    ev.Action,
+		RemoteIp:  ev.RemoteIp,
+		Timestamp: ev.Timestamp,
+	})
+	if err != nil {
+		log.Error("raiseSecurityEvent marshal error: ", err)
+		return
+	}
+	app.hub.broadcast(eventJSON)
+}
+
+// tripCardRule raises a security event for a rule tripped by a card, and
+// suspends the card when anomaly_auto_suspend is enabled.
+func (app *App) tripCardRule(r *http.Request, cardId int, eventType string, detail string) {
+	action := db.SecurityActionAlert
+	if db.Db_get_setting(app.db_read, "anomaly_auto_suspend") == "Y" {
+		err := db.Db_set_card_status(app.db_write, cardId, db.CardStatusSuspended, detail, "anomaly")
+		if err == nil {
+			action = db.SecurityActionSuspend
+		}
+	}
+
+	app.raiseSecurityEvent(db.CardSecurityEvent{
+		CardId:    cardId,
+		EventType: eventType,
+		Detail:    detail,
+		Action:    action,
+		RemoteIp:  clientIP(r),
+	})
+}
+
+// checkCounterJump flags a tap whose counter is further ahead of the last
+// seen value than the configured limit, which suggests taps were read from
+// the card (e.g. by a skimmer) and not presented here. A card with no counter
+// on record (new, or just re-keyed) has no baseline and is not checked.
+func (app *App) checkCounterJump(r *http.Request, cardTap CardTap, lastCounter uint32) {
+	limit := app.anomalyLimit("anomaly_counter_jump_limit", defaultCounterJumpLimit)
+	if limit == 0 || lastCounter == 0 || cardTap.Counter <= lastCounter {
+		return
+	}
+
+	jump := cardTap.Counter - lastCounter
+	if jump > uint32(limit) {
+		app.tripCardRule(r, cardTap.CardId, db.SecurityEventCounterJump,
+			"counter jumped from "+strconv.FormatUint(uint64(lastCounter), 10)+
+				" to "+strconv.FormatUint(uint64(cardTap.Counter), 10)+
+				" (limit "+strconv.Itoa(limit)+")")
+	}
+}
+
+// checkTapRules applies the rate based rules after a tap has been recorded.
+// Each rule trips once, when its count reaches the limit, so a sustained
+// attack raises one event per window rather than one per tap.
+func (app *App) checkTapRules(r *http.Request, cardTap CardTap, outcome string) {
+	now := time.Now()
+
+	if outcome == db.TapOutcomeCardNotFound {
+		limit := app.anomalyLimit("anomaly_failed_match_per_ip_limit", defaultFailedMatchPerIpLimit)
+		remoteIp := clientIP(r)
+		if limit > 0 && db.Db_count_ip_taps_since(app.db_read, remoteIp, db.TapOutcomeCardNotFound,
+			now.Add(-anomalyFailedMatchPerIpWindow).Unix()) == limit {
+			app.raiseSecurityEvent(db.CardSecurityEvent{
+				EventType: db.SecurityEventFailedMatchIp,
+				Detail:    strconv.Itoa(limit) + " taps matching no card in 10 minutes",
+				Action:    db.SecurityActionAlert,
+				RemoteIp:  remoteIp,
+			})
+		}
+		return
+	}
+
+	if cardTap.CardId == 0 {
+		return
+	}
+
+	if outcome == db.TapOutcomeCounterNotIncremented {
+		limit := app.anomalyLimit("anomaly_stale_counter_limit", defaultStaleCounterLimit)
+		if limit > 0 && db.Db_count_card_taps_since(app.db_read, cardTap.CardId,
+			db.TapOutcomeCounterNotIncremented, now.Add(-anomalyStaleCounterWindow).Unix()) == limit {
+			app.tripCardRule(r, cardTap.CardId, db.SecurityEventReplayAttempts,
+				strconv.Itoa(limit)+" stale counter taps in 10 minutes")
+		}
+	}
+
+	limit := app.anomalyLimit("anomaly_taps_per_minute_limit", defaultTapsPerMinuteLimit)
+	if limit > 0 && db.Db_count_card_taps_since(app.db_read, cardTap.CardId,
+		"", now.Add(-anomalyTapStormWindow).Unix()) == limit {
+		app.tripCardRule(r, cardTap.CardId, db.SecurityEventTapStorm,
+			strconv.Itoa(limit)+" taps in one minute")
+	}
+}
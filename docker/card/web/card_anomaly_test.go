@@ -0,0 +1,129 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"testing"
+)
+
+func TestAnomaly_CounterJumpAlerts(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_set_card_counter(app.db_write, cardId, 10)
+	alerts := app.hub.subscribe()
+	defer app.hub.unsubscribe(alerts)
+
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 500); resp.Status == "ERROR" {
+		t.Fatalf("expected tap to be accepted with auto-suspend off, got %q", resp.Reason)
+	}
+
+	events := db.Db_select_card_security_events(app.db_read, cardId, 10)
+	if len(events) != 1 || events[0].EventType != db.SecurityEventCounterJump ||
+		events[0].Action != db.SecurityActionAlert {
+		t.Fatalf("expected one counter_jump alert, got %+v", events)
+	}
+
+	select {
+	case msg := <-alerts:
+		var ev wsSecurityEvent
+		json.Unmarshal(msg, &ev)
+		if ev.Type != "security_event" || ev.EventType != db.SecurityEventCounterJump || ev.CardId != cardId {
+			t.Fatalf("unexpected websocket alert: %s", msg)
+		}
+	default:
+		t.Fatal("expected an alert on the admin websocket")
+	}
+}
+
+func TestAnomaly_CounterJumpWithinLimitIgnored(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_set_card_counter(app.db_write, cardId, 10)
+
+	tapLn(t, app, nfcTestKey1, nfcTestKey2, 60)
+
+	if events := db.Db_select_card_security_events(app.db_read, cardId, 10); len(events) != 0 {
+		t.Fatalf("expected no events, got %+v", events)
+	}
+}
+
+func TestAnomaly_CounterJumpAutoSuspends(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_set_card_counter(app.db_write, cardId, 10)
+	db.Db_set_setting(app.db_write, "anomaly_auto_suspend", "Y")
+
+	resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 500)
+	if resp.Reason != "card suspended" {
+		t.Fatalf("expected suspended card to refuse the tap, got status=%q reason=%q", resp.Status, resp.Reason)
+	}
+	if status := db.Db_get_card_status(app.db_read, cardId); status != db.CardStatusSuspended {
+		t.Fatalf("expected card suspended, got %q", status)
+	}
+
+	events := db.Db_select_card_security_events(app.db_read, cardId, 10)
+	if len(events) != 1 || events[0].Action != db.SecurityActionSuspend {
+		t.Fatalf("expected one suspend decision, got %+v", events)
+	}
+}
+
+func TestAnomaly_ReplayAttemptsTripOnce(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_set_card_counter(app.db_write, cardId, 10)
+
+	for i := 0; i < 5; i++ {
+		tapLn(t, app, nfcTestKey1, nfcTestKey2, 5)
+	}
+
+	events := db.Db_select_card_security_events(app.db_read, cardId, 10)
+	if len(events) != 1 || events[0].EventType != db.SecurityEventReplayAttempts {
+		t.Fatalf("expected one replay_attempts event, got %+v", events)
+	}
+}
+
+func TestAnomaly_TapStorm(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_set_setting(app.db_write, "anomaly_taps_per_minute_limit", "3")
+
+	for ctr := uint32(1); ctr <= 4; ctr++ {
+		tapLn(t, app, nfcTestKey1, nfcTestKey2, ctr)
+	}
+
+	events := db.Db_select_card_security_events(app.db_read, cardId, 10)
+	if len(events) != 1 || events[0].EventType != db.SecurityEventTapStorm {
+		t.Fatalf("expected one tap_storm event, got %+v", events)
+	}
+}
+
+func TestAnomaly_RuleDisabledByZeroLimit(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_set_card_counter(app.db_write, cardId, 10)
+	db.Db_set_setting(app.db_write, "anomaly_counter_jump_limit", "0")
+
+	tapLn(t, app, nfcTestKey1, nfcTestKey2, 5000)
+
+	if events := db.Db_select_card_security_events(app.db_read, cardId, 10); len(events) != 0 {
+		t.Fatalf("expected no events with the rule disabled, got %+v", events)
+	}
+}
+
+func TestAnomaly_FailedMatchesPerIp(t *testing.T) {
+	app := openTestApp(t)
+	db.Db_set_setting(app.db_write, "anomaly_failed_match_per_ip_limit", "2")
+
+	// no card has these keys
+	for ctr := uint32(1); ctr <= 3; ctr++ {
+		tapLn(t, app, nfcTestKey1, nfcTestKey2, ctr)
+	}
+
+	events := db.Db_select_card_security_events(app.db_read, 0, 10)
+	if len(events) != 1 || events[0].EventType != db.SecurityEventFailedMatchIp {
+		t.Fatalf("expected one failed_match_ip event, got %+v", events)
+	}
+	if events[0].RemoteIp != "192.0.2.1" {
+		t.Fatalf("expected client address recorded, got %q", events[0].RemoteIp)
+	}
+}
@@ -0,0 +1,311 @@
+package web
+
+import (
+	"card/db"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// cardCsvColumns are the columns of a card export. An import reads the same
+// columns plus allocate_sats; status, balance_sats and ln_address are
+// read-only and ignored on import.
+var cardCsvColumns = []string{"card_id", "uid", "group", "note", "tx_limit_sats",
+	"day_limit_sats", "status", "balance_sats", "ln_address"}
+
+// ErrCardImportInvalid is returned when applying an import that has errors.
+var ErrCardImportInvalid = errors.New("card import has errors")
+
+// CardImportChange is one field a row of an import would change.
+type CardImportChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// CardImportRow is the validation report and diff for one row of an import.
+// Line is the line number in the file, counting the header as line 1.
+type CardImportRow struct {
+	Line    int                `json:"line"`
+	CardId  int                `json:"cardId"`
+	Errors  []string           `json:"errors"`
+	Changes []CardImportChange `json:"changes"`
+}
+
+// CardImport is a checked CSV import: the report for every row and the
+// updates to make. Nothing is changed until it is applied.
+type CardImport struct {
+	Errors  []string        `json:"errors"` // problems with the file as a whole
+	Rows    []CardImportRow `json:"rows"`
+	Updates []db.CardUpdate `json:"-"`
+}
+
+// Valid reports whether the import can be applied.
+func (ci CardImport) Valid() bool {
+	if len(ci.Errors) > 0 {
+		return false
+	}
+	for _, row := range ci.Rows {
+		if len(row.Errors) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// csvFormulaPrefix reports whether a cell starting with s would be taken as a
+// formula by a spreadsheet.
+func csvFormulaPrefix(s string) bool {
+	return s != "" && strings.ContainsRune("=+-@", rune(s[0]))
+}
+
+// csvEscapeCell prefixes a cell a spreadsheet would run as a formula with a
+// quote, which spreadsheets show as text. A cell that already starts with a
+// quote before a formula character is quoted again so the import gives it
+// back unchanged.
+func csvEscapeCell(s string) string {
+	if csvFormulaPrefix(s) || (strings.HasPrefix(s, "'") && csvFormulaPrefix(s[1:])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvUnescapeCell undoes csvEscapeCell.
+func csvUnescapeCell(s string) string {
+	if strings.HasPrefix(s, "'") && (csvFormulaPrefix(s[1:]) ||
+		(strings.HasPrefix(s[1:], "'") && csvFormulaPrefix(s[2:]))) {
+		return s[1:]
+	}
+	return s
+}
+
+// ExportCardsCSV writes every card that has not been wiped as CSV. Cells
+// that start like a formula are escaped with csvEscapeCell.
+func ExportCardsCSV(db_conn *sql.DB, out io.Writer) error {
+	cards := db.Db_select_all_cards(db_conn)
+	sort.Slice(cards, func(i, j int) bool { return cards[i].CardId < cards[j].CardId })
+
+	hostDomain := db.Db_get_setting(db_conn, "host_domain")
+
+	cw := csv.NewWriter(out)
+	if err := cw.Write(cardCsvColumns); err != nil {
+		return err
+	}
+	for _, c := range cards {
+		lnAddress := ""
+		if c.LnAddress != "" {
+			lnAddress = c.LnAddress + "@" + hostDomain
+		}
+		record := []string{strconv.Itoa(c.CardId), c.Uid, c.GroupTag, c.Note,
+			strconv.Itoa(c.TxLimitSats), strconv.Itoa(c.DayLimitSats), c.Status,
+			strconv.Itoa(c.BalanceSats), lnAddress}
+		for i := range record {
+			record[i] = csvEscapeCell(record[i])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// PlanCardImport reads a CSV of card updates and checks every row against the
+// database. Rows are keyed by card_id, or by uid when card_id is empty. An
+// empty cell leaves the field unchanged; allocate_sats credits the card.
+func PlanCardImport(db_conn *sql.DB, in io.Reader) CardImport {
+	var ci CardImport
+
+	cr := csv.NewReader(in)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		ci.Errors = append(ci.Errors, "could not read the header row")
+		return ci
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if name == "group_tag" {
+			name = "group"
+		}
+		if name != "allocate_sats" && !slices.Contains(cardCsvColumns, name) {
+			ci.Errors = append(ci.Errors, "unknown column "+strconv.Quote(name))
+			continue
+		}
+		if _, dup := columns[name]; dup {
+			ci.Errors = append(ci.Errors, "duplicate column "+strconv.Quote(name))
+			continue
+		}
+		columns[name] = i
+	}
+	_, hasId := columns["card_id"]
+	_, hasUid := columns["uid"]
+	if !hasId && !hasUid {
+		ci.Errors = append(ci.Errors, "a card_id or uid column is needed")
+	}
+	if len(ci.Errors) > 0 {
+		return ci
+	}
+
+	cards := map[int]db.CardSummary{}
+	for _, c := range db.Db_select_cards_by_status(db_conn, db.CardStatuses) {
+		cards[c.CardId] = c
+	}
+	seen := map[int]int{}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ci.Errors = append(ci.Errors, "line "+strconv.Itoa(line)+": "+err.Error())
+			break
+		}
+
+		cell := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return csvUnescapeCell(strings.TrimSpace(record[i]))
+		}
+
+		blank := true
+		for _, v := range record {
+			if strings.TrimSpace(v) != "" {
+				blank = false
+			}
+		}
+		if blank {
+			continue
+		}
+
+		row, update := planCardImportRow(db_conn, cell, cards)
+		row.Line = line
+		if row.CardId != 0 {
+			if first, dup := seen[row.CardId]; dup {
+				row.Errors = append(row.Errors, "card is also on line "+strconv.Itoa(first))
+			} else {
+				seen[row.CardId] = line
+			}
+		}
+		ci.Rows = append(ci.Rows, row)
+		if len(row.Errors) == 0 && len(row.Changes) > 0 {
+			ci.Updates = append(ci.Updates, update)
+		}
+	}
+
+	return ci
+}
+
+func planCardImportRow(db_conn *sql.DB, cell func(string) string, cards map[int]db.CardSummary) (CardImportRow, db.CardUpdate) {
+	row := CardImportRow{Errors: []string{}, Changes: []CardImportChange{}}
+	var update db.CardUpdate
+
+	// find the card
+	cardId := 0
+	if v := cell("card_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			row.Errors = append(row.Errors, "invalid card_id "+strconv.Quote(v))
+			return row, update
+		}
+		cardId = id
+	}
+	if uid := cell("uid"); uid != "" {
+		uidCardId := db.Db_get_card_id_from_card_uid(db_conn, uid)
+		if cardId == 0 {
+			cardId = uidCardId
+			if cardId == 0 {
+				row.Errors = append(row.Errors, "no card with uid "+uid)
+				return row, update
+			}
+		} else if uidCardId != cardId {
+			row.Errors = append(row.Errors, "card_id and uid are different cards")
+			return row, update
+		}
+	}
+	if cardId == 0 {
+		row.Errors = append(row.Errors, "card_id or uid is needed")
+		return row, update
+	}
+
+	card, ok := cards[cardId]
+	row.CardId = cardId
+	update.CardId = cardId
+	if !ok {
+		row.Errors = append(row.Errors, "card not found")
+		return row, update
+	}
+	if card.Status == db.CardStatusWiped {
+		row.Errors = append(row.Errors, "card is wiped")
+		return row, update
+	}
+
+	if v := cell("note"); v != "" && v != card.Note {
+		row.Changes = append(row.Changes, CardImportChange{"note", card.Note, v})
+		update.Note = &v
+	}
+	if v := cell("group"); v != "" && v != card.GroupTag {
+		row.Changes = append(row.Changes, CardImportChange{"group", card.GroupTag, v})
+		update.GroupTag = &v
+	}
+
+	limit := func(field string, current int) *int {
+		v := cell(field)
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			row.Errors = append(row.Errors, "invalid "+field+" "+strconv.Quote(v))
+			return nil
+		}
+		if n == current {
+			return nil
+		}
+		row.Changes = append(row.Changes, CardImportChange{field, strconv.Itoa(current), v})
+		return &n
+	}
+	update.TxLimitSats = limit("tx_limit_sats", card.TxLimitSats)
+	update.DayLimitSats = limit("day_limit_sats", card.DayLimitSats)
+
+	if v := cell("allocate_sats"); v != "" {
+		n, err := strconv.Atoi(v)
+		switch {
+		case err != nil || n < 0:
+			row.Errors = append(row.Errors, "invalid allocate_sats "+strconv.Quote(v))
+		case n == 0:
+		case !db.Card_status_allows_receive(card.Status):
+			row.Errors = append(row.Errors, "card is "+card.Status+" and cannot be allocated funds")
+		default:
+			row.Changes = append(row.Changes, CardImportChange{"balance_sats",
+				strconv.Itoa(card.BalanceSats), strconv.Itoa(card.BalanceSats + n)})
+			update.AllocateSats = n
+		}
+	}
+
+	return row, update
+}
+
+// ApplyCardImport makes the changes of a checked import in one transaction.
+// An import with any errors is not applied.
+func ApplyCardImport(db_conn *sql.DB, ci CardImport) error {
+	if !ci.Valid() {
+		return ErrCardImportInvalid
+	}
+	if len(ci.Updates) == 0 {
+		return nil
+	}
+	return db.Db_apply_card_updates(db_conn, ci.Updates, "csv_import")
+}
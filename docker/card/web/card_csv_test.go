@@ -0,0 +1,185 @@
+package web
+
+import (
+	"bytes"
+	"card/db"
+	"encoding/csv"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func insertGroupCard(t *testing.T, app *App, login string, uid string, group string) int {
+	t.Helper()
+	keys := db.CardKeys{Key0: "k0", Key1: "k1", Key2: "k2", Key3: "k3", Key4: "k4"}
+	id, err := db.Db_insert_group_card(app.db_write, keys, login, "pass", uid, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// TestExportCardsCSV verifies the export has a header and one row per card
+// with its balance and lightning address.
+func TestExportCardsCSV(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	id := insertGroupCard(t, app, "l1", "04AABBCCDDEE01", "fest")
+	db.Db_add_card_receipt(app.db_write, id, "", "csvfund", 1200)
+	db.Db_set_receipt_paid(app.db_write, "csvfund", "test")
+
+	var buf bytes.Buffer
+	if err := ExportCardsCSV(app.db_read, &buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected header and one row, got %v err=%v", records, err)
+	}
+	row := records[1]
+	if row[0] != strconv.Itoa(id) || row[1] != "04AABBCCDDEE01" || row[2] != "fest" ||
+		row[7] != "1200" || !strings.HasSuffix(row[8], "@"+db.Db_get_setting(app.db_read, "host_domain")) {
+		t.Fatalf("unexpected export row %v", row)
+	}
+}
+
+// TestPlanCardImport_ReportsAndApplies verifies the preview lists the changes
+// and row errors, an import with errors is not applied, and a valid one is.
+func TestPlanCardImport_ReportsAndApplies(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	a := insertGroupCard(t, app, "la", "04AABBCCDDEE01", "fest")
+	b := insertGroupCard(t, app, "lb", "04AABBCCDDEE02", "fest")
+
+	csvText := "uid,note,group,tx_limit_sats,allocate_sats\n" +
+		"04aabbccddee01,Alice Smith,vip,5000,2000\n" +
+		"04AABBCCDDEE02,Bob Jones,,-1,\n" +
+		"04FFFFFFFFFFFF,Nobody,,,\n"
+
+	ci := PlanCardImport(app.db_read, strings.NewReader(csvText))
+	if ci.Valid() || len(ci.Rows) != 3 {
+		t.Fatalf("expected three rows with errors, got %+v", ci)
+	}
+	if r := ci.Rows[0]; r.CardId != a || len(r.Errors) != 0 || len(r.Changes) != 4 {
+		t.Fatalf("unexpected first row %+v", r)
+	}
+	if r := ci.Rows[1]; r.CardId != b || len(r.Errors) != 1 || r.Line != 3 {
+		t.Fatalf("expected invalid limit on line 3, got %+v", r)
+	}
+	if r := ci.Rows[2]; len(r.Errors) != 1 {
+		t.Fatalf("expected unknown uid error, got %+v", r)
+	}
+	if err := ApplyCardImport(app.db_write, ci); err != ErrCardImportInvalid {
+		t.Fatalf("expected invalid import to be refused, got %v", err)
+	}
+	if bal := db.Db_get_card_balance(app.db_read, a); bal != 0 {
+		t.Fatalf("refused import changed the balance to %d", bal)
+	}
+
+	csvText = "card_id,note,group,tx_limit_sats,allocate_sats\n" +
+		strconv.Itoa(a) + ",Alice Smith,vip,5000,2000\n" +
+		strconv.Itoa(b) + ",Bob Jones,,,\n"
+	ci = PlanCardImport(app.db_read, strings.NewReader(csvText))
+	if err := ApplyCardImport(app.db_write, ci); err != nil {
+		t.Fatalf("expected import applied, got %v (%+v)", err, ci)
+	}
+
+	card, _ := db.Db_get_card(app.db_read, a)
+	if card.Note != "Alice Smith" || card.Tx_limit_sats != 5000 || db.Db_get_card_balance(app.db_read, a) != 2000 {
+		t.Fatalf("card not updated: %+v", card)
+	}
+	if _, err := db.Db_get_card_group(app.db_read, "vip"); err != nil {
+		t.Fatalf("expected vip group created, got %v", err)
+	}
+	if card, _ := db.Db_get_card(app.db_read, b); card.Note != "Bob Jones" {
+		t.Fatalf("expected note on second card, got %q", card.Note)
+	}
+}
+
+// TestAdminApiImportCardsCSV_PreviewThenCommit verifies the admin endpoint
+// only changes cards when asked to commit.
+func TestAdminApiImportCardsCSV_PreviewThenCommit(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	id := insertGroupCard(t, app, "la", "04AABBCCDDEE01", "fest")
+	body := "card_id,note\n" + strconv.Itoa(id) + ",Guest 1\n"
+
+	for _, commit := range []bool{false, true} {
+		r := httptest.NewRequest("POST", "/admin/api/cards/import?commit="+strconv.FormatBool(commit),
+			strings.NewReader(body))
+		r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+		w := httptest.NewRecorder()
+		app.CreateHandler_AdminApi().ServeHTTP(w, r)
+
+		var resp struct {
+			Valid     bool `json:"valid"`
+			Committed bool `json:"committed"`
+			Rows      []struct {
+				Changes []CardImportChange `json:"changes"`
+			} `json:"rows"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || !resp.Valid || resp.Committed != commit ||
+			len(resp.Rows) != 1 || len(resp.Rows[0].Changes) != 1 {
+			t.Fatalf("commit=%v: unexpected response %d: %s", commit, w.Code, w.Body.String())
+		}
+
+		card, _ := db.Db_get_card(app.db_read, id)
+		if (card.Note == "Guest 1") != commit {
+			t.Fatalf("commit=%v: note is %q", commit, card.Note)
+		}
+	}
+}
+
+// TestAdminApiImportCardsCSV_UploadSizeCapped verifies a csv_file upload over
+// the 5MB limit is refused.
+func TestAdminApiImportCardsCSV_UploadSizeCapped(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("csv_file", "cards.csv")
+	part.Write([]byte("card_id,note\n"))
+	part.Write(bytes.Repeat([]byte("1,x\n"), (5<<20)/4+1))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/admin/api/cards/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	app.CreateHandler_AdminApi().ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an oversized upload, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestCardsCSV_EscapesFormulas verifies cells a spreadsheet would run as a
+// formula are exported with a leading quote, and that importing the export
+// gives the values back unchanged.
+func TestCardsCSV_EscapesFormulas(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	a := insertGroupCard(t, app, "la", "04AABBCCDDEE01", "=fest")
+	b := insertGroupCard(t, app, "lb", "04AABBCCDDEE02", "fest")
+	db.Db_update_card_note(app.db_write, a, "=HYPERLINK(\"http://evil\")")
+	db.Db_update_card_note(app.db_write, b, "'@quoted")
+
+	var buf bytes.Buffer
+	if err := ExportCardsCSV(app.db_read, &buf); err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.String()
+	records, _ := csv.NewReader(strings.NewReader(exported)).ReadAll()
+	if len(records) != 3 || records[1][2] != "'=fest" || records[1][3] != "'=HYPERLINK(\"http://evil\")" ||
+		records[2][3] != "''@quoted" {
+		t.Fatalf("expected formula cells escaped, got %v", records)
+	}
+
+	ci := PlanCardImport(app.db_read, strings.NewReader(exported))
+	if !ci.Valid() || len(ci.Updates) != 0 {
+		t.Fatalf("expected the export to import with no changes, got %+v", ci)
+	}
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"card/db"
+	"database/sql"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultExpiryGraceDays  = 30
+	defaultForfeitHouseName = "house"
+	cardExpiryCheckInterval = time.Hour
+	forfeitReasonTimeLayout = "2006-01-02"
+	secondsPerDay           = 24 * 60 * 60
+)
+
+// ExpiryRun reports what a card expiry run did, or for a dry run, would do.
+type ExpiryRun struct {
+	DryRun        bool
+	GraceDays     int
+	HouseAccount  string
+	Cutoff        int64 // cards that expired at or before this are forfeited
+	Expired       []int // cards moved to the expired status
+	Forfeited     db.ExpiringCards
+	ForfeitedSats int
+}
+
+// expiryGraceDays reads the expiry_grace_days setting: how long the holder of
+// an expired card can still pay out the balance before it is forfeited.
+func expiryGraceDays(db_conn *sql.DB) int {
+	if v := db.Db_get_setting(db_conn, "expiry_grace_days"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days >= 0 {
+			return days
+		}
+	}
+	return defaultExpiryGraceDays
+}
+
+// ForfeitHouseAccount reads the forfeit_house_account setting, the house
+// account that receives forfeited balances.
+func ForfeitHouseAccount(db_conn *sql.DB) string {
+	if v := db.Db_get_setting(db_conn, "forfeit_house_account"); v != "" {
+		return v
+	}
+	return defaultForfeitHouseName
+}
+
+// payoutGraceOpen reports whether an expired card is still within the grace
+// period in which its holder may pay out the balance.
+func payoutGraceOpen(db_conn *sql.DB, cardId int, now int64) bool {
+	expiresAt := db.Db_get_card_expiry(db_conn, cardId)
+	if expiresAt == 0 {
+		return false
+	}
+	return now < int64(expiresAt)+int64(expiryGraceDays(db_conn))*secondsPerDay
+}
+
+// RunCardExpiry moves cards whose expiry has passed to the expired status,
+// which stops withdrawals, and forfeits the balance of cards whose payout
+// grace period has also passed to the forfeit house account. With dryRun set
+// nothing is changed and the result lists what would be.
+func RunCardExpiry(db_read *sql.DB, db_write *sql.DB, now int64, dryRun bool) ExpiryRun {
+	run := ExpiryRun{
+		DryRun:       dryRun,
+		GraceDays:    expiryGraceDays(db_read),
+		HouseAccount: ForfeitHouseAccount(db_read),
+	}
+	run.Cutoff = now - int64(run.GraceDays)*secondsPerDay
+
+	for _, cardId := range db.Db_select_cards_due_to_expire(db_read, now) {
+		if !dryRun {
+			err := db.Db_set_card_status(db_write, cardId, db.CardStatusExpired, "expiry date reached", "system")
+			if err != nil {
+				continue
+			}
+		}
+		run.Expired = append(run.Expired, cardId)
+	}
+
+	for _, card := range db.Db_select_cards_to_forfeit(db_read, run.Cutoff) {
+		if !dryRun {
+			reason := "expired " + time.Unix(int64(card.ExpiresAt), 0).UTC().Format(forfeitReasonTimeLayout)
+			amount, err := db.Db_forfeit_card_balance(db_write, card.CardId, run.HouseAccount, reason)
+			if err != nil || amount == 0 {
+				continue
+			}
+			card.BalanceSats = amount
+		}
+		run.Forfeited = append(run.Forfeited, card)
+		run.ForfeitedSats += card.BalanceSats
+	}
+
+	return run
+}
+
+// startCardExpiry runs card expiry every hour until app.stop is closed.
+func (app *App) startCardExpiry() {
+	go func() {
+		ticker := time.NewTicker(cardExpiryCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.stop:
+				return
+			case <-ticker.C:
+				run := RunCardExpiry(app.db_read, app.db_write, time.Now().Unix(), false)
+				if len(run.Expired) > 0 || len(run.Forfeited) > 0 {
+					log.Info("card expiry: expired ", len(run.Expired), " cards, forfeited ",
+						run.ForfeitedSats, " sats from ", len(run.Forfeited), " cards to ", run.HouseAccount)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,81 @@
+package web
+
+import (
+	"card/db"
+	"card/phoenix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRunCardExpiry_DryRunThenForfeit verifies the dry run reports without
+// changing anything, and the real run expires the card and moves its balance
+// to the house account once the grace period has passed.
+func TestRunCardExpiry_DryRunThenForfeit(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 4000)
+	db.Db_set_setting(app.db_write, "expiry_grace_days", "7")
+
+	now := time.Now().Unix()
+	db.Db_set_card_expiry(app.db_write, cardId, int(now-8*secondsPerDay))
+
+	run := RunCardExpiry(app.db_read, app.db_write, now, true)
+	if len(run.Expired) != 1 || len(run.Forfeited) != 1 || run.ForfeitedSats != 4000 {
+		t.Fatalf("unexpected dry run %+v", run)
+	}
+	if s := db.Db_get_card_status(app.db_read, cardId); s != db.CardStatusPendingProgramming {
+		t.Fatalf("dry run changed the card status to %q", s)
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 4000 {
+		t.Fatalf("dry run changed the balance to %d", b)
+	}
+
+	run = RunCardExpiry(app.db_read, app.db_write, now, false)
+	if run.ForfeitedSats != 4000 || run.HouseAccount != "house" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if s := db.Db_get_card_status(app.db_read, cardId); s != db.CardStatusExpired {
+		t.Fatalf("expected card expired, got %q", s)
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 0 {
+		t.Fatalf("expected balance forfeited, got %d", b)
+	}
+	if accounts := db.Db_select_house_accounts(app.db_read); len(accounts) != 1 || accounts[0].BalanceSats != 4000 {
+		t.Fatalf("unexpected house accounts %+v", accounts)
+	}
+}
+
+// TestRunCardExpiry_GracePeriodAllowsPayout verifies an expired card can no
+// longer withdraw but its holder can still pay out during the grace period,
+// even with payouts otherwise closed.
+func TestRunCardExpiry_GracePeriodAllowsPayout(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 4000)
+
+	now := time.Now().Unix()
+	db.Db_set_card_expiry(app.db_write, cardId, int(now-60))
+
+	run := RunCardExpiry(app.db_read, app.db_write, now, false)
+	if len(run.Expired) != 1 || len(run.Forfeited) != 0 {
+		t.Fatalf("expected card expired but not forfeited, got %+v", run)
+	}
+
+	if st := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1); st.Reason != "card expired" {
+		t.Fatalf("expected withdrawals refused after expiry, got %q", st.Reason)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"routingFeeSat":1,"paymentHash":"gracehash"}`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	resp, reason := postPayout(t, app, payoutTapUrl(t, 2), "holder@example.com")
+	if resp.Status != "OK" {
+		t.Fatalf("expected payout during grace period, got %q", reason)
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 4000-resp.AmountSats-1 {
+		t.Fatalf("unexpected balance after grace payout %d", b)
+	}
+}
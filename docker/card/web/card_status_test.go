@@ -0,0 +1,227 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestCardStatus_FirstTapActivates(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+
+	tapLn(t, app, nfcTestKey1, nfcTestKey2, 1)
+
+	if status := db.Db_get_card_status(app.db_read, cardId); status != db.CardStatusActive {
+		t.Fatalf("expected card active after first tap, got %q", status)
+	}
+	history := db.Db_select_card_status_history(app.db_read, cardId)
+	if len(history) != 1 || history[0].FromStatus != db.CardStatusPendingProgramming {
+		t.Fatalf("unexpected status history: %+v", history)
+	}
+}
+
+func TestCardStatus_LnRefusesLostCard(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 1000)
+	db.Db_set_card_status(app.db_write, cardId, db.CardStatusLost, "reported lost", "admin")
+
+	resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1)
+	if resp.Status != "ERROR" || resp.Reason != "card lost" {
+		t.Fatalf("expected lost card refused, got status=%q reason=%q", resp.Status, resp.Reason)
+	}
+
+	taps := db.Db_select_card_taps(app.db_read, cardId, 10, 0)
+	if len(taps) != 1 || taps[0].Outcome != db.TapOutcomeCardNotActive {
+		t.Fatalf("expected card_not_active tap, got %+v", taps)
+	}
+}
+
+func TestCardStatus_LnurlpRefusesLostCard(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+	db.Db_set_card_status(app.db_write, cardId, db.CardStatusLost, "", "admin")
+
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address, nil)
+	r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpRequest().ServeHTTP(w, r)
+
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["status"] != "ERROR" || resp["reason"] != "card not active" {
+		t.Fatalf("expected lost card refused, got %s", w.Body.String())
+	}
+}
+
+func TestCardStatus_WalletPayRefusesSuspendedCard(t *testing.T) {
+	app := setupEnabledApp(t)
+	cardId := insertFundedCard(t, app.db_write, 5000)
+	db.Db_set_card_status(app.db_write, cardId, db.CardStatusSuspended, "", "admin")
+
+	body := fmt.Sprintf(`{"invoice":"%s","amount":1500}`, testBolt11)
+	r := httptest.NewRequest("POST", "/payinvoice", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer lnaccess")
+	w := httptest.NewRecorder()
+	app.CreateHandler_WalletApi_PayInvoice().ServeHTTP(w, r)
+
+	var errResp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if errResp.Message != "card not active" {
+		t.Fatalf("expected 'card not active', got %q", errResp.Message)
+	}
+}
+
+func TestCardStatus_WalletAddInvoiceRefusesExpiredCard(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	authedCard(t, app, "expired", "expiredtoken")
+	cardId := db.Db_get_card_id_from_access_token(app.db_read, "expiredtoken")
+	db.Db_set_card_status(app.db_write, cardId, db.CardStatusExpired, "", "admin")
+
+	r := httptest.NewRequest("POST", "/addinvoice", strings.NewReader(`{"amt":"50","memo":"x"}`))
+	r.Header.Set("Authorization", "Bearer expiredtoken")
+	w := httptest.NewRecorder()
+	app.CreateHandler_AddInvoice().ServeHTTP(w, r)
+
+	var errResp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if errResp.Message != "card not active" {
+		t.Fatalf("expected 'card not active', got %q", errResp.Message)
+	}
+}
+
+// setCardStatusViaAdmin calls PUT /admin/api/cards/{id}/status.
+func setCardStatusViaAdmin(t *testing.T, app *App, token string, cardId int, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("PUT", "/admin/api/cards/"+strconv.Itoa(cardId)+"/status", strings.NewReader(body))
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	app.CreateHandler_AdminApi().ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminApiSetCardStatus(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 0)
+
+	w := setCardStatusViaAdmin(t, app, token, cardId, `{"status":"suspended","reason":"support ticket 12"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/admin/api/cards/"+strconv.Itoa(cardId)+"/status-history", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w = httptest.NewRecorder()
+	app.CreateHandler_AdminApi().ServeHTTP(w, r)
+
+	var resp struct {
+		History []struct {
+			FromStatus string `json:"fromStatus"`
+			ToStatus   string `json:"toStatus"`
+			Reason     string `json:"reason"`
+			Actor      string `json:"actor"`
+		} `json:"history"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.History) != 1 || resp.History[0].ToStatus != "suspended" ||
+		resp.History[0].Reason != "support ticket 12" || resp.History[0].Actor != "admin" {
+		t.Fatalf("unexpected history: %+v", resp.History)
+	}
+}
+
+func TestAdminApiSetCardStatus_Rejected(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 0)
+
+	// wiping must go through the wipe endpoint
+	if w := setCardStatusViaAdmin(t, app, token, cardId, `{"status":"wiped"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for wiped, got %d", w.Code)
+	}
+
+	setCardStatusViaAdmin(t, app, token, cardId, `{"status":"lost"}`)
+	if w := setCardStatusViaAdmin(t, app, token, cardId, `{"status":"suspended"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for lost -> suspended, got %d", w.Code)
+	}
+
+	if w := setCardStatusViaAdmin(t, app, token, 99999, `{"status":"suspended"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown card, got %d", w.Code)
+	}
+}
+
+func TestAdminApiListCards_StatusFilter(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	db.Db_insert_card(app.db_write, "a0", "a1", "a2", "a3", "a4", "login1", "pass1")
+	db.Db_insert_card(app.db_write, "b0", "b1", "b2", "b3", "b4", "login2", "pass2")
+	db.Db_wipe_card(app.db_write, 2)
+
+	list := func(query string) []map[string]any {
+		r := httptest.NewRequest("GET", "/admin/api/cards"+query, nil)
+		r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+		w := httptest.NewRecorder()
+		app.CreateHandler_AdminApi().ServeHTTP(w, r)
+		var resp struct {
+			Cards []map[string]any `json:"cards"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Cards
+	}
+
+	if cards := list(""); len(cards) != 1 || cards[0]["status"] != "pending_programming" {
+		t.Fatalf("expected only the unwiped card by default, got %+v", cards)
+	}
+	if cards := list("?status=wiped"); len(cards) != 1 || cards[0]["status"] != "wiped" {
+		t.Fatalf("expected the wiped card, got %+v", cards)
+	}
+	if cards := list("?status=all"); len(cards) != 2 {
+		t.Fatalf("expected both cards, got %+v", cards)
+	}
+}
+
+func TestAdminApiListCards_Paginated(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	for i := 1; i <= 3; i++ {
+		db.Db_insert_card(app.db_write, "k0", "k1", "k2", "k3", "k4", "login"+strconv.Itoa(i), "pass")
+	}
+	db.Db_update_card_note(app.db_write, 2, "Alice")
+
+	list := func(query string) (int, map[string]any) {
+		r := httptest.NewRequest("GET", "/admin/api/cards"+query, nil)
+		r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+		w := httptest.NewRecorder()
+		app.CreateHandler_AdminApi().ServeHTTP(w, r)
+		var resp map[string]any
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, resp := list("?page=2&pageSize=2&order=asc")
+	cards, _ := resp["cards"].([]any)
+	if code != http.StatusOK || resp["total"] != float64(3) || resp["page"] != float64(2) || len(cards) != 1 ||
+		cards[0].(map[string]any)["cardId"] != float64(3) {
+		t.Fatalf("unexpected second page %d: %+v", code, resp)
+	}
+
+	_, resp = list("?q=alice")
+	if cards, _ := resp["cards"].([]any); len(cards) != 1 || resp["total"] != float64(1) {
+		t.Fatalf("expected one search match, got %+v", resp)
+	}
+
+	if code, _ := list("?sort=name"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown sort, got %d", code)
+	}
+	if code, _ := list("?pageSize=1000"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for oversized page, got %d", code)
+	}
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"card/db"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// card tap endpoints recorded in card_taps
+const (
+	tapEndpointLn            = "/ln"
+	tapEndpointBalanceAjax   = "/balance-ajax"
+	tapEndpointBalancePayout = "/balance-payout"
+)
+
+const (
+	defaultTapRetentionDays = 90
+	tapPruneInterval        = time.Hour
+	maxTapUserAgentLength   = 256
+)
+
+// recordTap stores a card tap and its outcome in the card_taps table, then
+// applies the rate based anomaly rules.
+func (app *App) recordTap(r *http.Request, endpoint string, cardTap CardTap, outcome string) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxTapUserAgentLength {
+		userAgent = userAgent[:maxTapUserAgentLength]
+	}
+
+	db.Db_insert_card_tap(app.db_write, db.CardTapLog{
+		CardId:    cardTap.CardId,
+		Counter:   cardTap.Counter,
+		Endpoint:  endpoint,
+		Outcome:   outcome,
+		RemoteIp:  clientIP(r),
+		UserAgent: userAgent,
+		Timestamp: int(time.Now().Unix()),
+
+		KeyVersion:   cardTap.KeyVersion,
+		PreviousKeys: cardTap.Previous,
+	})
+
+	app.checkTapRules(r, cardTap, outcome)
+}
+
+// tapNotMatchedOutcome gives the card_taps outcome for a tap findCard refused.
+func tapNotMatchedOutcome(cardTap CardTap) string {
+	if cardTap.UidMismatch {
+		return db.TapOutcomeUidMismatch
+	}
+	return db.TapOutcomeCardNotFound
+}
+
+// clientIP returns the address of the client. The service runs behind Caddy,
+// which sets X-Forwarded-For, so the last entry (added by the proxy) is used
+// when present.
+func clientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tapRetentionDays reads the card_tap_retention_days setting; 0 keeps taps
+// forever.
+func (app *App) tapRetentionDays() int {
+	if v := db.Db_get_setting(app.db_read, "card_tap_retention_days"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days >= 0 {
+			return days
+		}
+	}
+	return defaultTapRetentionDays
+}
+
+// pruneCardTaps removes taps older than the retention period.
+func (app *App) pruneCardTaps() {
+	days := app.tapRetentionDays()
+	if days == 0 {
+		return
+	}
+	before := time.Now().Add(-time.Duration(days) * 24 * time.Hour).Unix()
+	if n := db.Db_delete_card_taps_before(app.db_write, before); n > 0 {
+		log.Info("pruned ", n, " card taps older than ", days, " days")
+	}
+}
+
+// startTapPruner prunes the card tap log every hour until app.stop is closed.
+func (app *App) startTapPruner() {
+	go func() {
+		ticker := time.NewTicker(tapPruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.stop:
+				return
+			case <-ticker.C:
+				app.pruneCardTaps()
+			}
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"card/db"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCardTaps_LnOutcomesRecorded(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+
+	tapLn(t, app, nfcTestKey1, nfcTestKey2, 5) // ok
+	tapLn(t, app, nfcTestKey1, nfcTestKey2, 5) // replay
+	db.Db_update_card_without_pin(app.db_write, cardId, 1000000, 1000000, "N", 0, "N")
+	tapLn(t, app, nfcTestKey1, nfcTestKey2, 6) // withdrawals disabled
+
+	taps := db.Db_select_card_taps(app.db_read, cardId, 10, 0)
+	want := []string{
+		db.TapOutcomeWithdrawalsDisabled,
+		db.TapOutcomeCounterNotIncremented,
+		db.TapOutcomeOk,
+	}
+	if len(taps) != len(want) {
+		t.Fatalf("expected %d taps, got %+v", len(want), taps)
+	}
+	for i, outcome := range want {
+		if taps[i].Outcome != outcome || taps[i].Endpoint != "/ln" {
+			t.Fatalf("tap %d: expected %s on /ln, got %+v", i, outcome, taps[i])
+		}
+	}
+	if taps[1].Counter != 5 {
+		t.Fatalf("expected replayed counter 5 recorded, got %d", taps[1].Counter)
+	}
+}
+
+func TestCardTaps_UnknownCardRecorded(t *testing.T) {
+	app := openTestApp(t)
+
+	tapLn(t, app, nfcTestKey1, nfcTestKey2, 1)
+
+	taps := db.Db_select_card_taps(app.db_read, 0, 10, 0)
+	if len(taps) != 1 || taps[0].Outcome != db.TapOutcomeCardNotFound {
+		t.Fatalf("expected one card_not_found tap, got %+v", taps)
+	}
+}
+
+func TestCardTaps_BalanceAjaxRecordsClient(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+
+	p, c := buildNfcTap(t, nfcTestKey1, nfcTestKey2, nfcTestUID, 1)
+	cardUrl := "lnurlw://test.example.com/ln?p=" + hex.EncodeToString(p) + "%26c=" + hex.EncodeToString(c)
+	r := httptest.NewRequest("GET", "/balance-ajax?card="+cardUrl, nil)
+	r.Header.Set("User-Agent", "balance-page")
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	w := httptest.NewRecorder()
+	app.CreateHandler_BalanceAjaxPage().ServeHTTP(w, r)
+
+	taps := db.Db_select_card_taps(app.db_read, cardId, 10, 0)
+	if len(taps) != 1 {
+		t.Fatalf("expected one tap, got %+v (response %s)", taps, w.Body.String())
+	}
+	if taps[0].Endpoint != "/balance-ajax" || taps[0].Outcome != db.TapOutcomeOk ||
+		taps[0].RemoteIp != "203.0.113.9" || taps[0].UserAgent != "balance-page" {
+		t.Fatalf("unexpected tap: %+v", taps[0])
+	}
+}
+
+func TestCardTaps_PruneHonoursRetention(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	now := time.Now()
+	db.Db_insert_card_tap(app.db_write, db.CardTapLog{CardId: 1, Outcome: db.TapOutcomeOk,
+		Timestamp: int(now.Add(-10 * 24 * time.Hour).Unix())})
+	db.Db_insert_card_tap(app.db_write, db.CardTapLog{CardId: 1, Outcome: db.TapOutcomeOk,
+		Timestamp: int(now.Unix())})
+
+	db.Db_set_setting(app.db_write, "card_tap_retention_days", "0")
+	app.pruneCardTaps()
+	if n := db.Db_get_card_tap_count(app.db_read, 1); n != 2 {
+		t.Fatalf("expected retention 0 to keep all taps, got %d", n)
+	}
+
+	db.Db_set_setting(app.db_write, "card_tap_retention_days", "7")
+	app.pruneCardTaps()
+	if n := db.Db_get_card_tap_count(app.db_read, 1); n != 1 {
+		t.Fatalf("expected the old tap pruned, got %d remaining", n)
+	}
+}
+
+func TestAdminApiCardTaps_Paginated(t *testing.T) {
+	app := openTestApp(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	for i := 1; i <= 3; i++ {
+		db.Db_insert_card_tap(app.db_write, db.CardTapLog{CardId: cardId, Counter: uint32(i),
+			Endpoint: "/ln", Outcome: db.TapOutcomeOk, Timestamp: int(time.Now().Unix())})
+	}
+
+	handler := app.CreateHandler_AdminApi()
+	r := httptest.NewRequest("GET", "/admin/api/cards/"+strconv.Itoa(cardId)+"/taps?limit=2&offset=1", nil)
+	r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Taps []struct {
+			Counter uint32 `json:"counter"`
+			Outcome string `json:"outcome"`
+		} `json:"taps"`
+		Total  int `json:"total"`
+		Limit  int `json:"limit"`
+		Offset int `json:"offset"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Total != 3 || resp.Limit != 2 || resp.Offset != 1 {
+		t.Fatalf("unexpected paging: %+v", resp)
+	}
+	if len(resp.Taps) != 2 || resp.Taps[0].Counter != 2 || resp.Taps[1].Counter != 1 {
+		t.Fatalf("unexpected taps page: %+v", resp.Taps)
+	}
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"card/db"
+	"database/sql"
+	"strconv"
+)
+
+// The routing fee reserve held on a card for each payment is configured with
+// settings; unset or invalid settings fall back to the defaults, which match
+// the Phoenix fee of 4 sat + 0.4%.
+//
+//	fee_reserve_base_sats  fixed part of the reserve
+//	fee_reserve_ppm        proportional part, in parts per million of the amount
+//	fee_reserve_min_sats   smallest reserve
+//	fee_reserve_max_sats   largest reserve, 0 for no maximum
+const (
+	defaultFeeReserveBaseSats = 4
+	defaultFeeReservePpm      = 4000
+)
+
+// FeePolicy is the routing fee reserve policy. The reserve is held on the card
+// while a payment is in flight, passed to Phoenix as the fee cap, and replaced
+// by the actual fee when the payment settles.
+type FeePolicy struct {
+	BaseSats int
+	Ppm      int
+	MinSats  int
+	MaxSats  int
+}
+
+// loadFeePolicy reads the fee reserve settings.
+func loadFeePolicy(db_conn *sql.DB) FeePolicy {
+	setting := func(name string, defaultValue int) int {
+		if v := db.Db_get_setting(db_conn, name); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				return n
+			}
+		}
+		return defaultValue
+	}
+	return FeePolicy{
+		BaseSats: setting("fee_reserve_base_sats", defaultFeeReserveBaseSats),
+		Ppm:      setting("fee_reserve_ppm", defaultFeeReservePpm),
+		MinSats:  setting("fee_reserve_min_sats", 0),
+		MaxSats:  setting("fee_reserve_max_sats", 0),
+	}
+}
+
+// ReserveSats is the fee reserve for a payment of amountSats, rounded up.
+func (p FeePolicy) ReserveSats(amountSats int) int {
+	reserve := p.BaseSats + int((int64(amountSats)*int64(p.Ppm)+999_999)/1_000_000)
+	reserve = max(reserve, p.MinSats)
+	if p.MaxSats > 0 {
+		reserve = min(reserve, p.MaxSats)
+	}
+	return reserve
+}
+
+// MaxAmountSats is the largest amount that can be paid from balanceSats while
+// leaving the fee reserve for it on the card.
+func (p FeePolicy) MaxAmountSats(balanceSats int) int {
+	// amount + reserve grows with the amount, so search for the largest fit
+	lo, hi := 0, max(balanceSats, 0)
+	for lo < hi {
+		mid := hi - (hi-lo)/2
+		if mid+p.ReserveSats(mid) <= balanceSats {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// Describe explains the policy to a card holder, e.g. "4 sats + 0.4%".
+func (p FeePolicy) Describe() string {
+	text := strconv.Itoa(p.BaseSats) + " sats + " +
+		strconv.FormatFloat(float64(p.Ppm)/10_000, 'f', -1, 64) + "%"
+	if p.MinSats > 0 {
+		text += ", at least " + strconv.Itoa(p.MinSats) + " sats"
+	}
+	if p.MaxSats > 0 {
+		text += ", at most " + strconv.Itoa(p.MaxSats) + " sats"
+	}
+	return text
+}
+
+// insufficientFeeReserveMessage is the LNURL error for a card that can cover
+// a payment but not the fee reserve held with it.
+func insufficientFeeReserveMessage(p FeePolicy, amountSats int) string {
+	return "Insufficient funds with network fees: a payment of " + strconv.Itoa(amountSats) +
+		" sats holds a fee reserve of " + strconv.Itoa(p.ReserveSats(amountSats)) +
+		" sats (" + p.Describe() + "), and the unused part is returned once the payment settles"
+}
@@ -2,14 +2,15 @@ package web
 
 import (
 	"card/db"
+	"card/nostr"
 	"card/phoenix"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
-	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -17,16 +18,31 @@ import (
 
 // resolveCardByAddress looks up a card by lightning address.
 // Addresses starting with "pl." are one-time pay link addresses;
-// addresses starting with "c." are permanent per-card addresses.
+// addresses starting with "c." are permanent per-card addresses;
+// any other address is an alias a card has claimed.
 func resolveCardByAddress(db_conn *sql.DB, username string) int {
 	if strings.HasPrefix(username, "pl.") {
 		return db.Db_get_card_by_pay_link_address(db_conn, username)
 	}
-	return db.Db_get_card_by_ln_address(db_conn, username)
+	if cardId := db.Db_get_card_by_ln_address(db_conn, username); cardId != 0 {
+		return cardId
+	}
+	return db.Db_get_card_by_ln_alias(db_conn, username)
 }
 
-func lnurlpMetadata(username, hostDomain string) string {
-	return fmt.Sprintf(`[["text/plain","Payment to %s@%s"]]`, username, hostDomain)
+// defaultLnAddressCommentLength is the longest LUD-12 payer comment accepted
+// on a lightning address payment, unless the ln_address_comment_length
+// setting says otherwise. A setting of 0 turns comments off.
+const defaultLnAddressCommentLength = 255
+
+// lnAddressCommentLength reads the longest comment accepted.
+func lnAddressCommentLength(db_conn *sql.DB) int {
+	if v := db.Db_get_setting(db_conn, "ln_address_comment_length"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultLnAddressCommentLength
 }
 
 func descriptionHash(metadata string) string {
@@ -53,16 +69,37 @@ func (app *App) CreateHandler_LnurlpRequest() http.HandlerFunc {
 			return
 		}
 
+		if !db.Card_status_allows_receive(db.Db_get_card_status(app.db_read, cardId)) {
+			writeJSON(w, map[string]string{"status": "ERROR", "reason": "card not active"})
+			return
+		}
+
+		policy := db.Db_get_card_receive_policy(app.db_read, cardId)
+		minSats, maxSats, ok := receivableSats(policy, db.Db_get_card_committed_balance_msat(app.db_read, cardId))
+		if !ok {
+			writeJSON(w, map[string]string{"status": "ERROR", "reason": "card balance limit reached"})
+			return
+		}
+
 		hostDomain := db.Db_get_setting(app.db_read, "host_domain")
-		metadata := lnurlpMetadata(username, hostDomain)
+		metadata := lnurlpMetadata(username, hostDomain, policy)
 
-		writeJSON(w, map[string]any{
+		payRequest := map[string]any{
 			"tag":         "payRequest",
 			"callback":    "https://" + hostDomain + "/.well-known/lnurlp/" + username + "/callback",
-			"minSendable": 1000,
-			"maxSendable": 100000000000,
+			"minSendable": db.SatsToMsat(minSats),
+			"maxSendable": db.SatsToMsat(maxSats),
 			"metadata":    metadata,
-		})
+		}
+		if commentLength := lnAddressCommentLength(app.db_read); commentLength > 0 {
+			payRequest["commentAllowed"] = commentLength
+		}
+		payRequest["payerData"] = lnurlpPayerData
+		if nostrPubkey, err := nostr.PublicKey(zapSecret(app)); err == nil {
+			payRequest["allowsNostr"] = true
+			payRequest["nostrPubkey"] = nostrPubkey
+		}
+		writeJSON(w, payRequest)
 	}
 }
 
@@ -85,6 +122,11 @@ func (app *App) CreateHandler_LnurlpCallback() http.HandlerFunc {
 			return
 		}
 
+		if !db.Card_status_allows_receive(db.Db_get_card_status(app.db_read, cardId)) {
+			writeJSON(w, map[string]string{"status": "ERROR", "reason": "card not active"})
+			return
+		}
+
 		// Validate amount (in millisats)
 		amountStr := r.URL.Query().Get("amount")
 		if amountStr == "" {
@@ -93,18 +135,72 @@ func (app *App) CreateHandler_LnurlpCallback() http.HandlerFunc {
 			return
 		}
 
+		policy := db.Db_get_card_receive_policy(app.db_read, cardId)
 		amountMsat, err := strconv.ParseInt(amountStr, 10, 64)
-		if err != nil || amountMsat < 1000 || amountMsat > 100000000000 {
+		if err != nil || amountMsat < db.SatsToMsat(policy.MinSats) || amountMsat > db.SatsToMsat(policy.MaxSats) {
 			w.WriteHeader(http.StatusBadRequest)
 			writeJSON(w, map[string]string{"status": "ERROR", "reason": "amount out of range"})
 			return
 		}
 
-		amountSats := int(amountMsat / 1000)
+		// the balance cap is checked when the invoice is created, counting
+		// the card's other invoices that may still be paid
+		if policy.MaxBalanceSats > 0 &&
+			db.Db_get_card_committed_balance_msat(app.db_read, cardId)+amountMsat > db.SatsToMsat(policy.MaxBalanceSats) {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"status": "ERROR", "reason": "amount would exceed the card balance limit"})
+			return
+		}
+
+		// the invoice must be for the exact amount asked for, and Phoenix
+		// creates invoices in whole sats
+		if amountMsat%1000 != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"status": "ERROR", "reason": "amount must be a whole number of sats"})
+			return
+		}
+		amountSats := db.MsatToSatsDown(amountMsat)
+
+		// LUD-12 payer comment, ignored when comments are off
+		comment := ""
+		if commentLength := lnAddressCommentLength(app.db_read); commentLength > 0 {
+			comment = strings.TrimSpace(r.URL.Query().Get("comment"))
+			if utf8.RuneCountInString(comment) > commentLength {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJSON(w, map[string]string{"status": "ERROR", "reason": "comment too long"})
+				return
+			}
+		}
 
 		hostDomain := db.Db_get_setting(app.db_read, "host_domain")
-		metadata := lnurlpMetadata(username, hostDomain)
+		metadata := lnurlpMetadata(username, hostDomain, policy)
 		dHash := descriptionHash(metadata)
+		payer := db.ReceiptPayer{Comment: comment}
+
+		if zapRequest := r.URL.Query().Get("nostr"); zapRequest != "" {
+			// NIP-57 zap: the invoice commits to the zap request, and payer
+			// data is not used
+			event, err := parseZapRequest(zapRequest, amountMsat)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJSON(w, map[string]string{"status": "ERROR", "reason": err.Error()})
+				return
+			}
+			dHash = descriptionHash(zapRequest)
+			payer.ZapRequest = zapRequest
+			if payer.Comment == "" && utf8.RuneCountInString(event.Content) <= lnAddressCommentLength(app.db_read) {
+				payer.Comment = strings.TrimSpace(event.Content)
+			}
+		} else if payerData := r.URL.Query().Get("payerdata"); payerData != "" {
+			// LUD-18: the invoice commits to the metadata and payer data
+			if err := parsePayerData(payerData); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJSON(w, map[string]string{"status": "ERROR", "reason": err.Error()})
+				return
+			}
+			dHash = descriptionHash(metadata + payerData)
+			payer.PayerData = payerData
+		}
 
 		// Create invoice via Phoenix with description hash
 		createInvoiceResponse, err := phoenix.CreateInvoice(phoenix.CreateInvoiceRequest{
@@ -119,14 +215,50 @@ func (app *App) CreateHandler_LnurlpCallback() http.HandlerFunc {
 		}
 
 		// Insert pending receipt
-		db.Db_add_card_receipt(app.db_write, cardId,
-			createInvoiceResponse.Serialized, createInvoiceResponse.PaymentHash, amountSats)
+		db.Db_add_card_receipt_from_payer(app.db_write, cardId,
+			createInvoiceResponse.Serialized, createInvoiceResponse.PaymentHash, amountMsat, payer)
 
 		log.Info("lnurlp invoice created for ", username, " amount=", amountSats)
 
 		writeJSON(w, map[string]any{
 			"pr":     createInvoiceResponse.Serialized,
 			"routes": []string{},
+			"verify": "https://" + hostDomain + "/.well-known/lnurlp/" + username + "/verify/" +
+				createInvoiceResponse.PaymentHash,
+		})
+	}
+}
+
+// CreateHandler_LnurlpVerify reports whether an invoice from the lightning
+// address callback is settled (LUD-21). It answers from the receipt, which
+// the Phoenix websocket listener and the receipt poller keep current.
+func (app *App) CreateHandler_LnurlpVerify() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		vars := mux.Vars(r)
+		var receipt db.CardReceipt
+		found := false
+		if username := vars["username"]; username != "" {
+			if cardId := resolveCardByAddress(app.db_read, username); cardId != 0 {
+				receipt, found = db.Db_select_card_receipt_by_hash(app.db_read, cardId, vars["hash"])
+			}
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]string{"status": "ERROR", "reason": "Not found"})
+			return
+		}
+
+		var preimage any // null until settled
+		if receipt.IsPaid == "Y" && receipt.Preimage != "" {
+			preimage = receipt.Preimage
+		}
+		writeJSON(w, map[string]any{
+			"status":   "OK",
+			"settled":  receipt.IsPaid == "Y",
+			"preimage": preimage,
+			"pr":       receipt.PaymentRequest,
 		})
 	}
 }
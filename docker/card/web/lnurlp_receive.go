@@ -0,0 +1,87 @@
+package web
+
+import (
+	"card/db"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxLnDescriptionLength = 500       // characters
+	maxLnImageLength       = 64 * 1024 // bytes of data URL
+)
+
+// lnImageMetadata splits a data URL of a PNG or JPEG into its LUD-06
+// metadata type, such as image/png;base64, and its base64 data.
+func lnImageMetadata(dataURL string) (metadataType string, data string, err error) {
+	header, data, found := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !found || !strings.HasPrefix(dataURL, "data:") {
+		return "", "", errors.New("image must be a data URL")
+	}
+	if header != "image/png;base64" && header != "image/jpeg;base64" {
+		return "", "", errors.New("image must be a base64 PNG or JPEG")
+	}
+	if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+		return "", "", errors.New("image data is not base64")
+	}
+	return header, data, nil
+}
+
+// validReceiveSettings checks receive settings an admin sets on a card or
+// group.
+func validReceiveSettings(s db.ReceiveSettings) error {
+	if s.MinSats < 0 || s.MaxSats < 0 || s.MaxBalanceSats < 0 {
+		return errors.New("receive limits must not be negative")
+	}
+	if s.MinSats > 0 && s.MaxSats > 0 && s.MinSats > s.MaxSats {
+		return errors.New("receive minimum is above the maximum")
+	}
+	if utf8.RuneCountInString(s.Description) > maxLnDescriptionLength {
+		return errors.New("description too long")
+	}
+	if s.Image != "" {
+		if len(s.Image) > maxLnImageLength {
+			return errors.New("image too large")
+		}
+		if _, _, err := lnImageMetadata(s.Image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lnurlpMetadata is the LUD-06 metadata for a lightning address: its
+// description, its text/identifier (LUD-16) and, if set, its image.
+func lnurlpMetadata(username, hostDomain string, policy db.ReceiveSettings) string {
+	address := username + "@" + hostDomain
+	description := policy.Description
+	if description == "" {
+		description = "Payment to " + address
+	}
+
+	metadata := [][]string{{"text/plain", description}, {"text/identifier", address}}
+	if policy.Image != "" {
+		if metadataType, data, err := lnImageMetadata(policy.Image); err == nil {
+			metadata = append(metadata, []string{metadataType, data})
+		}
+	}
+	b, _ := json.Marshal(metadata)
+	return string(b)
+}
+
+// receivableSats is the range of amounts a card can be paid in sats: its
+// receive limits, with the maximum lowered to the room left under its
+// balance cap. ok is false when the card can take no payment at all.
+func receivableSats(policy db.ReceiveSettings, balanceMsat int64) (minSats int, maxSats int, ok bool) {
+	minSats, maxSats = policy.MinSats, policy.MaxSats
+	if policy.MaxBalanceSats > 0 {
+		room := db.MsatToSatsDown(db.SatsToMsat(policy.MaxBalanceSats) - balanceMsat)
+		if room < maxSats {
+			maxSats = room
+		}
+	}
+	return minSats, maxSats, maxSats >= minSats && maxSats > 0
+}
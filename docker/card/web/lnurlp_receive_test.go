@@ -0,0 +1,127 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLnurlpRequest_CardReceiveSettings(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 1500)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+
+	const image = "data:image/png;base64,iVBORw0KGgo="
+	err := db.Db_set_card_receive_settings(app.db_write, cardId, db.ReceiveSettings{
+		MinSats: 10, MaxSats: 5000, MaxBalanceSats: 2000, Description: "Coffee stall", Image: image})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address, nil)
+	r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpRequest().ServeHTTP(w, r)
+
+	var resp struct {
+		MinSendable int64  `json:"minSendable"`
+		MaxSendable int64  `json:"maxSendable"`
+		Metadata    string `json:"metadata"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	// 500 sats of room is left under the 2000 sat cap
+	if resp.MinSendable != 10000 || resp.MaxSendable != 500000 {
+		t.Fatalf("expected 10000..500000 msat, got %s", w.Body.String())
+	}
+
+	var metadata [][]string
+	if err := json.Unmarshal([]byte(resp.Metadata), &metadata); err != nil {
+		t.Fatalf("metadata is not JSON: %v", err)
+	}
+	want := [][]string{
+		{"text/plain", "Coffee stall"},
+		{"text/identifier", card.Ln_address + "@test.example.com"},
+		{"image/png;base64", "iVBORw0KGgo="},
+	}
+	if len(metadata) != len(want) {
+		t.Fatalf("unexpected metadata: %v", metadata)
+	}
+	for i := range want {
+		if metadata[i][0] != want[i][0] || metadata[i][1] != want[i][1] {
+			t.Fatalf("unexpected metadata entry %d: %v", i, metadata[i])
+		}
+	}
+}
+
+func TestLnurlpCallback_RefusesAmountOverBalanceCap(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 1500)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+	db.Db_set_card_receive_settings(app.db_write, cardId, db.ReceiveSettings{MaxBalanceSats: 2000})
+	descriptionHashes := mockCreateInvoice(t, "c0ffee0123456789")
+
+	w := lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"501000"}})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an amount over the cap to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"500000"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected an amount up to the cap to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	<-descriptionHashes
+
+	// the unpaid invoice counts against the cap until it is paid or expires
+	w = lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"1000"}})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected the unpaid invoice to use up the room, got %d: %s", w.Code, w.Body.String())
+	}
+	app.db_write.Exec(`UPDATE card_receipts SET expire_time = unixepoch() - 1 WHERE r_hash_hex = 'c0ffee0123456789'`)
+	if got := db.Db_get_card_committed_balance_msat(app.db_read, cardId); got != 1500_000 {
+		t.Fatalf("expected an expired invoice not to count, got %d msat", got)
+	}
+
+	// a full card offers no payment at all
+	db.Db_set_card_receive_settings(app.db_write, cardId, db.ReceiveSettings{MaxBalanceSats: 1500})
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address, nil)
+	r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+	w = httptest.NewRecorder()
+	app.CreateHandler_LnurlpRequest().ServeHTTP(w, r)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["status"] != "ERROR" {
+		t.Fatalf("expected a full card to refuse payments, got %s", w.Body.String())
+	}
+}
+
+func TestAdminApiSetCardReceive_Validates(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	token := setupAdminSession(t, app)
+	cardId := insertFundedCard(t, app.db_write, 0)
+
+	for body, want := range map[string]int{
+		`{"receiveMinSats":500,"receiveMaxSats":100}`:   http.StatusBadRequest,
+		`{"lnImage":"https://example.com/a.png"}`:       http.StatusBadRequest,
+		`{"receiveMaxSats":100,"lnDescription":"Tips"}`: http.StatusOK,
+	} {
+		r := httptest.NewRequest("PUT", "/admin/api/cards/"+strconv.Itoa(cardId)+"/receive", strings.NewReader(body))
+		r.AddCookie(&http.Cookie{Name: "admin_session_token", Value: token})
+		w := httptest.NewRecorder()
+		app.CreateHandler_AdminApi().ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d: %s", body, want, w.Code, w.Body.String())
+		}
+	}
+
+	receive, _ := db.Db_get_card_receive_settings(app.db_read, cardId)
+	if receive.MaxSats != 100 || receive.Description != "Tips" {
+		t.Fatalf("expected the valid settings saved, got %+v", receive)
+	}
+}
@@ -0,0 +1,59 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func lnurlpVerify(app *App, username string, hash string) (int, map[string]any) {
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+username+"/verify/"+hash, nil)
+	r = mux.SetURLVars(r, map[string]string{"username": username, "hash": hash})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpVerify().ServeHTTP(w, r)
+	var resp map[string]any
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestLnurlpVerify_ReportsSettlement(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+
+	const paymentHash = "c0ffee0123456789"
+	mockCreateInvoice(t, paymentHash)
+
+	w := lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"21000"}})
+	var callback map[string]any
+	json.Unmarshal(w.Body.Bytes(), &callback)
+	wantVerify := "https://test.example.com/.well-known/lnurlp/" + card.Ln_address + "/verify/" + paymentHash
+	if callback["verify"] != wantVerify {
+		t.Fatalf("expected verify %s, got %v", wantVerify, callback["verify"])
+	}
+
+	code, resp := lnurlpVerify(app, card.Ln_address, paymentHash)
+	if code != http.StatusOK || resp["status"] != "OK" || resp["settled"] != false ||
+		resp["preimage"] != nil || resp["pr"] != "lnbc210n1mockzap" {
+		t.Fatalf("expected an unsettled invoice, got %d %v", code, resp)
+	}
+
+	app.settleReceipt(paymentHash, "5eed", "websocket")
+
+	code, resp = lnurlpVerify(app, card.Ln_address, paymentHash)
+	if code != http.StatusOK || resp["settled"] != true || resp["preimage"] != "5eed" {
+		t.Fatalf("expected a settled invoice with its preimage, got %d %v", code, resp)
+	}
+
+	if code, resp = lnurlpVerify(app, card.Ln_address, "0123"); code != http.StatusNotFound || resp["status"] != "ERROR" {
+		t.Fatalf("expected an unknown hash not to be found, got %d %v", code, resp)
+	}
+	if code, _ = lnurlpVerify(app, "c.00000000", paymentHash); code != http.StatusNotFound {
+		t.Fatalf("expected another address not to verify the invoice, got %d", code)
+	}
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"card/db"
+	"card/nostr"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LUD-18 payer data fields asked for on lightning address payments, none
+// of them mandatory
+var lnurlpPayerData = map[string]any{
+	"name":       map[string]bool{"mandatory": false},
+	"identifier": map[string]bool{"mandatory": false},
+}
+
+const (
+	maxPayerDataLength  = 1024
+	maxZapRequestLength = 4096
+	maxZapRelays        = 10
+)
+
+// parsePayerData checks the payerdata sent with a lightning address payment
+// holds only the fields asked for, as strings.
+func parsePayerData(payerData string) error {
+	if len(payerData) > maxPayerDataLength {
+		return errors.New("payerdata too long")
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(payerData), &fields); err != nil {
+		return errors.New("payerdata is not a JSON object")
+	}
+	for name, value := range fields {
+		if _, asked := lnurlpPayerData[name]; !asked {
+			return errors.New("payerdata field " + name + " not supported")
+		}
+		if _, ok := value.(string); !ok {
+			return errors.New("payerdata field " + name + " must be a string")
+		}
+	}
+	return nil
+}
+
+// parseZapRequest checks a NIP-57 zap request sent with a lightning address
+// payment of amountMsat, as the NIP's appendix D requires.
+func parseZapRequest(zapRequest string, amountMsat int64) (nostr.Event, error) {
+	var event nostr.Event
+	if len(zapRequest) > maxZapRequestLength {
+		return event, errors.New("zap request too long")
+	}
+	if err := json.Unmarshal([]byte(zapRequest), &event); err != nil {
+		return event, errors.New("zap request is not a nostr event")
+	}
+	if event.Kind != nostr.KindZapRequest {
+		return event, errors.New("zap request has the wrong kind")
+	}
+	if err := event.Verify(); err != nil {
+		return event, errors.New("zap request " + err.Error())
+	}
+	if len(event.Tags) == 0 {
+		return event, errors.New("zap request has no tags")
+	}
+
+	recipients := event.TagValues("p")
+	if len(recipients) != 1 || !nostr.IsHexKey(recipients[0]) {
+		return event, errors.New("zap request must have one p tag")
+	}
+	if events := event.TagValues("e"); len(events) > 1 || (len(events) == 1 && !nostr.IsHexKey(events[0])) {
+		return event, errors.New("zap request must have at most one e tag")
+	}
+	if senders := event.TagValues("P"); len(senders) > 1 || (len(senders) == 1 && !nostr.IsHexKey(senders[0])) {
+		return event, errors.New("zap request must have at most one P tag")
+	}
+	if amounts := event.TagValues("amount"); len(amounts) > 0 {
+		if len(amounts) > 1 || amounts[0] != strconv.FormatInt(amountMsat, 10) {
+			return event, errors.New("zap request amount does not match")
+		}
+	}
+	if coordinates := event.TagValues("a"); len(coordinates) > 0 {
+		// kind:pubkey:d-identifier
+		parts := strings.SplitN(coordinates[0], ":", 3)
+		if len(coordinates) > 1 || len(parts) != 3 || !nostr.IsHexKey(parts[1]) {
+			return event, errors.New("zap request a tag is not an event coordinate")
+		}
+		if _, err := strconv.Atoi(parts[0]); err != nil {
+			return event, errors.New("zap request a tag is not an event coordinate")
+		}
+	}
+	return event, nil
+}
+
+// zapSecret returns the key zap receipts are signed with.
+func zapSecret(app *App) string {
+	return db.Db_get_setting(app.db_read, "nostr_zap_secret")
+}
+
+// settleReceipt marks a receipt paid and, for a zap, publishes its receipt.
+func (app *App) settleReceipt(paymentHash string, preimage string, settledBy string) {
+	db.Db_set_receipt_paid_with_preimage(app.db_write, paymentHash, preimage, settledBy)
+	go app.publishZapReceipt(paymentHash)
+}
+
+// publishZapReceipt signs the NIP-57 zap receipt for a paid receipt that
+// came with a zap request and publishes it to the relays the request lists.
+func (app *App) publishZapReceipt(paymentHash string) {
+	zap, found := db.Db_claim_zap_receipt(app.db_write, paymentHash)
+	if !found {
+		return
+	}
+
+	var request nostr.Event
+	if err := json.Unmarshal([]byte(zap.ZapRequest), &request); err != nil {
+		log.Error("zap request unmarshal error: ", err)
+		return
+	}
+
+	tags := [][]string{request.Tag("p")}
+	if tag := request.Tag("e"); tag != nil {
+		tags = append(tags, tag)
+	}
+	if tag := request.Tag("a"); tag != nil {
+		tags = append(tags, tag)
+	}
+	tags = append(tags,
+		[]string{"P", request.PubKey},
+		[]string{"bolt11", zap.Invoice},
+		[]string{"description", zap.ZapRequest})
+
+	createdAt := zap.SettledAt
+	if createdAt == 0 {
+		createdAt = time.Now().Unix()
+	}
+	receipt := nostr.Event{CreatedAt: createdAt, Kind: nostr.KindZapReceipt, Tags: tags, Content: ""}
+	if err := receipt.Sign(zapSecret(app)); err != nil {
+		log.Error("zap receipt sign error: ", err)
+		return
+	}
+	db.Db_set_zap_receipt_id(app.db_write, paymentHash, receipt.ID)
+
+	publisher := app.zapPublisher
+	if publisher == nil {
+		publisher = nostr.RelayPublisher{Timeout: 10 * time.Second}
+	}
+	relays := request.Tag("relays")
+	if len(relays) > 0 {
+		relays = relays[1:]
+	}
+	for i, relay := range relays {
+		if i == maxZapRelays {
+			break
+		}
+		if err := publisher.Publish(relay, receipt); err != nil {
+			log.Warn("zap receipt ", receipt.ID, " not published to ", relay, ": ", err)
+			continue
+		}
+		log.Info("zap receipt ", receipt.ID, " published to ", relay)
+	}
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"card/db"
+	"card/nostr"
+	"card/phoenix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const zapSenderSecret = "0000000000000000000000000000000000000000000000000000000000000001"
+
+// testRelayPublisher stands in for the relays a zap receipt is published to.
+type testRelayPublisher struct {
+	published chan publishedEvent
+}
+
+type publishedEvent struct {
+	relay string
+	event nostr.Event
+}
+
+func (p testRelayPublisher) Publish(relayURL string, event nostr.Event) error {
+	p.published <- publishedEvent{relayURL, event}
+	return nil
+}
+
+// mockCreateInvoice answers /createinvoice and hands over the description
+// hash each invoice was asked for.
+func mockCreateInvoice(t *testing.T, paymentHash string) (descriptionHashes chan string) {
+	t.Helper()
+	descriptionHashes = make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/createinvoice" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		descriptionHashes <- r.PostForm.Get("descriptionHash")
+		json.NewEncoder(w).Encode(phoenix.CreateInvoiceResponse{
+			AmountSat:   21,
+			PaymentHash: paymentHash,
+			Serialized:  "lnbc210n1mockzap",
+		})
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(phoenix.UseMockPhoenix(srv.URL))
+	return descriptionHashes
+}
+
+func lnurlpCallback(app *App, username string, query url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+username+"/callback?"+query.Encode(), nil)
+	r = mux.SetURLVars(r, map[string]string{"username": username})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpCallback().ServeHTTP(w, r)
+	return w
+}
+
+func signedZapRequest(t *testing.T, tags [][]string, content string) string {
+	t.Helper()
+	request := nostr.Event{CreatedAt: time.Now().Unix(), Kind: nostr.KindZapRequest, Tags: tags, Content: content}
+	if err := request.Sign(zapSenderSecret); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(request)
+	return string(b)
+}
+
+func TestLnurlpRequest_AdvertisesZapsAndPayerData(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address, nil)
+	r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpRequest().ServeHTTP(w, r)
+
+	var resp struct {
+		AllowsNostr bool                       `json:"allowsNostr"`
+		NostrPubkey string                     `json:"nostrPubkey"`
+		PayerData   map[string]json.RawMessage `json:"payerData"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	wantPubkey, _ := nostr.PublicKey(db.Db_get_setting(app.db_read, "nostr_zap_secret"))
+	if !resp.AllowsNostr || resp.NostrPubkey != wantPubkey {
+		t.Fatalf("expected zaps allowed with pubkey %s, got %s", wantPubkey, w.Body.String())
+	}
+	if _, ok := resp.PayerData["name"]; !ok {
+		t.Fatalf("expected payerData to ask for a name, got %s", w.Body.String())
+	}
+}
+
+func TestLnurlpCallback_ZapPublishesReceipt(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	published := make(chan publishedEvent, 2)
+	app.zapPublisher = testRelayPublisher{published}
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+
+	const paymentHash = "c0ffee0123456789"
+	descriptionHashes := mockCreateInvoice(t, paymentHash)
+
+	recipient := "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+	zapRequest := signedZapRequest(t, [][]string{
+		{"relays", "wss://relay.one", "wss://relay.two"},
+		{"amount", "21000"},
+		{"p", recipient},
+	}, "great post")
+
+	w := lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"21000"}, "nostr": {zapRequest}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := <-descriptionHashes; got != descriptionHash(zapRequest) {
+		t.Fatalf("expected the invoice to commit to the zap request, got description hash %s", got)
+	}
+	receipts := db.Db_select_card_receipts(app.db_read, cardId, 1)
+	if len(receipts) != 1 || receipts[0].Comment != "great post" {
+		t.Fatalf("expected the zap content as the comment, got %+v", receipts)
+	}
+
+	app.settleReceipt(paymentHash, "", "test")
+
+	relays := map[string]bool{}
+	var receipt nostr.Event
+	for range 2 {
+		p := <-published
+		relays[p.relay] = true
+		receipt = p.event
+	}
+	if !relays["wss://relay.one"] || !relays["wss://relay.two"] {
+		t.Fatalf("expected the receipt on both relays, got %v", relays)
+	}
+	if err := receipt.Verify(); err != nil {
+		t.Fatalf("expected a signed zap receipt: %v", err)
+	}
+	senderPubkey, _ := nostr.PublicKey(zapSenderSecret)
+	if receipt.Kind != nostr.KindZapReceipt ||
+		receipt.Tag("p")[1] != recipient ||
+		receipt.Tag("P")[1] != senderPubkey ||
+		receipt.Tag("bolt11")[1] != "lnbc210n1mockzap" ||
+		receipt.Tag("description")[1] != zapRequest {
+		t.Fatalf("unexpected zap receipt: %+v", receipt)
+	}
+
+	// settling again, as the poller might, does not zap twice
+	app.publishZapReceipt(paymentHash)
+	select {
+	case p := <-published:
+		t.Fatalf("expected one zap receipt, got another: %+v", p)
+	default:
+	}
+}
+
+func TestLnurlpCallback_ZapRequestRejected(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+	mockCreateInvoice(t, "c0ffee0123456789")
+
+	recipient := "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+	wrongAmount := signedZapRequest(t, [][]string{{"p", recipient}, {"amount", "1000"}}, "")
+	twoRecipients := signedZapRequest(t, [][]string{{"p", recipient}, {"p", recipient}}, "")
+	var tampered nostr.Event
+	json.Unmarshal([]byte(signedZapRequest(t, [][]string{{"p", recipient}}, "")), &tampered)
+	tampered.Content = "changed"
+	tamperedJSON, _ := json.Marshal(tampered)
+
+	for name, zapRequest := range map[string]string{
+		"wrong amount":   wrongAmount,
+		"two recipients": twoRecipients,
+		"bad signature":  string(tamperedJSON),
+		"not an event":   "{}",
+	} {
+		w := lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"21000"}, "nostr": {zapRequest}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+	if receipts := db.Db_select_card_receipts(app.db_read, cardId, 1); len(receipts) != 0 {
+		t.Fatalf("expected no receipt for a rejected zap, got %+v", receipts)
+	}
+}
+
+func TestLnurlpCallback_PayerData(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+	descriptionHashes := mockCreateInvoice(t, "c0ffee0123456789")
+
+	w := lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"21000"}, "payerdata": {`{"email":"a@b.c"}`}})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a field not asked for to be rejected, got %d", w.Code)
+	}
+
+	payerData := `{"name":"Alice"}`
+	w = lnurlpCallback(app, card.Ln_address, url.Values{"amount": {"21000"}, "payerdata": {payerData}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	metadata := lnurlpMetadata(card.Ln_address, db.Db_get_setting(app.db_read, "host_domain"),
+		db.Db_get_card_receive_policy(app.db_read, cardId))
+	if got := <-descriptionHashes; got != descriptionHash(metadata+payerData) {
+		t.Fatalf("expected the invoice to commit to metadata and payer data, got %s", got)
+	}
+}
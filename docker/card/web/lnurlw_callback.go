@@ -134,7 +134,9 @@ func (app *App) CreateHandler_LnurlwCallback() http.HandlerFunc {
 			lnurlError(w, "invalid invoice amount")
 			return
 		}
-		amountSats := int(bolt11.MSatoshi / 1000)
+		// the card is debited the exact invoice amount; sats are rounded up
+		amountMsat := bolt11.MSatoshi
+		amountSats := db.MsatToSatsUp(amountMsat)
 
 		// detect gift card use, i.e. sweeping of max_withdraw_sats amount
 		if amountSats == 100_000_000 {
@@ -142,14 +144,21 @@ func (app *App) CreateHandler_LnurlwCallback() http.HandlerFunc {
 			return
 		}
 
-		// atomically check balance and reserve funds (BEGIN IMMEDIATE transaction)
-		max_network_fee_sats := 4 + amountSats*4/1000 // Phoenix fee: 0.4% + 4 sat
+		// atomically check balance and reserve funds with the fee reserve
+		// (BEGIN IMMEDIATE transaction)
+		feePolicy := loadFeePolicy(app.db_read)
+		max_network_fee_sats := feePolicy.ReserveSats(amountSats)
 
 		log.Info("amountSats ", amountSats)
 		log.Info("max_network_fee_sats ", max_network_fee_sats)
 
-		balance, card_payment_id, err := db.Db_reserve_card_payment(
-			app.db_write, cardId, amountSats+max_network_fee_sats, amountSats, param_pr)
+		balanceMsat, card_payment_id, err := db.Db_reserve_card_payment_msat(
+			app.db_write, cardId, amountMsat, db.SatsToMsat(max_network_fee_sats), param_pr)
+		if errors.Is(err, db.ErrCardNotActive) {
+			log.Info("card status does not allow withdrawals")
+			lnurlError(w, "card not active")
+			return
+		}
 		if errors.Is(err, db.ErrTxLimitExceeded) {
 			log.Info("payment exceeds card transaction limit")
 			lnurlError(w, "amount exceeds card limit")
@@ -161,12 +170,12 @@ func (app *App) CreateHandler_LnurlwCallback() http.HandlerFunc {
 			return
 		}
 		if errors.Is(err, db.ErrInsufficientFunds) {
-			if amountSats > balance {
+			if amountMsat > balanceMsat {
 				log.Info("insufficient funds on card")
 				lnurlError(w, "Insufficient funds")
 			} else {
 				log.Info("insufficient funds on card with network fees")
-				lnurlError(w, "Insufficient funds with network fees")
+				lnurlError(w, insufficientFeeReserveMessage(feePolicy, amountSats))
 			}
 			return
 		}
@@ -176,7 +185,7 @@ func (app *App) CreateHandler_LnurlwCallback() http.HandlerFunc {
 			return
 		}
 
-		log.Info("total_card_balance ", balance)
+		log.Info("total_card_balance_msat ", balanceMsat)
 
 		// per-transaction and daily limits are enforced atomically inside
 		// Db_reserve_card_payment above (ErrTxLimitExceeded / ErrDayLimitExceeded)
@@ -185,6 +194,7 @@ func (app *App) CreateHandler_LnurlwCallback() http.HandlerFunc {
 		var payInvoiceRequest phoenix.SendLightningPaymentRequest
 		payInvoiceRequest.Invoice = param_pr
 		payInvoiceRequest.AmountSat = strconv.Itoa(amountSats)
+		payInvoiceRequest.MaxFeeSat = strconv.Itoa(max_network_fee_sats)
 
 		log.Info("attempting payment")
 		payInvoiceResponse, payInvoiceResult, err := phoenix.SendLightningPayment(payInvoiceRequest)
@@ -204,8 +214,12 @@ func (app *App) CreateHandler_LnurlwCallback() http.HandlerFunc {
 			return
 		}
 
-		// payment succeeded — record the routing fee
-		db.Db_update_card_payment_fee(app.db_write, card_payment_id, payInvoiceResponse.RoutingFeeSat)
+		// payment succeeded — settle the routing fee in place of the reserve
+		if err := db.Db_settle_card_payment(app.db_write, card_payment_id,
+			db.SatsToMsat(payInvoiceResponse.RoutingFeeSat), bolt11.PaymentHash,
+			payInvoiceResponse.PaymentPreimage); err != nil {
+			log.Error("settle payment error, card_payment_id = ", card_payment_id, ": ", err)
+		}
 
 		// broadcast to websocket clients
 		app.broadcastPaymentSent(amountSats, bolt11.PaymentHash, time.Now().Unix())
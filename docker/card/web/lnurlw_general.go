@@ -6,33 +6,108 @@ import (
 	"database/sql"
 	"errors"
 	"net/url"
+	"strings"
 
 	"encoding/hex"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func Find_card(db_conn *sql.DB, p []byte, c []byte) (bool, int, uint32) {
+// CardTap is a tap matched to a card by Find_card_tap.
+type CardTap struct {
+	CardId     int
+	Uid        string // UID decrypted from p
+	Counter    uint32
+	KeyVersion int
+	Pending    bool   // matched the card's pending key set (rotation in progress)
+	Previous   bool   // matched a replaced key set still in its grace period
+	Status     string // card lifecycle status, set by findCard
+
+	UidMismatch bool // set by findCard when the tap was refused for a UID mismatch
+}
+
+// Find_card_tap matches p and c against every stored key set, including the
+// pending keys of cards part-way through a key rotation and replaced keys
+// still in their grace period.
+func Find_card_tap(db_conn *sql.DB, p []byte, c []byte) (CardTap, bool) {
 	cardKeys := db.Db_get_card_keys(db_conn)
 
 	log.Info("number of cards to check is ", len(cardKeys))
 
-	cardMatch := false
-	var cardId int
-	var ctr uint32
-
 	for _, cardKey := range cardKeys {
 
-		card_match, _, match_ctr := check_card_tap(p, c, cardKey.Key1, cardKey.Key2)
+		card_match, uid, match_ctr := check_card_tap(p, c, cardKey.Key1, cardKey.Key2)
 
 		if card_match {
-			cardMatch = true
-			cardId = cardKey.CardId
-			ctr = match_ctr
-			break
+			return CardTap{
+				CardId:     cardKey.CardId,
+				Uid:        uid,
+				Counter:    match_ctr,
+				KeyVersion: cardKey.KeyVersion,
+				Pending:    cardKey.Pending,
+				Previous:   cardKey.Previous,
+			}, true
+		}
+	}
+	return CardTap{}, false
+}
+
+func Find_card(db_conn *sql.DB, p []byte, c []byte) (bool, int, uint32) {
+	cardTap, cardMatch := Find_card_tap(db_conn, p, c)
+	return cardMatch, cardTap.CardId, cardTap.Counter
+}
+
+// findCard matches a tap to a card. The UID decrypted from the tap must match
+// the UID on record for the card; a card without one has it filled in on its
+// first tap. A tap made with a card's pending keys shows the chip has been
+// re-programmed, so the rotation is completed and the previous keys are
+// retired. A tap made with a replaced key set is accepted during its grace
+// period and left flagged as Previous; a tap with the current keys ends the
+// grace. A card still pending_programming becomes active on its first tap;
+// callers check cardTap.Status for anything else.
+func (app *App) findCard(p []byte, c []byte) (CardTap, bool) {
+	cardTap, cardMatch := Find_card_tap(app.db_read, p, c)
+	if !cardMatch {
+		return cardTap, false
+	}
+
+	cardUid := db.Db_get_card_uid(app.db_read, cardTap.CardId)
+	if cardUid == "" {
+		log.Info("card_id = ", cardTap.CardId, " recording uid ", cardTap.Uid)
+		db.Db_set_card_uid_if_empty(app.db_write, cardTap.CardId, cardTap.Uid)
+	} else if !strings.EqualFold(cardUid, cardTap.Uid) {
+		log.Warn("card_id = ", cardTap.CardId, " uid mismatch, expected ",
+			cardUid, " got ", cardTap.Uid)
+		app.raiseSecurityEvent(db.CardSecurityEvent{
+			CardId:    cardTap.CardId,
+			EventType: db.SecurityEventUidMismatch,
+			Detail:    "expected " + cardUid + ", tapped " + cardTap.Uid,
+			Action:    db.SecurityActionReject,
+		})
+		cardTap.UidMismatch = true
+		return cardTap, false
+	}
+
+	if cardTap.Pending {
+		log.Info("card_id = ", cardTap.CardId, " tapped with key version ",
+			cardTap.KeyVersion, ", retiring previous keys")
+		db.Db_promote_card_pending_keys(app.db_write, cardTap.CardId)
+		cardTap.Pending = false
+	} else if cardTap.Previous {
+		log.Warn("card_id = ", cardTap.CardId, " tapped with replaced key version ",
+			cardTap.KeyVersion, ", chip has not been re-programmed")
+	} else {
+		db.Db_end_card_key_grace(app.db_write, cardTap.CardId)
+	}
+
+	cardTap.Status = db.Db_get_card_status(app.db_read, cardTap.CardId)
+	if cardTap.Status == db.CardStatusPendingProgramming {
+		err := db.Db_set_card_status(app.db_write, cardTap.CardId, db.CardStatusActive, "first tap", "system")
+		if err == nil {
+			cardTap.Status = db.CardStatusActive
 		}
 	}
-	return cardMatch, cardId, ctr
+	return cardTap, cardMatch
 }
 
 func check_card_tap(p []byte, c []byte, key1_str string, k2_str string) (card_found bool, uid_str string, counter uint32) {
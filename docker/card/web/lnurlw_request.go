@@ -37,35 +37,53 @@ func (app *App) CreateHandler_LnurlwRequest() http.HandlerFunc {
 			return
 		}
 
-		cardMatch, cardId, ctr := Find_card(app.db_read, p, c)
+		cardTap, cardMatch := app.findCard(p, c)
 
 		if !cardMatch {
 			log.Info("card not found")
+			app.recordTap(r, tapEndpointLn, cardTap, tapNotMatchedOutcome(cardTap))
 			w.Write([]byte(`{"status": "ERROR", "reason": "card not found"}`))
 			return
 		}
 
+		cardId, ctr := cardTap.CardId, cardTap.Counter
+
 		log.Info("card_id = " + strconv.Itoa(cardId))
 
 		// check counter is incremented
 		cardLastCounter := db.Db_get_card_counter(app.db_read, cardId)
 		if ctr <= cardLastCounter {
 			log.Info("card counter not incremented")
+			app.recordTap(r, tapEndpointLn, cardTap, db.TapOutcomeCounterNotIncremented)
 			w.Write([]byte(`{"status": "ERROR", "reason": "card counter not incremented"}`))
 			return
 		}
 
 		// store new counter value
 		db.Db_set_card_counter(app.db_write, cardId, ctr)
+		app.checkCounterJump(r, cardTap, cardLastCounter)
+
+		// check the card's status allows withdrawals (re-read, as the counter
+		// check above may have suspended the card)
+		cardStatus := db.Db_get_card_status(app.db_read, cardId)
+		if !db.Card_status_allows_withdraw(cardStatus) {
+			log.Info("card status is ", cardStatus)
+			app.recordTap(r, tapEndpointLn, cardTap, db.TapOutcomeCardNotActive)
+			w.Write([]byte(`{"status": "ERROR", "reason": "card ` + cardStatus + `"}`))
+			return
+		}
 
 		// check card withdrawals are enabled
 		lnurlwEnable := db.Db_get_card_lnurlw_enable(app.db_read, cardId)
 		if lnurlwEnable != "Y" {
 			log.Info("card withdrawals disabled")
+			app.recordTap(r, tapEndpointLn, cardTap, db.TapOutcomeWithdrawalsDisabled)
 			w.Write([]byte(`{"status": "ERROR", "reason": "withdrawals disabled"}`))
 			return
 		}
 
+		app.recordTap(r, tapEndpointLn, cardTap, db.TapOutcomeOk)
+
 		// create and store lnurlw_k1
 		lnurlwK1 := util.Random_hex()
 		k1TimeoutSecs := 10 // default
@@ -3,12 +3,13 @@ package web
 import (
 	"card/db"
 	"card/phoenix"
-	"database/sql"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/gorilla/mux"
 )
 
 // newTestAppNoPollers builds an App backed by an initialised in-memory DB
@@ -18,12 +19,7 @@ import (
 // pollers of their own so the mutation cannot race a concurrent poll.
 func newTestAppNoPollers(t *testing.T) *App {
 	t.Helper()
-	db_conn, err := sql.Open("sqlite3", ":memory:?_foreign_keys=1")
-	if err != nil {
-		t.Fatal(err)
-	}
-	t.Cleanup(func() { db_conn.Close() })
-	db.Db_init(db_conn)
+	db_conn := openTestDB(t)
 	return &App{db_read: db_conn, db_write: db_conn, hub: newWsHub(), stop: make(chan struct{})}
 }
 
@@ -148,3 +144,60 @@ func TestAddInvoice_RequiresAuth(t *testing.T) {
 		t.Fatal("expected an error for unauthenticated request")
 	}
 }
+
+// TestLnurlpCallback_StoresComment verifies a LUD-12 payer comment is kept on
+// the receipt and shown in the card history, the LndHub API and the
+// payment_received event.
+func TestLnurlpCallback_StoresComment(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+
+	const paymentHash = "c0ffee0123456789"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/createinvoice" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(phoenix.CreateInvoiceResponse{
+			AmountSat:   21,
+			PaymentHash: paymentHash,
+			Serialized:  "lnbc210n1mockcomment",
+		})
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address+
+		"/callback?amount=21000&comment=from+alice", nil)
+	r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpCallback().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	receipts := db.Db_select_card_receipts(app.db_read, cardId, 1)
+	if len(receipts) != 1 || receipts[0].Comment != "from alice" {
+		t.Fatalf("expected the comment on the receipt, got %+v", receipts)
+	}
+
+	ch := app.hub.subscribe()
+	defer app.hub.unsubscribe(ch)
+	db.Db_set_receipt_paid(app.db_write, paymentHash, "test")
+	app.broadcastPaymentReceived(21, paymentHash, 0)
+	var event wsPaymentEvent
+	json.Unmarshal(<-ch, &event)
+	if event.Type != "payment_received" || event.Comment != "from alice" {
+		t.Fatalf("expected the comment in the websocket event, got %+v", event)
+	}
+
+	txs := db.Db_select_card_wallet_txs(app.db_read, cardId, 1, 0)
+	if len(txs) != 1 || walletTransaction(txs[0]).Memo != "from alice" {
+		t.Fatalf("expected the comment as the /gettxs memo, got %+v", txs)
+	}
+	cardTxs := db.Db_select_card_txs(app.db_read, cardId)
+	if len(cardTxs) != 1 || cardTxs[0].Comment != "from alice" {
+		t.Fatalf("expected the comment in the card history, got %+v", cardTxs)
+	}
+}
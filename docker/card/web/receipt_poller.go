@@ -0,0 +1,134 @@
+package web
+
+import (
+	"card/db"
+	"card/phoenix"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	receiptPollInterval = 30 * time.Second
+	receiptPollPageSize = 100 // payments per Phoenix list call
+	receiptPollMaxPages = 20  // list calls per poll
+
+	// a receipt is recorded just after Phoenix creates its invoice
+	receiptPollFromMargin = 60 // seconds
+)
+
+// receiptPollerStats is what the receipt poller reports on the admin
+// dashboard. Lag is the time since the last poll that got through every
+// payment Phoenix had for the pending receipts.
+type receiptPollerStats struct {
+	mu           sync.Mutex
+	startedAt    time.Time
+	lastPolledAt time.Time
+	pending      int
+	expired      int // marked expired since the poller started
+}
+
+type receiptPollerView struct {
+	LagSec       int64 `json:"lagSec"`
+	LastPolledAt int64 `json:"lastPolledAt"` // 0 before the first complete poll
+	Pending      int   `json:"pending"`
+	Expired      int   `json:"expired"`
+}
+
+func (s *receiptPollerStats) view(now time.Time) receiptPollerView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := receiptPollerView{Pending: s.pending, Expired: s.expired}
+	since := s.startedAt
+	if !s.lastPolledAt.IsZero() {
+		since = s.lastPolledAt
+		v.LastPolledAt = s.lastPolledAt.Unix()
+	}
+	if !since.IsZero() {
+		v.LagSec = int64(now.Sub(since) / time.Second)
+	}
+	return v
+}
+
+// startReceiptPoller settles receipts Phoenix has been paid for every 30s.
+// This is a backstop for any payments missed by the WebSocket listener
+// (e.g. during restarts). It exits when app.stop is closed.
+func (app *App) startReceiptPoller() {
+	app.receiptPoller.mu.Lock()
+	app.receiptPoller.startedAt = time.Now()
+	app.receiptPoller.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(receiptPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-app.stop:
+				return
+			case <-ticker.C:
+				app.pollReceipts()
+			}
+		}
+	}()
+}
+
+// pollReceipts marks stale receipts expired, then settles the pending ones
+// Phoenix lists as paid. Rather than one call per receipt it pages through
+// the paid payments for invoices created since the oldest pending receipt.
+func (app *App) pollReceipts() {
+	if expired := db.Db_expire_receipts(app.db_write); expired > 0 {
+		log.Info("receipt poller marked ", expired, " receipts expired")
+		app.receiptPoller.mu.Lock()
+		app.receiptPoller.expired += expired
+		app.receiptPoller.mu.Unlock()
+	}
+
+	unpaid := db.Db_select_unpaid_receipts(app.db_read)
+	pending := make(map[string]bool, len(unpaid))
+	var from int64
+	for _, r := range unpaid {
+		pending[r.PaymentHash] = true
+		if from == 0 || r.Timestamp < from {
+			from = r.Timestamp
+		}
+	}
+
+	complete := len(unpaid) == 0
+	for page := 0; page < receiptPollMaxPages && !complete; page++ {
+		select {
+		case <-app.stop:
+			return
+		default:
+		}
+
+		payments, err := phoenix.ListPaidIncomingPaymentsSince((from-receiptPollFromMargin)*1000, receiptPollPageSize,
+			page*receiptPollPageSize)
+		if err != nil {
+			log.Warn("receipt poll error: ", err)
+			break
+		}
+		for _, p := range payments {
+			if !p.IsPaid || !pending[p.PaymentHash] {
+				continue
+			}
+			delete(pending, p.PaymentHash)
+			app.settleReceipt(p.PaymentHash, p.Preimage, "poller")
+			log.Info("receipt poller settled: ", p.PaymentHash)
+
+			app.broadcastPaymentReceived(p.ReceivedSat, p.PaymentHash, p.CompletedAt/1000)
+		}
+		complete = len(payments) < receiptPollPageSize || len(pending) == 0
+	}
+	if !complete {
+		log.Warn("receipt poll incomplete, ", len(pending), " receipts left to check")
+	}
+
+	app.receiptPoller.mu.Lock()
+	app.receiptPoller.pending = len(pending)
+	if complete {
+		app.receiptPoller.lastPolledAt = time.Now()
+	}
+	app.receiptPoller.mu.Unlock()
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"card/db"
+	"card/phoenix"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPollReceipts_SettlesFromPaidList(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_add_card_receipt(app.db_write, cardId, "lnbcpaid", "paidhash", 100)
+	db.Db_add_card_receipt(app.db_write, cardId, "lnbcpending", "pendinghash", 200)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		q := r.URL.Query()
+		if r.URL.Path != "/payments/incoming" || q.Get("from") == "" || q.Get("offset") != "0" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		w.Write([]byte(`[{"paymentHash":"paidhash","preimage":"paidpre","isPaid":true,"receivedSat":100},` +
+			`{"paymentHash":"otherhash","isPaid":true,"receivedSat":5}]`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	app.pollReceipts()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected one list call for both receipts, got %d", n)
+	}
+	paid, _ := db.Db_select_card_receipt_by_hash(app.db_read, cardId, "paidhash")
+	if paid.IsPaid != "Y" || paid.Preimage != "paidpre" {
+		t.Fatalf("expected paid receipt settled with its preimage, got %+v", paid)
+	}
+	pending, _ := db.Db_select_card_receipt_by_hash(app.db_read, cardId, "pendinghash")
+	if pending.IsPaid != "N" {
+		t.Fatalf("expected pending receipt left unpaid, got %+v", pending)
+	}
+
+	view := app.receiptPoller.view(time.Now())
+	if view.Pending != 1 || view.LastPolledAt == 0 || view.LagSec > 1 {
+		t.Fatalf("unexpected poller stats %+v", view)
+	}
+}
+
+func TestPollReceipts_ExpiresStaleAndSkipsPhoenix(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_add_card_receipt(app.db_write, cardId, "lnbcstale", "stalehash", 100)
+	app.db_write.Exec(`UPDATE card_receipts SET expire_time = unixepoch() - 86400`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Phoenix call: %s", r.URL)
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	app.pollReceipts()
+
+	view := app.receiptPoller.view(time.Now())
+	if view.Expired != 1 || view.Pending != 0 {
+		t.Fatalf("expected stale receipt expired, got %+v", view)
+	}
+}
+
+func TestPollReceipts_StopsWhenAppStops(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	db.Db_add_card_receipt(app.db_write, cardId, "lnbcpending", "pendinghash", 100)
+	close(app.stop)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Phoenix call after stop: %s", r.URL)
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	app.pollReceipts()
+
+	if view := app.receiptPoller.view(time.Now()); view.LastPolledAt != 0 {
+		t.Fatalf("expected no completed poll after stop, got %+v", view)
+	}
+}
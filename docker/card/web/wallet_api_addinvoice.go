@@ -39,6 +39,11 @@ func (app *App) CreateHandler_AddInvoice() http.HandlerFunc {
 			return
 		}
 
+		if !db.Card_status_allows_receive(db.Db_get_card_status(app.db_read, card_id)) {
+			sendError(w, "Error", 999, "card not active")
+			return
+		}
+
 		// get details from request body
 
 		decoder := json.NewDecoder(r.Body)
@@ -10,7 +10,8 @@ import (
 
 type BalanceResponse struct {
 	BTC struct {
-		AvailableBalance int `json:"AvailableBalance"`
+		AvailableBalance int   `json:"AvailableBalance"`
+		AvailableMsat    int64 `json:"AvailableMsat"`
 	} `json:"BTC"`
 	Error string `json:"error,omitempty"`
 }
@@ -33,6 +34,7 @@ func (app *App) CreateHandler_Balance() http.HandlerFunc {
 
 		var resObj BalanceResponse
 		resObj.BTC.AvailableBalance = total_card_balance
+		resObj.BTC.AvailableMsat = db.Db_get_card_balance_msat(app.db_read, card_id)
 
 		writeJSON(w, resObj)
 	}
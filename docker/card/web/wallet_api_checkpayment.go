@@ -0,0 +1,43 @@
+package web
+
+import (
+	"card/db"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+type CheckPaymentResponse struct {
+	Paid bool `json:"paid"`
+}
+
+// reports whether an invoice created by the card has been paid
+// /checkpayment/{payment_hash}
+func (app *App) CreateHandler_WalletApi_CheckPayment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("checkpayment request received")
+
+		card_id, ok := app.getAuthenticatedCardID(w, r)
+		if !ok {
+			return
+		}
+
+		paymentHash := mux.Vars(r)["payment_hash"]
+
+		cardReceipt, found := db.Db_select_card_receipt_by_hash(app.db_read, card_id, paymentHash)
+		if !found {
+			sendError(w, "Error", 8, "invoice not found")
+			return
+		}
+
+		// ask Phoenix about an invoice not yet seen as paid
+		if cardReceipt.IsPaid != "Y" {
+			app.updateInvoiceStatus(paymentHash)
+			cardReceipt, _ = db.Db_select_card_receipt_by_hash(app.db_write, card_id, paymentHash)
+		}
+
+		writeJSON(w, CheckPaymentResponse{Paid: cardReceipt.IsPaid == "Y"})
+	}
+}
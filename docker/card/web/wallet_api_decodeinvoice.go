@@ -0,0 +1,84 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	log "github.com/sirupsen/logrus"
+)
+
+// DecodeInvoiceResponse follows lnd's decodepayreq, as LndHub returns it,
+// with 64-bit integers as strings.
+type DecodeInvoiceResponse struct {
+	Destination     string      `json:"destination"`
+	PaymentHash     string      `json:"payment_hash"`
+	NumSatoshis     string      `json:"num_satoshis"`
+	Timestamp       string      `json:"timestamp"`
+	Expiry          string      `json:"expiry"`
+	Description     string      `json:"description"`
+	DescriptionHash string      `json:"description_hash"`
+	FallbackAddr    string      `json:"fallback_addr"`
+	CltvExpiry      string      `json:"cltv_expiry"`
+	RouteHints      []RouteHint `json:"route_hints"`
+	NumMsat         string      `json:"num_msat"`
+}
+
+type RouteHint struct {
+	HopHints []HopHint `json:"hop_hints"`
+}
+
+type HopHint struct {
+	NodeId                    string `json:"node_id"`
+	ChanId                    string `json:"chan_id"`
+	FeeBaseMsat               int    `json:"fee_base_msat"`
+	FeeProportionalMillionths int    `json:"fee_proportional_millionths"`
+	CltvExpiryDelta           int    `json:"cltv_expiry_delta"`
+}
+
+// /decodeinvoice?invoice=lnbc...
+func (app *App) CreateHandler_WalletApi_DecodeInvoice() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("decodeinvoice request received")
+
+		if _, ok := app.getAuthenticatedCardID(w, r); !ok {
+			return
+		}
+
+		bolt11, err := decodepay.Decodepay(r.URL.Query().Get("invoice"))
+		if err != nil {
+			sendError(w, "Error", 4, "invalid invoice")
+			return
+		}
+
+		var resObj DecodeInvoiceResponse
+		resObj.Destination = bolt11.Payee
+		resObj.PaymentHash = bolt11.PaymentHash
+		resObj.NumSatoshis = strconv.FormatInt(bolt11.MSatoshi/1000, 10)
+		resObj.Timestamp = strconv.Itoa(bolt11.CreatedAt)
+		resObj.Expiry = strconv.Itoa(bolt11.Expiry)
+		resObj.Description = bolt11.Description
+		resObj.DescriptionHash = bolt11.DescriptionHash
+		resObj.CltvExpiry = strconv.Itoa(bolt11.MinFinalCLTVExpiry)
+		resObj.NumMsat = strconv.FormatInt(bolt11.MSatoshi, 10)
+
+		resObj.RouteHints = make([]RouteHint, 0, len(bolt11.Route))
+		for _, route := range bolt11.Route {
+			var routeHint RouteHint
+			routeHint.HopHints = make([]HopHint, 0, len(route))
+			for _, hop := range route {
+				routeHint.HopHints = append(routeHint.HopHints, HopHint{
+					NodeId:                    hop.PubKey,
+					ChanId:                    hop.ShortChannelId,
+					FeeBaseMsat:               hop.FeeBaseMsat,
+					FeeProportionalMillionths: hop.FeeProportionalMillionths,
+					CltvExpiryDelta:           hop.CLTVExpiryDelta,
+				})
+			}
+			resObj.RouteHints = append(resObj.RouteHints, routeHint)
+		}
+
+		writeJSON(w, resObj)
+	}
+}
@@ -17,6 +17,8 @@ type CardResponse struct {
 	DayLimitSats string `json:"day_limit_sats"`
 	PinEnable    string `json:"pin_enable"`
 	PinLimitSats string `json:"pin_limit_sats"`
+	LnAddress    string `json:"ln_address"`
+	LnAlias      string `json:"ln_alias"`
 }
 
 func (app *App) CreateHandler_WalletApi_GetCard() http.HandlerFunc {
@@ -45,6 +47,8 @@ func (app *App) CreateHandler_WalletApi_GetCard() http.HandlerFunc {
 		resObj.DayLimitSats = strconv.Itoa(c.Day_limit_sats)
 		resObj.PinEnable = c.Pin_enable
 		resObj.PinLimitSats = strconv.Itoa(c.Pin_limit_sats)
+		resObj.LnAddress = c.Ln_address
+		resObj.LnAlias = c.Ln_alias
 
 		writeJSON(w, resObj)
 	}
@@ -34,10 +34,13 @@ func (app *App) CreateHandler_WalletApi_GetCardKeys() http.HandlerFunc {
 		// create new random card keys in database
 		key0, key1, k2, key3, key4 := generateCardKeys()
 
-		// TODO: archive card keys
-
+		// the replaced keys are archived and still accepted until the new
+		// keys are written to the chip
 		db.Db_set_card_keys(app.db_write, card_id, key0, key1, k2, key3, key4)
 
+		// tokens issued for the old card end with its keys
+		db.Db_revoke_card_tokens(app.db_write, card_id, db.TokenRevokedRekeyed)
+
 		var resObj CardKeysResponse
 
 		resObj.ProtocolName = "create_bolt_card_response"
@@ -0,0 +1,73 @@
+package web
+
+import (
+	"card/phoenix"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GetInfoResponse follows lnd's getinfo, as LndHub returns it, filled in from
+// the Phoenix node.
+type GetInfoResponse struct {
+	IdentityPubkey    string   `json:"identity_pubkey"`
+	Alias             string   `json:"alias"`
+	NumActiveChannels int      `json:"num_active_channels"`
+	BlockHeight       int      `json:"block_height"`
+	SyncedToChain     bool     `json:"synced_to_chain"`
+	Testnet           bool     `json:"testnet"`
+	Chains            []Chain  `json:"chains"`
+	Uris              []string `json:"uris"`
+	Version           string   `json:"version"`
+}
+
+type Chain struct {
+	Chain   string `json:"chain"`
+	Network string `json:"network"`
+}
+
+func (app *App) CreateHandler_WalletApi_GetInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("getinfo request received")
+
+		if _, ok := app.getAuthenticatedCardID(w, r); !ok {
+			return
+		}
+
+		nodeInfo, err := phoenix.GetInfo()
+		if err != nil {
+			log.Error("phoenix GetInfo error: ", err)
+			sendError(w, "Error", 7, "failed to get node info")
+			return
+		}
+
+		var resObj GetInfoResponse
+		resObj.IdentityPubkey = nodeInfo.NodeId
+		resObj.Alias = "phoenixd"
+		resObj.NumActiveChannels = len(nodeInfo.Channels)
+		resObj.BlockHeight = nodeInfo.BlockHeight
+		resObj.SyncedToChain = true
+		resObj.Testnet = nodeInfo.Chain != "mainnet"
+		resObj.Chains = []Chain{{Chain: "bitcoin", Network: nodeInfo.Chain}}
+		resObj.Uris = []string{}
+		resObj.Version = nodeInfo.Version
+
+		writeJSON(w, resObj)
+	}
+}
+
+// returns the card's on-chain deposit addresses, always none as cards are
+// funded over lightning only
+func (app *App) CreateHandler_WalletApi_GetBtc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("getbtc request received")
+
+		if _, ok := app.getAuthenticatedCardID(w, r); !ok {
+			return
+		}
+
+		writeJSON(w, []string{})
+	}
+}
@@ -1,18 +1,38 @@
 package web
 
 import (
+	"card/db"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// returns payments in flight, whose amount and fee reserve are held on the card
 func (app *App) CreateHandler_GetPending() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		log.Info("getPending request received")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		jsonData := []byte(`[]`) // array
-		w.Write(jsonData)
+
+		card_id, ok := app.getAuthenticatedCardID(w, r)
+		if !ok {
+			return
+		}
+
+		cardPayments := db.Db_select_card_pending_payments(app.db_read, card_id)
+
+		resObj := make(Transactions, 0, len(cardPayments))
+		for _, cardPayment := range cardPayments {
+			resObj = append(resObj, walletTransaction(db.WalletTx{
+				Kind:           cardPayment.Kind,
+				Timestamp:      cardPayment.Timestamp,
+				AmountSats:     cardPayment.AmountSats,
+				FeeSats:        cardPayment.FeeSats,
+				AmountMsat:     cardPayment.AmountMsat,
+				FeeMsat:        cardPayment.FeeMsat,
+				PaymentRequest: cardPayment.PaymentRequest,
+			}))
+		}
+
+		writeJSON(w, resObj)
 	}
 }
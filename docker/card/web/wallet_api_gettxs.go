@@ -2,10 +2,12 @@ package web
 
 import (
 	"card/db"
+	"card/util"
 
 	"net/http"
 	"strconv"
 
+	decodepay "github.com/nbd-wtf/ln-decodepay"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -15,16 +17,72 @@ type Transaction struct {
 		Type string `json:"type"`
 		Data []int  `json:"data"`
 	} `json:"payment_hash,omitempty"`
-	Type      string `json:"type"`
-	Fee       int    `json:"fee"`
-	Value     int    `json:"value"`
-	Timestamp string `json:"timestamp"`
-	Memo      string `json:"memo"`
+	PaymentRequest string `json:"payment_request,omitempty"`
+	IsPaid         bool   `json:"ispaid,omitempty"`
+	Type           string `json:"type"`
+	Fee            int    `json:"fee"`
+	Value          int    `json:"value"`
+	FeeMsat        int64  `json:"fee_msat"`
+	ValueMsat      int64  `json:"value_msat"`
+	Timestamp      string `json:"timestamp"`
+	Memo           string `json:"memo"`
 }
 
 type Transactions []Transaction
 
-// returns invoices paid
+// walletTransaction converts a history line to its LndHub form. The memo and,
+// for a payment recorded before hashes were kept, the payment hash come from
+// the bolt11 invoice; a payer comment on a receipt replaces the memo.
+func walletTransaction(walletTx db.WalletTx) Transaction {
+	var tx Transaction
+
+	paymentHash := walletTx.PaymentHash
+	if bolt11, err := decodepay.Decodepay(walletTx.PaymentRequest); err == nil {
+		tx.Memo = bolt11.Description
+		if paymentHash == "" {
+			paymentHash = bolt11.PaymentHash
+		}
+	} else if payoutDestinationKind(walletTx.PaymentRequest) == payoutToLnAddress {
+		// paid through /paylnaddress
+		tx.Memo = walletTx.PaymentRequest
+	}
+
+	tx.PaymentHash.Type = "Buffer"
+	tx.PaymentHash.Data = []int{}
+	if paymentHash != "" {
+		if data := util.ConvertPaymentHash(paymentHash); data != nil {
+			tx.PaymentHash.Data = data
+		}
+	}
+	tx.PaymentPreimage = walletTx.PaymentPreimage
+
+	if walletTx.Comment != "" {
+		tx.Memo = walletTx.Comment
+	}
+
+	switch {
+	case walletTx.Incoming:
+		tx.Type = "user_invoice"
+		tx.PaymentRequest = walletTx.PaymentRequest
+		tx.IsPaid = true
+	case walletTx.Kind == db.PaymentKindServiceFee:
+		tx.Type = "service_fee"
+		tx.Memo = "service fee"
+	default:
+		tx.Type = "paid_invoice"
+	}
+
+	tx.Fee = walletTx.FeeSats
+	tx.Value = walletTx.AmountSats
+	tx.FeeMsat = walletTx.FeeMsat
+	tx.ValueMsat = walletTx.AmountMsat
+	tx.Timestamp = strconv.Itoa(walletTx.Timestamp)
+
+	return tx
+}
+
+// returns invoices paid and receipts received, most recent first
+// /gettxs?limit=10&offset=0
 func (app *App) CreateHandler_GetTxs() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -35,23 +93,18 @@ func (app *App) CreateHandler_GetTxs() http.HandlerFunc {
 			return
 		}
 
-		// query database card payments for card
+		// get parameters, a missing limit returning everything
 
-		cardPayments := db.Db_select_card_payments(app.db_read, card_id)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
 
-		var resObj Transactions
-		resObj = make([]Transaction, 0)
-		var tx Transaction
+		// query database card history for card
 
-		for _, cardPayment := range cardPayments {
-			tx.PaymentHash.Type = "Buffer"
-			tx.Type = "paid_invoice"
-			tx.Fee = cardPayment.FeeSats
-			tx.Value = cardPayment.AmountSats
-			tx.Timestamp = strconv.Itoa(cardPayment.Timestamp)
-			tx.Memo = "" // TODO: add this
+		walletTxs := db.Db_select_card_wallet_txs(app.db_read, card_id, limit, offset)
 
-			resObj = append(resObj, tx)
+		resObj := make(Transactions, 0, len(walletTxs))
+		for _, walletTx := range walletTxs {
+			resObj = append(resObj, walletTransaction(walletTx))
 		}
 
 		writeJSON(w, resObj)
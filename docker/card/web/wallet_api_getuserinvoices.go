@@ -3,7 +3,6 @@ package web
 import (
 	"card/db"
 	"card/util"
-	"database/sql"
 
 	"card/phoenix"
 	"net/http"
@@ -24,6 +23,7 @@ type UserInvoice struct {
 	PaymentHash    string `json:"payment_hash"`
 	IsPaid         bool   `json:"ispaid,omitempty"`
 	Amt            int    `json:"amt"`
+	AmtMsat        int64  `json:"amt_msat"`
 	ExpireTime     int    `json:"expire_time"`
 	Timestamp      int    `json:"timestamp"`
 	Type           string `json:"type"`
@@ -31,7 +31,7 @@ type UserInvoice struct {
 
 type UserInvoicesResponse []UserInvoice
 
-func updateInvoiceStatus(db_conn *sql.DB, paymentHash string) {
+func (app *App) updateInvoiceStatus(paymentHash string) {
 	// get status from phoenix server
 	incomingPayment, err := phoenix.GetIncomingPayment(paymentHash)
 	if err != nil {
@@ -41,7 +41,7 @@ func updateInvoiceStatus(db_conn *sql.DB, paymentHash string) {
 
 	// update status in the database if paid
 	if incomingPayment.IsPaid {
-		db.Db_set_receipt_paid(db_conn, paymentHash, "api")
+		app.settleReceipt(paymentHash, incomingPayment.Preimage, "api")
 	}
 }
 
@@ -85,9 +85,10 @@ func (app *App) CreateHandler_WalletApi_GetUserInvoices() http.HandlerFunc {
 			userInvoice.RHash.Data = util.ConvertPaymentHash(cardReceipt.PaymentHash)
 			userInvoice.PayReq = cardReceipt.PaymentRequest
 			userInvoice.PaymentHash = cardReceipt.PaymentHash
-			userInvoice.Description = ""
+			userInvoice.Description = cardReceipt.Comment
 			userInvoice.IsPaid = false
 			userInvoice.Amt = cardReceipt.AmountSats
+			userInvoice.AmtMsat = cardReceipt.AmountMsat
 			userInvoice.ExpireTime = cardReceipt.ExpireTime
 			userInvoice.Timestamp = cardReceipt.Timestamp
 			userInvoice.Type = "user_invoice"
@@ -95,7 +96,7 @@ func (app *App) CreateHandler_WalletApi_GetUserInvoices() http.HandlerFunc {
 			if cardReceipt.IsPaid == "Y" {
 				userInvoice.IsPaid = true
 			} else {
-				updateInvoiceStatus(app.db_write, cardReceipt.PaymentHash)
+				app.updateInvoiceStatus(cardReceipt.PaymentHash)
 				// userInvoice.IsPaid status will be updated on the next call
 			}
 
@@ -0,0 +1,220 @@
+package web
+
+import (
+	"card/db"
+	"card/phoenix"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type PayLnAddressRequest struct {
+	Address string `json:"address"` // user@domain
+	Amount  int    `json:"amount"`  // sats
+	Comment string `json:"comment"`
+}
+
+type PayOfferRequest struct {
+	Offer   string `json:"offer"`  // lno1...
+	Amount  int    `json:"amount"` // sats
+	Comment string `json:"comment"`
+}
+
+type PayDestinationResponse struct {
+	Status          string `json:"status"`
+	Amount          int    `json:"amount"`
+	Fee             int    `json:"fee"`
+	PaymentHash     string `json:"payment_hash"`
+	PaymentPreimage string `json:"payment_preimage"`
+}
+
+// trimLightningPrefix removes a "lightning:" URI prefix from a destination.
+func trimLightningPrefix(destination string) string {
+	destination = strings.TrimSpace(destination)
+	if len(destination) > 10 && strings.EqualFold(destination[:10], "lightning:") {
+		destination = destination[10:]
+	}
+	return destination
+}
+
+// CreateHandler_WalletApi_PayLnAddress pays a lightning address from the
+// card balance.
+func (app *App) CreateHandler_WalletApi_PayLnAddress() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("paylnaddress request received")
+
+		card_id, ok := app.getAuthenticatedCardID(w, r)
+		if !ok {
+			return
+		}
+
+		var reqObj PayLnAddressRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+			sendError(w, "Error", 8, "request parameters invalid")
+			return
+		}
+
+		address := trimLightningPrefix(reqObj.Address)
+		if payoutDestinationKind(address) != payoutToLnAddress {
+			sendError(w, "Error", 8, "invalid lightning address")
+			return
+		}
+
+		app.walletPayDestination(w, card_id, payoutToLnAddress, address, reqObj.Amount, reqObj.Comment)
+	}
+}
+
+// CreateHandler_WalletApi_PayOffer pays a BOLT12 offer from the card balance.
+func (app *App) CreateHandler_WalletApi_PayOffer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("payoffer request received")
+
+		card_id, ok := app.getAuthenticatedCardID(w, r)
+		if !ok {
+			return
+		}
+
+		var reqObj PayOfferRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+			sendError(w, "Error", 8, "request parameters invalid")
+			return
+		}
+
+		offer := trimLightningPrefix(reqObj.Offer)
+		if payoutDestinationKind(offer) != payoutToOffer {
+			sendError(w, "Error", 8, "invalid offer")
+			return
+		}
+
+		app.walletPayDestination(w, card_id, payoutToOffer, offer, reqObj.Amount, reqObj.Comment)
+	}
+}
+
+// walletPayDestination pays amountSats to a lightning address or offer with
+// the same reservation, limits, fee reserve and settlement as /payinvoice.
+func (app *App) walletPayDestination(w http.ResponseWriter, card_id int, kind string,
+	destination string, amountSats int, comment string) {
+
+	if amountSats <= 0 {
+		sendError(w, "Error", 8, "amount must be positive")
+		return
+	}
+
+	// atomically check balance and reserve funds with the fee reserve
+	// (BEGIN IMMEDIATE transaction)
+	feeReserveSats := loadFeePolicy(app.db_read).ReserveSats(amountSats)
+	_, cardPaymentId, err := db.Db_reserve_card_payment_msat(
+		app.db_write, card_id, db.SatsToMsat(amountSats), db.SatsToMsat(feeReserveSats), destination)
+	if errors.Is(err, db.ErrCardNotActive) {
+		sendError(w, "Error", 8, "card not active")
+		return
+	}
+	if errors.Is(err, db.ErrTxLimitExceeded) {
+		sendError(w, "Error", 2, "amount exceeds card limit")
+		return
+	}
+	if errors.Is(err, db.ErrDayLimitExceeded) {
+		sendError(w, "Error", 2, "daily limit exceeded")
+		return
+	}
+	if errors.Is(err, db.ErrInsufficientFunds) {
+		sendError(w, "Error", 2, "not enough balance")
+		return
+	}
+	if err != nil {
+		sendError(w, "Error", 7, "payment reservation failed")
+		return
+	}
+
+	log.Info("paying card_id = ", card_id, " amount = ", amountSats, " to ", kind)
+
+	var payResult, payReason, paymentHash, preimage string
+	var feeSats int
+	switch kind {
+	case payoutToLnAddress:
+		res, result, err := phoenix.PayLightningAddress(phoenix.PayLightningAddressRequest{
+			AmountSat: strconv.Itoa(amountSats),
+			Address:   destination,
+			Message:   comment,
+			MaxFeeSat: strconv.Itoa(feeReserveSats),
+		})
+		if err != nil {
+			log.Error(err)
+		}
+		payResult, payReason, feeSats, paymentHash, preimage = result, res.Reason, res.RoutingFeeSat, res.PaymentHash, res.PaymentPreimage
+	case payoutToOffer:
+		res, result, err := phoenix.PayOffer(phoenix.PayOfferRequest{
+			AmountSat: strconv.Itoa(amountSats),
+			Offer:     destination,
+			Message:   comment,
+			MaxFeeSat: strconv.Itoa(feeReserveSats),
+		})
+		if err != nil {
+			log.Error(err)
+		}
+		payResult, payReason, feeSats, paymentHash, preimage = result, res.Reason, res.RoutingFeeSat, res.PaymentHash, res.PaymentPreimage
+	}
+
+	if walletPaymentFailed(w, app.db_write, payResult, payReason, cardPaymentId) {
+		return
+	}
+
+	// settle the routing fee in place of the reserve
+	if err := db.Db_settle_card_payment(app.db_write, cardPaymentId,
+		db.SatsToMsat(feeSats), paymentHash, preimage); err != nil {
+		log.Error("settle payment error, card_payment_id = ", cardPaymentId, ": ", err)
+	}
+
+	app.broadcastPaymentSent(amountSats, paymentHash, time.Now().Unix())
+
+	writeJSON(w, PayDestinationResponse{
+		Status:          "OK",
+		Amount:          amountSats,
+		Fee:             feeSats,
+		PaymentHash:     paymentHash,
+		PaymentPreimage: preimage,
+	})
+}
+
+// walletPaymentFailed answers a wallet payment Phoenix did not complete. As
+// with handlePaymentResult and handlePaymentReason, the reservation is only
+// released when the payment is known not to have been made. Returns true if
+// the payment failed and the handler should return.
+func walletPaymentFailed(w http.ResponseWriter, db_conn *sql.DB, result string, reason string, card_payment_id int) bool {
+	switch result {
+	case "no_error":
+	case "no_config", "failed_request_creation", "failed_read_response":
+		log.Error("payment not made (", result, "), card_payment_id = ", card_payment_id)
+		db.Db_update_card_payment_unpaid(db_conn, card_payment_id)
+		sendError(w, "Error", 7, "payment failed")
+		return true
+	default:
+		// the payment may have been made and must be handled manually
+		log.Error("payment outcome unknown (", result, "), card_payment_id = ", card_payment_id)
+		sendError(w, "Error", 7, "payment status unknown")
+		return true
+	}
+
+	switch reason {
+	case "":
+		return false
+	case "this invoice has already been paid", "recipient node rejected the payment",
+		"not enough funds in wallet to afford payment", "routing fees are insufficient":
+		log.Error("payment failed (", reason, "), card_payment_id = ", card_payment_id)
+		db.Db_update_card_payment_unpaid(db_conn, card_payment_id)
+		sendError(w, "Error", 10, "payment failed: "+reason)
+		return true
+	default:
+		log.Error("phoenix result is invalid, card_payment_id = ", card_payment_id)
+		sendError(w, "Error", 7, "payment status unknown")
+		return true
+	}
+}
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"card/db"
+	"card/phoenix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// walletPost POSTs body to a wallet API handler as the funded test card.
+func walletPost(t *testing.T, handler http.HandlerFunc, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer lnaccess")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestPayLnAddress_SettlesFeeReserve(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 2000)
+
+	var gotAddress, gotMaxFee, gotMessage string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/paylnaddress" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		gotAddress, gotMaxFee, gotMessage = r.FormValue("address"), r.FormValue("maxFeeSat"), r.FormValue("message")
+		w.Write([]byte(`{"recipientAmountSat":1000,"routingFeeSat":2,"paymentHash":"lnaddrhash","paymentPreimage":"lnaddrpre"}`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	w := walletPost(t, app.CreateHandler_WalletApi_PayLnAddress(), "/paylnaddress",
+		`{"address":"lightning:alice@example.com","amount":1000,"comment":"thanks"}`)
+
+	var resp PayDestinationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Status != "OK" {
+		t.Fatalf("expected OK, got %s", w.Body.String())
+	}
+	if resp.Amount != 1000 || resp.Fee != 2 || resp.PaymentHash != "lnaddrhash" || resp.PaymentPreimage != "lnaddrpre" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	// the default reserve is 4 + 0.4% = 8 sats
+	if gotAddress != "alice@example.com" || gotMaxFee != "8" || gotMessage != "thanks" {
+		t.Fatalf("unexpected phoenix request: address=%q maxFeeSat=%q message=%q", gotAddress, gotMaxFee, gotMessage)
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 2000-1000-2 {
+		t.Fatalf("expected balance %d after settlement, got %d", 2000-1000-2, b)
+	}
+	if found := db.Db_select_card_pending_payments(app.db_read, cardId); len(found) != 0 {
+		t.Fatalf("expected the payment to be settled, got %+v", found)
+	}
+}
+
+func TestPayOffer_FailedPaymentReleasesFunds(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 2000)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/payoffer" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"reason":"recipient node rejected the payment"}`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	w := walletPost(t, app.CreateHandler_WalletApi_PayOffer(), "/payoffer",
+		`{"offer":"lno1qtest","amount":500}`)
+
+	var errResp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if errResp.Code != 10 {
+		t.Fatalf("expected payment failed error, got %s", w.Body.String())
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 2000 {
+		t.Fatalf("expected funds released, balance 2000, got %d", b)
+	}
+}
+
+func TestPayDestination_RejectsBadRequests(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	insertFundedCard(t, app.db_write, 1000)
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		code    int
+	}{
+		{"not an address", app.CreateHandler_WalletApi_PayLnAddress(), `{"address":"lno1qtest","amount":100}`, 8},
+		{"not an offer", app.CreateHandler_WalletApi_PayOffer(), `{"offer":"alice@example.com","amount":100}`, 8},
+		{"no amount", app.CreateHandler_WalletApi_PayOffer(), `{"offer":"lno1qtest"}`, 8},
+		{"over balance", app.CreateHandler_WalletApi_PayLnAddress(), `{"address":"alice@example.com","amount":1000}`, 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := walletPost(t, tc.handler, "/", tc.body)
+			var errResp ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &errResp)
+			if errResp.Code != tc.code {
+				t.Fatalf("expected code %d, got %s", tc.code, w.Body.String())
+			}
+		})
+	}
+}
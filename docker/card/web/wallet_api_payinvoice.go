@@ -53,17 +53,24 @@ func (app *App) CreateHandler_WalletApi_PayInvoice() http.HandlerFunc {
 			sendError(w, "Error", 999, "invalid invoice amount")
 			return
 		}
-		invAmtSat := int(bolt11.MSatoshi / 1000)
+		invAmtMsat := bolt11.MSatoshi
+		invAmtSat := db.MsatToSatsDown(invAmtMsat)
 
-		log.Info("invAmtSat ", invAmtSat)
+		log.Info("invAmtMsat ", invAmtMsat)
 		log.Info("reqObj.Amount ", reqObj.Amount)
 
-		if invAmtSat != 0 && reqObj.Amount != invAmtSat {
+		// a client may round a sub-sat invoice amount either way
+		if invAmtMsat != 0 && reqObj.Amount != invAmtSat && reqObj.Amount != db.MsatToSatsUp(invAmtMsat) {
 			sendError(w, "Error", 999, "invoice amounts don't match")
 			return
 		}
 
-		actualAmtSat := max(invAmtSat, reqObj.Amount)
+		// the card is debited the exact invoice amount, or the requested
+		// amount for an invoice without one
+		actualAmtMsat := invAmtMsat
+		if actualAmtMsat == 0 {
+			actualAmtMsat = db.SatsToMsat(reqObj.Amount)
+		}
 
 		// check for duplicate payment
 		if db.Db_get_paid_payment_exists(app.db_read, reqObj.Invoice) {
@@ -71,9 +78,15 @@ func (app *App) CreateHandler_WalletApi_PayInvoice() http.HandlerFunc {
 			return
 		}
 
-		// atomically check balance and reserve funds (BEGIN IMMEDIATE transaction)
-		_, _, err = db.Db_reserve_card_payment(
-			app.db_write, card_id, actualAmtSat, reqObj.Amount, reqObj.Invoice)
+		// atomically check balance and reserve funds with the fee reserve
+		// (BEGIN IMMEDIATE transaction)
+		feeReserveSats := loadFeePolicy(app.db_read).ReserveSats(db.MsatToSatsUp(actualAmtMsat))
+		_, cardPaymentId, err := db.Db_reserve_card_payment_msat(
+			app.db_write, card_id, actualAmtMsat, db.SatsToMsat(feeReserveSats), reqObj.Invoice)
+		if errors.Is(err, db.ErrCardNotActive) {
+			sendError(w, "Error", 999, "card not active")
+			return
+		}
 		if errors.Is(err, db.ErrTxLimitExceeded) {
 			sendError(w, "Error", 999, "amount exceeds card limit")
 			return
@@ -97,6 +110,7 @@ func (app *App) CreateHandler_WalletApi_PayInvoice() http.HandlerFunc {
 
 		payInvoiceRequest.Invoice = reqObj.Invoice
 		payInvoiceRequest.AmountSat = strconv.Itoa(reqObj.Amount)
+		payInvoiceRequest.MaxFeeSat = strconv.Itoa(feeReserveSats)
 
 		payInvoiceResponse, payInvoiceResult, err := phoenix.SendLightningPayment(payInvoiceRequest)
 
@@ -107,6 +121,18 @@ func (app *App) CreateHandler_WalletApi_PayInvoice() http.HandlerFunc {
 		log.Info("payInvoiceResult : ", payInvoiceResult)
 		log.Info("payInvoiceResponse : ", payInvoiceResponse)
 
+		// a payment known to have failed releases the amount and fee reserve
+		if walletPaymentFailed(w, app.db_write, payInvoiceResult, payInvoiceResponse.Reason, cardPaymentId) {
+			return
+		}
+
+		// settle the routing fee in place of the reserve
+		if err := db.Db_settle_card_payment(app.db_write, cardPaymentId,
+			db.SatsToMsat(payInvoiceResponse.RoutingFeeSat), bolt11.PaymentHash,
+			payInvoiceResponse.PaymentPreimage); err != nil {
+			log.Error("settle payment error, card_payment_id = ", cardPaymentId, ": ", err)
+		}
+
 		// create the response
 
 		// broadcast to websocket clients
@@ -0,0 +1,61 @@
+package web
+
+import (
+	"card/db"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type SetLnAliasRequest struct {
+	Alias string `json:"alias"` // empty to release
+}
+
+type SetLnAliasResponse struct {
+	Status    string `json:"status"`
+	LnAlias   string `json:"ln_alias"`
+	LnAddress string `json:"ln_address"` // alias@host, empty once released
+}
+
+// CreateHandler_WalletApi_SetLnAlias claims a vanity lightning address for
+// the card. The card's c. address keeps working alongside it.
+func (app *App) CreateHandler_WalletApi_SetLnAlias() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		log.Info("setlnalias request received")
+
+		card_id, ok := app.getAuthenticatedCardID(w, r)
+		if !ok {
+			return
+		}
+
+		var reqObj SetLnAliasRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqObj); err != nil {
+			sendError(w, "Error", 8, "request parameters invalid")
+			return
+		}
+
+		alias, err := db.Db_set_card_ln_alias(app.db_write, card_id, reqObj.Alias)
+		switch {
+		case errors.Is(err, db.ErrLnAliasInvalid), errors.Is(err, db.ErrLnAliasReserved),
+			errors.Is(err, db.ErrLnAliasTaken):
+			sendError(w, "Error", 8, err.Error())
+			return
+		case errors.Is(err, db.ErrCardNotActive), errors.Is(err, sql.ErrNoRows):
+			sendError(w, "Error", 8, "card not active")
+			return
+		case err != nil:
+			sendError(w, "Error", 999, "failed to set alias")
+			return
+		}
+
+		resObj := SetLnAliasResponse{Status: "OK", LnAlias: alias}
+		if alias != "" {
+			resObj.LnAddress = alias + "@" + db.Db_get_setting(app.db_read, "host_domain")
+		}
+		writeJSON(w, resObj)
+	}
+}
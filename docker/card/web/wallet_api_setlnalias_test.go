@@ -0,0 +1,51 @@
+package web
+
+import (
+	"card/db"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func lnurlpRequestStatus(app *App, username string) int {
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+username, nil)
+	r = mux.SetURLVars(r, map[string]string{"username": username})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpRequest().ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestSetLnAlias_ResolvesAlongsideCardAddress(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+	card, _ := db.Db_get_card(app.db_read, cardId)
+
+	w := walletPost(t, app.CreateHandler_WalletApi_SetLnAlias(), "/setlnalias", `{"alias":"Alice"}`)
+	var resp SetLnAliasResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Status != "OK" || resp.LnAlias != "alice" || resp.LnAddress != "alice@test.example.com" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+
+	if code := lnurlpRequestStatus(app, "alice"); code != http.StatusOK {
+		t.Fatalf("expected the alias to resolve, got %d", code)
+	}
+	if code := lnurlpRequestStatus(app, card.Ln_address); code != http.StatusOK {
+		t.Fatalf("expected the c. address to keep working, got %d", code)
+	}
+
+	w = walletPost(t, app.CreateHandler_WalletApi_SetLnAlias(), "/setlnalias", `{"alias":"postmaster"}`)
+	var errResp ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if errResp.Code != 8 {
+		t.Fatalf("expected a reserved alias to be refused, got %s", w.Body.String())
+	}
+
+	walletPost(t, app.CreateHandler_WalletApi_SetLnAlias(), "/setlnalias", `{"alias":""}`)
+	if code := lnurlpRequestStatus(app, "alice"); code != http.StatusNotFound {
+		t.Fatalf("expected a released alias not to resolve, got %d", code)
+	}
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"card/db"
+	"card/phoenix"
 	"crypto/aes"
 	"crypto/cipher"
 
@@ -175,6 +176,30 @@ func TestBalance_ValidToken(t *testing.T) {
 	}
 }
 
+// Test balance handler returns the msat balance beside the sat balance
+func TestBalance_ReturnsMsat(t *testing.T) {
+	app := openTestApp(t)
+	db.Db_insert_card(app.db_write, "k0", "k1", "k2", "k3", "k4", "msatlogin", "msatpass")
+	if err := db.Db_set_tokens(app.db_write, "msatlogin", "msatpass", "msataccesstoken", "msatrefreshtoken"); err != nil {
+		t.Fatal("failed to set tokens: ", err)
+	}
+	db.Db_add_card_receipt_msat(app.db_write, 1, "lnbc_msat", "hash_msat", 2_750)
+	db.Db_set_receipt_paid(app.db_write, "hash_msat", "test")
+
+	r := httptest.NewRequest("GET", "/balance", nil)
+	r.Header.Set("Authorization", "Bearer msataccesstoken")
+	w := httptest.NewRecorder()
+	app.CreateHandler_Balance().ServeHTTP(w, r)
+
+	var balResp BalanceResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &balResp); err != nil {
+		t.Fatal("expected JSON balance response, got: ", w.Body.String())
+	}
+	if balResp.BTC.AvailableBalance != 2 || balResp.BTC.AvailableMsat != 2_750 {
+		t.Fatalf("expected 2 sats and 2750 msat, got %+v", balResp.BTC)
+	}
+}
+
 // Test auth handler with login and password
 func TestAuth_LoginPassword(t *testing.T) {
 	app := openTestApp(t)
@@ -1293,6 +1318,59 @@ func TestLnurlwRequest_CounterReplay(t *testing.T) {
 	}
 }
 
+func TestLnurlwRequest_RecordsUidOnFirstTap(t *testing.T) {
+	app := openTestApp(t)
+	cardId := insertFundedCard(t, app.db_write, 0)
+
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1); resp.Status == "ERROR" {
+		t.Fatalf("expected tap to succeed, got %q", resp.Reason)
+	}
+
+	if uid := db.Db_get_card_uid(app.db_read, cardId); uid != hex.EncodeToString(nfcTestUID) {
+		t.Fatalf("expected uid recorded on first tap, got %q", uid)
+	}
+}
+
+func TestLnurlwRequest_UidMatchIgnoresCase(t *testing.T) {
+	app := openTestApp(t)
+	key1Hex := hex.EncodeToString(nfcTestKey1)
+	key2Hex := hex.EncodeToString(nfcTestKey2)
+	db.Db_insert_card_with_uid(app.db_write, "k0", key1Hex, key2Hex, "k3", "k4", "lnlogin", "lnpass",
+		strings.ToUpper(hex.EncodeToString(nfcTestUID)), "")
+
+	if resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1); resp.Status == "ERROR" {
+		t.Fatalf("expected tap to succeed, got %q", resp.Reason)
+	}
+}
+
+func TestLnurlwRequest_UidMismatchRejected(t *testing.T) {
+	app := openTestApp(t)
+	key1Hex := hex.EncodeToString(nfcTestKey1)
+	key2Hex := hex.EncodeToString(nfcTestKey2)
+	db.Db_insert_card_with_uid(app.db_write, "k0", key1Hex, key2Hex, "k3", "k4", "lnlogin", "lnpass",
+		"04aabbccddeeff", "")
+	db.Db_set_tokens(app.db_write, "lnlogin", "lnpass", "lnaccess", "lnrefresh")
+	cardId := db.Db_get_card_id_from_access_token(app.db_read, "lnaccess")
+
+	resp := tapLn(t, app, nfcTestKey1, nfcTestKey2, 1)
+	if resp.Status != "ERROR" || resp.Reason != "card not found" {
+		t.Fatalf("expected tap to be rejected, got status=%q reason=%q", resp.Status, resp.Reason)
+	}
+
+	// the rejected tap must not advance the counter
+	if ctr := db.Db_get_card_counter(app.db_read, cardId); ctr != 0 {
+		t.Fatalf("expected counter unchanged, got %d", ctr)
+	}
+
+	events := db.Db_select_card_security_events(app.db_read, cardId, 10)
+	if len(events) != 1 || events[0].EventType != db.SecurityEventUidMismatch {
+		t.Fatalf("expected one uid_mismatch event, got %+v", events)
+	}
+	if uid := db.Db_get_card_uid(app.db_read, cardId); uid != "04aabbccddeeff" {
+		t.Fatalf("expected recorded uid unchanged, got %q", uid)
+	}
+}
+
 func TestLnurlwRequest_CounterIncrement(t *testing.T) {
 	app := openTestApp(t)
 	key1Hex := hex.EncodeToString(nfcTestKey1)
@@ -1592,8 +1670,28 @@ func TestLnurlwCallback_InsufficientFundsWithFees(t *testing.T) {
 
 	var resp lnurlStatus
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	if resp.Reason != "Insufficient funds with network fees" {
-		t.Fatalf("expected 'Insufficient funds with network fees', got %q", resp.Reason)
+	if !strings.HasPrefix(resp.Reason, "Insufficient funds with network fees") ||
+		!strings.Contains(resp.Reason, "fee reserve of 10 sats (4 sats + 0.4%)") {
+		t.Fatalf("expected the fee reserve to be explained, got %q", resp.Reason)
+	}
+}
+
+func TestLnurlwCallback_FeePolicySetting(t *testing.T) {
+	app := openTestApp(t)
+	// a 1500 sat invoice with a 100 sat minimum reserve needs 1600 sats
+	db.Db_set_setting(app.db_write, "fee_reserve_min_sats", "100")
+	cardId := insertFundedCard(t, app.db_write, 1550)
+	setupK1(t, app.db_write, cardId, "minfeek1", 300)
+
+	handler := app.CreateHandler_LnurlwCallback()
+	r := httptest.NewRequest("GET", "/cb?k1=minfeek1&pr="+testBolt11, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var resp lnurlStatus
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !strings.Contains(resp.Reason, "fee reserve of 100 sats") {
+		t.Fatalf("expected the minimum fee reserve to apply, got %q", resp.Reason)
 	}
 }
 
@@ -1957,6 +2055,81 @@ func TestPayInvoice_InsufficientBalance(t *testing.T) {
 	}
 }
 
+// TestPayInvoice_SettlesFeeReserve checks /payinvoice passes the fee reserve
+// to Phoenix as the fee cap and settles the actual routing fee.
+func TestPayInvoice_SettlesFeeReserve(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 2000)
+
+	var gotMaxFee string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/payinvoice" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		gotMaxFee = r.FormValue("maxFeeSat")
+		w.Write([]byte(`{"routingFeeSat":3,"paymentHash":"ph"}`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	// testBolt11 is 1500 sats; the default reserve is 4 + 0.4% = 10 sats
+	body := fmt.Sprintf(`{"invoice":"%s","amount":1500}`, testBolt11)
+	r := httptest.NewRequest("POST", "/payinvoice", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer lnaccess")
+	w := httptest.NewRecorder()
+	app.CreateHandler_WalletApi_PayInvoice().ServeHTTP(w, r)
+
+	if gotMaxFee != "10" {
+		t.Fatalf("expected fee cap 10, got %q", gotMaxFee)
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 2000-1500-3 {
+		t.Fatalf("expected balance %d after settlement, got %d", 2000-1500-3, b)
+	}
+}
+
+// TestPayInvoice_FailedPaymentReleasesReserve checks a payment Phoenix
+// reports as failed returns an error and gives the card back the amount and
+// fee reserve.
+func TestPayInvoice_FailedPaymentReleasesReserve(t *testing.T) {
+	app := newTestAppNoPollers(t)
+	cardId := insertFundedCard(t, app.db_write, 2000)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/payinvoice" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"reason":"recipient node rejected the payment"}`))
+	}))
+	defer srv.Close()
+	defer phoenix.UseMockPhoenix(srv.URL)()
+
+	events := app.hub.subscribe()
+	defer app.hub.unsubscribe(events)
+
+	body := fmt.Sprintf(`{"invoice":"%s","amount":1500}`, testBolt11)
+	r := httptest.NewRequest("POST", "/payinvoice", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer lnaccess")
+	w := httptest.NewRecorder()
+	app.CreateHandler_WalletApi_PayInvoice().ServeHTTP(w, r)
+
+	var resp map[string]any
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["error"] != "Error" || resp["code"] != float64(10) {
+		t.Fatalf("expected an LndHub error, got %s", w.Body.String())
+	}
+	if b := db.Db_get_card_balance(app.db_read, cardId); b != 2000 {
+		t.Fatalf("expected balance restored to 2000, got %d", b)
+	}
+	select {
+	case msg := <-events:
+		t.Fatalf("expected no broadcast for a failed payment, got %s", msg)
+	default:
+	}
+}
+
 func TestPayInvoice_DuplicateInvoice(t *testing.T) {
 	app := setupEnabledApp(t)
 	cardId := insertFundedCard(t, app.db_write, 50000)
@@ -2183,6 +2356,12 @@ func TestBatchCreateCard_Valid(t *testing.T) {
 	if !strings.Contains(resp.Lnurlw, "test.example.com") {
 		t.Fatalf("expected LNURLW to contain host domain, got %q", resp.Lnurlw)
 	}
+
+	// the batch's initial balance is loaded later by SetupCardAmountForTag
+	cardId := db.Db_get_card_id_from_card_uid(app.db_read, "048B71B22D6B80")
+	if cardId == 0 || db.Db_get_card_balance(app.db_read, cardId) != 0 {
+		t.Fatalf("expected an unfunded card, got card %d", cardId)
+	}
 }
 
 func TestBatchCreateCard_InvalidJSON(t *testing.T) {
@@ -2427,6 +2606,22 @@ func TestLnurlpCallback_AmountTooLow(t *testing.T) {
 	}
 }
 
+func TestLnurlpCallback_SubSatAmountRejected(t *testing.T) {
+	app := openTestApp(t)
+	db.Db_insert_card(app.db_write, "k0", "k1", "k2", "k3", "k4", "login1", "pass1")
+	card, _ := db.Db_get_card(app.db_read, 1)
+
+	handler := app.CreateHandler_LnurlpCallback()
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address+"/callback?amount=1500", nil)
+	r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a sub-sat amount, got %d", w.Code)
+	}
+}
+
 func TestLnurlpCallback_AmountTooHigh(t *testing.T) {
 	app := openTestApp(t)
 	db.Db_insert_card(app.db_write, "k0", "k1", "k2", "k3", "k4", "login1", "pass1")
@@ -2443,6 +2638,47 @@ func TestLnurlpCallback_AmountTooHigh(t *testing.T) {
 	}
 }
 
+func TestLnurlpRequest_CommentAllowed(t *testing.T) {
+	app := openTestApp(t)
+	db.Db_insert_card(app.db_write, "k0", "k1", "k2", "k3", "k4", "login1", "pass1")
+	card, _ := db.Db_get_card(app.db_read, 1)
+
+	payRequest := func() map[string]any {
+		r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address, nil)
+		r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+		w := httptest.NewRecorder()
+		app.CreateHandler_LnurlpRequest().ServeHTTP(w, r)
+		var resp map[string]any
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	if resp := payRequest(); resp["commentAllowed"] != float64(defaultLnAddressCommentLength) {
+		t.Fatalf("expected commentAllowed %d, got %v", defaultLnAddressCommentLength, resp["commentAllowed"])
+	}
+
+	db.Db_set_setting(app.db_write, "ln_address_comment_length", "0")
+	if resp := payRequest(); resp["commentAllowed"] != nil {
+		t.Fatalf("expected no commentAllowed with comments off, got %v", resp["commentAllowed"])
+	}
+}
+
+func TestLnurlpCallback_CommentTooLong(t *testing.T) {
+	app := openTestApp(t)
+	db.Db_insert_card(app.db_write, "k0", "k1", "k2", "k3", "k4", "login1", "pass1")
+	card, _ := db.Db_get_card(app.db_read, 1)
+	db.Db_set_setting(app.db_write, "ln_address_comment_length", "5")
+
+	r := httptest.NewRequest("GET", "/.well-known/lnurlp/"+card.Ln_address+"/callback?amount=5000&comment=toolong", nil)
+	r = mux.SetURLVars(r, map[string]string{"username": card.Ln_address})
+	w := httptest.NewRecorder()
+	app.CreateHandler_LnurlpCallback().ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "comment too long") {
+		t.Fatalf("expected 400 comment too long, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
 func TestLnurlpCallback_UnknownAddress(t *testing.T) {
 	app := openTestApp(t)
 
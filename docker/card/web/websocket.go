@@ -21,6 +21,7 @@ type wsPaymentEvent struct {
 	AmountSat   int    `json:"amountSat"`
 	PaymentHash string `json:"paymentHash"`
 	Timestamp   int64  `json:"timestamp"`
+	Comment     string `json:"comment,omitempty"` // payer comment on a received payment
 }
 
 type WebSocketMessage struct {
@@ -152,23 +153,11 @@ func (app *App) connectAndServePhoenix() error {
 
 		// Mark any matching receipt as paid (for lightning address payments)
 		if incomingPayment.IsPaid {
-			db.Db_set_receipt_paid(app.db_write, incomingPayment.PaymentHash, "websocket")
+			app.settleReceipt(incomingPayment.PaymentHash, incomingPayment.Preimage, "websocket")
 		}
 
-		event := wsPaymentEvent{
-			Type:        "payment_received",
-			AmountSat:   incomingPayment.ReceivedSat,
-			PaymentHash: incomingPayment.PaymentHash,
-			Timestamp:   incomingPayment.CompletedAt / 1000,
-		}
-
-		eventJSON, err := json.Marshal(event)
-		if err != nil {
-			log.Error("websocket json marshal error: ", err)
-			continue
-		}
-
-		app.hub.broadcast(eventJSON)
+		app.broadcastPaymentReceived(incomingPayment.ReceivedSat, incomingPayment.PaymentHash,
+			incomingPayment.CompletedAt/1000)
 	}
 }
 
@@ -216,40 +205,6 @@ func (app *App) startChannelPoller() {
 	}()
 }
 
-// startReceiptPoller checks for unsettled receipts every 30s and marks them
-// paid if Phoenix confirms the payment. This is a backstop for any payments
-// missed by the WebSocket listener (e.g. during restarts).
-func (app *App) startReceiptPoller() {
-	go func() {
-		for {
-			time.Sleep(30 * time.Second)
-
-			unpaid := db.Db_select_unpaid_receipts(app.db_read)
-			for _, r := range unpaid {
-				incoming, err := phoenix.GetIncomingPayment(r.PaymentHash)
-				if err != nil {
-					continue
-				}
-				if incoming.IsPaid {
-					db.Db_set_receipt_paid(app.db_write, incoming.PaymentHash, "poller")
-					log.Info("receipt poller settled: ", incoming.PaymentHash)
-
-					event := wsPaymentEvent{
-						Type:        "payment_received",
-						AmountSat:   incoming.ReceivedSat,
-						PaymentHash: incoming.PaymentHash,
-						Timestamp:   incoming.CompletedAt / 1000,
-					}
-					eventJSON, err := json.Marshal(event)
-					if err == nil {
-						app.hub.broadcast(eventJSON)
-					}
-				}
-			}
-		}
-	}()
-}
-
 func (app *App) CreateHandler_Websocket() http.HandlerFunc {
 	hostDomain := db.Db_get_setting(app.db_read, "host_domain")
 
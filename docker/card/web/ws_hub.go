@@ -1,6 +1,7 @@
 package web
 
 import (
+	"card/db"
 	"encoding/json"
 	"sync"
 
@@ -57,3 +58,21 @@ func (app *App) broadcastPaymentSent(amountSat int, paymentHash string, timestam
 	}
 	app.hub.broadcast(eventJSON)
 }
+
+// broadcastPaymentReceived announces an incoming payment, with the payer's
+// comment when it paid a receipt that has one.
+func (app *App) broadcastPaymentReceived(amountSat int, paymentHash string, timestamp int64) {
+	event := wsPaymentEvent{
+		Type:        "payment_received",
+		AmountSat:   amountSat,
+		PaymentHash: paymentHash,
+		Timestamp:   timestamp,
+		Comment:     db.Db_get_receipt_comment(app.db_read, paymentHash),
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		log.Error("broadcastPaymentReceived marshal error: ", err)
+		return
+	}
+	app.hub.broadcast(eventJSON)
+}